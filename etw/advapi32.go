@@ -161,6 +161,29 @@ func CloseTrace(traceHandle syscall.Handle) error {
 	return syscall.Errno(r1)
 }
 
+/*
+TraceSetInformation API wrapper generated from prototype
+EXTERN_C ULONG WMIAPI TraceSetInformation (
+	 TRACEHANDLE SessionHandle,
+	 TRACE_INFO_CLASS InformationClass,
+	 PVOID TraceInformation,
+	 ULONG InformationLength);
+*/
+func TraceSetInformation(sessionHandle syscall.Handle,
+	informationClass uint32,
+	traceInformation unsafe.Pointer,
+	informationLength uint32) error {
+	r1, _, _ := traceSetInformation.Call(
+		uintptr(sessionHandle),
+		uintptr(informationClass),
+		uintptr(traceInformation),
+		uintptr(informationLength))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
 /*
 EventAccessQuery API wrapper generated from prototype
 ULONG EVNTAPI EventAccessQuery (
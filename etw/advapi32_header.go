@@ -1,10 +1,6 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
-	"syscall"
 	"time"
 	"unsafe"
 )
@@ -232,6 +228,13 @@ const (
 	EVENT_CONTROL_CODE_CAPTURE_STATE    = 2
 )
 
+const (
+	// TRACE_INFO_CLASS values used with TraceSetInformation
+	TraceStackTracingInfo           = 3
+	TraceSystemTraceEnableFlagsInfo = 4
+	TraceSampledProfileIntervalInfo = 5
+)
+
 const (
 	// Information levels
 	TRACE_LEVEL_NONE        = 0
@@ -247,12 +250,6 @@ const (
 	TRACE_LEVEL_RESERVED9   = 9
 )
 
-const (
-	PROCESS_TRACE_MODE_REAL_TIME     = 0x00000100
-	PROCESS_TRACE_MODE_RAW_TIMESTAMP = 0x00001000
-	PROCESS_TRACE_MODE_EVENT_RECORD  = 0x10000000
-)
-
 const (
 	EVENT_HEADER_FLAG_EXTENDED_INFO   = 0x0001
 	EVENT_HEADER_FLAG_PRIVATE_SESSION = 0x0002
@@ -271,28 +268,6 @@ const (
 	EVENT_HEADER_PROPERTY_LEGACY_EVENTLOG = 0x0004
 )
 
-const (
-	EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID = 0x0001
-	EVENT_HEADER_EXT_TYPE_SID                = 0x0002
-	EVENT_HEADER_EXT_TYPE_TS_ID              = 0x0003
-	EVENT_HEADER_EXT_TYPE_INSTANCE_INFO      = 0x0004
-	EVENT_HEADER_EXT_TYPE_STACK_TRACE32      = 0x0005
-	EVENT_HEADER_EXT_TYPE_STACK_TRACE64      = 0x0006
-	EVENT_HEADER_EXT_TYPE_PEBS_INDEX         = 0x0007
-	EVENT_HEADER_EXT_TYPE_PMC_COUNTERS       = 0x0008
-	EVENT_HEADER_EXT_TYPE_PSM_KEY            = 0x0009
-	EVENT_HEADER_EXT_TYPE_EVENT_KEY          = 0x000A
-	EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL    = 0x000B
-	EVENT_HEADER_EXT_TYPE_PROV_TRAITS        = 0x000C
-	EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY  = 0x000D
-	EVENT_HEADER_EXT_TYPE_CONTROL_GUID       = 0x000E
-	EVENT_HEADER_EXT_TYPE_QPC_DELTA          = 0x000F
-	EVENT_HEADER_EXT_TYPE_CONTAINER_ID       = 0x0010
-	EVENT_HEADER_EXT_TYPE_STACK_KEY32        = 0x0011
-	EVENT_HEADER_EXT_TYPE_STACK_KEY64        = 0x0012
-	EVENT_HEADER_EXT_TYPE_MAX                = 0x0013
-)
-
 //////////////////////////////////////////////////////////////////
 
 /*
@@ -343,7 +318,7 @@ type EventTraceProperties struct {
 	BuffersWritten      uint32
 	LogBuffersLost      uint32
 	RealTimeBuffersLost uint32
-	LoggerThreadId      syscall.Handle
+	LoggerThreadId      uintptr
 	LogFileNameOffset   uint32
 	LoggerNameOffset    uint32
 }
@@ -393,45 +368,6 @@ type EnableTraceParameters struct {
 	FilterDescCount  uint32
 }
 
-const (
-	EVENT_ENABLE_PROPERTY_SID                       = 0x00000001
-	EVENT_ENABLE_PROPERTY_TS_ID                     = 0x00000002
-	EVENT_ENABLE_PROPERTY_STACK_TRACE               = 0x00000004
-	EVENT_ENABLE_PROPERTY_PSM_KEY                   = 0x00000008
-	EVENT_ENABLE_PROPERTY_IGNORE_KEYWORD_0          = 0x00000010
-	EVENT_ENABLE_PROPERTY_PROVIDER_GROUP            = 0x00000020
-	EVENT_ENABLE_PROPERTY_ENABLE_KEYWORD_0          = 0x00000040
-	EVENT_ENABLE_PROPERTY_PROCESS_START_KEY         = 0x00000080
-	EVENT_ENABLE_PROPERTY_EVENT_KEY                 = 0x00000100
-	EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE         = 0x00000200
-	EVENT_ENABLE_PROPERTY_ENABLE_SILOS              = 0x00000400
-	EVENT_ENABLE_PROPERTY_SOURCE_CONTAINER_TRACKING = 0x00000800
-)
-
-const (
-	EVENT_FILTER_TYPE_NONE               = 0x00000000
-	EVENT_FILTER_TYPE_SCHEMATIZED        = 0x80000000 // Provider-side.
-	EVENT_FILTER_TYPE_SYSTEM_FLAGS       = 0x80000001 // Internal use only.
-	EVENT_FILTER_TYPE_TRACEHANDLE        = 0x80000002 // Initiate rundown.
-	EVENT_FILTER_TYPE_PID                = 0x80000004 // Process ID.
-	EVENT_FILTER_TYPE_EXECUTABLE_NAME    = 0x80000008 // EXE file name.
-	EVENT_FILTER_TYPE_PACKAGE_ID         = 0x80000010 // Package ID.
-	EVENT_FILTER_TYPE_PACKAGE_APP_ID     = 0x80000020 // Package Relative App Id (PRAID).
-	EVENT_FILTER_TYPE_PAYLOAD            = 0x80000100 // TDH payload filter.
-	EVENT_FILTER_TYPE_EVENT_ID           = 0x80000200 // Event IDs.
-	EVENT_FILTER_TYPE_EVENT_NAME         = 0x80000400 // Event name (TraceLogging only).
-	EVENT_FILTER_TYPE_STACKWALK          = 0x80001000 // Event IDs for stack.
-	EVENT_FILTER_TYPE_STACKWALK_NAME     = 0x80002000 // Event name for stack (TraceLogging only).
-	EVENT_FILTER_TYPE_STACKWALK_LEVEL_KW = 0x80004000 // Filter stack collection by level and keyword.
-	EVENT_FILTER_TYPE_CONTAINER          = 0x80008000 // Filter by Container ID.
-)
-
-const (
-	MAX_EVENT_FILTER_DATA_SIZE = 1024
-
-	MAX_EVENT_FILTER_EVENT_ID_COUNT = 64
-)
-
 /*
 EVENT_FILTER_EVENT_ID is used to pass EventId filter for
 stack walk filters.
@@ -496,6 +432,12 @@ type FileTime struct {
 	dwHighDateTime uint32
 }
 
+// NewFileTime converts a time to a FileTime
+func NewFileTime(t time.Time) FileTime {
+	ft := uint64(TimeToFiletime(t))
+	return FileTime{uint32(ft), uint32(ft >> 32)}
+}
+
 /*
 typedef struct _EVENT_TRACE_LOGFILEW {
   LPWSTR                        LogFileName;
@@ -587,104 +529,34 @@ func (e *EventRecord) ExtendedDataItem(i uint16) *EventHeaderExtendedDataItem {
 	panic("out of bound extended data item")
 }
 
-func (e *EventRecord) RelatedActivityID() string {
+func (e *EventRecord) RelatedActivityID() GUID {
 	for i := uint16(0); i < e.ExtendedDataCount; i++ {
 		item := e.ExtendedDataItem(i)
 		if item.ExtType == EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID {
-			g := (*GUID)(unsafe.Pointer(item.DataPtr))
-			return g.String()
+			return *(*GUID)(unsafe.Pointer(item.DataPtr))
 		}
 	}
-	return nullGUIDStr
+	return nullGUID
 }
 
-func (e *EventRecord) GetEventInformation() (tei *TraceEventInfo, err error) {
-	bufferSize := uint32(0)
-	if err = TdhGetEventInformation(e, 0, nil, nil, &bufferSize); err == syscall.ERROR_INSUFFICIENT_BUFFER {
-		// don't know how this would behave
-		buff := make([]byte, bufferSize)
-		tei = ((*TraceEventInfo)(unsafe.Pointer(&buff[0])))
-		err = TdhGetEventInformation(e, 0, nil, tei, &bufferSize)
+// DecodeExtendedData decodes all the extended data items of the event
+func (e *EventRecord) DecodeExtendedData() (*EventExtendedData, error) {
+	items := make([]ExtendedDataItem, 0, e.ExtendedDataCount)
+	for i := uint16(0); i < e.ExtendedDataCount; i++ {
+		item := e.ExtendedDataItem(i)
+		items = append(items, ExtendedDataItem{ExtType: item.ExtType, Data: item.Bytes()})
 	}
-	return
-}
-
-/*
-// Both MOF-based events and manifest-based events can specify name/value maps. The
-// map values can be integer values or bit values. If the property specifies a value
-// map, get the map.
-
-DWORD GetMapInfo(PEVENT_RECORD pEvent, LPWSTR pMapName, DWORD DecodingSource, PEVENT_MAP_INFO & pMapInfo)
-{
-    DWORD status = ERROR_SUCCESS;
-    DWORD MapSize = 0;
-
-    // Retrieve the required buffer size for the map info.
-
-    status = TdhGetEventMapInformation(pEvent, pMapName, pMapInfo, &MapSize);
-
-    if (ERROR_INSUFFICIENT_BUFFER == status)
-    {
-        pMapInfo = (PEVENT_MAP_INFO) malloc(MapSize);
-        if (pMapInfo == NULL)
-        {
-            wprintf(L"Failed to allocate memory for map info (size=%lu).\n", MapSize);
-            status = ERROR_OUTOFMEMORY;
-            goto cleanup;
-        }
-
-        // Retrieve the map info.
-
-        status = TdhGetEventMapInformation(pEvent, pMapName, pMapInfo, &MapSize);
-    }
-
-    if (ERROR_SUCCESS == status)
-    {
-        if (DecodingSourceXMLFile == DecodingSource)
-        {
-            RemoveTrailingSpace(pMapInfo);
-        }
-    }
-    else
-    {
-        if  (ERROR_NOT_FOUND == status)
-        {
-            status = ERROR_SUCCESS; // This case is okay.
-        }
-        else
-        {
-            wprintf(L"TdhGetEventMapInformation failed with 0x%x.\n", status);
-        }
-    }
-
-cleanup:
-
-    return status;
+	return DecodeExtendedData(items)
 }
-*/
-
-func (e *EventRecord) GetMapInfo(pMapName *uint16, decodingSource uint32) (pMapInfo *EventMapInfo, err error) {
-	mapSize := uint32(64)
-	buff := make([]byte, mapSize)
-	pMapInfo = ((*EventMapInfo)(unsafe.Pointer(&buff[0])))
-	err = TdhGetEventMapInformation(e, pMapName, pMapInfo, &mapSize)
-
-	if err == syscall.ERROR_INSUFFICIENT_BUFFER {
-		buff := make([]byte, mapSize)
-		pMapInfo = ((*EventMapInfo)(unsafe.Pointer(&buff[0])))
-		err = TdhGetEventMapInformation(e, pMapName, pMapInfo, &mapSize)
-	}
-
-	if err == nil {
-		if DecodingSource(decodingSource) == DecodingSourceXMLFile {
-			pMapInfo.RemoveTrailingSpace()
-		}
-	}
 
-	if err == syscall.ERROR_NOT_FOUND {
-		err = nil
+// userDataBytes returns the user data of the event without copying it, the
+// slice must not be used once the event callback returned. UserData is read
+// as an unsafe.Pointer so that it is not converted back from an uintptr.
+func (e *EventRecord) userDataBytes() []byte {
+	if e.UserData == 0 {
+		return nil
 	}
-	return
+	return unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&e.UserData))), e.UserDataLength)
 }
 
 func (e *EventRecord) PointerSize() uint32 {
@@ -715,6 +587,11 @@ type EventHeaderExtendedDataItem struct {
 	DataPtr        uintptr
 }
 
+// Bytes returns the data of the extended data item
+func (i *EventHeaderExtendedDataItem) Bytes() []byte {
+	return CopyData(i.DataPtr, int(i.DataSize))
+}
+
 /*
 	typedef struct _EVENT_HEADER {
 	  USHORT           Size;
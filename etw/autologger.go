@@ -71,7 +71,9 @@ func (a *AutoLogger) Create() (err error) {
 	return
 }
 
-func binaryFilter(filter []uint16) (f string, err error) {
+// binaryFilter encodes a slice of fixed size integers
+// as a hex string to be used as REG_BINARY value
+func binaryFilter(filter interface{}) (f string, err error) {
 	buf := new(bytes.Buffer)
 	if err = binary.Write(buf, binary.LittleEndian, filter); err != nil {
 		return
@@ -79,9 +81,16 @@ func binaryFilter(filter []uint16) (f string, err error) {
 	return hex.EncodeToString(buf.Bytes()), nil
 }
 
+// EnableProvider configures the autologger to enable provider p. Payload
+// filters cannot be configured in the registry, so an error is returned
+// if p has any.
 func (a *AutoLogger) EnableProvider(p Provider) (err error) {
 	path := fmt.Sprintf(`%s\%s`, a.Path(), p.GUID)
 
+	if len(p.PayloadFilters) > 0 {
+		return fmt.Errorf("payload filters of provider %s cannot be applied by autologgers", p.GUID)
+	}
+
 	sargs := [][]string{}
 
 	// ETWtrace parameters
@@ -97,18 +106,73 @@ func (a *AutoLogger) EnableProvider(p Provider) (err error) {
 		sargs = append(sargs, []string{path, "MatchAllKeyword", regQword, hexStr(p.MatchAllKeyword)})
 	}
 
-	// enable event filtering
-	if len(p.Filter) > 0 {
+	if p.EnableProperties() != 0 {
+		sargs = append(sargs, []string{path, "EnableProperty", regDword, hexStr(p.EnableProperties())})
+	}
+
+	filtersPath := filepath.Join(path, "Filters")
+	filtersEnabled := false
+
+	// enable event filtering, too large filters must
+	// be applied on consumer side
+	if p.KernelEventIDFilter() {
 		var binFilter string
 		if binFilter, err = binaryFilter(p.Filter); err != nil {
 			return fmt.Errorf("failed to create binary filter: %w", err)
 		}
-		filtersPath := filepath.Join(path, "Filters")
-		sargs = append(sargs, []string{filtersPath, "Enabled", regDword, "0x1"})
-		sargs = append(sargs, []string{filtersPath, "EventIdFilterIn", regDword, "0x1"})
+		filterIn := "0x1"
+		if p.FilterOut {
+			filterIn = "0x0"
+		}
+		filtersEnabled = true
+		sargs = append(sargs, []string{filtersPath, "EventIdFilterIn", regDword, filterIn})
 		sargs = append(sargs, []string{filtersPath, "EventIds", regBinary, binFilter})
 	}
 
+	// enable process ID filtering
+	if len(p.PIDs) > 0 {
+		var binFilter string
+		if len(p.PIDs) > MAX_EVENT_FILTER_PID_COUNT {
+			return fmt.Errorf("too many PIDs %d > %d", len(p.PIDs), MAX_EVENT_FILTER_PID_COUNT)
+		}
+		if binFilter, err = binaryFilter(p.PIDs); err != nil {
+			return fmt.Errorf("failed to create binary filter: %w", err)
+		}
+		filtersEnabled = true
+		sargs = append(sargs, []string{filtersPath, "PIDs", regBinary, binFilter})
+	}
+
+	// enable executable name filtering
+	if len(p.ExecutableNames) > 0 {
+		if _, err = EncodeExecutableNameFilter(p.ExecutableNames); err != nil {
+			return
+		}
+		filtersEnabled = true
+		sargs = append(sargs, []string{filtersPath, "ExeNames", regSz, strings.Join(p.ExecutableNames, ";")})
+	}
+
+	// enable stack walk filtering on event IDs
+	if p.StackWalkEnabled() && len(p.StackWalk.EventIDs) > 0 {
+		var binFilter string
+		if len(p.StackWalk.EventIDs) > MAX_EVENT_FILTER_EVENT_ID_COUNT {
+			return fmt.Errorf("too many stack walk event IDs %d > %d", len(p.StackWalk.EventIDs), MAX_EVENT_FILTER_EVENT_ID_COUNT)
+		}
+		if binFilter, err = binaryFilter(p.StackWalk.EventIDs); err != nil {
+			return fmt.Errorf("failed to create binary filter: %w", err)
+		}
+		filterIn := "0x1"
+		if p.StackWalk.FilterOut {
+			filterIn = "0x0"
+		}
+		filtersEnabled = true
+		sargs = append(sargs, []string{filtersPath, "StackEventIdFilterIn", regDword, filterIn})
+		sargs = append(sargs, []string{filtersPath, "StackEventIds", regBinary, binFilter})
+	}
+
+	if filtersEnabled {
+		sargs = append(sargs, []string{filtersPath, "Enabled", regDword, "0x1"})
+	}
+
 	// executing commands
 	for _, args := range sargs {
 		if err = regAddValue(args[0], args[1], args[2], args[3]); err != nil {
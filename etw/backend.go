@@ -0,0 +1,118 @@
+package etw
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnsupportedPlatform = fmt.Errorf("ETW is not supported on this platform")
+
+	// defaultBackend is the backend used by sessions and consumers,
+	// it is replaced by the system backend on Windows
+	defaultBackend backend = unsupportedBackend{}
+)
+
+// sessionBackend controls trace sessions
+type sessionBackend interface {
+	startTrace(name string, props *EventTraceProperties) (handle uint64, err error)
+	// stopTrace stops a session given either its handle or its name
+	stopTrace(handle uint64, name string, props *EventTraceProperties) error
+	enableProvider(handle uint64, prov *Provider) error
+	disableProvider(handle uint64, prov *Provider) error
+	enableStackTracing(handle uint64, ids []ClassicEventID) error
+}
+
+// traceConsumer receives the records of the traces processed by a
+// traceBackend
+type traceConsumer interface {
+	// keepProcessing is called for every buffer processed,
+	// processing stops if it returns false
+	keepProcessing() bool
+	processRecord(er *EventRecord)
+}
+
+// traceBackend opens, processes and closes traces
+type traceBackend interface {
+	openTrace(src traceSource, c traceConsumer) (handle uint64, err error)
+	// processTrace blocks until all the events of the traces are
+	// processed, start and end are ignored when zero
+	processTrace(handles []uint64, start, end time.Time) error
+	closeTrace(handle uint64) error
+}
+
+// eventInfoBackend gives information needed to parse event records
+type eventInfoBackend interface {
+	// eventInformation returns the TRACE_EVENT_INFO buffer of an event
+	eventInformation(er *EventRecord) ([]byte, error)
+	// mapInformation returns the EVENT_MAP_INFO buffer of a value map,
+	// nil without error if the map is not found
+	mapInformation(er *EventRecord, mapName string) ([]byte, error)
+	propertySize(er *EventRecord, desc *PropertyDataDescriptor) (uint32, error)
+	property(er *EventRecord, desc *PropertyDataDescriptor, buf []byte) error
+	formatProperty(tei *TraceEventInfo, mapInfo *EventMapInfo, pointerSize uint32,
+		inType, outType, length uint16, userData []byte) (string, error)
+}
+
+// backend abstracts the advapi32 and tdh functions used by sessions and
+// consumers so that their logic can run on any OS
+type backend interface {
+	sessionBackend
+	traceBackend
+	eventInfoBackend
+}
+
+// unsupportedBackend is the backend used on platforms without ETW
+type unsupportedBackend struct{}
+
+func (unsupportedBackend) startTrace(string, *EventTraceProperties) (uint64, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) stopTrace(uint64, string, *EventTraceProperties) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) enableProvider(uint64, *Provider) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) disableProvider(uint64, *Provider) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) enableStackTracing(uint64, []ClassicEventID) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) openTrace(traceSource, traceConsumer) (uint64, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) processTrace([]uint64, time.Time, time.Time) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) closeTrace(uint64) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) eventInformation(*EventRecord) ([]byte, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) mapInformation(*EventRecord, string) ([]byte, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) propertySize(*EventRecord, *PropertyDataDescriptor) (uint32, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) property(*EventRecord, *PropertyDataDescriptor, []byte) error {
+	return ErrUnsupportedPlatform
+}
+
+func (unsupportedBackend) formatProperty(*TraceEventInfo, *EventMapInfo, uint32, uint16, uint16, uint16, []byte) (string, error) {
+	return "", ErrUnsupportedPlatform
+}
@@ -0,0 +1,371 @@
+//go:build windows
+// +build windows
+
+package etw
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func init() {
+	defaultBackend = systemBackend{}
+}
+
+// systemBackend is the backend calling advapi32 and tdh functions
+type systemBackend struct{}
+
+// startTrace implements sessionBackend
+func (systemBackend) startTrace(name string, props *EventTraceProperties) (uint64, error) {
+	var handle syscall.Handle
+
+	u16TraceName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = StartTrace(&handle, u16TraceName, props)
+	return uint64(handle), err
+}
+
+// stopTrace implements sessionBackend
+func (systemBackend) stopTrace(handle uint64, name string, props *EventTraceProperties) (err error) {
+	var u16TraceName *uint16
+
+	if name != "" {
+		if u16TraceName, err = syscall.UTF16PtrFromString(name); err != nil {
+			return
+		}
+	}
+
+	return ControlTrace(syscall.Handle(handle), u16TraceName, props, EVENT_TRACE_CONTROL_STOP)
+}
+
+// enableProvider implements sessionBackend
+func (systemBackend) enableProvider(handle uint64, prov *Provider) (err error) {
+	var guid *GUID
+
+	if guid, err = ParseGUID(prov.GUID); err != nil {
+		return
+	}
+
+	params := EnableTraceParameters{
+		Version:        2,
+		EnableProperty: prov.EnableProperties(),
+	}
+
+	fds, data, err := prov.BuildFilterDesc()
+	if err != nil {
+		return fmt.Errorf("failed to build filter descriptors: %w", err)
+	}
+
+	if len(prov.PayloadFilters) > 0 {
+		var pfd EventFilterDescriptor
+		var cleanup func()
+
+		if pfd, cleanup, err = prov.BuildPayloadFilterDesc(); err != nil {
+			return fmt.Errorf("failed to build payload filter descriptor: %w", err)
+		}
+		defer cleanup()
+
+		fds = append(fds, pfd)
+	}
+
+	if len(fds) > 0 {
+		params.EnableFilterDesc = (*EventFilterDescriptor)(unsafe.Pointer(&fds[0]))
+		params.FilterDescCount = uint32(len(fds))
+	}
+
+	if err = EnableTraceEx2(
+		syscall.Handle(handle),
+		guid,
+		EVENT_CONTROL_CODE_ENABLE_PROVIDER,
+		prov.EnableLevel,
+		prov.MatchAnyKeyword,
+		prov.MatchAllKeyword,
+		0,
+		&params,
+	); err != nil {
+		return
+	}
+
+	// filter data must not be collected before EnableTraceEx2 returns
+	runtime.KeepAlive(fds)
+	runtime.KeepAlive(data)
+
+	return
+}
+
+// disableProvider implements sessionBackend
+func (systemBackend) disableProvider(handle uint64, prov *Provider) (err error) {
+	var guid *GUID
+
+	if guid, err = ParseGUID(prov.GUID); err != nil {
+		return
+	}
+
+	return EnableTraceEx2(
+		syscall.Handle(handle),
+		guid,
+		EVENT_CONTROL_CODE_DISABLE_PROVIDER,
+		0,
+		0,
+		0,
+		0,
+		nil,
+	)
+}
+
+// enableStackTracing implements sessionBackend
+func (systemBackend) enableStackTracing(handle uint64, ids []ClassicEventID) error {
+	return TraceSetInformation(
+		syscall.Handle(handle),
+		TraceStackTracingInfo,
+		unsafe.Pointer(&ids[0]),
+		uint32(uintptr(len(ids))*unsafe.Sizeof(ids[0])),
+	)
+}
+
+// openTrace implements traceBackend
+func (systemBackend) openTrace(src traceSource, c traceConsumer) (uint64, error) {
+	var loggerInfo EventTraceLogfile
+
+	name, err := syscall.UTF16PtrFromString(src.name)
+	if err != nil {
+		return 0, err
+	}
+
+	// log files are opened by path and real-time sessions by name
+	if src.logFile {
+		loggerInfo.LogFileName = name
+	} else {
+		loggerInfo.LoggerName = name
+	}
+
+	loggerInfo.SetProcessTraceMode(src.processTraceMode())
+	loggerInfo.BufferCallback = syscall.NewCallbackCDecl(func(*EventTraceLogfile) uintptr {
+		if c.keepProcessing() {
+			return 1
+		}
+		return 0
+	})
+	loggerInfo.Callback = syscall.NewCallbackCDecl(func(er *EventRecord) uintptr {
+		c.processRecord(er)
+		return 0
+	})
+
+	traceHandle, err := OpenTrace(&loggerInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(traceHandle), nil
+}
+
+// processTrace implements traceBackend
+func (systemBackend) processTrace(handles []uint64, start, end time.Time) error {
+	var pStart, pEnd *FileTime
+
+	traceHandles := make([]syscall.Handle, len(handles))
+	for i, h := range handles {
+		traceHandles[i] = syscall.Handle(h)
+	}
+
+	if !start.IsZero() {
+		ft := NewFileTime(start)
+		pStart = &ft
+	}
+
+	if !end.IsZero() {
+		ft := NewFileTime(end)
+		pEnd = &ft
+	}
+
+	return ProcessTrace(&traceHandles[0], uint32(len(traceHandles)), pStart, pEnd)
+}
+
+// closeTrace implements traceBackend
+func (systemBackend) closeTrace(h uint64) error {
+	// if we don't wait for traces ERROR_CTX_CLOSE_PENDING is a valid error
+	if err := CloseTrace(syscall.Handle(h)); err != nil && err != ERROR_CTX_CLOSE_PENDING {
+		return err
+	}
+	return nil
+}
+
+// eventInformation implements eventInfoBackend
+func (systemBackend) eventInformation(er *EventRecord) ([]byte, error) {
+	return er.eventInformation()
+}
+
+// mapInformation implements eventInfoBackend
+func (systemBackend) mapInformation(er *EventRecord, mapName string) ([]byte, error) {
+	pMapName, err := syscall.UTF16PtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	return er.mapInformation(pMapName)
+}
+
+// propertySize implements eventInfoBackend
+func (systemBackend) propertySize(er *EventRecord, desc *PropertyDataDescriptor) (size uint32, err error) {
+	err = TdhGetPropertySize(er, 0, nil, 1, desc, &size)
+	return
+}
+
+// property implements eventInfoBackend
+func (systemBackend) property(er *EventRecord, desc *PropertyDataDescriptor, buf []byte) error {
+	var pBuf *byte
+
+	if len(buf) > 0 {
+		pBuf = &buf[0]
+	}
+
+	return TdhGetProperty(er, 0, nil, 1, desc, uint32(len(buf)), pBuf)
+}
+
+// formatProperty implements eventInfoBackend
+func (systemBackend) formatProperty(tei *TraceEventInfo, mapInfo *EventMapInfo, pointerSize uint32,
+	inType, outType, length uint16, userData []byte) (string, error) {
+	var udc uint16
+	var pUserData *byte
+
+	if len(userData) > 0 {
+		pUserData = &userData[0]
+	}
+
+	formattedDataSize := maxu32(16, uint32(length))
+	for {
+		buff := make([]uint16, formattedDataSize)
+
+		err := TdhFormatProperty(
+			tei,
+			mapInfo,
+			pointerSize,
+			inType,
+			outType,
+			length,
+			uint16(len(userData)),
+			pUserData,
+			&formattedDataSize,
+			&buff[0],
+			&udc)
+
+		switch err {
+		case nil:
+			return syscall.UTF16ToString(buff), nil
+		case ERROR_INSUFFICIENT_BUFFER:
+			continue
+		default:
+			return "", err
+		}
+	}
+}
+
+func (e *EventRecord) GetEventInformation() (tei *TraceEventInfo, err error) {
+	var buff []byte
+	if buff, err = e.eventInformation(); err == nil && len(buff) > 0 {
+		tei = ((*TraceEventInfo)(unsafe.Pointer(&buff[0])))
+	}
+	return
+}
+
+// eventInformation returns the buffer filled by TdhGetEventInformation
+func (e *EventRecord) eventInformation() (buff []byte, err error) {
+	bufferSize := uint32(0)
+	if err = TdhGetEventInformation(e, 0, nil, nil, &bufferSize); err == ERROR_INSUFFICIENT_BUFFER {
+		buff = make([]byte, bufferSize)
+		err = TdhGetEventInformation(e, 0, nil, (*TraceEventInfo)(unsafe.Pointer(&buff[0])), &bufferSize)
+	}
+	return
+}
+
+/*
+// Both MOF-based events and manifest-based events can specify name/value maps. The
+// map values can be integer values or bit values. If the property specifies a value
+// map, get the map.
+
+DWORD GetMapInfo(PEVENT_RECORD pEvent, LPWSTR pMapName, DWORD DecodingSource, PEVENT_MAP_INFO & pMapInfo)
+{
+    DWORD status = ERROR_SUCCESS;
+    DWORD MapSize = 0;
+
+    // Retrieve the required buffer size for the map info.
+
+    status = TdhGetEventMapInformation(pEvent, pMapName, pMapInfo, &MapSize);
+
+    if (ERROR_INSUFFICIENT_BUFFER == status)
+    {
+        pMapInfo = (PEVENT_MAP_INFO) malloc(MapSize);
+        if (pMapInfo == NULL)
+        {
+            wprintf(L"Failed to allocate memory for map info (size=%lu).\n", MapSize);
+            status = ERROR_OUTOFMEMORY;
+            goto cleanup;
+        }
+
+        // Retrieve the map info.
+
+        status = TdhGetEventMapInformation(pEvent, pMapName, pMapInfo, &MapSize);
+    }
+
+    if (ERROR_SUCCESS == status)
+    {
+        if (DecodingSourceXMLFile == DecodingSource)
+        {
+            RemoveTrailingSpace(pMapInfo);
+        }
+    }
+    else
+    {
+        if  (ERROR_NOT_FOUND == status)
+        {
+            status = ERROR_SUCCESS; // This case is okay.
+        }
+        else
+        {
+            wprintf(L"TdhGetEventMapInformation failed with 0x%x.\n", status);
+        }
+    }
+
+cleanup:
+
+    return status;
+}
+*/
+
+func (e *EventRecord) GetMapInfo(pMapName *uint16, decodingSource uint32) (pMapInfo *EventMapInfo, err error) {
+	var buff []byte
+	if buff, err = e.mapInformation(pMapName); err == nil && buff != nil {
+		pMapInfo = ((*EventMapInfo)(unsafe.Pointer(&buff[0])))
+		if DecodingSource(decodingSource) == DecodingSourceXMLFile {
+			pMapInfo.RemoveTrailingSpace()
+		}
+	}
+	return
+}
+
+// mapInformation returns the buffer filled by TdhGetEventMapInformation,
+// nil without error if the map is not found
+func (e *EventRecord) mapInformation(pMapName *uint16) (buff []byte, err error) {
+	mapSize := uint32(64)
+	buff = make([]byte, mapSize)
+	err = TdhGetEventMapInformation(e, pMapName, (*EventMapInfo)(unsafe.Pointer(&buff[0])), &mapSize)
+
+	if err == ERROR_INSUFFICIENT_BUFFER {
+		buff = make([]byte, mapSize)
+		err = TdhGetEventMapInformation(e, pMapName, (*EventMapInfo)(unsafe.Pointer(&buff[0])), &mapSize)
+	}
+
+	if err != nil {
+		buff = nil
+	}
+
+	if err == ERROR_NOT_FOUND {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,897 @@
+package etw
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+const (
+	fakeProviderName = "Fake-Provider"
+	fakePropertyName = "Value"
+)
+
+var (
+	fakeProviderGUID = MustParseGUIDFromString("{3D6FA8D0-FE05-11D0-9DDA-00C04FD7BA7C}")
+)
+
+// newFakeEventInformation builds a TraceEventInfo buffer laid out as
+// returned by TdhGetEventInformation, strings following the structure.
+// The event has a single UInt32 property.
+func newFakeEventInformation(guid GUID, name string, id uint16) []byte {
+	size := unsafe.Sizeof(TraceEventInfo{})
+	pname := utf16.Encode([]rune(name + "\x00"))
+	vname := utf16.Encode([]rune(fakePropertyName + "\x00"))
+	total := size + uintptr(len(pname)+len(vname))*2
+
+	// uint64 slice guarantees structure alignment
+	buf := make([]uint64, (total+7)/8)
+	tei := (*TraceEventInfo)(unsafe.Pointer(&buf[0]))
+	strs := unsafe.Slice((*uint16)(unsafe.Add(unsafe.Pointer(tei), size)), len(pname)+len(vname))
+	copy(strs, pname)
+	copy(strs[len(pname):], vname)
+
+	tei.ProviderGUID = guid
+	tei.EventDescriptor.Id = id
+	tei.DecodingSource = DecodingSourceXMLFile
+	tei.ProviderNameOffset = uint32(size)
+	tei.PropertyCount = 1
+	tei.TopLevelPropertyCount = 1
+
+	epi := &tei.EventPropertyInfoArray[0]
+	epi.NameOffset = uint32(size) + uint32(len(pname)*2)
+	epi.TypeUnion.u1 = uint16(TdhInTypeUint32)
+	epi.TypeUnion.u2 = uint16(TdhOutTypeUnsignedint)
+	epi.CountUnion = 1
+	epi.LengthUnion = 4
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(tei)), total)
+}
+
+type fakeTrace struct {
+	src      traceSource
+	consumer traceConsumer
+	closed   chan struct{}
+}
+
+type processCall struct {
+	handles    []uint64
+	start, end time.Time
+}
+
+// fakeBackend is an in-memory backend. Sessions are tracked by name,
+// records injected in a trace are delivered when it is processed and
+// real-time traces are processed until they get closed. Event information
+// is built for the providers found in names, unless info is set in which
+// case it is given for any event.
+type fakeBackend struct {
+	sync.Mutex
+	next      uint64
+	sessions  map[string]uint64
+	providers map[uint64][]Provider
+	stacks    map[uint64][]ClassicEventID
+	traces    map[uint64]*fakeTrace
+	records   map[string][]*EventRecord
+	userData  map[*EventRecord][]byte
+	names     map[GUID]string
+	info      []byte
+	maps      map[string][]byte
+	// when set, processTrace calls block until release is closed
+	// instead of delivering records
+	release chan struct{}
+
+	stopped       []string
+	providerCalls []string
+	processCalls  []processCall
+	closed        []uint64
+	infoCalls     int
+	mapCalls      int
+	tdhCalls      int
+
+	startErr   error
+	enableErr  error
+	disableErr error
+	openErr    error
+	processErr error
+	closeErr   error
+	infoErr    error
+	sizeErr    error
+	formatErr  error
+}
+
+func newFakeBackend() *fakeBackend {
+	b := &fakeBackend{
+		sessions:  make(map[string]uint64),
+		providers: make(map[uint64][]Provider),
+		stacks:    make(map[uint64][]ClassicEventID),
+		traces:    make(map[uint64]*fakeTrace),
+		records:   make(map[string][]*EventRecord),
+		userData:  make(map[*EventRecord][]byte),
+		names:     map[GUID]string{*fakeProviderGUID: fakeProviderName},
+		maps:      make(map[string][]byte),
+	}
+	return b
+}
+
+// newFakeBackendWithInfo creates a fakeBackend giving info as the
+// information of any event
+func newFakeBackendWithInfo(info []byte) *fakeBackend {
+	// uint64 slice guarantees structure alignment
+	aligned := make([]uint64, len(info)/8+1)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&aligned[0])), len(info))
+	copy(buf, info)
+
+	b := newFakeBackend()
+	b.info = buf
+	return b
+}
+
+// record creates an event record whose user data is the first length
+// bytes of data, data being kept along with the record
+func (b *fakeBackend) record(data []byte, length int, flags uint16) *EventRecord {
+	er := &EventRecord{}
+	er.EventHeader.Flags = flags
+	er.UserData = uintptr(unsafe.Pointer(&data[0]))
+	er.UserDataLength = uint16(length)
+
+	b.Lock()
+	defer b.Unlock()
+	b.userData[er] = data[:length]
+
+	return er
+}
+
+// inject adds an event record of the fake provider carrying value to trace
+func (b *fakeBackend) inject(trace string, id uint16, value uint32) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, value)
+	b.injectData(trace, id, data)
+}
+
+// injectData adds an event record of the fake provider with user data to
+// trace
+func (b *fakeBackend) injectData(trace string, id uint16, data []byte) {
+	er := b.record(data, len(data), 0)
+	er.EventHeader.ProviderId = *fakeProviderGUID
+	er.EventHeader.EventDescriptor.Id = id
+	er.EventHeader.ProcessId = 4
+	er.EventHeader.TimeStamp = TimeToFiletime(time.Now())
+
+	b.Lock()
+	defer b.Unlock()
+	b.records[trace] = append(b.records[trace], er)
+}
+
+// injectLost adds a lost event notification to trace
+func (b *fakeBackend) injectLost(trace string) {
+	er := &EventRecord{}
+	er.EventHeader.ProviderId = *rtLostEventGuid
+
+	b.Lock()
+	defer b.Unlock()
+	b.records[trace] = append(b.records[trace], er)
+}
+
+// prepare prepares the properties of an event record
+func (b *fakeBackend) prepare(er *EventRecord, cache *SchemaCache) (h *EventRecordHelper, err error) {
+	if h, err = newEventRecordHelper(er, b, cache); err != nil {
+		return
+	}
+
+	h.initialize()
+	err = h.prepareProperties()
+
+	return
+}
+
+// enabled returns the provider enabled on a session given its GUID
+func (b *fakeBackend) enabled(handle uint64, guid string) (Provider, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, p := range b.providers[handle] {
+		if sameGUID(p.GUID, guid) {
+			return p, true
+		}
+	}
+
+	return Provider{}, false
+}
+
+func (b *fakeBackend) startTrace(name string, props *EventTraceProperties) (uint64, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.startErr != nil {
+		return 0, b.startErr
+	}
+
+	if _, ok := b.sessions[name]; ok {
+		return 0, ERROR_ALREADY_EXISTS
+	}
+
+	b.next++
+	b.sessions[name] = b.next
+	return b.next, nil
+}
+
+func (b *fakeBackend) stopTrace(handle uint64, name string, props *EventTraceProperties) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for n, h := range b.sessions {
+		if (handle != 0 && h == handle) || (handle == 0 && n == name) {
+			delete(b.sessions, n)
+			b.stopped = append(b.stopped, n)
+			return nil
+		}
+	}
+
+	return ERROR_WMI_INSTANCE_NOT_FOUND
+}
+
+func (b *fakeBackend) enableProvider(handle uint64, prov *Provider) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.enableErr != nil {
+		return b.enableErr
+	}
+
+	b.providerCalls = append(b.providerCalls, "enable "+prov.GUID)
+	// enabling an enabled provider updates it
+	for i, p := range b.providers[handle] {
+		if sameGUID(p.GUID, prov.GUID) {
+			b.providers[handle][i] = *prov
+			return nil
+		}
+	}
+	b.providers[handle] = append(b.providers[handle], *prov)
+
+	return nil
+}
+
+func (b *fakeBackend) disableProvider(handle uint64, prov *Provider) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.disableErr != nil {
+		return b.disableErr
+	}
+
+	for i, p := range b.providers[handle] {
+		if sameGUID(p.GUID, prov.GUID) {
+			b.providerCalls = append(b.providerCalls, "disable "+prov.GUID)
+			b.providers[handle] = append(b.providers[handle][:i], b.providers[handle][i+1:]...)
+			return nil
+		}
+	}
+
+	return ERROR_NOT_FOUND
+}
+
+func (b *fakeBackend) enableStackTracing(handle uint64, ids []ClassicEventID) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.stacks[handle] = ids
+	return nil
+}
+
+func (b *fakeBackend) openTrace(src traceSource, c traceConsumer) (uint64, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.openErr != nil {
+		return 0, b.openErr
+	}
+
+	b.next++
+	b.traces[b.next] = &fakeTrace{src: src, consumer: c, closed: make(chan struct{})}
+	return b.next, nil
+}
+
+func (b *fakeBackend) processTrace(handles []uint64, start, end time.Time) error {
+	b.Lock()
+	b.processCalls = append(b.processCalls, processCall{handles, start, end})
+	release := b.release
+	b.Unlock()
+
+	if release != nil {
+		<-release
+		return b.processErr
+	}
+
+	for _, h := range handles {
+		b.Lock()
+		t, ok := b.traces[h]
+		b.Unlock()
+
+		// trace closed before being processed
+		if !ok {
+			continue
+		}
+
+		b.Lock()
+		records := b.records[t.src.name]
+		b.Unlock()
+
+		for _, er := range records {
+			if !t.consumer.keepProcessing() {
+				break
+			}
+			t.consumer.processRecord(er)
+		}
+
+		if !t.src.logFile {
+			<-t.closed
+		}
+	}
+
+	return b.processErr
+}
+
+func (b *fakeBackend) closeTrace(handle uint64) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.closed = append(b.closed, handle)
+	if t, ok := b.traces[handle]; ok {
+		close(t.closed)
+		delete(b.traces, handle)
+	}
+
+	return b.closeErr
+}
+
+func (b *fakeBackend) eventInformation(er *EventRecord) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.infoCalls++
+	switch {
+	case b.infoErr != nil:
+		return nil, b.infoErr
+	case b.info != nil:
+		return b.info, nil
+	}
+
+	if name, ok := b.names[er.EventHeader.ProviderId]; ok {
+		return newFakeEventInformation(er.EventHeader.ProviderId, name, er.EventHeader.EventDescriptor.Id), nil
+	}
+
+	return nil, ERROR_NOT_FOUND
+}
+
+func (b *fakeBackend) mapInformation(er *EventRecord, name string) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.mapCalls++
+	if m, ok := b.maps[name]; ok {
+		// TDH gives a new buffer at every call
+		return append([]byte{}, m...), nil
+	}
+
+	return nil, nil
+}
+
+func (b *fakeBackend) propertySize(er *EventRecord, desc *PropertyDataDescriptor) (uint32, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.tdhCalls++
+	if b.sizeErr != nil {
+		return 0, b.sizeErr
+	}
+	return 4, nil
+}
+
+func (b *fakeBackend) property(er *EventRecord, desc *PropertyDataDescriptor, buf []byte) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.tdhCalls++
+	copy(buf, b.userData[er])
+	return nil
+}
+
+func (b *fakeBackend) formatProperty(tei *TraceEventInfo, mapInfo *EventMapInfo, pointerSize uint32,
+	inType, outType, length uint16, userData []byte) (string, error) {
+	if b.formatErr != nil {
+		return "", b.formatErr
+	}
+
+	if TdhInType(inType) != TdhInTypeUint32 || len(userData) < int(length) {
+		return "", ERROR_EVT_INVALID_EVENT_DATA
+	}
+
+	return fmt.Sprint(binary.LittleEndian.Uint32(userData)), nil
+}
+
+// consume waits for n events of the consumer
+func consume(t *testing.T, c *Consumer, n int) (events []*Event) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for len(events) < n {
+		select {
+		case e := <-c.Events:
+			events = append(events, e)
+		case <-timeout:
+			t.Fatalf("received %d events out of %d", len(events), n)
+		}
+	}
+
+	return
+}
+
+func TestUnsupportedBackend(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s := newRealTimeSession("EtwUnsupported", unsupportedBackend{})
+	tt.Assert(errors.Is(s.Start(), ErrUnsupportedPlatform))
+	tt.Assert(!s.IsStarted())
+	tt.Assert(errors.Is(s.EnableProvider(Provider{GUID: fakeProviderGUID.String()}), ErrUnsupportedPlatform))
+
+	c := newConsumer(context.Background(), unsupportedBackend{}).FromTraceNames("EtwUnsupported")
+	tt.Assert(errors.Is(c.Start(), ErrUnsupportedPlatform))
+	tt.CheckErr(c.Stop())
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	s := newRealTimeSession("EtwSession", b)
+
+	// enabling a provider starts the session
+	prov := Provider{GUID: fakeProviderGUID.String(), EnableLevel: 4}
+	tt.CheckErr(s.EnableProvider(prov))
+	tt.Assert(s.IsStarted())
+	tt.Assert(b.sessions["EtwSession"] == s.providers.handle)
+	tt.Assert(len(b.providers[s.providers.handle]) == 1)
+	tt.Assert(len(s.Providers()) == 1)
+
+	tt.CheckErr(s.EnableKernelStackTracing(ClassicEventID{EventGuid: *fakeProviderGUID, Type: 1}))
+	tt.Assert(len(b.stacks[s.providers.handle]) == 1)
+
+	tt.CheckErr(s.DisableProvider(prov.GUID))
+	tt.Assert(len(b.providers[s.providers.handle]) == 0)
+	tt.Assert(len(s.Providers()) == 0)
+
+	tt.CheckErr(s.Stop())
+	tt.Assert(!s.IsStarted())
+	tt.Assert(len(b.sessions) == 0)
+
+	// stopping a stopped session fails
+	tt.Assert(s.Stop() != nil)
+
+	b.enableErr = errors.New("enable error")
+	tt.Assert(errors.Is(s.EnableProvider(prov), b.enableErr))
+	// the session got started anyway
+	tt.Assert(s.IsStarted())
+	tt.Assert(len(s.Providers()) == 0)
+	tt.CheckErr(s.Stop())
+
+	b.startErr = errors.New("start error")
+	tt.Assert(errors.Is(s.Start(), b.startErr))
+	tt.Assert(!s.IsStarted())
+}
+
+func TestSessionRestart(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+
+	// session left running by another process
+	stale := newRealTimeSession("EtwSession", b)
+	tt.CheckErr(stale.Start())
+
+	s := newRealTimeSession("EtwSession", b)
+	tt.CheckErr(s.Start())
+	tt.Assert(s.IsStarted())
+	tt.Assert(s.providers.handle != stale.providers.handle)
+	tt.Assert(b.sessions["EtwSession"] == s.providers.handle)
+	tt.Assert(len(b.stopped) == 1 && b.stopped[0] == "EtwSession")
+
+	// starting again is a no-op
+	tt.CheckErr(s.Start())
+	tt.Assert(len(b.stopped) == 1)
+}
+
+func TestSessionConcurrentProviders(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	s := newRealTimeSession("EtwSession", b)
+	prov := Provider{GUID: fakeProviderGUID.String()}
+
+	// providers are changed while the session is restarted, errors
+	// depending on the order of the operations
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.EnableProvider(prov)
+			s.UpdateProvider(prov)
+			s.EnableKernelStackTracing(ClassicEventID{})
+			s.DisableProvider(prov.GUID)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Start()
+			s.IsStarted()
+			s.Stop()
+		}
+	}()
+	wg.Wait()
+
+	s.Stop()
+	tt.Assert(!s.IsStarted())
+}
+
+func TestConsumerLifecycle(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	s := newRealTimeSession("EtwSession", b)
+	tt.CheckErr(s.EnableProvider(Provider{GUID: fakeProviderGUID.String(), Filter: []uint16{42}}))
+
+	for i := 0; i < 10; i++ {
+		b.inject("EtwSession", 42, uint32(i))
+		// filtered out by provider filter
+		b.inject("EtwSession", 43, uint32(i))
+	}
+	b.injectLost("EtwSession")
+
+	c := newConsumer(context.Background(), b).FromSessions(s)
+	tt.CheckErr(c.Start())
+
+	events := consume(t, c, 10)
+	for i, e := range events {
+		tt.Assert(e.System.EventID == 42)
+		tt.Assert(e.System.Provider.Name == fakeProviderName)
+		tt.Assert(e.System.Provider.Guid.Equals(fakeProviderGUID))
+		tt.Assert(e.System.Execution.ProcessID == 4)
+		tt.Assert(e.EventData[fakePropertyName] == fmt.Sprint(i))
+	}
+
+	// real-time traces are processed until the consumer is stopped
+	select {
+	case <-c.Completed():
+		t.Error("consumer completed before being stopped")
+	default:
+	}
+
+	tt.CheckErr(c.Stop())
+	<-c.Completed()
+	tt.CheckErr(c.Err())
+	tt.Assert(atomic.LoadUint64(&c.LostEvents) == 1)
+	tt.Assert(len(b.traces) == 0)
+
+	// events channel is closed
+	_, ok := <-c.Events
+	tt.Assert(!ok)
+
+	// stopping twice is harmless
+	tt.CheckErr(c.Stop())
+	tt.CheckErr(s.Stop())
+}
+
+func TestConsumerProviderFilters(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// too many event IDs to be filtered by ETW
+	prov := Provider{GUID: fakeProviderGUID.String()}
+	for id := uint16(0); id <= MAX_EVENT_FILTER_EVENT_ID_COUNT; id++ {
+		prov.Filter = append(prov.Filter, id+100)
+	}
+
+	b := newFakeBackend()
+	s := newRealTimeSession("EtwSession", b)
+	tt.CheckErr(s.EnableProvider(prov))
+
+	for i := 0; i < 10; i++ {
+		b.inject("EtwSession", 142, uint32(i))
+		b.inject("EtwSession", 42, uint32(i))
+	}
+
+	// consumer side filters follow the providers of the session
+	c := newConsumer(context.Background(), b).FromSessions(s)
+	tt.CheckErr(c.Start())
+	for _, e := range consume(t, c, 10) {
+		tt.Assert(e.System.EventID == 142)
+	}
+
+	// and providers updated on the session while consuming
+	prov.FilterOut = true
+	tt.CheckErr(s.UpdateProvider(prov))
+	tt.Assert(c.Filter.Match(testIEvent{prov.GUID, 42}))
+	tt.Assert(!c.Filter.Match(testIEvent{prov.GUID, 142}))
+
+	// events of disabled providers left in buffers are filtered out
+	tt.CheckErr(s.DisableProvider(prov.GUID))
+	tt.Assert(!c.Filter.Match(testIEvent{prov.GUID, 42}))
+	tt.CheckErr(s.EnableProvider(Provider{GUID: prov.GUID}))
+	tt.Assert(c.Filter.Match(testIEvent{prov.GUID, 42}))
+
+	// consumers of the trace not created from the session are unrelated
+	other := newConsumer(context.Background(), b).FromTraceNames("EtwSession")
+	tt.CheckErr(s.UpdateProvider(Provider{GUID: prov.GUID, Filter: []uint16{1}}))
+	tt.Assert(!c.Filter.Match(testIEvent{prov.GUID, 42}))
+	tt.Assert(other.Filter.Match(testIEvent{prov.GUID, 42}))
+
+	// stopped consumers are not notified anymore
+	tt.CheckErr(c.Stop())
+	tt.CheckErr(s.UpdateProvider(Provider{GUID: prov.GUID}))
+	tt.Assert(!c.Filter.Match(testIEvent{prov.GUID, 42}))
+	tt.CheckErr(s.Stop())
+}
+
+func TestConsumerLogFiles(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 5; i++ {
+		b.inject("trace.etl", 42, 0)
+	}
+
+	dedup, err := NewDeduplicator(time.Hour, 16, "EventData.Value")
+	tt.CheckErr(err)
+
+	c := newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.Dedup = dedup
+	tt.CheckErr(c.Start())
+
+	select {
+	case <-c.Completed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("log file replay did not complete")
+	}
+
+	tt.CheckErr(c.Stop())
+
+	// duplicates are flushed when consumer is closed
+	events := consume(t, c, 1)
+	tt.Assert(events[0].Dedup.Count == 5)
+}
+
+func TestConsumerTypedEventData(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 10; i++ {
+		b.inject("trace.etl", 42, uint32(i))
+	}
+
+	c := newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.TypedEventData = true
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+	tt.CheckErr(c.Err())
+
+	i := uint32(0)
+	for e := range c.Events {
+		tt.Assert(e.EventData[fakePropertyName] == i)
+		i++
+	}
+	tt.Assert(i == 10)
+}
+
+func TestConsumerDedupFlush(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 9; i++ {
+		b.inject("trace.etl", 42, uint32(i%3))
+	}
+
+	start := func(ctx context.Context) *Consumer {
+		dedup, err := NewDeduplicator(time.Hour, 16, "EventData.Value")
+		tt.CheckErr(err)
+
+		c := newConsumer(ctx, b).FromLogFiles("trace.etl")
+		c.Dedup = dedup
+		c.Events = make(chan *Event, 1)
+		tt.CheckErr(c.Start())
+		<-c.Completed()
+		return c
+	}
+
+	// pending events are not dropped when Events is full
+	c := start(context.Background())
+	go c.Stop()
+	count := 0
+	for e := range c.Events {
+		tt.Assert(e.Dedup.Count == 3)
+		count++
+	}
+	tt.Assert(count == 3)
+	tt.Assert(atomic.LoadUint64(&c.Skipped) == 0)
+
+	// unless the context of the consumer is done
+	ctx, cancel := context.WithCancel(context.Background())
+	c = start(ctx)
+	cancel()
+	tt.CheckErr(c.Stop())
+	tt.Assert(len(c.Events) == 1)
+	tt.Assert(atomic.LoadUint64(&c.Skipped) == 2)
+
+	// deduplication does not depend on DefaultEventCallback
+	dedup, err := NewDeduplicator(time.Hour, 16, "EventData.Value")
+	tt.CheckErr(err)
+
+	var events []*Event
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.Dedup = dedup
+	c.EventCallback = func(e *Event) error {
+		events = append(events, e)
+		return nil
+	}
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+	tt.Assert(len(events) == 3)
+	for _, e := range events {
+		tt.Assert(e.Dedup.Count == 3)
+	}
+}
+
+func TestConsumerStopBlockedSend(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 3; i++ {
+		b.inject("trace.etl", 42, uint32(i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan struct{})
+	prepared := 0
+
+	c := newConsumer(ctx, b).FromLogFiles("trace.etl")
+	c.Events = make(chan *Event, 1)
+	c.PreparedCallback = func(*EventRecordHelper) error {
+		if prepared++; prepared == 2 {
+			close(second)
+		}
+		return nil
+	}
+	tt.CheckErr(c.Start())
+
+	// second event waits for room in Events, it must not prevent the
+	// consumer from being stopped
+	<-second
+	cancel()
+	tt.CheckErr(c.Stop())
+	tt.Assert(len(c.Events) == 1)
+	tt.Assert(prepared == 2)
+}
+
+func TestConsumerSampling(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 10; i++ {
+		b.inject("trace.etl", 42, uint32(i))
+	}
+
+	sampler, err := NewSamplingStage(SamplingRule{Provider: fakeProviderGUID.String(), Policy: OneInN{N: 2}})
+	tt.CheckErr(err)
+
+	parsed := 0
+	c := newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.Sampler = sampler
+	c.PreparedCallback = func(*EventRecordHelper) error {
+		parsed++
+		return nil
+	}
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+
+	tt.Assert(len(c.Events) == 5)
+	tt.Assert(parsed == 5)
+	tt.Assert(sampler.Dropped() == 5)
+
+	// events held by a reservoir are output when the consumer is stopped
+	sampler, err = NewSamplingStage(SamplingRule{Policy: Reservoir{Size: 3, Window: time.Hour}})
+	tt.CheckErr(err)
+
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.Sampler = sampler
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.Assert(len(c.Events) == 0)
+	tt.CheckErr(c.Stop())
+
+	tt.Assert(len(c.Events) == 3)
+	tt.Assert(sampler.Dropped() == 7)
+}
+
+func TestConsumerErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// open error
+	b := newFakeBackend()
+	b.openErr = errors.New("open error")
+	c := newConsumer(context.Background(), b).FromTraceNames("EtwSession")
+	tt.Assert(errors.Is(c.Start(), b.openErr))
+	// nothing gets processed
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+
+	// consumer stopped without being started
+	c = newConsumer(context.Background(), newFakeBackend()).FromTraceNames("EtwSession")
+	tt.CheckErr(c.Stop())
+	<-c.Completed()
+
+	// process and close errors
+	b = newFakeBackend()
+	b.processErr = errors.New("process error")
+	b.closeErr = errors.New("close error")
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.Assert(errors.Is(c.Err(), b.processErr))
+	tt.Assert(errors.Is(c.Stop(), b.closeErr))
+
+	// property errors
+	b = newFakeBackend()
+	b.injectData("trace.etl", 42, []byte{0, 0})
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+	tt.Assert(errors.Is(c.Err(), ErrPropertyParsing))
+	tt.Assert(len(c.Events) == 0)
+
+	b = newFakeBackend()
+	b.inject("trace.etl", 42, 0)
+	b.formatErr = errors.New("format error")
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+	tt.Assert(errors.Is(c.Err(), ErrPropertyParsing))
+	// event is delivered without the property failing to parse
+	tt.Assert(len(c.Events) == 1)
+}
@@ -1,6 +1,3 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
@@ -8,7 +5,13 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// number of times held and deduplicated events are expired per window
+	expireTicks = 8
 )
 
 var (
@@ -38,12 +41,25 @@ func SessionSlice(i interface{}) (out []Session) {
 }
 
 type Consumer struct {
+	// Counters are updated atomically and must be read with
+	// atomic.LoadUint64, they come first to be 64-bit aligned
+	LostEvents uint64
+	Skipped    uint64
+
 	sync.WaitGroup
-	ctx          context.Context
-	cancel       context.CancelFunc
-	traceHandles []syscall.Handle
-	lastError    error
-	closed       bool
+	// context the consumer is created with
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	backend backend
+	traces  *traceRunner
+	// sessions notifying the consumer of the changes made to their
+	// providers (see FromSessions)
+	notifiers []providerNotifier
+	lastError error
+	closed    bool
+	// set while Stop flushes pending events
+	flushing bool
 
 	// First callback executed, it allows to filter out events
 	// based on fields of raw ETW EventRecord structure. When this callback
@@ -67,25 +83,53 @@ type Consumer struct {
 	// with the event (printed, sent to a channel ...)
 	EventCallback func(*Event) error
 
+	// Names of the real-time sessions to consume
 	Traces map[string]bool
+	// Paths of the log files (.etl) to consume
+	LogFiles map[string]bool
+	// Time bounds of the events consumed from log files, ignored
+	// when zero
+	StartTime time.Time
+	EndTime   time.Time
+	// Filter applied in DefaultEventRecordCallback, it can be
+	// any combination of filters (see And, Or, Not ...)
 	Filter EventFilter
-	Events chan *Event
-
-	LostEvents uint64
-
-	Skipped uint64
+	// Sampling applied to events passing Filter, before they are
+	// parsed. Drop counters are reported by SamplingStage.Stats and
+	// events held by Reservoir policies are passed to EventCallback
+	// when their window elapses
+	Sampler *SamplingStage
+	// Deduplication applied to parsed events, duplicated events are
+	// collapsed before being passed to EventCallback
+	Dedup *Deduplicator
+	// Cache of the schemas of events, set to nil to query TDH for every
+	// event
+	Schemas *SchemaCache
+	// Decode EventData and UserData into native Go values (integers,
+	// time.Time, net.IP ...) instead of strings formatted by TDH, see
+	// Property.Decode. Properties with a value map are still formatted.
+	TypedEventData bool
+	Events         chan *Event
 }
 
 // NewRealTimeConsumer creates a new Consumer to consume ETW
 // in RealTime mode
 func NewRealTimeConsumer(ctx context.Context) (c *Consumer) {
+	return newConsumer(ctx, defaultBackend)
+}
+
+func newConsumer(ctx context.Context, backend backend) (c *Consumer) {
 	c = &Consumer{
-		traceHandles: make([]syscall.Handle, 0, 64),
-		Traces:       make(map[string]bool),
-		Filter:       NewProviderFilter(),
-		Events:       make(chan *Event, 4096),
+		backend:  backend,
+		Traces:   make(map[string]bool),
+		LogFiles: make(map[string]bool),
+		Filter:   NewProviderFilter(),
+		Schemas:  NewSchemaCache(DefaultSchemaCacheCapacity),
+		Events:   make(chan *Event, 4096),
 	}
 
+	c.traces = newTraceRunner(backend, c)
+	c.parent = ctx
 	c.ctx, c.cancel = context.WithCancel(ctx)
 	c.EventRecordHelperCallback = c.DefaultEventRecordCallback
 	c.EventCallback = c.DefaultEventCallback
@@ -93,21 +137,19 @@ func NewRealTimeConsumer(ctx context.Context) (c *Consumer) {
 	return c
 }
 
-func (c *Consumer) bufferCallback(e *EventTraceLogfile) uintptr {
-	if c.ctx.Err() != nil {
-		// if the consumer has been stopped we
-		// don't process event records anymore
-		return 0
-	}
-	// we keep processing event records
-	return 1
+// keepProcessing implements traceConsumer
+func (c *Consumer) keepProcessing() bool {
+	// if the consumer has been stopped we
+	// don't process event records anymore
+	return c.ctx.Err() == nil
 }
 
-func (c *Consumer) callback(er *EventRecord) (rc uintptr) {
+// processRecord implements traceConsumer
+func (c *Consumer) processRecord(er *EventRecord) {
 	var event *Event
 
 	if er.EventHeader.ProviderId.Equals(rtLostEventGuid) {
-		c.LostEvents++
+		atomic.AddUint64(&c.LostEvents, 1)
 	}
 
 	// calling EventHeaderCallback if possible
@@ -118,7 +160,7 @@ func (c *Consumer) callback(er *EventRecord) (rc uintptr) {
 	}
 
 	// we get the consumer from user context
-	if h, err := newEventRecordHelper(er); err == nil {
+	if h, err := newEventRecordHelper(er, c.backend, c.Schemas); err == nil {
 
 		if c.EventRecordHelperCallback != nil {
 			if err = c.EventRecordHelperCallback(h); err != nil {
@@ -131,10 +173,19 @@ func (c *Consumer) callback(er *EventRecord) (rc uintptr) {
 			return
 		}
 
+		// sampling happens before parsing so that dropped
+		// events are never parsed
+		if c.Sampler != nil && !c.Sampler.Sample(h) {
+			return
+		}
+
 		// initialize record helper
 		h.initialize()
 
 		if err := h.prepareProperties(); err != nil {
+			// schema might be outdated if the provider registered a new
+			// manifest, it is reloaded for the next event
+			c.Schemas.invalidate(h.metadata)
 			c.lastError = err
 			return
 		}
@@ -151,27 +202,42 @@ func (c *Consumer) callback(er *EventRecord) (rc uintptr) {
 			return
 		}
 
+		h.typed = c.TypedEventData
 		if event, err = h.buildEvent(); err != nil {
 			c.lastError = err
 		}
 
-		if err := c.EventCallback(event); err != nil {
-			c.lastError = err
+		// events held by a Reservoir are output when its window elapses
+		if c.Sampler != nil {
+			c.dedup(c.Sampler.Process(event)...)
+			return
 		}
+
+		c.dedup(event)
 	}
 
 	return
 }
 
-func (c *Consumer) newRealTimeLogfile() (loggerInfo EventTraceLogfile) {
-	// PROCESS_TRACE_MODE_EVENT_RECORD to receive EventRecords (new format)
-	// PROCESS_TRACE_MODE_RAW_TIMESTAMP don't convert TimeStamp member of EVENT_HEADER and EVENT_TRACE_HEADER converted to system time
-	// PROCESS_TRACE_MODE_REAL_TIME to receive events in real time
-	//loggerInfo.SetProcessTraceMode(PROCESS_TRACE_MODE_EVENT_RECORD | PROCESS_TRACE_MODE_RAW_TIMESTAMP | PROCESS_TRACE_MODE_REAL_TIME)
-	loggerInfo.SetProcessTraceMode(PROCESS_TRACE_MODE_EVENT_RECORD | PROCESS_TRACE_MODE_REAL_TIME)
-	loggerInfo.BufferCallback = syscall.NewCallbackCDecl(c.bufferCallback)
-	loggerInfo.Callback = syscall.NewCallbackCDecl(c.callback)
-	return
+// dedup passes the events output by Dedup to EventCallback
+func (c *Consumer) dedup(events ...*Event) {
+	if c.Dedup == nil {
+		c.emit(events...)
+		return
+	}
+
+	for _, e := range events {
+		c.emit(c.Dedup.Process(e)...)
+	}
+}
+
+// emit passes events to EventCallback
+func (c *Consumer) emit(events ...*Event) {
+	for _, e := range events {
+		if err := c.EventCallback(e); err != nil {
+			c.lastError = err
+		}
+	}
 }
 
 // close closes the Consumer and eventually waits for ProcessTraces calls
@@ -181,55 +247,69 @@ func (c *Consumer) close(wait bool) (lastErr error) {
 		return
 	}
 
-	// closing trace handles
-	for _, h := range c.traceHandles {
-		// if we don't wait for traces ERROR_CTX_CLOSE_PENDING is a valid error
-		if err := CloseTrace(h); err != nil && err != ERROR_CTX_CLOSE_PENDING {
-			lastErr = err
-		}
+	for _, n := range c.notifiers {
+		n.unwatchProviders(c)
 	}
 
+	// closing trace handles
+	lastErr = c.traces.close()
+
 	if wait {
+		c.traces.Wait()
 		c.Wait()
 	}
 
+	// pending events are passed to EventCallback, DefaultEventCallback
+	// waiting for room in Events unless they are skippable or the context
+	// the consumer is created with is done
+	c.flushing = true
+	if c.EventCallback != nil {
+		if c.Sampler != nil {
+			c.dedup(c.Sampler.Flush()...)
+		}
+
+		if c.Dedup != nil {
+			c.emit(c.Dedup.Flush()...)
+		}
+	}
+
 	close(c.Events)
 	c.closed = true
 
 	return
 }
 
-// OpenTrace opens a
+// OpenTrace opens a real-time trace given the name of its session
 func (c *Consumer) OpenTrace(name string) (err error) {
-	var traceHandle syscall.Handle
-
-	loggerInfo := c.newRealTimeLogfile()
-
-	// We use the session name to open the trace
-	if loggerInfo.LoggerName, err = syscall.UTF16PtrFromString(name); err != nil {
-		return err
-	}
-
-	if traceHandle, err = OpenTrace(&loggerInfo); err != nil {
-		return err
-	}
+	return c.traces.open(traceSource{name: name})
+}
 
-	c.traceHandles = append(c.traceHandles, syscall.Handle(traceHandle))
-	return nil
+// OpenLogFile opens a log file given its path
+func (c *Consumer) OpenLogFile(path string) (err error) {
+	return c.traces.open(traceSource{name: path, logFile: true})
 }
 
-// FromSessions initializes the consumer from sessions
+// FromSessions initializes the consumer from sessions. Consumer side
+// filtering of a RealTimeSession follows the providers enabled, updated and
+// disabled on it until the consumer is stopped.
 func (c *Consumer) FromSessions(sessions ...Session) *Consumer {
 
 	for _, s := range sessions {
-		c.InitFilters(s.Providers())
+		if n, ok := s.(providerNotifier); ok {
+			n.watchProviders(c)
+			c.notifiers = append(c.notifiers, n)
+		} else {
+			c.InitFilters(s.Providers())
+		}
 		c.Traces[s.TraceName()] = true
 	}
 
 	return c
 }
 
-// FromTraceNames initializes consumer from existing traces
+// FromTraceNames initializes consumer from existing traces. Consumer side
+// filtering has to be initialized with InitFilters or UpdateProvider, use
+// FromSessions for the sessions of this process.
 func (c *Consumer) FromTraceNames(names ...string) *Consumer {
 	for _, n := range names {
 		c.Traces[n] = true
@@ -237,46 +317,149 @@ func (c *Consumer) FromTraceNames(names ...string) *Consumer {
 	return c
 }
 
+// FromLogFiles initializes consumer from log files (.etl) to be replayed
+// through the same callbacks and filters as real-time traces. Log files
+// are processed altogether, events being delivered in chronological
+// order, and Completed is closed when all of them have been processed.
+func (c *Consumer) FromLogFiles(paths ...string) *Consumer {
+	for _, p := range paths {
+		c.LogFiles[p] = true
+	}
+	return c
+}
+
 // InitFilters initializes event filtering from a Provider slice
 func (c *Consumer) InitFilters(providers []Provider) {
+	if c.Filter == nil {
+		return
+	}
+
 	for _, p := range providers {
 		c.Filter.Update(&p)
 	}
 }
 
+// UpdateProvider updates consumer side filtering of a provider. It is called
+// along with RealTimeSession.EnableProvider or UpdateProvider for the
+// sessions given to FromSessions, it is safe to call while the consumer is
+// running.
+func (c *Consumer) UpdateProvider(p Provider) {
+	if c.Filter == nil {
+		return
+	}
+
+	c.Filter.Update(&p)
+}
+
+// RemoveProvider notifies consumer side filtering that a provider got
+// disabled, its events still in ETW buffers being filtered out until it is
+// enabled again. It is called along with RealTimeSession.DisableProvider
+// for the sessions given to FromSessions, it is safe to call while the
+// consumer is running.
+func (c *Consumer) RemoveProvider(guid string) {
+	if c.Filter == nil {
+		return
+	}
+
+	removeProvider(c.Filter, guid)
+}
+
 // DefaultEventRecordCallback is the default EventRecordCallback method applied
 // to Consumer created with NewRealTimeConsumer
 func (c *Consumer) DefaultEventRecordCallback(h *EventRecordHelper) error {
-	h.Flags.Skip = !c.Filter.Match(h)
+	h.Flags.Skip = c.Filter != nil && !c.Filter.Match(h)
 	return nil
 }
 
 // DefaultEventCallback is the default EventCallback method applied
 // to Consumer created with NewRealTimeConsumer
 func (c *Consumer) DefaultEventCallback(event *Event) (err error) {
-	// we have to check again here as the lock introduced delay
-	if c.ctx.Err() == nil {
-
-		// if the event can be skipped we send it in a non-blocking way
-		if event.Flags.Skippable {
-			select {
-			case c.Events <- event:
-			default:
-				c.Skipped++
-			}
+	switch {
+	case c.flushing:
+		// events flushed by Stop
+		c.flushEvent(event)
+	case c.ctx.Err() == nil:
+		// we have to check again here as the lock introduced delay
+		c.sendEvent(event)
+	}
 
-			return
+	return
+}
+
+func (c *Consumer) sendEvent(event *Event) {
+	// if the event can be skipped we send it in a non-blocking way
+	if event.Flags.Skippable {
+		select {
+		case c.Events <- event:
+		default:
+			atomic.AddUint64(&c.Skipped, 1)
 		}
 
-		// if we cannot skip event we send it in a blocking way
-		c.Events <- event
+		return
 	}
 
-	return
+	// if we cannot skip event we send it in a blocking way
+	select {
+	case c.Events <- event:
+	case <-c.ctx.Done():
+		// the consumer got stopped while waiting for room in Events
+		c.flushEvent(event)
+	}
+}
+
+// flushEvent sends an event after the consumer got stopped
+func (c *Consumer) flushEvent(event *Event) {
+	select {
+	case c.Events <- event:
+		return
+	default:
+	}
+
+	if event.Flags.Skippable {
+		atomic.AddUint64(&c.Skipped, 1)
+		return
+	}
+
+	select {
+	case c.Events <- event:
+	case <-c.parent.Done():
+		atomic.AddUint64(&c.Skipped, 1)
+	}
+}
+
+// expire regularly outputs the events returned by expire, whose window
+// elapsed
+func (c *Consumer) expire(window time.Duration, expire func() []*Event, output func(...*Event)) {
+	defer c.Done()
+
+	// ticking at a fraction of the window bounds the delay of events
+	// whose window elapsed
+	period := window / expireTicks
+	if period <= 0 {
+		period = window
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			output(expire()...)
+		}
+	}
 }
 
 // Start starts the consumer
 func (c *Consumer) Start() (err error) {
+	defer func() {
+		// no trace is processed if the consumer fails to start
+		if err != nil {
+			c.traces.done()
+		}
+	}()
 
 	// opening all traces first
 	for n := range c.Traces {
@@ -285,29 +468,51 @@ func (c *Consumer) Start() (err error) {
 		}
 	}
 
-	for i := range c.traceHandles {
-		i := i
+	for p := range c.LogFiles {
+		if err = c.OpenLogFile(p); err != nil {
+			return fmt.Errorf("failed to open log file %s: %w", p, err)
+		}
+	}
+
+	if c.Sampler != nil && c.EventCallback != nil {
+		if w := c.Sampler.holdWindow(); w > 0 {
+			c.Add(1)
+			go c.expire(w, c.Sampler.Expire, c.dedup)
+		}
+	}
+
+	if c.Dedup != nil && c.EventCallback != nil {
 		c.Add(1)
-		go func() {
-			defer c.Done()
-			// ProcessTrace can contain only ONE handle to a real-time processing session
-			// src: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-processtrace
-			if err := ProcessTrace(&c.traceHandles[i], 1, nil, nil); err != nil {
-				c.lastError = err
-			}
-		}()
+		go c.expire(c.Dedup.Window, c.Dedup.Expire, c.emit)
 	}
 
+	// ProcessTrace can contain only ONE handle to a real-time processing session
+	// src: https://docs.microsoft.com/en-us/windows/win32/api/evntrace/nf-evntrace-processtrace
+	c.traces.StartTime, c.traces.EndTime = c.StartTime, c.EndTime
+	c.traces.start(func(err error) {
+		c.lastError = err
+	})
+
 	return
 }
 
+// Completed returns a channel closed when all the traces have been
+// processed, which happens when all log files have been replayed, after
+// the consumer is stopped or when it fails to start. Events remain
+// readable until Stop is called.
+func (c *Consumer) Completed() <-chan struct{} {
+	return c.traces.Completed()
+}
+
 // Err returns the last error encountered by the consumer
 func (c *Consumer) Err() error {
 	return c.lastError
 }
 
 // Stop stops the Consumer and waits for the ProcessTrace calls
-// to be terminated
+// to be terminated. Events held by Sampler and Dedup are then passed to
+// EventCallback, DefaultEventCallback blocking until they are read from
+// Events or the context the consumer is created with is done.
 func (c *Consumer) Stop() (err error) {
 	// calling context cancel function
 	c.cancel()
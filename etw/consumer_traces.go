@@ -0,0 +1,134 @@
+package etw
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	PROCESS_TRACE_MODE_REAL_TIME     = 0x00000100
+	PROCESS_TRACE_MODE_RAW_TIMESTAMP = 0x00001000
+	PROCESS_TRACE_MODE_EVENT_RECORD  = 0x10000000
+)
+
+// traceSource is a trace consumed by a Consumer, either a real-time
+// session given by its name or a log file given by its path
+type traceSource struct {
+	name    string
+	logFile bool
+}
+
+// processTraceMode returns the PROCESS_TRACE_MODE_* flags used to open
+// the trace
+func (s traceSource) processTraceMode() uint32 {
+	// PROCESS_TRACE_MODE_EVENT_RECORD to receive EventRecords (new format)
+	// PROCESS_TRACE_MODE_RAW_TIMESTAMP don't convert TimeStamp member of EVENT_HEADER and EVENT_TRACE_HEADER converted to system time
+	// PROCESS_TRACE_MODE_REAL_TIME to receive events in real time
+	if s.logFile {
+		return PROCESS_TRACE_MODE_EVENT_RECORD
+	}
+	return PROCESS_TRACE_MODE_EVENT_RECORD | PROCESS_TRACE_MODE_REAL_TIME
+}
+
+// traceRunner runs the traces of a Consumer. Real-time sessions are
+// processed by distinct processTrace calls, as ProcessTrace accepts only
+// one real-time handle, while log files are processed altogether so that
+// their events are delivered in chronological order.
+type traceRunner struct {
+	sync.WaitGroup
+	backend   traceBackend
+	consumer  traceConsumer
+	realTime  []uint64
+	logFiles  []uint64
+	started   bool
+	completed chan struct{}
+	complete  sync.Once
+
+	// time bounds of the events processed from log files
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func newTraceRunner(backend traceBackend, consumer traceConsumer) *traceRunner {
+	return &traceRunner{
+		backend:   backend,
+		consumer:  consumer,
+		completed: make(chan struct{}),
+	}
+}
+
+// open opens a trace to be processed by start
+func (r *traceRunner) open(src traceSource) error {
+	h, err := r.backend.openTrace(src, r.consumer)
+	if err != nil {
+		return err
+	}
+
+	if src.logFile {
+		r.logFiles = append(r.logFiles, h)
+	} else {
+		r.realTime = append(r.realTime, h)
+	}
+
+	return nil
+}
+
+// start processes the opened traces in the background. report is called
+// with errors returned by processTrace and the channel returned by
+// Completed is closed once all processTrace calls returned.
+func (r *traceRunner) start(report func(error)) {
+	r.started = true
+
+	for i := range r.realTime {
+		r.process(r.realTime[i:i+1], time.Time{}, time.Time{}, report)
+	}
+
+	if len(r.logFiles) > 0 {
+		r.process(r.logFiles, r.StartTime, r.EndTime, report)
+	}
+
+	go func() {
+		r.Wait()
+		r.done()
+	}()
+}
+
+// done closes the channel returned by Completed, it can be called several
+// times
+func (r *traceRunner) done() {
+	r.complete.Do(func() { close(r.completed) })
+}
+
+func (r *traceRunner) process(handles []uint64, start, end time.Time, report func(error)) {
+	r.Add(1)
+	go func() {
+		defer r.Done()
+		if err := r.backend.processTrace(handles, start, end); err != nil {
+			report(err)
+		}
+	}()
+}
+
+// close closes all the opened traces and returns the last error
+// encountered. Completed is closed at once if the traces were never
+// started, otherwise it is closed once processTrace calls return.
+func (r *traceRunner) close() (lastErr error) {
+	if !r.started {
+		r.done()
+	}
+
+	for _, handles := range [][]uint64{r.realTime, r.logFiles} {
+		for _, h := range handles {
+			if err := r.backend.closeTrace(h); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return
+}
+
+// Completed returns a channel closed when all the traces are processed or
+// when the runner is closed without being started
+func (r *traceRunner) Completed() <-chan struct{} {
+	return r.completed
+}
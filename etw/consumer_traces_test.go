@@ -0,0 +1,138 @@
+package etw
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestTraceSourceMode(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	rt := traceSource{name: "EtwSession"}
+	tt.Assert(rt.processTraceMode()&PROCESS_TRACE_MODE_REAL_TIME != 0)
+	tt.Assert(rt.processTraceMode()&PROCESS_TRACE_MODE_EVENT_RECORD != 0)
+
+	lf := traceSource{name: `C:\traces\trace.etl`, logFile: true}
+	tt.Assert(lf.processTraceMode()&PROCESS_TRACE_MODE_REAL_TIME == 0)
+	tt.Assert(lf.processTraceMode()&PROCESS_TRACE_MODE_EVENT_RECORD != 0)
+}
+
+func TestTraceRunner(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	b.release = make(chan struct{})
+	r := newTraceRunner(b, nil)
+	r.StartTime = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.EndTime = r.StartTime.Add(time.Hour)
+
+	tt.CheckErr(r.open(traceSource{name: "EtwSession1"}))
+	tt.CheckErr(r.open(traceSource{name: "first.etl", logFile: true}))
+	tt.CheckErr(r.open(traceSource{name: "EtwSession2"}))
+	tt.CheckErr(r.open(traceSource{name: "second.etl", logFile: true}))
+
+	b.openErr = errors.New("open error")
+	tt.Assert(errors.Is(r.open(traceSource{name: "EtwSession3"}), b.openErr))
+
+	reported := make(chan error, 8)
+	b.processErr = errors.New("process error")
+	r.start(func(err error) { reported <- err })
+
+	// real-time sessions are processed one by one and log files altogether
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.Lock()
+		n := len(b.processCalls)
+		b.Unlock()
+		if n == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-r.Completed():
+		t.Error("runner completed before traces were processed")
+	default:
+	}
+
+	b.Lock()
+	tt.Assert(len(b.processCalls) == 3, len(b.processCalls))
+	sort.Slice(b.processCalls, func(i, j int) bool { return b.processCalls[i].handles[0] < b.processCalls[j].handles[0] })
+	for _, call := range b.processCalls {
+		if b.traces[call.handles[0]].src.logFile {
+			tt.Assert(len(call.handles) == 2)
+			tt.Assert(b.traces[call.handles[1]].src.logFile)
+			tt.Assert(call.start.Equal(r.StartTime) && call.end.Equal(r.EndTime))
+		} else {
+			tt.Assert(len(call.handles) == 1)
+			tt.Assert(call.start.IsZero() && call.end.IsZero())
+		}
+	}
+	b.Unlock()
+
+	close(b.release)
+
+	select {
+	case <-r.Completed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("runner did not complete")
+	}
+
+	tt.Assert(len(reported) == 3)
+	tt.Assert(errors.Is(<-reported, b.processErr))
+
+	b.closeErr = errors.New("close error")
+	tt.Assert(errors.Is(r.close(), b.closeErr))
+	tt.Assert(len(b.closed) == 4)
+}
+
+func TestTraceRunnerNoTrace(t *testing.T) {
+	t.Parallel()
+
+	r := newTraceRunner(newFakeBackend(), nil)
+	r.start(func(error) {})
+
+	select {
+	case <-r.Completed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("runner without trace did not complete")
+	}
+}
+
+func TestTraceRunnerNotStarted(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	r := newTraceRunner(newFakeBackend(), nil)
+	tt.CheckErr(r.open(traceSource{name: "EtwSession"}))
+	tt.CheckErr(r.close())
+
+	select {
+	case <-r.Completed():
+	default:
+		t.Fatal("runner closed without being started did not complete")
+	}
+
+	// completing twice is harmless
+	r.done()
+}
+
+func TestTimeToFiletime(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	ts := time.Date(2022, 6, 1, 12, 30, 15, 123456700, time.UTC)
+	tt.Assert(FiletimeToTime(TimeToFiletime(ts)).Equal(ts))
+	tt.Assert(TimeToFiletime(time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)) == 0)
+}
@@ -0,0 +1,626 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// number of 100ns intervals between 1601-01-01 and 1970-01-01
+	filetimeEpochDelta = 116444736000000000
+
+	sizeofGUID       = 16
+	sizeofSystemtime = 16
+	sizeofIPv4       = 4
+	sizeofIPv6       = 16
+)
+
+var (
+	ErrShortBuffer    = fmt.Errorf("buffer too short")
+	ErrUnknownInType  = fmt.Errorf("unknown in type")
+	ErrBadPointerSize = fmt.Errorf("bad pointer size")
+)
+
+// PropertyDecoder decodes raw property data into Go values according to
+// TdhInType and TdhOutType of the property. It does not rely on any Windows
+// API so it can be used with data coming from any source.
+//
+// Values are decoded as follows:
+//   - integers to their Go counterpart (int8, uint16, int64 ...)
+//   - hexadecimal integers and pointers to strings formatted as 0x%X
+//   - booleans to bool and floats to float32 / float64
+//   - FILETIME and SYSTEMTIME to time.Time in UTC
+//   - IPv4 and IPv6 addresses to net.IP
+//   - GUIDs to GUID
+//   - SIDs to their string representation (i.e. S-1-5-18)
+//   - strings to string and binary data to []byte
+type PropertyDecoder struct {
+	// Size of the pointers in the data, 4 or 8 bytes
+	PointerSize uint32
+}
+
+// NewPropertyDecoder creates a new decoder for data using pointers of size
+// pointerSize, which can be obtained with EventRecord.PointerSize
+func NewPropertyDecoder(pointerSize uint32) *PropertyDecoder {
+	return &PropertyDecoder{PointerSize: pointerSize}
+}
+
+// Decode decodes the property found at the beginning of data. Length is
+// the length of the property as found in EVENT_PROPERTY_INFO (a number of
+// characters for strings, a number of bytes otherwise) and must be 0 for
+// variable length properties. It returns the decoded value and the number
+// of bytes of data used by the property.
+func (d *PropertyDecoder) Decode(data []byte, in TdhInType, out TdhOutType, length uint16) (value interface{}, size int, err error) {
+	if value, size, err = d.decode(data, in, out, int(length)); err != nil {
+		err = fmt.Errorf("failed to decode intype=%d outtype=%d: %w", in, out, err)
+	}
+	return
+}
+
+func (d *PropertyDecoder) decode(data []byte, in TdhInType, out TdhOutType, length int) (value interface{}, size int, err error) {
+	switch in {
+	case TdhInTypeNull:
+		return nil, 0, nil
+
+	case TdhInTypeUnicodestring:
+		if length > 0 {
+			size = length * 2
+			if len(data) < size {
+				return nil, 0, ErrShortBuffer
+			}
+			return decodeUTF16(data[:size]), size, nil
+		}
+		return decodeUTF16Nul(data)
+
+	case TdhInTypeAnsistring:
+		if length > 0 {
+			if len(data) < length {
+				return nil, 0, ErrShortBuffer
+			}
+			return strings.TrimRight(string(data[:length]), "\x00"), length, nil
+		}
+		return decodeAnsiNul(data)
+
+	case TdhInTypeInt8:
+		if len(data) < 1 {
+			return nil, 0, ErrShortBuffer
+		}
+		if out == TdhOutTypeString {
+			return string(rune(data[0])), 1, nil
+		}
+		return int8(data[0]), 1, nil
+
+	case TdhInTypeUint8:
+		if len(data) < 1 {
+			return nil, 0, ErrShortBuffer
+		}
+		switch out {
+		case TdhOutTypeHexint8:
+			return formatHex(uint64(data[0])), 1, nil
+		case TdhOutTypeBoolean:
+			return data[0] != 0, 1, nil
+		case TdhOutTypeString:
+			return string(rune(data[0])), 1, nil
+		}
+		return data[0], 1, nil
+
+	case TdhInTypeInt16:
+		if len(data) < 2 {
+			return nil, 0, ErrShortBuffer
+		}
+		return int16(binary.LittleEndian.Uint16(data)), 2, nil
+
+	case TdhInTypeUint16:
+		if len(data) < 2 {
+			return nil, 0, ErrShortBuffer
+		}
+		switch out {
+		case TdhOutTypeHexint16:
+			return formatHex(uint64(binary.LittleEndian.Uint16(data))), 2, nil
+		case TdhOutTypePort:
+			// ports are in network byte order
+			return binary.BigEndian.Uint16(data), 2, nil
+		case TdhOutTypeString:
+			return string(utf16.Decode([]uint16{binary.LittleEndian.Uint16(data)})), 2, nil
+		}
+		return binary.LittleEndian.Uint16(data), 2, nil
+
+	case TdhInTypeInt32:
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		return int32(binary.LittleEndian.Uint32(data)), 4, nil
+
+	case TdhInTypeUint32:
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		switch out {
+		case TdhOutTypeIpv4:
+			return net.IPv4(data[0], data[1], data[2], data[3]), 4, nil
+		case TdhOutTypeHexint32, TdhOutTypeErrorcode, TdhOutTypeWin32error, TdhOutTypeNtstatus, TdhOutTypeHresult:
+			return formatHex(uint64(binary.LittleEndian.Uint32(data))), 4, nil
+		}
+		return binary.LittleEndian.Uint32(data), 4, nil
+
+	case TdhInTypeHexint32:
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		return formatHex(uint64(binary.LittleEndian.Uint32(data))), 4, nil
+
+	case TdhInTypeInt64:
+		if len(data) < 8 {
+			return nil, 0, ErrShortBuffer
+		}
+		return int64(binary.LittleEndian.Uint64(data)), 8, nil
+
+	case TdhInTypeUint64:
+		if len(data) < 8 {
+			return nil, 0, ErrShortBuffer
+		}
+		if out == TdhOutTypeHexint64 {
+			return formatHex(binary.LittleEndian.Uint64(data)), 8, nil
+		}
+		return binary.LittleEndian.Uint64(data), 8, nil
+
+	case TdhInTypeHexint64:
+		if len(data) < 8 {
+			return nil, 0, ErrShortBuffer
+		}
+		return formatHex(binary.LittleEndian.Uint64(data)), 8, nil
+
+	case TdhInTypeFloat:
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(data)), 4, nil
+
+	case TdhInTypeDouble:
+		if len(data) < 8 {
+			return nil, 0, ErrShortBuffer
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), 8, nil
+
+	case TdhInTypeBoolean:
+		// BOOL is a 32 bits integer
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		return binary.LittleEndian.Uint32(data) != 0, 4, nil
+
+	case TdhInTypeBinary:
+		if length == 0 && out == TdhOutTypeIpv6 {
+			length = sizeofIPv6
+		}
+		if len(data) < length {
+			return nil, 0, ErrShortBuffer
+		}
+		switch {
+		case out == TdhOutTypeIpv6 && length == sizeofIPv6:
+			return copyIP(data[:sizeofIPv6]), length, nil
+		case out == TdhOutTypeIpv4 && length == sizeofIPv4:
+			return copyIP(data[:sizeofIPv4]), length, nil
+		}
+		return copyBytes(data[:length]), length, nil
+
+	case TdhInTypeGUID:
+		if len(data) < sizeofGUID {
+			return nil, 0, ErrShortBuffer
+		}
+		return decodeGUID(data), sizeofGUID, nil
+
+	case TdhInTypePointer:
+		var p uint64
+		if p, size, err = d.pointer(data); err != nil {
+			return
+		}
+		return formatHex(p), size, nil
+
+	case TdhInTypeSizet:
+		var p uint64
+		if p, size, err = d.pointer(data); err != nil {
+			return
+		}
+		if out == TdhOutTypeHexint64 {
+			return formatHex(p), size, nil
+		}
+		return p, size, nil
+
+	case TdhInTypeFiletime:
+		if len(data) < 8 {
+			return nil, 0, ErrShortBuffer
+		}
+		return FiletimeToTime(int64(binary.LittleEndian.Uint64(data))), 8, nil
+
+	case TdhInTypeSystemtime:
+		if len(data) < sizeofSystemtime {
+			return nil, 0, ErrShortBuffer
+		}
+		return decodeSystemtime(data), sizeofSystemtime, nil
+
+	case TdhInTypeSid:
+		return DecodeSID(data)
+
+	case TdhInTypeWbemsid:
+		// a TOKEN_USER structure made of two pointers followed by the SID
+		var ptrSize int
+		if ptrSize, err = d.pointerSize(); err != nil {
+			return
+		}
+		if len(data) < ptrSize*2 {
+			return nil, 0, ErrShortBuffer
+		}
+		if value, size, err = DecodeSID(data[ptrSize*2:]); err != nil {
+			return
+		}
+		return value, ptrSize*2 + size, nil
+
+	case TdhInTypeCountedstring, TdhInTypeReversedcountedstring,
+		TdhInTypeCountedansistring, TdhInTypeReversedcountedansistring:
+		if len(data) < 2 {
+			return nil, 0, ErrShortBuffer
+		}
+
+		// size prefix is in bytes
+		n := int(binary.LittleEndian.Uint16(data))
+		if in == TdhInTypeReversedcountedstring || in == TdhInTypeReversedcountedansistring {
+			n = int(binary.BigEndian.Uint16(data))
+		}
+
+		if len(data) < 2+n {
+			return nil, 0, ErrShortBuffer
+		}
+
+		if in == TdhInTypeCountedstring || in == TdhInTypeReversedcountedstring {
+			return decodeUTF16(data[2 : 2+n]), 2 + n, nil
+		}
+		return string(data[2 : 2+n]), 2 + n, nil
+
+	case TdhInTypeNonnullterminatedstring:
+		if length == 0 {
+			length = len(data) / 2
+		}
+		size = length * 2
+		if len(data) < size {
+			return nil, 0, ErrShortBuffer
+		}
+		return decodeUTF16(data[:size]), size, nil
+
+	case TdhInTypeNonnullterminatedansistring:
+		if length == 0 {
+			length = len(data)
+		}
+		if len(data) < length {
+			return nil, 0, ErrShortBuffer
+		}
+		return string(data[:length]), length, nil
+
+	case TdhInTypeUnicodechar:
+		if len(data) < 2 {
+			return nil, 0, ErrShortBuffer
+		}
+		return decodeUTF16(data[:2]), 2, nil
+
+	case TdhInTypeAnsichar:
+		if len(data) < 1 {
+			return nil, 0, ErrShortBuffer
+		}
+		return string(data[:1]), 1, nil
+
+	case TdhInTypeHexdump:
+		// size prefix is a 32 bits integer
+		if len(data) < 4 {
+			return nil, 0, ErrShortBuffer
+		}
+		n := int(binary.LittleEndian.Uint32(data))
+		if len(data)-4 < n {
+			return nil, 0, ErrShortBuffer
+		}
+		return copyBytes(data[4 : 4+n]), 4 + n, nil
+	}
+
+	return nil, 0, ErrUnknownInType
+}
+
+// Size returns the number of bytes of data used by the property found at
+// the beginning of data, as Decode does, without decoding its value. Length
+// has the same meaning as for Decode.
+func (d *PropertyDecoder) Size(data []byte, in TdhInType, out TdhOutType, length uint16) (size int, err error) {
+	if size, err = d.size(data, in, out, int(length)); err != nil {
+		err = fmt.Errorf("failed to get size of intype=%d outtype=%d: %w", in, out, err)
+	}
+	return
+}
+
+func (d *PropertyDecoder) size(data []byte, in TdhInType, out TdhOutType, length int) (size int, err error) {
+	switch in {
+	case TdhInTypeNull:
+		return 0, nil
+
+	case TdhInTypeUnicodestring:
+		if length == 0 {
+			return sizeUTF16Nul(data), nil
+		}
+		size = length * 2
+
+	case TdhInTypeAnsistring:
+		if length == 0 {
+			return sizeAnsiNul(data), nil
+		}
+		size = length
+
+	case TdhInTypeInt8, TdhInTypeUint8, TdhInTypeAnsichar:
+		size = 1
+
+	case TdhInTypeInt16, TdhInTypeUint16, TdhInTypeUnicodechar:
+		size = 2
+
+	case TdhInTypeInt32, TdhInTypeUint32, TdhInTypeHexint32, TdhInTypeFloat, TdhInTypeBoolean:
+		size = 4
+
+	case TdhInTypeInt64, TdhInTypeUint64, TdhInTypeHexint64, TdhInTypeDouble, TdhInTypeFiletime:
+		size = 8
+
+	case TdhInTypeGUID:
+		size = sizeofGUID
+
+	case TdhInTypeSystemtime:
+		size = sizeofSystemtime
+
+	case TdhInTypePointer, TdhInTypeSizet:
+		if size, err = d.pointerSize(); err != nil {
+			return
+		}
+
+	case TdhInTypeBinary:
+		if length == 0 && out == TdhOutTypeIpv6 {
+			length = sizeofIPv6
+		}
+		size = length
+
+	case TdhInTypeSid:
+		return sizeSID(data)
+
+	case TdhInTypeWbemsid:
+		var ptrSize int
+		if ptrSize, err = d.pointerSize(); err != nil {
+			return
+		}
+		if len(data) < ptrSize*2 {
+			return 0, ErrShortBuffer
+		}
+		if size, err = sizeSID(data[ptrSize*2:]); err != nil {
+			return
+		}
+		return ptrSize*2 + size, nil
+
+	case TdhInTypeCountedstring, TdhInTypeReversedcountedstring,
+		TdhInTypeCountedansistring, TdhInTypeReversedcountedansistring:
+		if len(data) < 2 {
+			return 0, ErrShortBuffer
+		}
+		if in == TdhInTypeReversedcountedstring || in == TdhInTypeReversedcountedansistring {
+			size = 2 + int(binary.BigEndian.Uint16(data))
+		} else {
+			size = 2 + int(binary.LittleEndian.Uint16(data))
+		}
+
+	case TdhInTypeNonnullterminatedstring:
+		if length == 0 {
+			length = len(data) / 2
+		}
+		size = length * 2
+
+	case TdhInTypeNonnullterminatedansistring:
+		if length == 0 {
+			length = len(data)
+		}
+		size = length
+
+	case TdhInTypeHexdump:
+		if len(data) < 4 {
+			return 0, ErrShortBuffer
+		}
+		// compared as uint64 not to overflow int on 32 bits platforms
+		n := uint64(binary.LittleEndian.Uint32(data))
+		if uint64(len(data)-4) < n {
+			return 0, ErrShortBuffer
+		}
+		return 4 + int(n), nil
+
+	default:
+		return 0, ErrUnknownInType
+	}
+
+	if len(data) < size {
+		return 0, ErrShortBuffer
+	}
+
+	return
+}
+
+func (d *PropertyDecoder) pointerSize() (int, error) {
+	switch d.PointerSize {
+	case 4, 8:
+		return int(d.PointerSize), nil
+	}
+	return 0, fmt.Errorf("%w %d", ErrBadPointerSize, d.PointerSize)
+}
+
+func (d *PropertyDecoder) pointer(data []byte) (p uint64, size int, err error) {
+	if size, err = d.pointerSize(); err != nil {
+		return
+	}
+
+	if len(data) < size {
+		return 0, 0, ErrShortBuffer
+	}
+
+	if size == 4 {
+		return uint64(binary.LittleEndian.Uint32(data)), size, nil
+	}
+	return binary.LittleEndian.Uint64(data), size, nil
+}
+
+// DecodeProperty decodes a property with a PropertyDecoder using pointers
+// of size pointerSize
+func DecodeProperty(data []byte, in TdhInType, out TdhOutType, length uint16, pointerSize uint32) (interface{}, int, error) {
+	return NewPropertyDecoder(pointerSize).Decode(data, in, out, length)
+}
+
+// DecodeSID decodes a binary SID into its string representation and returns
+// the size of the SID in bytes
+func DecodeSID(data []byte) (sid string, size int, err error) {
+	var authority uint64
+
+	if size, err = sizeSID(data); err != nil {
+		return
+	}
+
+	count := int(data[1])
+
+	// IdentifierAuthority is a big endian 48 bits integer
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("S-")
+	sb.WriteString(strconv.FormatUint(uint64(data[0]), 10))
+	sb.WriteByte('-')
+	if authority >= 1<<32 {
+		sb.WriteString(formatHex(authority))
+	} else {
+		sb.WriteString(strconv.FormatUint(authority, 10))
+	}
+
+	for i := 0; i < count; i++ {
+		sb.WriteByte('-')
+		sb.WriteString(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[8+i*4:])), 10))
+	}
+
+	return sb.String(), size, nil
+}
+
+// sizeSID returns the size of the binary SID at the beginning of data
+func sizeSID(data []byte) (size int, err error) {
+	// Revision, SubAuthorityCount and IdentifierAuthority
+	if len(data) < 8 {
+		return 0, ErrShortBuffer
+	}
+
+	size = 8 + int(data[1])*4
+	if len(data) < size {
+		return 0, ErrShortBuffer
+	}
+
+	return
+}
+
+// FiletimeToTime converts a FILETIME, expressed in 100ns intervals
+// since 1601-01-01, to UTC time
+func FiletimeToTime(ft int64) time.Time {
+	ft -= filetimeEpochDelta
+	return time.Unix(ft/10000000, (ft%10000000)*100).UTC()
+}
+
+// TimeToFiletime converts a time to a FILETIME, expressed in 100ns
+// intervals since 1601-01-01
+func TimeToFiletime(t time.Time) int64 {
+	return t.Unix()*10000000 + int64(t.Nanosecond()/100) + filetimeEpochDelta
+}
+
+func formatHex(u uint64) string {
+	return fmt.Sprintf("0x%X", u)
+}
+
+func copyBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+func copyIP(b []byte) net.IP {
+	return net.IP(copyBytes(b))
+}
+
+func decodeGUID(b []byte) GUID {
+	g := GUID{
+		Data1: binary.LittleEndian.Uint32(b),
+		Data2: binary.LittleEndian.Uint16(b[4:]),
+		Data3: binary.LittleEndian.Uint16(b[6:]),
+	}
+	copy(g.Data4[:], b[8:16])
+	return g
+}
+
+/*
+typedef struct _SYSTEMTIME {
+  WORD wYear;
+  WORD wMonth;
+  WORD wDayOfWeek;
+  WORD wDay;
+  WORD wHour;
+  WORD wMinute;
+  WORD wSecond;
+  WORD wMilliseconds;
+} SYSTEMTIME;
+*/
+
+func decodeSystemtime(b []byte) time.Time {
+	w := func(i int) int { return int(binary.LittleEndian.Uint16(b[i*2:])) }
+	return time.Date(w(0), time.Month(w(1)), w(3), w(4), w(5), w(6), w(7)*int(time.Millisecond), time.UTC)
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// sizeUTF16Nul returns the size of the NUL terminated UTF-16 string at the
+// beginning of b, terminator included
+func sizeUTF16Nul(b []byte) int {
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			return i + 2
+		}
+	}
+	// string not terminated, taking the rest of the data
+	return len(b) - len(b)%2
+}
+
+func decodeUTF16Nul(b []byte) (s string, size int, err error) {
+	size = sizeUTF16Nul(b)
+	return decodeUTF16(b[:size]), size, nil
+}
+
+// sizeAnsiNul returns the size of the NUL terminated ANSI string at the
+// beginning of b, terminator included
+func sizeAnsiNul(b []byte) int {
+	for i := range b {
+		if b[i] == 0 {
+			return i + 1
+		}
+	}
+	// string not terminated, taking the rest of the data
+	return len(b)
+}
+
+func decodeAnsiNul(b []byte) (s string, size int, err error) {
+	size = sizeAnsiNul(b)
+	return strings.TrimSuffix(string(b[:size]), "\x00"), size, nil
+}
@@ -0,0 +1,347 @@
+package etw
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/toast"
+)
+
+func le16(u uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, u)
+	return b
+}
+
+func le32(u uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, u)
+	return b
+}
+
+func le64(u uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, u)
+	return b
+}
+
+func utf16le(s string, nul bool) (b []byte) {
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, le16(c)...)
+	}
+	if nul {
+		b = append(b, 0, 0)
+	}
+	return
+}
+
+func TestDecodeScalars(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	decode := func(data []byte, in TdhInType, out TdhOutType, expSize int) interface{} {
+		v, size, err := d.Decode(data, in, out, 0)
+		tt.CheckErr(err)
+		tt.Assert(size == expSize, "unexpected size", size, "for intype", in)
+		return v
+	}
+
+	tt.Assert(decode([]byte{0xff}, TdhInTypeInt8, TdhOutTypeNull, 1) == int8(-1))
+	tt.Assert(decode([]byte{0xff}, TdhInTypeUint8, TdhOutTypeNull, 1) == uint8(255))
+	tt.Assert(decode([]byte{0x2a}, TdhInTypeUint8, TdhOutTypeHexint8, 1) == "0x2A")
+	tt.Assert(decode([]byte{0x01}, TdhInTypeUint8, TdhOutTypeBoolean, 1) == true)
+	tt.Assert(decode(le16(0xfffe), TdhInTypeInt16, TdhOutTypeNull, 2) == int16(-2))
+	tt.Assert(decode(le16(1337), TdhInTypeUint16, TdhOutTypeNull, 2) == uint16(1337))
+	tt.Assert(decode([]byte{0x01, 0xbb}, TdhInTypeUint16, TdhOutTypePort, 2) == uint16(443))
+	tt.Assert(decode(le32(0xfffffffd), TdhInTypeInt32, TdhOutTypeNull, 4) == int32(-3))
+	tt.Assert(decode(le32(4242), TdhInTypeUint32, TdhOutTypePid, 4) == uint32(4242))
+	tt.Assert(decode(le32(0xc0000022), TdhInTypeUint32, TdhOutTypeNtstatus, 4) == "0xC0000022")
+	tt.Assert(decode(le32(0xdeadbeef), TdhInTypeHexint32, TdhOutTypeNull, 4) == "0xDEADBEEF")
+	tt.Assert(decode(le64(math.MaxUint64), TdhInTypeInt64, TdhOutTypeNull, 8) == int64(-1))
+	tt.Assert(decode(le64(1<<40), TdhInTypeUint64, TdhOutTypeNull, 8) == uint64(1<<40))
+	tt.Assert(decode(le64(0xcafe), TdhInTypeUint64, TdhOutTypeHexint64, 8) == "0xCAFE")
+	tt.Assert(decode(le64(0xcafe), TdhInTypeHexint64, TdhOutTypeNull, 8) == "0xCAFE")
+	tt.Assert(decode(le32(math.Float32bits(1.5)), TdhInTypeFloat, TdhOutTypeNull, 4) == float32(1.5))
+	tt.Assert(decode(le64(math.Float64bits(-2.25)), TdhInTypeDouble, TdhOutTypeNull, 8) == float64(-2.25))
+	tt.Assert(decode(le32(1), TdhInTypeBoolean, TdhOutTypeNull, 4) == true)
+	tt.Assert(decode(le32(0), TdhInTypeBoolean, TdhOutTypeNull, 4) == false)
+	tt.Assert(decode(nil, TdhInTypeNull, TdhOutTypeNull, 0) == nil)
+}
+
+func TestDecodePointers(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	data := le64(0x00007ffe12345678)
+
+	v, size, err := DecodeProperty(data, TdhInTypePointer, TdhOutTypeNull, 0, 8)
+	tt.CheckErr(err)
+	tt.Assert(v == "0x7FFE12345678" && size == 8)
+
+	v, size, err = DecodeProperty(data, TdhInTypePointer, TdhOutTypeNull, 0, 4)
+	tt.CheckErr(err)
+	tt.Assert(v == "0x12345678" && size == 4)
+
+	v, size, err = DecodeProperty(data, TdhInTypeSizet, TdhOutTypeNull, 0, 4)
+	tt.CheckErr(err)
+	tt.Assert(v == uint64(0x12345678) && size == 4)
+
+	_, _, err = DecodeProperty(data, TdhInTypePointer, TdhOutTypeNull, 0, 0)
+	tt.Assert(errors.Is(err, ErrBadPointerSize))
+}
+
+func TestDecodeTime(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	exp := time.Date(2022, time.March, 14, 15, 9, 26, 535*int(time.Millisecond), time.UTC)
+
+	// 100ns intervals since 1601
+	ft := uint64(exp.UnixNano()/100 + filetimeEpochDelta)
+	v, size, err := d.Decode(le64(ft), TdhInTypeFiletime, TdhOutTypeNull, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == 8)
+	tt.Assert(v.(time.Time).Equal(exp))
+
+	st := make([]byte, 0, sizeofSystemtime)
+	for _, w := range []uint16{2022, 3, 1, 14, 15, 9, 26, 535} {
+		st = append(st, le16(w)...)
+	}
+	v, size, err = d.Decode(st, TdhInTypeSystemtime, TdhOutTypeNull, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == sizeofSystemtime)
+	tt.Assert(v.(time.Time).Equal(exp))
+}
+
+func TestDecodeNetwork(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	v, size, err := d.Decode([]byte{192, 168, 1, 42}, TdhInTypeUint32, TdhOutTypeIpv4, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == 4)
+	tt.Assert(v.(net.IP).Equal(net.ParseIP("192.168.1.42")))
+
+	ip6 := net.ParseIP("fe80::1ff:fe23:4567:890a")
+	v, size, err = d.Decode(ip6, TdhInTypeBinary, TdhOutTypeIpv6, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == 16)
+	tt.Assert(v.(net.IP).Equal(ip6))
+}
+
+func TestDecodeStrings(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	// nul terminated strings followed by other data
+	data := append(utf16le(`C:\Windows\System32\cmd.exe`, true), 0x41, 0x41)
+	v, size, err := d.Decode(data, TdhInTypeUnicodestring, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == `C:\Windows\System32\cmd.exe`)
+	tt.Assert(size == len(data)-2)
+
+	v, size, err = d.Decode([]byte("ansi\x00rest"), TdhInTypeAnsistring, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == "ansi" && size == 5)
+
+	// fixed length strings
+	v, size, err = d.Decode(utf16le("abcdef", false), TdhInTypeUnicodestring, TdhOutTypeString, 3)
+	tt.CheckErr(err)
+	tt.Assert(v == "abc" && size == 6)
+
+	v, size, err = d.Decode(utf16le("abcdef", false), TdhInTypeNonnullterminatedstring, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == "abcdef" && size == 12)
+
+	// counted strings
+	counted := append(le16(6), utf16le("xyz", false)...)
+	v, size, err = d.Decode(counted, TdhInTypeCountedstring, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == "xyz" && size == 8)
+
+	reversed := append([]byte{0, 3}, []byte("xyz")...)
+	v, size, err = d.Decode(reversed, TdhInTypeReversedcountedansistring, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == "xyz" && size == 5)
+
+	v, size, err = d.Decode(utf16le("é", false), TdhInTypeUnicodechar, TdhOutTypeString, 0)
+	tt.CheckErr(err)
+	tt.Assert(v == "é" && size == 2)
+}
+
+func TestDecodeBinary(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	data := []byte{1, 2, 3, 4, 5}
+	v, size, err := d.Decode(data, TdhInTypeBinary, TdhOutTypeHexbinary, 4)
+	tt.CheckErr(err)
+	tt.Assert(size == 4)
+	b := v.([]byte)
+	tt.Assert(string(b) == string(data[:4]))
+	// decoded value must not alias input data
+	data[0] = 42
+	tt.Assert(b[0] == 1)
+
+	v, size, err = d.Decode(append(le32(2), 0xaa, 0xbb, 0xcc), TdhInTypeHexdump, TdhOutTypeNull, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == 6)
+	tt.Assert(string(v.([]byte)) == "\xaa\xbb")
+
+	g := MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")
+	raw := append(le32(g.Data1), le16(g.Data2)...)
+	raw = append(raw, le16(g.Data3)...)
+	raw = append(raw, g.Data4[:]...)
+	v, size, err = d.Decode(raw, TdhInTypeGUID, TdhOutTypeGUID, 0)
+	tt.CheckErr(err)
+	tt.Assert(size == 16)
+	dg := v.(GUID)
+	tt.Assert(dg.Equals(g))
+}
+
+func TestDecodeSID(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// S-1-5-18
+	system := []byte{1, 1, 0, 0, 0, 0, 0, 5}
+	system = append(system, le32(18)...)
+
+	// S-1-5-21-1004336348-1177238915-682003330-512
+	domain := []byte{1, 5, 0, 0, 0, 0, 0, 5}
+	for _, sa := range []uint32{21, 1004336348, 1177238915, 682003330, 512} {
+		domain = append(domain, le32(sa)...)
+	}
+
+	v, size, err := DecodeProperty(system, TdhInTypeSid, TdhOutTypeString, 0, 8)
+	tt.CheckErr(err)
+	tt.Assert(v == "S-1-5-18" && size == 12)
+
+	v, size, err = DecodeProperty(domain, TdhInTypeSid, TdhOutTypeString, 0, 8)
+	tt.CheckErr(err)
+	tt.Assert(v == "S-1-5-21-1004336348-1177238915-682003330-512" && size == 28)
+
+	// TOKEN_USER structure with 32 bits pointers
+	wbem := append(make([]byte, 8), system...)
+	v, size, err = DecodeProperty(wbem, TdhInTypeWbemsid, TdhOutTypeString, 0, 4)
+	tt.CheckErr(err)
+	tt.Assert(v == "S-1-5-18" && size == 20)
+
+	_, _, err = DecodeProperty(domain[:20], TdhInTypeSid, TdhOutTypeString, 0, 8)
+	tt.Assert(errors.Is(err, ErrShortBuffer))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(8)
+
+	for _, in := range []TdhInType{
+		TdhInTypeInt16,
+		TdhInTypeUint32,
+		TdhInTypeUint64,
+		TdhInTypeDouble,
+		TdhInTypeGUID,
+		TdhInTypeFiletime,
+		TdhInTypeSystemtime,
+		TdhInTypeCountedstring,
+	} {
+		_, _, err := d.Decode([]byte{1}, in, TdhOutTypeNull, 0)
+		tt.Assert(errors.Is(err, ErrShortBuffer), "intype", in)
+	}
+
+	_, _, err := d.Decode([]byte{1, 2}, TdhInTypeBinary, TdhOutTypeNull, 4)
+	tt.Assert(errors.Is(err, ErrShortBuffer))
+
+	_, _, err = d.Decode([]byte{1}, TdhInType(4242), TdhOutTypeNull, 0)
+	tt.Assert(errors.Is(err, ErrUnknownInType))
+}
+
+func TestDecoderSize(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	d := NewPropertyDecoder(4)
+
+	system := append([]byte{1, 1, 0, 0, 0, 0, 0, 5}, le32(18)...)
+
+	// Size must always agree with Decode
+	for _, c := range []struct {
+		data   []byte
+		in     TdhInType
+		out    TdhOutType
+		length uint16
+	}{
+		{[]byte{0xff}, TdhInTypeInt8, TdhOutTypeNull, 1},
+		{le16(42), TdhInTypeUint16, TdhOutTypePort, 2},
+		{le32(42), TdhInTypeUint32, TdhOutTypeIpv4, 4},
+		{le32(1), TdhInTypeBoolean, TdhOutTypeNull, 4},
+		{le64(42), TdhInTypeUint64, TdhOutTypeHexint64, 8},
+		{le64(42), TdhInTypeFiletime, TdhOutTypeNull, 8},
+		{make([]byte, 16), TdhInTypeSystemtime, TdhOutTypeNull, 16},
+		{make([]byte, 16), TdhInTypeGUID, TdhOutTypeGUID, 16},
+		{le64(42), TdhInTypePointer, TdhOutTypeNull, 8},
+		{le64(42), TdhInTypeSizet, TdhOutTypeNull, 8},
+		{append(utf16le("abc", true), 0x41, 0x41), TdhInTypeUnicodestring, TdhOutTypeString, 0},
+		{utf16le("abc", false), TdhInTypeUnicodestring, TdhOutTypeString, 0},
+		{utf16le("abcdef", false), TdhInTypeUnicodestring, TdhOutTypeString, 3},
+		{[]byte("ansi\x00rest"), TdhInTypeAnsistring, TdhOutTypeString, 0},
+		{[]byte("ansi"), TdhInTypeAnsistring, TdhOutTypeString, 0},
+		{[]byte("ansi"), TdhInTypeAnsistring, TdhOutTypeString, 2},
+		{utf16le("abcdef", false), TdhInTypeNonnullterminatedstring, TdhOutTypeString, 0},
+		{[]byte("abcdef"), TdhInTypeNonnullterminatedansistring, TdhOutTypeString, 4},
+		{append(le16(6), utf16le("xyz", false)...), TdhInTypeCountedstring, TdhOutTypeString, 0},
+		{append([]byte{0, 3}, []byte("xyz")...), TdhInTypeReversedcountedansistring, TdhOutTypeString, 0},
+		{make([]byte, 20), TdhInTypeBinary, TdhOutTypeIpv6, 0},
+		{[]byte{1, 2, 3, 4, 5}, TdhInTypeBinary, TdhOutTypeHexbinary, 4},
+		{append(le32(2), 0xaa, 0xbb, 0xcc), TdhInTypeHexdump, TdhOutTypeNull, 0},
+		{append(system, 0x41), TdhInTypeSid, TdhOutTypeString, 0},
+		{append(make([]byte, 8), system...), TdhInTypeWbemsid, TdhOutTypeString, 0},
+	} {
+		_, expected, err := d.Decode(c.data, c.in, c.out, c.length)
+		tt.CheckErr(err)
+		size, err := d.Size(c.data, c.in, c.out, c.length)
+		tt.CheckErr(err)
+		tt.Assert(size == expected, "intype", c.in, "size", size, "expected", expected)
+	}
+
+	for _, c := range []struct {
+		data   []byte
+		in     TdhInType
+		length uint16
+	}{
+		{[]byte{1}, TdhInTypeUint32, 0},
+		{[]byte{1}, TdhInTypeCountedstring, 0},
+		{le16(4), TdhInTypeCountedansistring, 0},
+		{le32(0xffffffff), TdhInTypeHexdump, 0},
+		{system[:11], TdhInTypeSid, 0},
+		{utf16le("ab", false), TdhInTypeUnicodestring, 3},
+	} {
+		_, err := d.Size(c.data, c.in, TdhOutTypeNull, c.length)
+		tt.Assert(errors.Is(err, ErrShortBuffer), "intype", c.in)
+	}
+
+	_, err := d.Size([]byte{1}, TdhInType(4242), TdhOutTypeNull, 0)
+	tt.Assert(errors.Is(err, ErrUnknownInType))
+
+	_, err = NewPropertyDecoder(2).Size(le64(42), TdhInTypePointer, TdhOutTypeNull, 0)
+	tt.Assert(errors.Is(err, ErrBadPointerSize))
+}
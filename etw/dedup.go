@@ -0,0 +1,200 @@
+package etw
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDedupConfig = fmt.Errorf("bad deduplication config")
+)
+
+// DedupInfo is attached to events output by a Deduplicator and reports
+// how many duplicates the event stands for
+type DedupInfo struct {
+	Count     uint64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+type dedupEntry struct {
+	key   string
+	event *Event
+	// elements of the entry in the LRU and in the FIFO lists
+	lru  *list.Element
+	fifo *list.Element
+}
+
+// Deduplicator collapses duplicated events. Events are duplicates if they
+// come from the same provider, have the same ID and the same values for
+// the key fields. Duplicates are collapsed as long as they are seen within
+// Window of each other and the first event seen is output, with a
+// DedupInfo, once Window elapsed without duplicates or MaxAge elapsed
+// since it was first seen. Memory is bounded as
+// at most Capacity distinct events are tracked, least recently seen ones
+// being output early when capacity is exceeded. Seen times are given by
+// Clock and not by event timestamps.
+type Deduplicator struct {
+	sync.Mutex
+	fields  []string
+	lru     *list.List
+	fifo    *list.List
+	entries map[string]*dedupEntry
+
+	Window time.Duration
+	// Maximum time duplicates are collapsed for, so that events repeating
+	// faster than Window are output too. Window is used when zero.
+	MaxAge   time.Duration
+	Capacity int
+	// Clock used to compute windows, SystemClock by default
+	Clock Clock
+	// Number of events output before their window elapsed
+	Evicted uint64
+}
+
+// NewDeduplicator creates a new Deduplicator. Key fields are designated
+// by their path (see Event.GetField).
+func NewDeduplicator(window time.Duration, capacity int, fields ...string) (*Deduplicator, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("%w: window must be positive", ErrDedupConfig)
+	}
+
+	if capacity <= 0 {
+		return nil, fmt.Errorf("%w: capacity must be positive", ErrDedupConfig)
+	}
+
+	for _, f := range fields {
+		if _, ok := CanonicalField(f); !ok {
+			return nil, fmt.Errorf("%w: unknown field %s", ErrDedupConfig, f)
+		}
+	}
+
+	return &Deduplicator{
+		fields:   fields,
+		lru:      list.New(),
+		fifo:     list.New(),
+		entries:  make(map[string]*dedupEntry),
+		Window:   window,
+		Capacity: capacity,
+		Clock:    SystemClock{},
+	}, nil
+}
+
+func (d *Deduplicator) key(e *Event) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s:%d", e.System.Provider.Guid, e.System.EventID)
+	for _, f := range d.fields {
+		// a missing field is different from an empty one
+		if v, ok := e.GetField(f); ok {
+			fmt.Fprintf(&b, "\x00%v", v)
+		} else {
+			b.WriteString("\x01")
+		}
+	}
+
+	return b.String()
+}
+
+func (d *Deduplicator) maxAge() time.Duration {
+	if d.MaxAge > 0 {
+		return d.MaxAge
+	}
+	return d.Window
+}
+
+// expire removes entries not seen since Window, the least recently seen
+// entries being at the back of the LRU list, and entries first seen
+// since MaxAge, the oldest entries being at the back of the FIFO list
+func (d *Deduplicator) expire(now time.Time) (out []*Event) {
+	for elt := d.lru.Back(); elt != nil; elt = d.lru.Back() {
+		entry := elt.Value.(*dedupEntry)
+		if now.Sub(entry.event.Dedup.LastSeen) < d.Window {
+			break
+		}
+		out = append(out, d.remove(entry))
+	}
+
+	maxAge := d.maxAge()
+	for elt := d.fifo.Back(); elt != nil; elt = d.fifo.Back() {
+		entry := elt.Value.(*dedupEntry)
+		if now.Sub(entry.event.Dedup.FirstSeen) < maxAge {
+			break
+		}
+		out = append(out, d.remove(entry))
+	}
+
+	return
+}
+
+func (d *Deduplicator) remove(entry *dedupEntry) *Event {
+	d.lru.Remove(entry.lru)
+	d.fifo.Remove(entry.fifo)
+	delete(d.entries, entry.key)
+	return entry.event
+}
+
+// Process processes an event and returns the events ready to be output,
+// either because their window elapsed or because they got evicted.
+func (d *Deduplicator) Process(e *Event) (out []*Event) {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.Clock.Now()
+	out = d.expire(now)
+
+	key := d.key(e)
+	if entry, ok := d.entries[key]; ok {
+		dedup := entry.event.Dedup
+		dedup.Count++
+		dedup.LastSeen = now
+		d.lru.MoveToFront(entry.lru)
+		return
+	}
+
+	e.Dedup = &DedupInfo{Count: 1, FirstSeen: now, LastSeen: now}
+	entry := &dedupEntry{key: key, event: e}
+	entry.lru = d.lru.PushFront(entry)
+	entry.fifo = d.fifo.PushFront(entry)
+	d.entries[key] = entry
+
+	for d.lru.Len() > d.Capacity {
+		out = append(out, d.remove(d.lru.Back().Value.(*dedupEntry)))
+		d.Evicted++
+	}
+
+	return
+}
+
+// Expire returns the events whose window elapsed. It must be called
+// regularly so that events are output even if no other event is processed.
+func (d *Deduplicator) Expire() []*Event {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.expire(d.Clock.Now())
+}
+
+// Flush returns all the pending events, from the least to the most
+// recently seen
+func (d *Deduplicator) Flush() (out []*Event) {
+	d.Lock()
+	defer d.Unlock()
+
+	for elt := d.lru.Back(); elt != nil; elt = d.lru.Back() {
+		out = append(out, d.remove(elt.Value.(*dedupEntry)))
+	}
+
+	return
+}
+
+// Len returns the number of pending events
+func (d *Deduplicator) Len() int {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.lru.Len()
+}
@@ -0,0 +1,166 @@
+package etw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+func newDedupEvent(id uint16, object string, pid uint32) *Event {
+	e := NewEvent()
+	e.System.Provider.Guid = *MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")
+	e.System.EventID = id
+	e.System.Execution.ProcessID = pid
+	e.EventData["FileObject"] = object
+	return e
+}
+
+func TestDeduplicator(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	start := clock.now
+
+	d, err := NewDeduplicator(time.Second, 16, "EventData.FileObject", "ProcessID")
+	tt.CheckErr(err)
+	d.Clock = clock
+	d.MaxAge = time.Minute
+
+	// repeated reads of the same file object
+	first := newDedupEvent(15, "0xffff01", 4)
+	tt.Assert(len(d.Process(first)) == 0)
+	for i := 0; i < 9; i++ {
+		// window is sliding
+		clock.Advance(500 * time.Millisecond)
+		tt.Assert(len(d.Process(newDedupEvent(15, "0xffff01", 4))) == 0)
+	}
+
+	// event ID and key fields make the difference
+	tt.Assert(len(d.Process(newDedupEvent(16, "0xffff01", 4))) == 0)
+	tt.Assert(len(d.Process(newDedupEvent(15, "0xffff02", 4))) == 0)
+	tt.Assert(len(d.Process(newDedupEvent(15, "0xffff01", 5))) == 0)
+	tt.Assert(d.Len() == 4)
+
+	clock.Advance(999 * time.Millisecond)
+	tt.Assert(len(d.Expire()) == 0)
+
+	clock.Advance(time.Millisecond)
+	out := d.Expire()
+	tt.Assert(len(out) == 4)
+	// the first event seen is output
+	tt.Assert(out[0] == first)
+	tt.Assert(first.Dedup.Count == 10)
+	tt.Assert(first.Dedup.FirstSeen.Equal(start))
+	tt.Assert(first.Dedup.LastSeen.Equal(start.Add(4500 * time.Millisecond)))
+	for _, e := range out[1:] {
+		tt.Assert(e.Dedup.Count == 1)
+	}
+	tt.Assert(d.Len() == 0)
+	tt.Assert(d.Evicted == 0)
+
+	// expired events are returned by Process too
+	d.Process(newDedupEvent(15, "0xffff01", 4))
+	clock.Advance(time.Second)
+	out = d.Process(newDedupEvent(15, "0xffff01", 4))
+	tt.Assert(len(out) == 1 && out[0].Dedup.Count == 1)
+	tt.Assert(len(d.Flush()) == 1)
+}
+
+func TestDeduplicatorMaxAge(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	clock := &fakeClock{}
+	d, err := NewDeduplicator(time.Second, 16, "EventData.FileObject")
+	tt.CheckErr(err)
+	d.Clock = clock
+
+	// duplicates repeating faster than Window are output after Window
+	// by default
+	a := newDedupEvent(15, "a", 0)
+	d.Process(a)
+	clock.Advance(500 * time.Millisecond)
+	d.Process(newDedupEvent(15, "b", 0))
+	d.Process(newDedupEvent(15, "a", 0))
+	clock.Advance(499 * time.Millisecond)
+	d.Process(newDedupEvent(15, "a", 0))
+	tt.Assert(len(d.Expire()) == 0)
+
+	clock.Advance(time.Millisecond)
+	out := d.Process(newDedupEvent(15, "a", 0))
+	tt.Assert(len(out) == 1 && out[0] == a)
+	tt.Assert(a.Dedup.Count == 3)
+	// a new entry starts with the next duplicate
+	tt.Assert(d.Len() == 2)
+
+	// b expires on age too
+	clock.Advance(500 * time.Millisecond)
+	out = d.Expire()
+	tt.Assert(len(out) == 1 && out[0].EventData["FileObject"] == "b")
+
+	tt.Assert(len(d.Flush()) == 1)
+
+	d.MaxAge = 3 * time.Second
+	for i := 0; i < 6; i++ {
+		tt.Assert(len(d.Process(newDedupEvent(15, "c", 0))) == 0)
+		clock.Advance(500 * time.Millisecond)
+	}
+	out = d.Expire()
+	tt.Assert(len(out) == 1 && out[0].Dedup.Count == 6)
+	tt.Assert(d.Len() == 0)
+}
+
+func TestDeduplicatorLRU(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	clock := &fakeClock{}
+	d, err := NewDeduplicator(time.Minute, 2, "EventData.FileObject")
+	tt.CheckErr(err)
+	d.Clock = clock
+
+	a := newDedupEvent(15, "a", 0)
+	b := newDedupEvent(15, "b", 0)
+
+	d.Process(a)
+	d.Process(b)
+	// a becomes the most recently seen
+	d.Process(newDedupEvent(15, "a", 0))
+
+	out := d.Process(newDedupEvent(15, "c", 0))
+	tt.Assert(len(out) == 1 && out[0] == b)
+	tt.Assert(d.Evicted == 1)
+	tt.Assert(d.Len() == 2)
+
+	// flushed from the least to the most recently seen
+	out = d.Flush()
+	tt.Assert(len(out) == 2)
+	tt.Assert(out[0] == a && a.Dedup.Count == 2)
+	tt.Assert(out[1].EventData["FileObject"] == "c")
+
+	// missing key fields are not equal to empty ones
+	d.Process(NewEvent())
+	e := NewEvent()
+	e.EventData["FileObject"] = ""
+	d.Process(e)
+	tt.Assert(d.Len() == 2)
+}
+
+func TestDeduplicatorConfig(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	_, err := NewDeduplicator(0, 1)
+	tt.Assert(errors.Is(err, ErrDedupConfig))
+	_, err = NewDeduplicator(time.Second, 0)
+	tt.Assert(errors.Is(err, ErrDedupConfig))
+	_, err = NewDeduplicator(time.Second, 1, "System.Unknown")
+	tt.Assert(errors.Is(err, ErrDedupConfig))
+}
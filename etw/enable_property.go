@@ -0,0 +1,93 @@
+package etw
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	EVENT_ENABLE_PROPERTY_SID                       = 0x00000001
+	EVENT_ENABLE_PROPERTY_TS_ID                     = 0x00000002
+	EVENT_ENABLE_PROPERTY_STACK_TRACE               = 0x00000004
+	EVENT_ENABLE_PROPERTY_PSM_KEY                   = 0x00000008
+	EVENT_ENABLE_PROPERTY_IGNORE_KEYWORD_0          = 0x00000010
+	EVENT_ENABLE_PROPERTY_PROVIDER_GROUP            = 0x00000020
+	EVENT_ENABLE_PROPERTY_ENABLE_KEYWORD_0          = 0x00000040
+	EVENT_ENABLE_PROPERTY_PROCESS_START_KEY         = 0x00000080
+	EVENT_ENABLE_PROPERTY_EVENT_KEY                 = 0x00000100
+	EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE         = 0x00000200
+	EVENT_ENABLE_PROPERTY_ENABLE_SILOS              = 0x00000400
+	EVENT_ENABLE_PROPERTY_SOURCE_CONTAINER_TRACKING = 0x00000800
+)
+
+var (
+	// EnableProperties maps the names usable in provider strings
+	// to EVENT_ENABLE_PROPERTY_* flags
+	EnableProperties = map[string]uint32{
+		"sid":               EVENT_ENABLE_PROPERTY_SID,
+		"tsid":              EVENT_ENABLE_PROPERTY_TS_ID,
+		"stack":             EVENT_ENABLE_PROPERTY_STACK_TRACE,
+		"psmkey":            EVENT_ENABLE_PROPERTY_PSM_KEY,
+		"ignorekw0":         EVENT_ENABLE_PROPERTY_IGNORE_KEYWORD_0,
+		"providergroup":     EVENT_ENABLE_PROPERTY_PROVIDER_GROUP,
+		"enablekw0":         EVENT_ENABLE_PROPERTY_ENABLE_KEYWORD_0,
+		"startkey":          EVENT_ENABLE_PROPERTY_PROCESS_START_KEY,
+		"eventkey":          EVENT_ENABLE_PROPERTY_EVENT_KEY,
+		"noinprivate":       EVENT_ENABLE_PROPERTY_EXCLUDE_INPRIVATE,
+		"silos":             EVENT_ENABLE_PROPERTY_ENABLE_SILOS,
+		"containertracking": EVENT_ENABLE_PROPERTY_SOURCE_CONTAINER_TRACKING,
+	}
+
+	ErrUnknownEnableProperty = fmt.Errorf("unknown enable property")
+)
+
+// ParseEnableProperty parses a comma separated list of enable property
+// names (see EnableProperties) or integer values and returns the
+// corresponding EVENT_ENABLE_PROPERTY_* flags.
+// Example: sid,stack,0x80
+func ParseEnableProperty(s string) (prop uint32, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		if flag, ok := EnableProperties[name]; ok {
+			prop |= flag
+			continue
+		}
+
+		var u uint64
+		if u, err = strconv.ParseUint(name, 0, 32); err != nil {
+			return 0, fmt.Errorf("%w %s", ErrUnknownEnableProperty, name)
+		}
+		prop |= uint32(u)
+	}
+
+	return
+}
+
+// EnablePropertyString returns the comma separated list of names of the
+// EVENT_ENABLE_PROPERTY_* flags set in prop, unknown flags are formatted
+// as hexadecimal integers
+func EnablePropertyString(prop uint32) string {
+	names := make([]string, 0)
+
+	for name, flag := range EnableProperties {
+		if prop&flag == flag {
+			names = append(names, name)
+			prop &^= flag
+		}
+	}
+
+	sort.Strings(names)
+
+	if prop != 0 {
+		names = append(names, fmt.Sprintf("0x%x", prop))
+	}
+
+	return strings.Join(names, ",")
+}
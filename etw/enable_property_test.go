@@ -0,0 +1,42 @@
+package etw
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestParseEnableProperty(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	prop, err := ParseEnableProperty("sid,tsid,stack,startkey")
+	tt.CheckErr(err)
+	tt.Assert(prop == EVENT_ENABLE_PROPERTY_SID|
+		EVENT_ENABLE_PROPERTY_TS_ID|
+		EVENT_ENABLE_PROPERTY_STACK_TRACE|
+		EVENT_ENABLE_PROPERTY_PROCESS_START_KEY)
+
+	// names are case insensitive and integers are accepted
+	prop, err = ParseEnableProperty(" SID , 0x100")
+	tt.CheckErr(err)
+	tt.Assert(prop == EVENT_ENABLE_PROPERTY_SID|EVENT_ENABLE_PROPERTY_EVENT_KEY)
+
+	prop, err = ParseEnableProperty("")
+	tt.CheckErr(err)
+	tt.Assert(prop == 0)
+
+	_, err = ParseEnableProperty("sid,unknown")
+	tt.Assert(errors.Is(err, ErrUnknownEnableProperty))
+
+	tt.Assert(EnablePropertyString(EVENT_ENABLE_PROPERTY_STACK_TRACE|EVENT_ENABLE_PROPERTY_SID) == "sid,stack")
+	tt.Assert(EnablePropertyString(EVENT_ENABLE_PROPERTY_SID|0x10000) == "sid,0x10000")
+
+	for name, flag := range EnableProperties {
+		prop, err = ParseEnableProperty(EnablePropertyString(flag))
+		tt.CheckErr(err)
+		tt.Assert(prop == flag, name)
+	}
+}
@@ -0,0 +1,306 @@
+package etl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+var (
+	// ErrBadFormat is returned when data does not look like an .etl file
+	ErrBadFormat = fmt.Errorf("bad etl format")
+	// ErrTruncated is returned when a structure is cut before its end
+	ErrTruncated = fmt.Errorf("truncated etl data")
+)
+
+// Flags found in the marker byte of every record written in an ETW buffer
+const (
+	TRACE_HEADER_FLAG        = 0x80
+	TRACE_HEADER_EVENT_TRACE = 0x40
+)
+
+// HeaderType identifies the kind of header used by a record
+type HeaderType uint8
+
+const (
+	TRACE_HEADER_TYPE_SYSTEM32       = HeaderType(0x01)
+	TRACE_HEADER_TYPE_SYSTEM64       = HeaderType(0x02)
+	TRACE_HEADER_TYPE_COMPACT32      = HeaderType(0x03)
+	TRACE_HEADER_TYPE_COMPACT64      = HeaderType(0x04)
+	TRACE_HEADER_TYPE_FULL_HEADER32  = HeaderType(0x0A)
+	TRACE_HEADER_TYPE_INSTANCE32     = HeaderType(0x0B)
+	TRACE_HEADER_TYPE_TIMED          = HeaderType(0x0C)
+	TRACE_HEADER_TYPE_ERROR          = HeaderType(0x0D)
+	TRACE_HEADER_TYPE_WNODE_HEADER   = HeaderType(0x0E)
+	TRACE_HEADER_TYPE_MESSAGE        = HeaderType(0x0F)
+	TRACE_HEADER_TYPE_PERFINFO32     = HeaderType(0x10)
+	TRACE_HEADER_TYPE_PERFINFO64     = HeaderType(0x11)
+	TRACE_HEADER_TYPE_EVENT_HEADER32 = HeaderType(0x12)
+	TRACE_HEADER_TYPE_EVENT_HEADER64 = HeaderType(0x13)
+	TRACE_HEADER_TYPE_FULL_HEADER64  = HeaderType(0x14)
+	TRACE_HEADER_TYPE_INSTANCE64     = HeaderType(0x15)
+)
+
+// PointerSize returns the size of pointers of the machine which logged
+// a record using this header type
+func (t HeaderType) PointerSize() int {
+	switch t {
+	case TRACE_HEADER_TYPE_SYSTEM64,
+		TRACE_HEADER_TYPE_COMPACT64,
+		TRACE_HEADER_TYPE_PERFINFO64,
+		TRACE_HEADER_TYPE_EVENT_HEADER64,
+		TRACE_HEADER_TYPE_FULL_HEADER64,
+		TRACE_HEADER_TYPE_INSTANCE64:
+		return 8
+	}
+	return 4
+}
+
+// Clock types as stored in TRACE_LOGFILE_HEADER.ReservedFlags
+const (
+	ClockTypeQPC        = 1
+	ClockTypeSystemTime = 2
+	ClockTypeCPUCycle   = 3
+)
+
+const (
+	// sizeof(WMI_BUFFER_HEADER)
+	bufferHeaderSize = 0x48
+	// ETW buffers are at most a few MiB, larger sizes are corrupted data
+	// and must not be allocated
+	maxBufferSize = 16 << 20
+	// records are aligned on 8 bytes within buffers
+	recordAlignment = 8
+
+	systemHeaderSize   = 0x20
+	compactHeaderSize  = 0x18
+	perfinfoHeaderSize = 0x10
+	fullHeaderSize     = 0x30
+	eventHeaderSize    = 0x50
+	extItemHeaderSize  = 0x08
+
+	// sizeof(TIME_ZONE_INFORMATION)
+	timeZoneInformationSize = 0xAC
+)
+
+/*
+typedef struct _WMI_BUFFER_HEADER {
+  ULONG              BufferSize;
+  ULONG              SavedOffset;
+  ULONG              CurrentOffset;
+  LONG               ReferenceCount;
+  LARGE_INTEGER      TimeStamp;
+  LONGLONG           SequenceNumber;
+  ULONG64            ClockType : 3;
+  ULONG64            Frequency : 61;
+  ETW_BUFFER_CONTEXT ClientContext;
+  ETW_BUFFER_STATE   State;
+  ULONG              Offset;
+  USHORT             BufferFlag;
+  USHORT             BufferType;
+  union {
+    GUID             InstanceGuid;
+    ...
+  };
+} WMI_BUFFER_HEADER, *PWMI_BUFFER_HEADER;
+*/
+
+// BufferHeader is the header found at the beginning of every buffer
+// of an .etl file
+type BufferHeader struct {
+	BufferSize      uint32
+	SavedOffset     uint32
+	CurrentOffset   uint32
+	ReferenceCount  int32
+	TimeStamp       int64
+	SequenceNumber  int64
+	ClockType       uint8
+	Frequency       uint64
+	ProcessorNumber uint8
+	Alignment       uint8
+	LoggerId        uint16
+	State           uint32
+	Offset          uint32
+	BufferFlag      uint16
+	BufferType      uint16
+}
+
+func parseBufferHeader(b []byte) (h BufferHeader, err error) {
+	if len(b) < bufferHeaderSize {
+		return h, ErrTruncated
+	}
+
+	h.BufferSize = binary.LittleEndian.Uint32(b[0x00:])
+	h.SavedOffset = binary.LittleEndian.Uint32(b[0x04:])
+	h.CurrentOffset = binary.LittleEndian.Uint32(b[0x08:])
+	h.ReferenceCount = int32(binary.LittleEndian.Uint32(b[0x0C:]))
+	h.TimeStamp = int64(binary.LittleEndian.Uint64(b[0x10:]))
+	h.SequenceNumber = int64(binary.LittleEndian.Uint64(b[0x18:]))
+	ctf := binary.LittleEndian.Uint64(b[0x20:])
+	h.ClockType = uint8(ctf & 0x7)
+	h.Frequency = ctf >> 3
+	h.ProcessorNumber = b[0x28]
+	h.Alignment = b[0x29]
+	h.LoggerId = binary.LittleEndian.Uint16(b[0x2A:])
+	h.State = binary.LittleEndian.Uint32(b[0x2C:])
+	h.Offset = binary.LittleEndian.Uint32(b[0x30:])
+	h.BufferFlag = binary.LittleEndian.Uint16(b[0x34:])
+	h.BufferType = binary.LittleEndian.Uint16(b[0x36:])
+
+	if h.BufferSize < bufferHeaderSize {
+		err = fmt.Errorf("%w: buffer size too small (%d)", ErrBadFormat, h.BufferSize)
+	} else if h.BufferSize > maxBufferSize {
+		err = fmt.Errorf("%w: buffer size too large (%d)", ErrBadFormat, h.BufferSize)
+	}
+
+	return
+}
+
+// dataEnd returns the offset of the end of valid data within the buffer
+func (h *BufferHeader) dataEnd() int {
+	if h.SavedOffset > bufferHeaderSize && h.SavedOffset <= h.BufferSize {
+		return int(h.SavedOffset)
+	}
+	if h.CurrentOffset > bufferHeaderSize && h.CurrentOffset <= h.BufferSize {
+		return int(h.CurrentOffset)
+	}
+	return int(h.BufferSize)
+}
+
+/*
+typedef struct _TRACE_LOGFILE_HEADER {
+  ULONG                 BufferSize;
+  union {
+    ULONG  Version;
+    struct {
+      UCHAR MajorVersion;
+      UCHAR MinorVersion;
+      UCHAR SubVersion;
+      UCHAR SubMinorVersion;
+    } VersionDetail;
+  };
+  ULONG                 ProviderVersion;
+  ULONG                 NumberOfProcessors;
+  LARGE_INTEGER         EndTime;
+  ULONG                 TimerResolution;
+  ULONG                 MaximumFileSize;
+  ULONG                 LogFileMode;
+  ULONG                 BuffersWritten;
+  union {
+    GUID   LogInstanceGuid;
+    struct {
+      ULONG StartBuffers;
+      ULONG PointerSize;
+      ULONG EventsLost;
+      ULONG CpuSpeedInMHz;
+    };
+  };
+  LPWSTR                LoggerName;
+  LPWSTR                LogFileName;
+  TIME_ZONE_INFORMATION TimeZone;
+  LARGE_INTEGER         BootTime;
+  LARGE_INTEGER         PerfFreq;
+  LARGE_INTEGER         StartTime;
+  ULONG                 ReservedFlags;
+  ULONG                 BuffersLost;
+} TRACE_LOGFILE_HEADER, *PTRACE_LOGFILE_HEADER;
+
+In a file, the structure is followed by the logger name and the log
+file name as NULL terminated UTF16 strings.
+*/
+
+// LogfileHeader is the TRACE_LOGFILE_HEADER found in the first record
+// of an .etl file
+type LogfileHeader struct {
+	BufferSize         uint32
+	MajorVersion       uint8
+	MinorVersion       uint8
+	SubVersion         uint8
+	SubMinorVersion    uint8
+	ProviderVersion    uint32
+	NumberOfProcessors uint32
+	EndTime            int64
+	TimerResolution    uint32
+	MaximumFileSize    uint32
+	LogFileMode        uint32
+	BuffersWritten     uint32
+	StartBuffers       uint32
+	PointerSize        uint32
+	EventsLost         uint32
+	CpuSpeedInMHz      uint32
+	TimeZoneBias       int32
+	BootTime           int64
+	PerfFreq           int64
+	StartTime          int64
+	// ReservedFlags holds the clock type of the session
+	ReservedFlags uint32
+	BuffersLost   uint32
+	LoggerName    string
+	LogFileName   string
+}
+
+// ClockType returns the clock type used to timestamp events
+func (h *LogfileHeader) ClockType() uint32 {
+	return h.ReservedFlags
+}
+
+func parseLogfileHeader(b []byte, ptrSize int) (h LogfileHeader, err error) {
+	// TimeZone follows LoggerName and LogFileName pointers
+	tzOffset := 0x38 + 2*ptrSize
+	// LARGE_INTEGER members are aligned on 8 bytes
+	bootTimeOffset := align(tzOffset+timeZoneInformationSize, 8)
+	size := bootTimeOffset + 0x20
+
+	if len(b) < size {
+		return h, ErrTruncated
+	}
+
+	h.BufferSize = binary.LittleEndian.Uint32(b[0x00:])
+	h.MajorVersion = b[0x04]
+	h.MinorVersion = b[0x05]
+	h.SubVersion = b[0x06]
+	h.SubMinorVersion = b[0x07]
+	h.ProviderVersion = binary.LittleEndian.Uint32(b[0x08:])
+	h.NumberOfProcessors = binary.LittleEndian.Uint32(b[0x0C:])
+	h.EndTime = int64(binary.LittleEndian.Uint64(b[0x10:]))
+	h.TimerResolution = binary.LittleEndian.Uint32(b[0x18:])
+	h.MaximumFileSize = binary.LittleEndian.Uint32(b[0x1C:])
+	h.LogFileMode = binary.LittleEndian.Uint32(b[0x20:])
+	h.BuffersWritten = binary.LittleEndian.Uint32(b[0x24:])
+	h.StartBuffers = binary.LittleEndian.Uint32(b[0x28:])
+	h.PointerSize = binary.LittleEndian.Uint32(b[0x2C:])
+	h.EventsLost = binary.LittleEndian.Uint32(b[0x30:])
+	h.CpuSpeedInMHz = binary.LittleEndian.Uint32(b[0x34:])
+	h.TimeZoneBias = int32(binary.LittleEndian.Uint32(b[tzOffset:]))
+	h.BootTime = int64(binary.LittleEndian.Uint64(b[bootTimeOffset:]))
+	h.PerfFreq = int64(binary.LittleEndian.Uint64(b[bootTimeOffset+0x08:]))
+	h.StartTime = int64(binary.LittleEndian.Uint64(b[bootTimeOffset+0x10:]))
+	h.ReservedFlags = binary.LittleEndian.Uint32(b[bootTimeOffset+0x18:])
+	h.BuffersLost = binary.LittleEndian.Uint32(b[bootTimeOffset+0x1C:])
+
+	// names are optional
+	var n int
+	names := b[size:]
+	h.LoggerName, n = utf16String(names)
+	h.LogFileName, _ = utf16String(names[n:])
+
+	return
+}
+
+// utf16String decodes a NULL terminated UTF16 string and returns
+// the number of bytes consumed (including the terminator)
+func utf16String(b []byte) (s string, n int) {
+	u := make([]uint16, 0, len(b)/2)
+	for n = 0; n+1 < len(b); n += 2 {
+		c := binary.LittleEndian.Uint16(b[n:])
+		if c == 0 {
+			return string(utf16.Decode(u)), n + 2
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u)), n
+}
+
+func align(n, a int) int {
+	return (n + a - 1) &^ (a - 1)
+}
@@ -0,0 +1,189 @@
+// Package etl implements a pure Go reader of .etl files which does not
+// rely on Windows APIs, so that ETW traces can be analysed on any OS.
+package etl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+)
+
+// Reader reads event records from an .etl stream
+type Reader struct {
+	r      io.Reader
+	closer io.Closer
+
+	// Header of the log file, parsed from the first record of the file
+	Header LogfileHeader
+	// Number of buffers read so far
+	BuffersRead uint32
+
+	buffer    []byte
+	bufHeader BufferHeader
+	offset    int
+	end       int
+
+	// raw timestamp of the logfile header record, used as reference
+	// to convert timestamps to time
+	headerTimeStamp int64
+}
+
+// NewReader creates a new Reader and parses the log file header
+// found in the first buffer of the stream
+func NewReader(r io.Reader) (rd *Reader, err error) {
+	var rec *Record
+
+	rd = &Reader{r: r}
+
+	first := make([]byte, bufferHeaderSize)
+	if _, err = io.ReadFull(r, first); err != nil {
+		return nil, fmt.Errorf("%w: failed to read first buffer header: %s", ErrBadFormat, err)
+	}
+
+	if rd.bufHeader, err = parseBufferHeader(first); err != nil {
+		return nil, err
+	}
+
+	rd.buffer = make([]byte, rd.bufHeader.BufferSize)
+	copy(rd.buffer, first)
+	if _, err = io.ReadFull(r, rd.buffer[bufferHeaderSize:]); err != nil {
+		return nil, fmt.Errorf("%w: failed to read first buffer: %s", ErrTruncated, err)
+	}
+
+	rd.BuffersRead = 1
+	rd.offset = bufferHeaderSize
+	rd.end = rd.bufHeader.dataEnd()
+
+	// first record must be the log file header
+	if rec, err = rd.nextRecord(); err != nil {
+		return nil, fmt.Errorf("failed to read logfile header record: %w", err)
+	}
+
+	if !rec.IsKernel() || rec.HookId != EVENT_TRACE_GROUP_HEADER {
+		return nil, fmt.Errorf("%w: first record is not a logfile header", ErrBadFormat)
+	}
+
+	if rd.Header, err = parseLogfileHeader(rec.UserData, rec.PointerSize()); err != nil {
+		return nil, fmt.Errorf("failed to parse logfile header: %w", err)
+	}
+
+	rd.headerTimeStamp = rec.TimeStamp
+
+	return
+}
+
+// Open opens an .etl file
+func Open(path string) (rd *Reader, err error) {
+	var fd *os.File
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+
+	if rd, err = NewReader(fd); err != nil {
+		fd.Close()
+		return
+	}
+
+	rd.closer = fd
+	return
+}
+
+// Close closes the underlying file if the reader was created with Open
+func (rd *Reader) Close() error {
+	if rd.closer != nil {
+		return rd.closer.Close()
+	}
+	return nil
+}
+
+func (rd *Reader) readBuffer() (err error) {
+	if _, err = io.ReadFull(rd.r, rd.buffer); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return
+	}
+
+	if rd.bufHeader, err = parseBufferHeader(rd.buffer); err != nil {
+		return
+	}
+
+	if int(rd.bufHeader.BufferSize) != len(rd.buffer) {
+		return fmt.Errorf("%w: inconsistent buffer size %d", ErrBadFormat, rd.bufHeader.BufferSize)
+	}
+
+	rd.BuffersRead++
+	rd.offset = bufferHeaderSize
+	rd.end = rd.bufHeader.dataEnd()
+
+	return
+}
+
+func (rd *Reader) nextRecord() (r *Record, err error) {
+	var size int
+
+	for {
+		if rd.offset >= rd.end || isEndOfBuffer(rd.buffer[rd.offset:rd.end]) {
+			if err = rd.readBuffer(); err != nil {
+				return
+			}
+			continue
+		}
+
+		if r, size, err = parseRecord(rd.buffer[rd.offset:rd.end]); err != nil {
+			return nil, fmt.Errorf("buffer %d offset 0x%x: %w", rd.BuffersRead-1, rd.offset, err)
+		}
+
+		rd.offset += align(size, recordAlignment)
+
+		// unsupported record type
+		if r == nil {
+			continue
+		}
+
+		r.ProcessorNumber = rd.bufHeader.ProcessorNumber
+		r.LoggerId = rd.bufHeader.LoggerId
+
+		return
+	}
+}
+
+// Next returns the next record of the file or io.EOF when
+// all records have been read
+func (rd *Reader) Next() (r *Record, err error) {
+	if r, err = rd.nextRecord(); err != nil {
+		return
+	}
+
+	r.Time = rd.Time(r.TimeStamp)
+	return
+}
+
+// Time converts a raw record timestamp to UTC time according to the
+// clock type of the log file
+func (rd *Reader) Time(ts int64) time.Time {
+	var freq int64
+
+	switch rd.Header.ClockType() {
+	case ClockTypeQPC:
+		freq = rd.Header.PerfFreq
+	case ClockTypeCPUCycle:
+		freq = int64(rd.Header.CpuSpeedInMHz) * 1000000
+	}
+
+	if freq == 0 {
+		// system time is already a FILETIME
+		return etw.FiletimeToTime(ts)
+	}
+
+	// converting elapsed ticks in 100ns intervals without overflowing
+	delta := ts - rd.headerTimeStamp
+	ft := rd.Header.StartTime + (delta/freq)*10000000 + ((delta%freq)*10000000)/freq
+
+	return etw.FiletimeToTime(ft)
+}
@@ -0,0 +1,438 @@
+package etl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/toast"
+)
+
+var (
+	update = flag.Bool("update", false, "update .etl fixtures")
+
+	fixture = filepath.Join("testdata", "synthetic.etl")
+	// captures made on Windows, each one with a .json file describing it
+	captures = filepath.Join("testdata", "captures")
+
+	kernelFileGUID = etw.MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")
+	imageLoadGUID  = etw.MustParseGUIDFromString("{2cb15d1d-5fc1-11d2-abe1-00a0c911f518}")
+	activityGUID   = etw.MustParseGUIDFromString("{0F3A9D21-5B2A-4C8E-9E3D-1A2B3C4D5E6F}")
+	relatedGUID    = etw.MustParseGUIDFromString("{11111111-2222-3333-4444-555555555555}")
+)
+
+const (
+	fixtureBufferSize = 1024
+	fixturePerfFreq   = 10000000
+	fixtureHeaderTS   = 1000
+	// 2022-01-01T00:00:00Z
+	fixtureStartTime = 132854976000000000
+	// number of file events written in the fixture
+	fixtureFileEvents = 16
+)
+
+// etlWriter builds .etl streams laid out the way ETW writes them
+type etlWriter struct {
+	bufSize int
+	out     bytes.Buffer
+	cur     []byte
+	off     int
+}
+
+func newEtlWriter(bufSize int) *etlWriter {
+	w := &etlWriter{bufSize: bufSize}
+	w.newBuffer()
+	return w
+}
+
+func (w *etlWriter) newBuffer() {
+	w.cur = make([]byte, w.bufSize)
+	for i := bufferHeaderSize; i < len(w.cur); i++ {
+		w.cur[i] = 0xff
+	}
+	w.off = bufferHeaderSize
+}
+
+func (w *etlWriter) flush() {
+	binary.LittleEndian.PutUint32(w.cur[0x00:], uint32(w.bufSize))
+	binary.LittleEndian.PutUint32(w.cur[0x04:], uint32(w.off))
+	binary.LittleEndian.PutUint32(w.cur[0x08:], uint32(w.off))
+	binary.LittleEndian.PutUint64(w.cur[0x20:], ClockTypeQPC|fixturePerfFreq<<3)
+	w.cur[0x28] = 1
+	binary.LittleEndian.PutUint16(w.cur[0x2A:], 42)
+	w.out.Write(w.cur)
+}
+
+func (w *etlWriter) write(rec []byte) {
+	if w.off+len(rec) > w.bufSize {
+		w.flush()
+		w.newBuffer()
+	}
+	copy(w.cur[w.off:], rec)
+	w.off = align(w.off+len(rec), recordAlignment)
+}
+
+func (w *etlWriter) bytes() []byte {
+	w.flush()
+	return w.out.Bytes()
+}
+
+func putGUID(b []byte, g *etw.GUID) {
+	binary.LittleEndian.PutUint32(b[0:], g.Data1)
+	binary.LittleEndian.PutUint16(b[4:], g.Data2)
+	binary.LittleEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:], g.Data4[:])
+}
+
+func utf16Bytes(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, (len(u)+1)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+func systemRecord(ht HeaderType, hookId uint16, tid, pid uint32, ts int64, payload []byte) []byte {
+	b := make([]byte, systemHeaderSize+len(payload))
+	binary.LittleEndian.PutUint16(b[0:], 2)
+	b[2] = byte(ht)
+	b[3] = TRACE_HEADER_FLAG | TRACE_HEADER_EVENT_TRACE
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(b)))
+	binary.LittleEndian.PutUint16(b[6:], hookId)
+	binary.LittleEndian.PutUint32(b[8:], tid)
+	binary.LittleEndian.PutUint32(b[12:], pid)
+	binary.LittleEndian.PutUint64(b[16:], uint64(ts))
+	copy(b[systemHeaderSize:], payload)
+	return b
+}
+
+func fullRecord(guid *etw.GUID, opcode, level uint8, tid, pid uint32, ts int64, payload []byte) []byte {
+	b := make([]byte, fullHeaderSize+len(payload))
+	binary.LittleEndian.PutUint16(b[0:], uint16(len(b)))
+	b[2] = byte(TRACE_HEADER_TYPE_FULL_HEADER64)
+	b[3] = TRACE_HEADER_FLAG | TRACE_HEADER_EVENT_TRACE
+	b[4] = opcode
+	b[5] = level
+	binary.LittleEndian.PutUint16(b[6:], 2)
+	binary.LittleEndian.PutUint32(b[8:], tid)
+	binary.LittleEndian.PutUint32(b[12:], pid)
+	binary.LittleEndian.PutUint64(b[16:], uint64(ts))
+	putGUID(b[24:], guid)
+	copy(b[fullHeaderSize:], payload)
+	return b
+}
+
+func eventRecord(guid *etw.GUID, d EventDescriptor, tid, pid uint32, ts int64, ext []etw.ExtendedDataItem, payload []byte) []byte {
+	var flags uint16
+
+	extData := new(bytes.Buffer)
+	for i, item := range ext {
+		hdr := make([]byte, extItemHeaderSize)
+		binary.LittleEndian.PutUint16(hdr[2:], item.ExtType)
+		if i < len(ext)-1 {
+			binary.LittleEndian.PutUint16(hdr[4:], 1)
+		}
+		binary.LittleEndian.PutUint16(hdr[6:], uint16(len(item.Data)))
+		extData.Write(hdr)
+		extData.Write(item.Data)
+		extData.Write(make([]byte, align(len(item.Data), recordAlignment)-len(item.Data)))
+	}
+
+	if len(ext) > 0 {
+		flags |= EVENT_HEADER_FLAG_EXTENDED_INFO
+	}
+
+	b := make([]byte, eventHeaderSize+extData.Len()+len(payload))
+	binary.LittleEndian.PutUint16(b[0:], uint16(len(b)))
+	b[2] = byte(TRACE_HEADER_TYPE_EVENT_HEADER64)
+	b[3] = TRACE_HEADER_FLAG | TRACE_HEADER_EVENT_TRACE
+	binary.LittleEndian.PutUint16(b[4:], flags)
+	binary.LittleEndian.PutUint32(b[8:], tid)
+	binary.LittleEndian.PutUint32(b[12:], pid)
+	binary.LittleEndian.PutUint64(b[16:], uint64(ts))
+	putGUID(b[24:], guid)
+	binary.LittleEndian.PutUint16(b[40:], d.Id)
+	b[42] = d.Version
+	b[43] = d.Channel
+	b[44] = d.Level
+	b[45] = d.Opcode
+	binary.LittleEndian.PutUint16(b[46:], d.Task)
+	binary.LittleEndian.PutUint64(b[48:], d.Keyword)
+	putGUID(b[64:], activityGUID)
+	copy(b[eventHeaderSize:], extData.Bytes())
+	copy(b[eventHeaderSize+extData.Len():], payload)
+	return b
+}
+
+func logfileHeaderPayload() []byte {
+	b := make([]byte, 0x118)
+	binary.LittleEndian.PutUint32(b[0x00:], fixtureBufferSize)
+	b[0x04] = 10
+	binary.LittleEndian.PutUint32(b[0x0C:], 4)
+	binary.LittleEndian.PutUint32(b[0x2C:], 8)
+	binary.LittleEndian.PutUint32(b[0x30:], 3)
+	binary.LittleEndian.PutUint64(b[0x100:], fixturePerfFreq)
+	binary.LittleEndian.PutUint64(b[0x108:], fixtureStartTime)
+	binary.LittleEndian.PutUint32(b[0x110:], ClockTypeQPC)
+	b = append(b, utf16Bytes("GolangTest")...)
+	b = append(b, utf16Bytes(`C:\Temp\GolangTest.etl`)...)
+	return b
+}
+
+func buildFixture() []byte {
+	w := newEtlWriter(fixtureBufferSize)
+
+	w.write(systemRecord(TRACE_HEADER_TYPE_SYSTEM64, EVENT_TRACE_GROUP_HEADER, 4, 4, fixtureHeaderTS, logfileHeaderPayload()))
+
+	// process start kernel event
+	w.write(systemRecord(TRACE_HEADER_TYPE_SYSTEM64, 0x0301, 8, 1337, fixtureHeaderTS+fixturePerfFreq, []byte("process")))
+
+	// classic image load event
+	w.write(fullRecord(imageLoadGUID, 10, 4, 8, 1337, fixtureHeaderTS+2*fixturePerfFreq, []byte("image")))
+
+	// enough manifest events to spread over several buffers
+	for i := 0; i < fixtureFileEvents; i++ {
+		ext := []etw.ExtendedDataItem{
+			{ExtType: etw.EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID, Data: make([]byte, 16)},
+			{ExtType: etw.EVENT_HEADER_EXT_TYPE_TS_ID, Data: []byte{1, 0, 0, 0}},
+		}
+		putGUID(ext[0].Data, relatedGUID)
+
+		d := EventDescriptor{Id: 12, Version: 1, Channel: 16, Level: 4, Task: 12, Keyword: 0x10}
+		w.write(eventRecord(kernelFileGUID, d, 16, 4242, fixtureHeaderTS+int64(3+i)*fixturePerfFreq, ext, bytes.Repeat([]byte{byte(i)}, 64)))
+	}
+
+	return w.bytes()
+}
+
+func openFixture(t *testing.T) *Reader {
+	if *update {
+		if err := os.WriteFile(fixture, buildFixture(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rd, err := Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rd
+}
+
+func TestReaderHeader(t *testing.T) {
+	tt := toast.FromT(t)
+
+	rd := openFixture(t)
+	defer rd.Close()
+
+	tt.Assert(rd.Header.BufferSize == fixtureBufferSize)
+	tt.Assert(rd.Header.MajorVersion == 10)
+	tt.Assert(rd.Header.NumberOfProcessors == 4)
+	tt.Assert(rd.Header.PointerSize == 8)
+	tt.Assert(rd.Header.EventsLost == 3)
+	tt.Assert(rd.Header.PerfFreq == fixturePerfFreq)
+	tt.Assert(rd.Header.StartTime == fixtureStartTime)
+	tt.Assert(rd.Header.ClockType() == ClockTypeQPC)
+	tt.Assert(rd.Header.LoggerName == "GolangTest")
+	tt.Assert(rd.Header.LogFileName == `C:\Temp\GolangTest.etl`)
+}
+
+func TestReaderRecords(t *testing.T) {
+	var r *Record
+	var err error
+
+	tt := toast.FromT(t)
+
+	rd := openFixture(t)
+	defer rd.Close()
+
+	start := etw.FiletimeToTime(fixtureStartTime)
+
+	// kernel event
+	r, err = rd.Next()
+	tt.CheckErr(err)
+	tt.Assert(r.IsKernel())
+	tt.Assert(r.IsClassic())
+	tt.Assert(r.HookId == 0x0301)
+	tt.Assert(r.ProcessId == 1337)
+	tt.Assert(r.ProcessorNumber == 1)
+	tt.Assert(r.LoggerId == 42)
+	tt.Assert(string(r.UserData) == "process")
+	tt.Assert(r.Time.Equal(start.Add(time.Second)))
+	e, err := r.Event()
+	tt.CheckErr(err)
+	tt.Assert(e.System.EventType == "Process/1")
+	tt.Assert(e.System.EventID == etw.MofClassMapping[r.ProviderId.Data1].BaseId+1)
+
+	// classic event
+	r, err = rd.Next()
+	tt.CheckErr(err)
+	tt.Assert(!r.IsKernel())
+	tt.Assert(r.IsClassic())
+	tt.Assert(r.ProviderId.Equals(imageLoadGUID))
+	tt.Assert(r.EventDescriptor.Opcode == 10)
+	tt.Assert(r.EventDescriptor.Level == 4)
+	tt.Assert(string(r.UserData) == "image")
+	tt.Assert(r.Time.Equal(start.Add(2 * time.Second)))
+	e, err = r.Event()
+	tt.CheckErr(err)
+	tt.Assert(e.System.EventType == "ImageLoad/10")
+	tt.Assert(e.System.EventGuid == imageLoadGUID.String())
+
+	// manifest events
+	for i := 0; i < fixtureFileEvents; i++ {
+		r, err = rd.Next()
+		tt.CheckErr(err)
+		tt.Assert(!r.IsClassic())
+		tt.Assert(r.PointerSize() == 8)
+		tt.Assert(r.ProviderId.Equals(kernelFileGUID))
+		tt.Assert(r.EventID() == 12)
+		tt.Assert(r.EventDescriptor.Keyword == 0x10)
+		tt.Assert(r.ActivityId.Equals(activityGUID))
+		tt.Assert(len(r.ExtendedData) == 2)
+		tt.Assert(r.ExtendedData[1].ExtType == etw.EVENT_HEADER_EXT_TYPE_TS_ID)
+		tt.Assert(bytes.Equal(r.UserData, bytes.Repeat([]byte{byte(i)}, 64)))
+		tt.Assert(r.Time.Equal(start.Add(time.Duration(3+i) * time.Second)))
+
+		e, err = r.Event()
+		tt.CheckErr(err)
+		tt.Assert(e.System.EventID == 12)
+		tt.Assert(e.System.Provider.Guid == *kernelFileGUID)
+		tt.Assert(e.System.Execution.ProcessID == 4242)
+		tt.Assert(e.System.Execution.ThreadID == 16)
+		tt.Assert(e.System.Correlation.ActivityID == *activityGUID)
+		tt.Assert(e.System.Correlation.RelatedActivityID == *relatedGUID)
+		tt.Assert(e.System.TimeCreated.SystemTime.Equal(r.Time))
+		tt.Assert(*e.ExtendedData.TerminalSessionID == 1)
+	}
+
+	tt.Assert(rd.BuffersRead > 1)
+
+	_, err = rd.Next()
+	tt.Assert(err == io.EOF)
+}
+
+// captureRecord is a record expected at a given index of a capture
+type captureRecord struct {
+	Index     int
+	Provider  string
+	EventID   uint16
+	Opcode    uint8
+	ProcessID uint32
+}
+
+// capture describes an .etl file captured on Windows (logman, xperf...)
+// with values checked against another tool such as tracerpt
+type capture struct {
+	LoggerName  string
+	PointerSize uint32
+	Records     int
+	Expected    []captureRecord
+}
+
+// TestReaderCaptures checks the reader against real captures, unlike the
+// synthetic fixture they are not produced by the test itself. A capture
+// named testdata/captures/<name>.etl comes with <name>.json holding
+// a capture structure.
+func TestReaderCaptures(t *testing.T) {
+	tt := toast.FromT(t)
+
+	paths, err := filepath.Glob(filepath.Join(captures, "*.etl"))
+	tt.CheckErr(err)
+	if len(paths) == 0 {
+		t.Skip("no capture found in", captures)
+	}
+
+	for _, path := range paths {
+		var c capture
+
+		golden, err := os.ReadFile(path[:len(path)-len(".etl")] + ".json")
+		tt.CheckErr(err)
+		tt.CheckErr(json.Unmarshal(golden, &c))
+
+		rd, err := Open(path)
+		tt.CheckErr(err)
+		tt.Assert(rd.Header.LoggerName == c.LoggerName, path)
+		tt.Assert(rd.Header.PointerSize == c.PointerSize, path)
+
+		records := make([]*Record, 0, c.Records)
+		for {
+			r, err := rd.Next()
+			if err == io.EOF {
+				break
+			}
+			tt.CheckErr(err)
+			_, err = r.Event()
+			tt.CheckErr(err)
+			records = append(records, r)
+		}
+		tt.CheckErr(rd.Close())
+		tt.Assert(len(records) == c.Records, path, len(records))
+
+		for _, exp := range c.Expected {
+			tt.Assert(exp.Index < len(records), path, exp.Index)
+			r := records[exp.Index]
+			tt.Assert(r.ProviderId.Equals(etw.MustParseGUIDFromString(exp.Provider)), path, exp.Index)
+			tt.Assert(r.EventID() == exp.EventID, path, exp.Index)
+			tt.Assert(r.EventDescriptor.Opcode == exp.Opcode, path, exp.Index)
+			tt.Assert(r.ProcessId == exp.ProcessID, path, exp.Index)
+		}
+	}
+}
+
+func TestRecordEventMalformedExtendedData(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// S-1-5-18
+	sid := []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+	r := &Record{ExtendedData: []etw.ExtendedDataItem{
+		{ExtType: etw.EVENT_HEADER_EXT_TYPE_TS_ID, Data: []byte{1}},
+		{ExtType: etw.EVENT_HEADER_EXT_TYPE_SID, Data: sid},
+	}}
+
+	// items following a malformed one are still decoded
+	e, err := r.Event()
+	tt.Assert(errors.Is(err, etw.ErrExtendedData))
+	tt.Assert(e.ExtendedData.TerminalSessionID == nil)
+	tt.Assert(e.ExtendedData.UserSID == "S-1-5-18")
+}
+
+func TestReaderErrors(t *testing.T) {
+	tt := toast.FromT(t)
+
+	data := buildFixture()
+
+	// truncated in the middle of the second buffer
+	rd, err := NewReader(bytes.NewReader(data[:fixtureBufferSize+fixtureBufferSize/2]))
+	tt.CheckErr(err)
+	for err == nil {
+		_, err = rd.Next()
+	}
+	tt.Assert(errors.Is(err, ErrTruncated))
+
+	// not an etl file
+	_, err = NewReader(bytes.NewReader(bytes.Repeat([]byte{0x41}, fixtureBufferSize)))
+	tt.Assert(err != nil)
+
+	// corrupted buffer size must not be allocated
+	corrupted := append([]byte{}, data[:bufferHeaderSize]...)
+	binary.LittleEndian.PutUint32(corrupted, 0xffffffff)
+	_, err = NewReader(bytes.NewReader(corrupted))
+	tt.Assert(errors.Is(err, ErrBadFormat))
+
+	// first record is not a logfile header
+	w := newEtlWriter(fixtureBufferSize)
+	w.write(fullRecord(imageLoadGUID, 10, 4, 8, 1337, 0, nil))
+	_, err = NewReader(bytes.NewReader(w.bytes()))
+	tt.Assert(errors.Is(err, ErrBadFormat))
+}
@@ -0,0 +1,372 @@
+package etl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+)
+
+const (
+	EVENT_HEADER_FLAG_EXTENDED_INFO = 0x0001
+
+	// Kernel group of the event used to store the logfile header
+	EVENT_TRACE_GROUP_HEADER = 0x0000
+)
+
+var (
+	// kernelGroupGuids maps kernel event groups (high byte of HookId)
+	// to the GUID of the MOF class describing the events
+	kernelGroupGuids = map[uint16]etw.GUID{
+		0x0000: *etw.MustParseGUIDFromString("{68fdd900-4a3e-11d1-84f4-0000f80464e3}"), // EventTraceEvent
+		0x0100: *etw.MustParseGUIDFromString("{3d6fa8d4-fe05-11d0-9dda-00c04fd7ba7c}"), // DiskIo
+		0x0200: *etw.MustParseGUIDFromString("{3d6fa8d3-fe05-11d0-9dda-00c04fd7ba7c}"), // PageFault
+		0x0300: *etw.MustParseGUIDFromString("{3d6fa8d0-fe05-11d0-9dda-00c04fd7ba7c}"), // Process
+		0x0400: *etw.MustParseGUIDFromString("{90cbdc39-4a3e-11d1-84f4-0000f80464e3}"), // FileIo
+		0x0500: *etw.MustParseGUIDFromString("{3d6fa8d1-fe05-11d0-9dda-00c04fd7ba7c}"), // Thread
+		0x0600: *etw.MustParseGUIDFromString("{9a280ac0-c8e0-11d1-84e2-00c04fb998a2}"), // TcpIp
+		0x0800: *etw.MustParseGUIDFromString("{bf3a50c5-a9c9-4988-a005-2df0b7c80f80}"), // UdpIp
+		0x0900: *etw.MustParseGUIDFromString("{ae53722e-c863-11d2-8659-00c04fa321a1}"), // Registry
+		0x0A00: *etw.MustParseGUIDFromString("{13976d09-a327-438c-950b-7f03192815c7}"), // DbgPrint
+		0x0B00: *etw.MustParseGUIDFromString("{01853a65-418f-4f36-aefc-dc0f1d2fd235}"), // EventTraceConfig
+		0x0F00: *etw.MustParseGUIDFromString("{ce1dbfb4-137e-4da6-87b0-3f59aa102cbc}"), // PerfInfo
+		0x1400: *etw.MustParseGUIDFromString("{2cb15d1d-5fc1-11d2-abe1-00a0c911f518}"), // ImageLoad
+		0x1A00: *etw.MustParseGUIDFromString("{45d8cccd-539f-4b72-a8b7-5c683142609a}"), // ALPC
+		0x1B00: *etw.MustParseGUIDFromString("{d837ca92-12b9-44a5-ad6a-3a65b3578aa8}"), // SplitIo
+	}
+
+	nullGUID = etw.GUID{}
+)
+
+/*
+typedef struct _EVENT_DESCRIPTOR {
+  USHORT    Id;
+  UCHAR     Version;
+  UCHAR     Channel;
+  UCHAR     Level;
+  UCHAR     Opcode;
+  USHORT    Task;
+  ULONGLONG Keyword;
+} EVENT_DESCRIPTOR, *PEVENT_DESCRIPTOR;
+*/
+
+// EventDescriptor mirrors EVENT_DESCRIPTOR structure
+type EventDescriptor struct {
+	Id      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	Opcode  uint8
+	Task    uint16
+	Keyword uint64
+}
+
+// Record is an event record read from an .etl file. Depending on the
+// type of header used to log the event, some of the fields may be empty.
+type Record struct {
+	HeaderType HeaderType
+	// Flags and EventProperty members of EVENT_HEADER
+	Flags         uint16
+	EventProperty uint16
+	// HookId of kernel events (group << 8 | opcode)
+	HookId uint16
+	// Version of the header of kernel events
+	HeaderVersion uint16
+
+	ThreadId   uint32
+	ProcessId  uint32
+	TimeStamp  int64
+	ProviderId etw.GUID
+	// For classic and kernel events, only Version, Level and Opcode are set
+	EventDescriptor EventDescriptor
+	KernelTime      uint32
+	UserTime        uint32
+	ActivityId      etw.GUID
+
+	// Buffer context of the record
+	ProcessorNumber uint8
+	LoggerId        uint16
+
+	ExtendedData []etw.ExtendedDataItem
+	UserData     []byte
+
+	// Time is the timestamp converted to UTC according to
+	// the clock type of the log file
+	Time time.Time
+}
+
+// IsKernel returns true if the record has been logged with a
+// system (kernel) header
+func (r *Record) IsKernel() bool {
+	switch r.HeaderType {
+	case TRACE_HEADER_TYPE_SYSTEM32,
+		TRACE_HEADER_TYPE_SYSTEM64,
+		TRACE_HEADER_TYPE_COMPACT32,
+		TRACE_HEADER_TYPE_COMPACT64,
+		TRACE_HEADER_TYPE_PERFINFO32,
+		TRACE_HEADER_TYPE_PERFINFO64:
+		return true
+	}
+	return false
+}
+
+// IsClassic returns true if the record is a MOF event, logged either
+// with a kernel header or with an EVENT_TRACE_HEADER
+func (r *Record) IsClassic() bool {
+	return r.IsKernel() ||
+		r.HeaderType == TRACE_HEADER_TYPE_FULL_HEADER32 ||
+		r.HeaderType == TRACE_HEADER_TYPE_FULL_HEADER64
+}
+
+// PointerSize returns the size of pointers on the system the record
+// was logged on
+func (r *Record) PointerSize() int {
+	return r.HeaderType.PointerSize()
+}
+
+// RelatedActivityID returns the related activity ID found in extended
+// data or a null GUID
+func (r *Record) RelatedActivityID() etw.GUID {
+	for _, item := range r.ExtendedData {
+		if item.ExtType == etw.EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID && len(item.Data) >= 16 {
+			var g etw.GUID
+			if err := g.UnmarshalBinary(item.Data[:16]); err == nil {
+				return g
+			}
+		}
+	}
+	return nullGUID
+}
+
+// EventID returns the event ID of the record. For MOF events, it is
+// computed the same way as etw.TraceEventInfo.EventID does.
+func (r *Record) EventID() uint16 {
+	if r.IsClassic() {
+		if c, ok := etw.MofClassMapping[r.ProviderId.Data1]; ok {
+			return c.BaseId + uint16(r.EventDescriptor.Opcode)
+		}
+		return 0
+	}
+	return r.EventDescriptor.Id
+}
+
+// Event converts the record into an etw.Event. As no schema is available
+// in the log file, only System fields and extended data are filled. Like
+// with Consumer, the event is returned even if some extended data items
+// are malformed, err reporting them.
+func (r *Record) Event() (e *etw.Event, err error) {
+	e = etw.NewEvent()
+	e.System.EventID = r.EventID()
+	e.System.Execution.ProcessID = r.ProcessId
+	e.System.Execution.ThreadID = r.ThreadId
+	e.System.Correlation.ActivityID = r.ActivityId
+	e.System.Correlation.RelatedActivityID = r.RelatedActivityID()
+	e.System.Provider.Guid = r.ProviderId
+	e.System.Level.Value = r.EventDescriptor.Level
+	e.System.Opcode.Value = r.EventDescriptor.Opcode
+	e.System.Keywords.Value = r.EventDescriptor.Keyword
+	e.System.Task.Value = r.EventDescriptor.Task
+	e.System.TimeCreated.SystemTime = r.Time
+
+	if r.IsClassic() {
+		if c, ok := etw.MofClassMapping[r.ProviderId.Data1]; ok {
+			e.System.EventType = fmt.Sprintf("%s/%d", c.Name, r.EventDescriptor.Opcode)
+		} else {
+			e.System.EventType = fmt.Sprintf("UnknownClass/%d", r.EventDescriptor.Opcode)
+		}
+		e.System.EventGuid = r.ProviderId.String()
+	}
+
+	if len(r.ExtendedData) > 0 {
+		e.ExtendedData, err = etw.DecodeExtendedData(r.ExtendedData)
+	}
+
+	return
+}
+
+// isEndOfBuffer returns true if b does not start with a record
+func isEndOfBuffer(b []byte) bool {
+	// unused space in buffers is filled with 0xff
+	return len(b) < 4 || b[3]&TRACE_HEADER_FLAG == 0 || binary.LittleEndian.Uint32(b) == 0xffffffff
+}
+
+// parseRecord parses the record found at the beginning of b. It returns
+// the size of the record (not aligned) and a nil record if the header type
+// is not supported.
+func parseRecord(b []byte) (r *Record, size int, err error) {
+	var hdrSize int
+
+	if len(b) < 8 {
+		return nil, 0, ErrTruncated
+	}
+
+	ht := HeaderType(b[2])
+
+	switch ht {
+	case TRACE_HEADER_TYPE_SYSTEM32, TRACE_HEADER_TYPE_SYSTEM64,
+		TRACE_HEADER_TYPE_COMPACT32, TRACE_HEADER_TYPE_COMPACT64,
+		TRACE_HEADER_TYPE_PERFINFO32, TRACE_HEADER_TYPE_PERFINFO64:
+		size = int(binary.LittleEndian.Uint16(b[4:]))
+	default:
+		size = int(binary.LittleEndian.Uint16(b[0:]))
+	}
+
+	if size < 8 {
+		return nil, 0, fmt.Errorf("%w: invalid record size %d", ErrBadFormat, size)
+	}
+
+	if size > len(b) {
+		return nil, 0, ErrTruncated
+	}
+
+	b = b[:size]
+	r = &Record{HeaderType: ht}
+
+	switch ht {
+	case TRACE_HEADER_TYPE_SYSTEM32, TRACE_HEADER_TYPE_SYSTEM64:
+		hdrSize = systemHeaderSize
+	case TRACE_HEADER_TYPE_COMPACT32, TRACE_HEADER_TYPE_COMPACT64:
+		hdrSize = compactHeaderSize
+	case TRACE_HEADER_TYPE_PERFINFO32, TRACE_HEADER_TYPE_PERFINFO64:
+		hdrSize = perfinfoHeaderSize
+	case TRACE_HEADER_TYPE_FULL_HEADER32, TRACE_HEADER_TYPE_FULL_HEADER64:
+		hdrSize = fullHeaderSize
+	case TRACE_HEADER_TYPE_EVENT_HEADER32, TRACE_HEADER_TYPE_EVENT_HEADER64:
+		hdrSize = eventHeaderSize
+	default:
+		// unsupported header type, caller skips the record
+		return nil, size, nil
+	}
+
+	if size < hdrSize {
+		return nil, 0, fmt.Errorf("%w: record size %d smaller than header", ErrBadFormat, size)
+	}
+
+	if r.IsKernel() {
+		/*
+			USHORT Version; UCHAR HeaderType; UCHAR Flags;
+			USHORT Size; USHORT HookId;
+			ULONG ThreadId; ULONG ProcessId; (not in PERFINFO)
+			LARGE_INTEGER SystemTime;
+			ULONG KernelTime; ULONG UserTime; (only in SYSTEM)
+		*/
+		r.HeaderVersion = binary.LittleEndian.Uint16(b[0:])
+		r.HookId = binary.LittleEndian.Uint16(b[6:])
+		r.EventDescriptor.Opcode = uint8(r.HookId & 0xff)
+		r.EventDescriptor.Version = uint8(r.HeaderVersion)
+		r.ProviderId = kernelGroupGuids[r.HookId&0xff00]
+
+		switch hdrSize {
+		case perfinfoHeaderSize:
+			r.TimeStamp = int64(binary.LittleEndian.Uint64(b[8:]))
+		default:
+			r.ThreadId = binary.LittleEndian.Uint32(b[8:])
+			r.ProcessId = binary.LittleEndian.Uint32(b[12:])
+			r.TimeStamp = int64(binary.LittleEndian.Uint64(b[16:]))
+			if hdrSize == systemHeaderSize {
+				r.KernelTime = binary.LittleEndian.Uint32(b[24:])
+				r.UserTime = binary.LittleEndian.Uint32(b[28:])
+			}
+		}
+
+		r.UserData = b[hdrSize:]
+		return
+	}
+
+	if hdrSize == fullHeaderSize {
+		// EVENT_TRACE_HEADER
+		r.EventDescriptor.Opcode = b[4]
+		r.EventDescriptor.Level = b[5]
+		r.EventDescriptor.Version = uint8(binary.LittleEndian.Uint16(b[6:]))
+		r.ThreadId = binary.LittleEndian.Uint32(b[8:])
+		r.ProcessId = binary.LittleEndian.Uint32(b[12:])
+		r.TimeStamp = int64(binary.LittleEndian.Uint64(b[16:]))
+		if err = r.ProviderId.UnmarshalBinary(b[24:40]); err != nil {
+			return
+		}
+		r.KernelTime = binary.LittleEndian.Uint32(b[40:])
+		r.UserTime = binary.LittleEndian.Uint32(b[44:])
+		r.UserData = b[hdrSize:]
+		return
+	}
+
+	// EVENT_HEADER
+	r.Flags = binary.LittleEndian.Uint16(b[4:])
+	r.EventProperty = binary.LittleEndian.Uint16(b[6:])
+	r.ThreadId = binary.LittleEndian.Uint32(b[8:])
+	r.ProcessId = binary.LittleEndian.Uint32(b[12:])
+	r.TimeStamp = int64(binary.LittleEndian.Uint64(b[16:]))
+	if err = r.ProviderId.UnmarshalBinary(b[24:40]); err != nil {
+		return
+	}
+	r.EventDescriptor = EventDescriptor{
+		Id:      binary.LittleEndian.Uint16(b[40:]),
+		Version: b[42],
+		Channel: b[43],
+		Level:   b[44],
+		Opcode:  b[45],
+		Task:    binary.LittleEndian.Uint16(b[46:]),
+		Keyword: binary.LittleEndian.Uint64(b[48:]),
+	}
+	r.KernelTime = binary.LittleEndian.Uint32(b[56:])
+	r.UserTime = binary.LittleEndian.Uint32(b[60:])
+	if err = r.ActivityId.UnmarshalBinary(b[64:80]); err != nil {
+		return
+	}
+
+	off := hdrSize
+	if r.Flags&EVENT_HEADER_FLAG_EXTENDED_INFO == EVENT_HEADER_FLAG_EXTENDED_INFO {
+		if r.ExtendedData, off, err = parseExtendedData(b, off); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	r.UserData = b[off:]
+
+	return
+}
+
+/*
+Extended data items are stored inline after the EVENT_HEADER, each one
+starting with a header similar to EVENT_HEADER_EXTENDED_DATA_ITEM
+without DataPtr, followed by the data aligned on 8 bytes.
+
+	USHORT Reserved1;
+	USHORT ExtType;
+	struct {
+	  USHORT Linkage : 1;
+	  USHORT Reserved2 : 15;
+	};
+	USHORT DataSize;
+	BYTE   Data[DataSize];
+*/
+func parseExtendedData(b []byte, off int) (items []etw.ExtendedDataItem, end int, err error) {
+	for {
+		if off+extItemHeaderSize > len(b) {
+			return nil, 0, ErrTruncated
+		}
+
+		extType := binary.LittleEndian.Uint16(b[off+2:])
+		linkage := binary.LittleEndian.Uint16(b[off+4:]) & 0x1
+		dataSize := int(binary.LittleEndian.Uint16(b[off+6:]))
+		off += extItemHeaderSize
+
+		if off+dataSize > len(b) {
+			return nil, 0, ErrTruncated
+		}
+
+		items = append(items, etw.ExtendedDataItem{ExtType: extType, Data: b[off : off+dataSize]})
+		off = align(off+dataSize, recordAlignment)
+
+		if linkage == 0 {
+			break
+		}
+	}
+
+	if off > len(b) {
+		off = len(b)
+	}
+
+	return items, off, nil
+}
@@ -1,15 +1,13 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"strconv"
-	"syscall"
-	"unsafe"
+	"strings"
 
 	"github.com/0xrawsec/golang-utils/log"
 )
@@ -27,14 +25,14 @@ var (
 
 type Property struct {
 	evtRecordHelper *EventRecordHelper
-	evtPropInfo     *EventPropertyInfo
+	info            *PropertyInfo
 
 	name   string
 	value  string
 	length uint32
 
-	pValue         uintptr
-	userDataLength uint16
+	// user data starting at the property and ending with it
+	data []byte
 }
 
 func maxu32(a, b uint32) uint32 {
@@ -45,7 +43,7 @@ func maxu32(a, b uint32) uint32 {
 }
 
 func (p *Property) Parseable() bool {
-	return p.evtRecordHelper != nil && p.evtPropInfo != nil && p.pValue > 0
+	return p.evtRecordHelper != nil && p.info != nil && p.data != nil
 }
 
 func (p *Property) Value() (string, error) {
@@ -59,67 +57,95 @@ func (p *Property) Value() (string, error) {
 	return p.value, err
 }
 
+// Decode decodes the property into a native Go value without calling
+// TdhFormatProperty (see PropertyDecoder). Value maps are not resolved so
+// mapped properties are returned as raw integers.
+func (p *Property) Decode() (value interface{}, err error) {
+	if !p.Parseable() {
+		return p.Value()
+	}
+
+	decoder := NewPropertyDecoder(p.evtRecordHelper.EventRec.PointerSize())
+
+	length := uint16(p.length)
+	// length of fixed size types is implicit
+	if _, ok := p.info.LengthIndex(); !ok {
+		switch p.info.InType {
+		case TdhInTypeUnicodestring, TdhInTypeAnsistring, TdhInTypeBinary,
+			TdhInTypeNonnullterminatedstring, TdhInTypeNonnullterminatedansistring:
+		default:
+			length = 0
+		}
+	}
+
+	value, _, err = decoder.Decode(p.data, p.info.InType, p.info.OutType, length)
+
+	return
+}
+
 func (p *Property) parse() (value string, err error) {
 	var mapInfo *EventMapInfo
-	var udc uint16
-	var buff []uint16
 
-	formattedDataSize := maxu32(16, p.length)
+	e := p.evtRecordHelper
 
 	// Get the name/value mapping if the property specifies a value map.
-	if p.evtPropInfo.MapNameOffset() > 0 {
-		pMapName := (*uint16)(unsafe.Pointer(p.evtRecordHelper.TraceInfo.pointerOffset(uintptr(p.evtPropInfo.MapNameOffset()))))
-		decSrc := p.evtRecordHelper.TraceInfo.DecodingSource
-		if mapInfo, err = p.evtRecordHelper.EventRec.GetMapInfo(pMapName, uint32(decSrc)); err != nil {
+	if p.info.MapName != "" {
+		if mapInfo, err = e.metadata.mapInformation(e.backend, e.EventRec, p.info.MapName); err != nil {
 			err = fmt.Errorf("failed to get map info: %s", err)
 			return
 		}
 	}
 
 	for {
-		buff = make([]uint16, formattedDataSize)
-
-		err = TdhFormatProperty(
-			p.evtRecordHelper.TraceInfo,
+		value, err = e.backend.formatProperty(
+			e.TraceInfo,
 			mapInfo,
-			p.evtRecordHelper.EventRec.PointerSize(),
-			p.evtPropInfo.InType(),
-			p.evtPropInfo.OutType(),
+			e.EventRec.PointerSize(),
+			uint16(p.info.InType),
+			uint16(p.info.OutType),
 			uint16(p.length),
-			p.userDataLength,
-			(*byte)(unsafe.Pointer(p.pValue)),
-			&formattedDataSize,
-			&buff[0],
-			&udc)
-
-		if err == syscall.ERROR_INSUFFICIENT_BUFFER {
-			continue
-		}
+			p.data)
 
 		if err == ERROR_EVT_INVALID_EVENT_DATA {
 			if mapInfo == nil {
-				break
+				// property cannot be formatted
+				return "", nil
 			}
 			mapInfo = nil
 			continue
 		}
 
-		if err == nil {
-			break
+		if err != nil {
+			err = fmt.Errorf("failed to format property : %s", err)
 		}
 
-		err = fmt.Errorf("failed to format property : %s", err)
 		return
 	}
+}
 
-	value = syscall.UTF16ToString(buff)
+// eventValue returns the value of the property as set in events, decoded
+// into a native Go value if typed is true. Properties having a value map
+// or a type unknown to PropertyDecoder are formatted by TDH.
+func (p *Property) eventValue(typed bool) (interface{}, error) {
+	if !typed || p.info == nil || p.info.MapName != "" {
+		return p.Value()
+	}
 
-	return
+	v, err := p.Decode()
+	if errors.Is(err, ErrUnknownInType) {
+		return p.Value()
+	}
+	return v, err
 }
 
 type EventRecordHelper struct {
+	backend  eventInfoBackend
+	metadata *eventMetadata
+
 	EventRec  *EventRecord
 	TraceInfo *TraceEventInfo
+	// Schema is the validated content of TraceInfo
+	Schema *EventSchema
 
 	Properties      map[string]*Property
 	ArrayProperties map[string][]*Property
@@ -130,18 +156,28 @@ type EventRecordHelper struct {
 		Skippable bool
 	}
 
-	userDataIt         uintptr
+	// user data of the event and offset of the next property to prepare
+	userData           []byte
+	userDataOffset     int
 	selectedProperties map[string]bool
+	// properties are decoded into native Go values when building events
+	typed bool
+	// values of the integer properties already prepared, by index
+	values  []propertyValue
+	decoder PropertyDecoder
 }
 
-func newEventRecordHelper(er *EventRecord) (erh *EventRecordHelper, err error) {
-	erh = &EventRecordHelper{}
+func newEventRecordHelper(er *EventRecord, backend eventInfoBackend, cache *SchemaCache) (erh *EventRecordHelper, err error) {
+	erh = &EventRecordHelper{backend: backend}
 	erh.EventRec = er
 
-	if erh.TraceInfo, err = er.GetEventInformation(); err != nil {
+	if erh.metadata, err = cache.eventMetadata(backend, er); err != nil {
 		return
 	}
 
+	erh.TraceInfo = erh.metadata.info
+	erh.Schema = erh.metadata.schema
+
 	return
 }
 
@@ -150,88 +186,100 @@ func (e *EventRecordHelper) initialize() {
 	e.ArrayProperties = make(map[string][]*Property)
 	e.Structures = make([]map[string]*Property, 0)
 	e.selectedProperties = make(map[string]bool)
+	e.values = make([]propertyValue, len(e.Schema.Properties))
+	e.decoder.PointerSize = e.EventRec.PointerSize()
 
-	e.userDataIt = e.EventRec.UserData
+	e.userData = e.EventRec.userDataBytes()
+	e.userDataOffset = 0
 }
 
 func (e *EventRecordHelper) setEventMetadata(event *Event) {
 	event.System.Computer = hostname
 	event.System.Execution.ProcessID = e.EventRec.EventHeader.ProcessId
 	event.System.Execution.ThreadID = e.EventRec.EventHeader.ThreadId
-	event.System.Correlation.ActivityID = e.EventRec.EventHeader.ActivityId.String()
+	event.System.Correlation.ActivityID = e.EventRec.EventHeader.ActivityId
 	event.System.Correlation.RelatedActivityID = e.EventRec.RelatedActivityID()
-	event.System.EventID = e.TraceInfo.EventID()
-	event.System.Channel = e.TraceInfo.ChannelName()
-	event.System.Provider.Guid = e.TraceInfo.ProviderGUID.String()
-	event.System.Provider.Name = e.TraceInfo.ProviderName()
-	event.System.Level.Value = e.TraceInfo.EventDescriptor.Level
-	event.System.Level.Name = e.TraceInfo.LevelName()
-	event.System.Opcode.Value = e.TraceInfo.EventDescriptor.Opcode
-	event.System.Opcode.Name = e.TraceInfo.OpcodeName()
-	event.System.Keywords.Value = e.TraceInfo.EventDescriptor.Keyword
-	event.System.Keywords.Name = e.TraceInfo.KeywordName()
-	event.System.Task.Value = uint8(e.TraceInfo.EventDescriptor.Task)
-	event.System.Task.Name = e.TraceInfo.TaskName()
+	event.System.EventID = e.Schema.EventID()
+	event.System.Channel = e.Schema.ChannelName
+	event.System.Provider.Guid = e.Schema.ProviderGUID
+	event.System.Provider.Name = e.Schema.ProviderName
+	event.System.Level.Value = e.Schema.EventDescriptor.Level
+	event.System.Level.Name = e.Schema.LevelName
+	event.System.Opcode.Value = e.Schema.EventDescriptor.Opcode
+	event.System.Opcode.Name = e.Schema.OpcodeName
+	event.System.Keywords.Value = e.Schema.EventDescriptor.Keyword
+	event.System.Keywords.Name = e.Schema.KeywordsName
+	event.System.Task.Value = e.Task()
+	event.System.Task.Name = e.Schema.TaskName
 	event.System.TimeCreated.SystemTime = e.EventRec.EventHeader.UTCTimeStamp()
 
-	if e.TraceInfo.IsMof() {
+	if e.Schema.DecodingSource == DecodingSourceWbem {
 		var eventType string
-		if t, ok := MofClassMapping[e.TraceInfo.EventGUID.Data1]; ok {
+		if t, ok := MofClassMapping[e.Schema.EventGUID.Data1]; ok {
 			eventType = fmt.Sprintf("%s/%s", t.Name, event.System.Opcode.Name)
 		} else {
 			eventType = fmt.Sprintf("UnknownClass/%s", event.System.Opcode.Name)
 		}
 		event.System.EventType = eventType
-		event.System.EventGuid = e.TraceInfo.EventGUID.String()
+		event.System.EventGuid = e.Schema.EventGUID.String()
 	}
 }
 
-func (e *EventRecordHelper) endUserData() uintptr {
-	return e.EventRec.UserData + uintptr(e.EventRec.UserDataLength)
+// getPropertyUint returns the value of an integer property giving the
+// length or the count of another property
+func (e *EventRecordHelper) getPropertyUint(desc *PropertyDataDescriptor) (uint32, error) {
+	size, err := e.backend.propertySize(e.EventRec, desc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get property size: %s", err)
+	}
+
+	buf := make([]byte, maxu32(size, 4))
+	if err = e.backend.property(e.EventRec, desc, buf[:size]); err != nil {
+		return 0, fmt.Errorf("failed to get property: %s", err)
+	}
+
+	return binary.LittleEndian.Uint32(buf), nil
 }
 
-func (e *EventRecordHelper) userDataLength() uint16 {
-	return uint16(e.endUserData() - e.userDataIt)
+// getReferencedValue returns the value of property j giving the length or
+// the count of another property. It is taken from the properties already
+// prepared and TDH is queried only if j has not been prepared.
+func (e *EventRecordHelper) getReferencedValue(j uint32) (uint32, error) {
+	if int(j) < len(e.values) && e.values[j].set {
+		return e.values[j].value, nil
+	}
+
+	pdd := PropertyDataDescriptor{}
+	pdd.PropertyName = uint64(e.TraceInfo.PropertyNameOffset(j))
+	pdd.ArrayIndex = math.MaxUint32
+	return e.getPropertyUint(&pdd)
 }
 
 func (e *EventRecordHelper) getPropertyLength(i uint32) (uint32, error) {
-	if epi := e.TraceInfo.GetEventPropertyInfoAt(i); epi.Flags&PropertyParamLength == PropertyParamLength {
-		propSize := uint32(0)
-		length := uint32(0)
-		j := uint32(epi.LengthPropertyIndex())
-		pdd := PropertyDataDescriptor{}
-		pdd.PropertyName = uint64(e.TraceInfo.pointer()) + uint64(e.TraceInfo.GetEventPropertyInfoAt(j).NameOffset)
-		pdd.ArrayIndex = math.MaxUint32
-		if err := TdhGetPropertySize(e.EventRec, 0, nil, 1, &pdd, &propSize); err != nil {
-			return 0, fmt.Errorf("failed to get property size: %s", err)
-		} else {
-			if err := TdhGetProperty(e.EventRec, 0, nil, 1, &pdd, propSize, (*byte)(unsafe.Pointer(&length))); err != nil {
-				return 0, fmt.Errorf("failed to get property: %s", err)
-			}
-			return length, nil
-		}
+	if pi := &e.Schema.Properties[i]; pi.Flags&PropertyParamLength == PropertyParamLength {
+		return e.getReferencedValue(uint32(pi.Length))
 	} else {
-		if epi.Length() > 0 {
-			return uint32(epi.Length()), nil
+		if pi.Length > 0 {
+			return uint32(pi.Length), nil
 		} else {
 			switch {
 			// if there is an error returned here just try to add a switch case
 			// with the proper in type
-			case epi.InType() == uint16(TdhInTypeBinary) && epi.OutType() == uint16(TdhOutTypeIpv6):
+			case pi.InType == TdhInTypeBinary && pi.OutType == TdhOutTypeIpv6:
 				// sizeof(IN6_ADDR) == 16
 				return uint32(16), nil
-			case epi.InType() == uint16(TdhInTypeUnicodestring):
-				return uint32(epi.Length()), nil
-			case epi.InType() == uint16(TdhInTypeAnsistring):
-				return uint32(epi.Length()), nil
-			case epi.InType() == uint16(TdhInTypeSid):
-				return uint32(epi.Length()), nil
-			case epi.InType() == uint16(TdhInTypeWbemsid):
-				return uint32(epi.Length()), nil
-			case epi.Flags&PropertyStruct == PropertyStruct:
-				return uint32(epi.Length()), nil
+			case pi.InType == TdhInTypeUnicodestring:
+				return uint32(pi.Length), nil
+			case pi.InType == TdhInTypeAnsistring:
+				return uint32(pi.Length), nil
+			case pi.InType == TdhInTypeSid:
+				return uint32(pi.Length), nil
+			case pi.InType == TdhInTypeWbemsid:
+				return uint32(pi.Length), nil
+			case pi.IsStruct():
+				return uint32(pi.Length), nil
 			default:
-				return 0, fmt.Errorf("unexpected length of 0 for intype %d and outtype %d", epi.InType(), epi.OutType())
+				return 0, fmt.Errorf("unexpected length of 0 for intype %d and outtype %d", pi.InType, pi.OutType)
 			}
 		}
 	}
@@ -241,29 +289,35 @@ func (e *EventRecordHelper) getPropertySize(i uint32) (size uint32, err error) {
 	dataDesc := PropertyDataDescriptor{}
 	dataDesc.PropertyName = uint64(e.TraceInfo.PropertyNameOffset(i))
 	dataDesc.ArrayIndex = math.MaxUint32
-	err = TdhGetPropertySize(e.EventRec, 0, nil, 1, &dataDesc, &size)
-	return
+	return e.backend.propertySize(e.EventRec, &dataDesc)
+}
+
+// getLocalPropertySize returns the size of an element of property i at the
+// current position in user data. Size is computed from the schema and the
+// user data, TDH being queried only for properties of unknown layout.
+func (e *EventRecordHelper) getLocalPropertySize(i, length uint32) (uint32, error) {
+	size, ok, err := localPropertySize(&e.decoder, &e.Schema.Properties[i], length, e.remainingUserData())
+	if err != nil {
+		return 0, fmt.Errorf("%w %s: %s", ErrPropertyParsing, e.Schema.Properties[i].Name, err)
+	}
+
+	if !ok {
+		return e.getPropertySize(i)
+	}
+
+	return size, nil
 }
 
 func (e *EventRecordHelper) getArraySize(i uint32) (arraySize uint16, err error) {
-	dataDesc := PropertyDataDescriptor{}
-	propSz := uint32(0)
-
-	epi := e.TraceInfo.GetEventPropertyInfoAt(i)
-	if (epi.Flags & PropertyParamCount) == PropertyParamCount {
-		count := uint32(0)
-		j := epi.CountUnion
-		dataDesc.PropertyName = uint64(e.TraceInfo.pointer() + uintptr(e.TraceInfo.GetEventPropertyInfoAt(uint32(j)).NameOffset))
-		dataDesc.ArrayIndex = math.MaxUint32
-		if err = TdhGetPropertySize(e.EventRec, 0, nil, 1, &dataDesc, &propSz); err != nil {
-			return
-		}
-		if err = TdhGetProperty(e.EventRec, 0, nil, 1, &dataDesc, propSz, ((*byte)(unsafe.Pointer(&count)))); err != nil {
+	pi := &e.Schema.Properties[i]
+	if j, ok := pi.CountIndex(); ok {
+		var count uint32
+		if count, err = e.getReferencedValue(uint32(j)); err != nil {
 			return
 		}
 		arraySize = uint16(count)
 	} else {
-		arraySize = epi.CountUnion
+		arraySize = pi.Count
 	}
 	return
 }
@@ -273,11 +327,9 @@ func (e *EventRecordHelper) prepareProperty(i uint32) (p *Property, err error) {
 
 	p = &Property{}
 
-	p.evtPropInfo = e.TraceInfo.GetEventPropertyInfoAt(i)
+	p.info = &e.Schema.Properties[i]
 	p.evtRecordHelper = e
-	p.name = UTF16AtOffsetToString(e.TraceInfo.pointer(), uintptr(p.evtPropInfo.NameOffset))
-	p.pValue = e.userDataIt
-	p.userDataLength = e.userDataLength()
+	p.name = p.info.Name
 
 	if p.length, err = e.getPropertyLength(i); err != nil {
 		err = fmt.Errorf("failed to get property length: %s", err)
@@ -285,11 +337,23 @@ func (e *EventRecordHelper) prepareProperty(i uint32) (p *Property, err error) {
 	}
 
 	// size is different from length
-	if size, err = e.getPropertySize(i); err != nil {
+	if size, err = e.getLocalPropertySize(i, p.length); err != nil {
 		return
 	}
 
-	e.userDataIt += uintptr(size)
+	data := e.remainingUserData()
+	if int(size) > len(data) {
+		err = fmt.Errorf("%w %s: %s", ErrPropertyParsing, p.name, ErrShortBuffer)
+		return
+	}
+	p.data = data[:size:size]
+
+	// keeping integer values for the properties referencing them
+	if v, ok := integerValue(p.info.InType, p.data); ok {
+		e.values[i] = propertyValue{value: v, set: true}
+	}
+
+	e.userDataOffset += int(size)
 
 	return
 }
@@ -298,16 +362,16 @@ func (e *EventRecordHelper) prepareProperties() (last error) {
 	var arraySize uint16
 	var p *Property
 
-	for i := uint32(0); i < e.TraceInfo.TopLevelPropertyCount; i++ {
-		epi := e.TraceInfo.GetEventPropertyInfoAt(i)
-		isArray := epi.Flags&PropertyParamCount == PropertyParamCount
+	for i := uint32(0); i < e.Schema.TopLevelPropertyCount; i++ {
+		pi := &e.Schema.Properties[i]
+		_, isArray := pi.CountIndex()
 
 		switch {
 		case isArray:
 			log.Debugf("Property is an array")
-		case epi.Flags&PropertyParamLength == PropertyParamLength:
+		case pi.Flags&PropertyParamLength == PropertyParamLength:
 			log.Debugf("Property is a buffer")
-		case epi.Flags&PropertyParamCount == PropertyStruct:
+		case pi.Flags&PropertyParamCount == PropertyStruct:
 			log.Debugf("Property is a struct")
 		default:
 			// property is a map
@@ -328,12 +392,12 @@ func (e *EventRecordHelper) prepareProperties() (last error) {
 			for k := uint16(0); k < arraySize; k++ {
 
 				// If the property is a structure
-				if epi.Flags&PropertyStruct == PropertyStruct {
+				if start, ok := pi.MembersIndex(); ok {
 					log.Debugf("structure over here")
 					propStruct := make(map[string]*Property)
-					lastMember := epi.StructStartIndex() + epi.NumOfStructMembers()
+					lastMember := start + len(pi.Members)
 
-					for j := epi.StructStartIndex(); j < lastMember; j++ {
+					for j := start; j < lastMember; j++ {
 						log.Debugf("parsing struct property: %d", j)
 						if p, last = e.prepareProperty(uint32(j)); last != nil {
 							return
@@ -380,6 +444,12 @@ func (e *EventRecordHelper) buildEvent() (event *Event, err error) {
 
 	e.setEventMetadata(event)
 
+	if e.EventRec.ExtendedDataCount > 0 {
+		// malformed items are reported but do not prevent the others
+		// from being set
+		event.ExtendedData, err = e.EventRec.DecodeExtendedData()
+	}
+
 	return
 }
 
@@ -388,51 +458,97 @@ func (e *EventRecordHelper) parseAndSetProperty(name string, out *Event) (err er
 	eventData := out.EventData
 
 	// it is a user data property
-	if (e.TraceInfo.Flags & TEMPLATE_USER_DATA) == TEMPLATE_USER_DATA {
+	if e.Schema.Flags&TEMPLATE_USER_DATA == TEMPLATE_USER_DATA {
 		eventData = out.UserData
 	}
 
 	if p, ok := e.Properties[name]; ok {
-		if eventData[p.name], err = p.Value(); err != nil {
+		if eventData[p.name], err = p.eventValue(e.typed); err != nil {
 			return fmt.Errorf("%w %s: %s", ErrPropertyParsing, name, err)
 		}
 	}
 
 	// parsing array
 	if props, ok := e.ArrayProperties[name]; ok {
-		values := make([]string, len(props))
+		if eventData[name], err = e.arrayValue(props); err != nil {
+			return fmt.Errorf("%w array %s: %s", ErrPropertyParsing, name, err)
+		}
+	}
 
-		// iterate over the properties
-		for _, p := range props {
-			var v string
-			if v, err = p.Value(); err != nil {
-				return fmt.Errorf("%w array %s: %s", ErrPropertyParsing, name, err)
+	// parsing structures
+	if name == StructurePropertyName {
+		if len(e.Structures) > 0 {
+			var field string
+			if eventData[StructurePropertyName], field, err = e.structuresValue(); err != nil {
+				return fmt.Errorf("%w %s.%s: %s", ErrPropertyParsing, StructurePropertyName, field, err)
 			}
+		}
+	}
+
+	return
+}
 
+// arrayValue returns the values of the elements of an array property,
+// as strings unless properties are decoded into native Go values. Elements
+// failing to parse are set to their zero value and the last error is
+// returned.
+func (e *EventRecordHelper) arrayValue(props []*Property) (interface{}, error) {
+	var last error
+
+	if !e.typed {
+		values := make([]string, 0, len(props))
+		for _, p := range props {
+			v, err := p.Value()
+			if err != nil {
+				last = err
+			}
 			values = append(values, v)
 		}
+		return values, last
+	}
 
-		eventData[name] = values
+	values := make([]interface{}, 0, len(props))
+	for _, p := range props {
+		v, err := p.eventValue(true)
+		if err != nil {
+			last = err
+		}
+		values = append(values, v)
 	}
+	return values, last
+}
 
-	// parsing structures
-	if name == StructurePropertyName {
-		if len(e.Structures) > 0 {
-			structs := make([]map[string]string, len(e.Structures))
-			for _, m := range e.Structures {
-				s := make(map[string]string)
-				for field, prop := range m {
-					if s[field], err = prop.Value(); err != nil {
-						return fmt.Errorf("%w %s.%s: %s", ErrPropertyParsing, StructurePropertyName, field, err)
-					}
+// structuresValue returns the values of the fields of the structures of
+// the event, as strings unless properties are decoded into native Go
+// values. The last error is returned along with the field it relates to.
+func (e *EventRecordHelper) structuresValue() (v interface{}, field string, last error) {
+	if !e.typed {
+		structs := make([]map[string]string, 0, len(e.Structures))
+		for _, m := range e.Structures {
+			s := make(map[string]string, len(m))
+			for f, prop := range m {
+				var err error
+				if s[f], err = prop.Value(); err != nil {
+					field, last = f, err
 				}
 			}
-
-			eventData[StructurePropertyName] = structs
+			structs = append(structs, s)
 		}
+		return structs, field, last
 	}
 
-	return
+	structs := make([]map[string]interface{}, 0, len(e.Structures))
+	for _, m := range e.Structures {
+		s := make(map[string]interface{}, len(m))
+		for f, prop := range m {
+			var err error
+			if s[f], err = prop.eventValue(true); err != nil {
+				field, last = f, err
+			}
+		}
+		structs = append(structs, s)
+	}
+	return structs, field, last
 }
 
 func (e *EventRecordHelper) shouldParse(name string) bool {
@@ -449,7 +565,7 @@ func (e *EventRecordHelper) parseAndSetAllProperties(out *Event) (last error) {
 	eventData := out.EventData
 
 	// it is a user data property
-	if (e.TraceInfo.Flags & TEMPLATE_USER_DATA) == TEMPLATE_USER_DATA {
+	if e.Schema.Flags&TEMPLATE_USER_DATA == TEMPLATE_USER_DATA {
 		eventData = out.UserData
 	}
 
@@ -461,7 +577,7 @@ func (e *EventRecordHelper) parseAndSetAllProperties(out *Event) (last error) {
 		/*if err := e.parseAndSetProperty(pname, out); err != nil {
 			last = err
 		}*/
-		if eventData[p.name], err = p.Value(); err != nil {
+		if eventData[p.name], err = p.eventValue(e.typed); err != nil {
 			last = fmt.Errorf("%w %s: %s", ErrPropertyParsing, p.name, err)
 		}
 	}
@@ -472,19 +588,9 @@ func (e *EventRecordHelper) parseAndSetAllProperties(out *Event) (last error) {
 			continue
 		}
 
-		values := make([]string, len(props))
-
-		// iterate over the properties
-		for _, p := range props {
-			var v string
-			if v, err = p.Value(); err != nil {
-				last = fmt.Errorf("%w array %s: %s", ErrPropertyParsing, pname, err)
-			}
-
-			values = append(values, v)
+		if eventData[pname], err = e.arrayValue(props); err != nil {
+			last = fmt.Errorf("%w array %s: %s", ErrPropertyParsing, pname, err)
 		}
-
-		eventData[pname] = values
 	}
 
 	// Structure
@@ -493,17 +599,10 @@ func (e *EventRecordHelper) parseAndSetAllProperties(out *Event) (last error) {
 	}
 
 	if len(e.Structures) > 0 {
-		structs := make([]map[string]string, len(e.Structures))
-		for _, m := range e.Structures {
-			s := make(map[string]string)
-			for field, prop := range m {
-				if s[field], err = prop.Value(); err != nil {
-					last = fmt.Errorf("%w %s.%s: %s", ErrPropertyParsing, StructurePropertyName, field, err)
-				}
-			}
+		var field string
+		if eventData[StructurePropertyName], field, err = e.structuresValue(); err != nil {
+			last = fmt.Errorf("%w %s.%s: %s", ErrPropertyParsing, StructurePropertyName, field, err)
 		}
-
-		eventData[StructurePropertyName] = structs
 	}
 
 	return
@@ -521,19 +620,47 @@ func (e *EventRecordHelper) SelectFields(names ...string) {
 }
 
 func (e *EventRecordHelper) ProviderGUID() string {
-	return e.TraceInfo.ProviderGUID.String()
+	return e.Schema.ProviderGUID.String()
+}
+
+func (e *EventRecordHelper) ProviderID() GUID {
+	return e.Schema.ProviderGUID
 }
 
 func (e *EventRecordHelper) Provider() string {
-	return e.TraceInfo.ProviderName()
+	return e.Schema.ProviderName
 }
 
 func (e *EventRecordHelper) Channel() string {
-	return e.TraceInfo.ChannelName()
+	return e.Schema.ChannelName
 }
 
 func (e *EventRecordHelper) EventID() uint16 {
-	return e.TraceInfo.EventID()
+	return e.Schema.EventID()
+}
+
+func (e *EventRecordHelper) Level() uint8 {
+	return e.EventRec.EventHeader.EventDescriptor.Level
+}
+
+func (e *EventRecordHelper) Keywords() uint64 {
+	return e.EventRec.EventHeader.EventDescriptor.Keyword
+}
+
+func (e *EventRecordHelper) Opcode() uint8 {
+	return e.EventRec.EventHeader.EventDescriptor.Opcode
+}
+
+func (e *EventRecordHelper) Task() uint16 {
+	return e.EventRec.EventHeader.EventDescriptor.Task
+}
+
+func (e *EventRecordHelper) ProcessID() uint32 {
+	return e.EventRec.EventHeader.ProcessId
+}
+
+func (e *EventRecordHelper) ThreadID() uint32 {
+	return e.EventRec.EventHeader.ThreadId
 }
 
 func (e *EventRecordHelper) GetPropertyString(name string) (s string, err error) {
@@ -545,6 +672,17 @@ func (e *EventRecordHelper) GetPropertyString(name string) (s string, err error)
 	return "", fmt.Errorf("%w %s", ErrUnknownProperty, name)
 }
 
+// GetPropertyValue returns the value of a property decoded as a native
+// Go value (see Property.Decode)
+func (e *EventRecordHelper) GetPropertyValue(name string) (v interface{}, err error) {
+
+	if p, ok := e.Properties[name]; ok {
+		return p.Decode()
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrUnknownProperty, name)
+}
+
 func (e *EventRecordHelper) GetPropertyInt(name string) (i int64, err error) {
 	var s string
 
@@ -619,6 +757,37 @@ func (e *EventRecordHelper) ParseProperty(name string) (err error) {
 	return
 }
 
+// resolveField implements fieldResolver, properties can be resolved
+// only once they have been prepared
+func (e *EventRecordHelper) resolveField(field string) (interface{}, fieldState) {
+	var prop string
+
+	switch {
+	case strings.HasPrefix(field, EventDataPrefix):
+		prop = strings.TrimPrefix(field, EventDataPrefix)
+	case strings.HasPrefix(field, UserDataPrefix):
+		prop = strings.TrimPrefix(field, UserDataPrefix)
+	}
+
+	if prop != "" {
+		if e.Properties == nil {
+			return nil, fieldUnknown
+		}
+		if v, err := e.GetPropertyString(prop); err == nil {
+			return v, fieldFound
+		} else if errors.Is(err, ErrUnknownProperty) {
+			return nil, fieldMissing
+		}
+		return nil, fieldUnknown
+	}
+
+	if f, ok := eventFields[field]; ok && f.record != nil {
+		return f.record(e), fieldFound
+	}
+
+	return nil, fieldUnknown
+}
+
 func (e *EventRecordHelper) Skippable() {
 	e.Flags.Skippable = true
 }
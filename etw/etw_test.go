@@ -6,12 +6,14 @@ package etw
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -206,15 +208,15 @@ func TestEventMapInfo(t *testing.T) {
 
 		for _, p := range erh.Properties {
 			// calling those two method just to test they don't cause memory corruption
-			p.evtPropInfo.Count()
-			p.evtPropInfo.CountPropertyIndex()
-			if p.evtPropInfo.MapNameOffset() > 0 {
+			p.info.CountIndex()
+			p.info.LengthIndex()
+			if p.info.MapName != "" {
 				erh.Flags.Skip = false
 			}
 		}
 
 		// don't skip events with related activity ID
-		erh.Flags.Skip = erh.EventRec.RelatedActivityID() == nullGUIDStr
+		erh.Flags.Skip = erh.EventRec.RelatedActivityID().IsZero()
 
 		return fakeError
 	}
@@ -234,7 +236,7 @@ func TestEventMapInfo(t *testing.T) {
 
 			_, err := json.Marshal(&e)
 			tt.CheckErr(err)
-			if e.System.Correlation.ActivityID != nullGUIDStr && e.System.Correlation.RelatedActivityID != nullGUIDStr {
+			if !e.System.Correlation.ActivityID.IsZero() && !e.System.Correlation.RelatedActivityID.IsZero() {
 				t.Logf("Provider=%s ActivityID=%s RelatedActivityID=%s", e.System.Provider.Name, e.System.Correlation.ActivityID, e.System.Correlation.RelatedActivityID)
 			}
 			//t.Log(string(b))
@@ -247,8 +249,8 @@ func TestEventMapInfo(t *testing.T) {
 	wg.Wait()
 
 	// we got many events so some must have been skipped
-	t.Logf("skipped %d events", c.Skipped)
-	tt.Assert(c.Skipped == 0)
+	t.Logf("skipped %d events", atomic.LoadUint64(&c.Skipped))
+	tt.Assert(atomic.LoadUint64(&c.Skipped) == 0)
 
 	delta := time.Since(start)
 	eps := float64(eventCount) / delta.Seconds()
@@ -289,8 +291,8 @@ func TestLostEvents(t *testing.T) {
 	tt.CheckErr(c.Stop())
 	time.Sleep(5 * time.Second)
 	t.Logf("Events received: %d", cnt)
-	t.Logf("Events lost: %d", c.LostEvents)
-	tt.Assert(c.LostEvents > 0)
+	t.Logf("Events lost: %d", atomic.LoadUint64(&c.LostEvents))
+	tt.Assert(atomic.LoadUint64(&c.LostEvents) > 0)
 }
 
 func jsonStr(i interface{}) string {
@@ -506,7 +508,7 @@ func TestConsumerCallbacks(t *testing.T) {
 	tt.CheckErr(c.Stop())
 
 	tt.Assert(eventCount != 0, "did not receive any event")
-	tt.Assert(c.Skipped == 0)
+	tt.Assert(atomic.LoadUint64(&c.Skipped) == 0)
 	// verifying that we caught all events
 	t.Logf("read=%d etwread=%d", nReadWrite, etwread)
 	tt.Assert(nReadWrite == etwread)
@@ -521,6 +523,38 @@ func TestConsumerCallbacks(t *testing.T) {
 	tt.CheckErr(c.Err())
 }
 
+func TestGUIDEquality(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	p := MustParseProvider("Microsoft-Windows-Kernel-File")
+	g1 := MustParseGUIDFromString(p.GUID)
+	g2 := MustParseGUIDFromString(p.GUID)
+
+	tt.Assert(g1.Equals(g2))
+
+	// testing Data1
+	g2.Data1++
+	tt.Assert(!g1.Equals(g2))
+
+	// testing Data2
+	g2 = MustParseGUIDFromString(p.GUID)
+	g2.Data2++
+	tt.Assert(!g1.Equals(g2))
+
+	// testing Data3
+	g2 = MustParseGUIDFromString(p.GUID)
+	g2.Data3++
+	tt.Assert(!g1.Equals(g2))
+
+	// testing Data4
+	for i := 0; i < 8; i++ {
+		g2 = MustParseGUIDFromString(p.GUID)
+		g2.Data4[i]++
+		tt.Assert(!g1.Equals(g2))
+	}
+}
+
 func TestParseProvider(t *testing.T) {
 	t.Parallel()
 
@@ -548,11 +582,105 @@ func TestParseProvider(t *testing.T) {
 	tt.CheckErr(err)
 	tt.Assert(p.EnableLevel == 255 && p.MatchAnyKeyword == 4242 && p.MatchAllKeyword == 1337)
 
+	p, err = ParseProvider(KernelFileProviderName + ":255:1,2,3,4:4242:1337:sid,stack,startkey")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableProperty == EVENT_ENABLE_PROPERTY_SID|EVENT_ENABLE_PROPERTY_STACK_TRACE|EVENT_ENABLE_PROPERTY_PROCESS_START_KEY)
+
+	p, err = ParseProvider(KernelFileProviderName + ":::::tsid")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableProperty == EVENT_ENABLE_PROPERTY_TS_ID)
+
+	_, err = ParseProvider(KernelFileProviderName + ":::::unknown")
+	tt.Assert(errors.Is(err, ErrUnknownEnableProperty))
+
+	p, err = ParseProvider(KernelFileProviderName + ":0xff:!10,11")
+	tt.CheckErr(err)
+	tt.Assert(p.FilterOut)
+	tt.Assert(len(p.Filter) == 2 && p.Filter[0] == 10 && p.Filter[1] == 11)
+
+	_, err = ParseProvider(KernelFileProviderName + ":0xff:!")
+	tt.Assert(err != nil)
+
+	p, err = ParseProvider(KernelFileProviderName + "::::::4,0x10:explorer.exe,svchost.exe")
+	tt.CheckErr(err)
+	tt.Assert(len(p.PIDs) == 2 && p.PIDs[0] == 4 && p.PIDs[1] == 16)
+	tt.Assert(len(p.ExecutableNames) == 2 && p.ExecutableNames[1] == "svchost.exe")
+
+	fds, data, err := p.BuildFilterDesc()
+	tt.CheckErr(err)
+	tt.Assert(len(fds) == 2 && len(data) == 2)
+	tt.Assert(fds[0].Type == EVENT_FILTER_TYPE_PID && fds[0].Size == 8)
+	tt.Assert(fds[1].Type == EVENT_FILTER_TYPE_EXECUTABLE_NAME)
+
+	_, err = ParseProvider(KernelFileProviderName + "::::::1,2,3,4,5,6,7,8,9")
+	tt.Assert(err != nil)
+
+	_, err = ParseProvider(KernelFileProviderName + "::::::notapid")
+	tt.Assert(err != nil)
+
+	_, err = ParseProvider(KernelFileProviderName + ":::::::a.exe,,b.exe")
+	tt.Assert(errors.Is(err, ErrFilterData))
+
+	p, err = ParseProvider(KernelFileProviderName + ":0xff:::::::!12,13")
+	tt.CheckErr(err)
+	tt.Assert(p.StackWalk.FilterOut)
+	tt.Assert(len(p.StackWalk.EventIDs) == 2 && p.StackWalk.EventIDs[1] == 13)
+	// stack traces are enabled by the stack walk filter
+	tt.Assert(p.EnableProperty == 0)
+	tt.Assert(p.EnableProperties() == EVENT_ENABLE_PROPERTY_STACK_TRACE)
+
+	fds, _, err = p.BuildFilterDesc()
+	tt.CheckErr(err)
+	tt.Assert(len(fds) == 1 && fds[0].Type == EVENT_FILTER_TYPE_STACKWALK)
+
+	_, err = ParseProvider(KernelFileProviderName + "::::::::notanid")
+	tt.Assert(err != nil)
+
+	// keyword names are resolved with TDH
+	p, err = ParseProvider(KernelFileProviderName + ":verbose::KERNEL_FILE_KEYWORD_FILENAME|KERNEL_FILE_KEYWORD_FILEIO")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableLevel == 5)
+	tt.Assert(p.MatchAnyKeyword == 0x30)
+
 	// this calls must panic on error
 	MustParseProvider(KernelFileProviderName)
 	tt.ShouldPanic(func() { MustParseProvider("Microsoft-Unknown-Provider") })
 }
 
+func TestProviderMetadata(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	prov := MustParseProvider(KernelFileProviderName)
+	info, err := ProviderMetadata(prov.GUID)
+	tt.CheckErr(err)
+	tt.Assert(info.Name == KernelFileProviderName)
+
+	kw, err := ParseKeywords("KERNEL_FILE_KEYWORD_FILENAME", prov.GUID, TdhFieldLookup{})
+	tt.CheckErr(err)
+	found := false
+	for _, f := range info.Keywords {
+		found = found || (f.Name == "KERNEL_FILE_KEYWORD_FILENAME" && f.Value == kw)
+	}
+	tt.Assert(found)
+
+	// FileCreate event
+	found = false
+	for _, e := range info.Events {
+		if e.ID != 12 {
+			continue
+		}
+		for _, f := range e.Fields {
+			found = found || f.Name == "FileObject"
+		}
+	}
+	tt.Assert(found)
+
+	_, err = json.Marshal(info)
+	tt.CheckErr(err)
+}
+
 func TestConvertSid(t *testing.T) {
 	t.Parallel()
 
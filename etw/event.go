@@ -1,11 +1,138 @@
 package etw
 
 import (
+	"strings"
 	"time"
 )
 
+const (
+	EventDataPrefix = "EventData."
+	UserDataPrefix  = "UserData."
+	systemPrefix    = "System."
+)
+
+// eventField describes a System field of an Event. The field is read from
+// events with get and from event records, before they are parsed, with
+// record. Fields which are only known once the event is built have no
+// record function.
+type eventField struct {
+	typ    exprType
+	get    func(*Event) interface{}
+	record func(*EventRecordHelper) interface{}
+}
+
+var (
+	// eventFields maps the canonical path of Event System fields
+	// (without System prefix) to their description
+	eventFields = map[string]eventField{
+		"Channel": {typeString,
+			func(e *Event) interface{} { return e.System.Channel },
+			func(h *EventRecordHelper) interface{} { return h.Channel() }},
+		"Computer": {typeString,
+			func(e *Event) interface{} { return e.System.Computer },
+			func(h *EventRecordHelper) interface{} { return hostname }},
+		"EventID": {typeNumber,
+			func(e *Event) interface{} { return e.System.EventID },
+			func(h *EventRecordHelper) interface{} { return h.EventID() }},
+		"EventType": {typeString,
+			func(e *Event) interface{} { return e.System.EventType },
+			nil},
+		"EventGuid": {typeString,
+			func(e *Event) interface{} { return e.System.EventGuid },
+			nil},
+		"Correlation.ActivityID": {typeString,
+			func(e *Event) interface{} { return e.System.Correlation.ActivityID.String() },
+			func(h *EventRecordHelper) interface{} { return h.EventRec.EventHeader.ActivityId.String() }},
+		"Correlation.RelatedActivityID": {typeString,
+			func(e *Event) interface{} { return e.System.Correlation.RelatedActivityID.String() },
+			func(h *EventRecordHelper) interface{} { return h.EventRec.RelatedActivityID().String() }},
+		"Execution.ProcessID": {typeNumber,
+			func(e *Event) interface{} { return e.System.Execution.ProcessID },
+			func(h *EventRecordHelper) interface{} { return h.ProcessID() }},
+		"Execution.ThreadID": {typeNumber,
+			func(e *Event) interface{} { return e.System.Execution.ThreadID },
+			func(h *EventRecordHelper) interface{} { return h.ThreadID() }},
+		"Keywords.Value": {typeNumber,
+			func(e *Event) interface{} { return e.System.Keywords.Value },
+			func(h *EventRecordHelper) interface{} { return h.Schema.EventDescriptor.Keyword }},
+		"Keywords.Name": {typeString,
+			func(e *Event) interface{} { return e.System.Keywords.Name },
+			func(h *EventRecordHelper) interface{} { return h.Schema.KeywordsName }},
+		"Level.Value": {typeNumber,
+			func(e *Event) interface{} { return e.System.Level.Value },
+			func(h *EventRecordHelper) interface{} { return h.Schema.EventDescriptor.Level }},
+		"Level.Name": {typeString,
+			func(e *Event) interface{} { return e.System.Level.Name },
+			func(h *EventRecordHelper) interface{} { return h.Schema.LevelName }},
+		"Opcode.Value": {typeNumber,
+			func(e *Event) interface{} { return e.System.Opcode.Value },
+			func(h *EventRecordHelper) interface{} { return h.Schema.EventDescriptor.Opcode }},
+		"Opcode.Name": {typeString,
+			func(e *Event) interface{} { return e.System.Opcode.Name },
+			func(h *EventRecordHelper) interface{} { return h.Schema.OpcodeName }},
+		"Task.Value": {typeNumber,
+			func(e *Event) interface{} { return e.System.Task.Value },
+			func(h *EventRecordHelper) interface{} { return h.Task() }},
+		"Task.Name": {typeString,
+			func(e *Event) interface{} { return e.System.Task.Name },
+			func(h *EventRecordHelper) interface{} { return h.Schema.TaskName }},
+		"Provider.Guid": {typeString,
+			func(e *Event) interface{} { return e.System.Provider.Guid.String() },
+			func(h *EventRecordHelper) interface{} { return h.ProviderGUID() }},
+		"Provider.Name": {typeString,
+			func(e *Event) interface{} { return e.System.Provider.Name },
+			func(h *EventRecordHelper) interface{} { return h.Provider() }},
+	}
+
+	// eventFieldAliases are shortcuts to some System fields
+	eventFieldAliases = map[string]string{
+		"ActivityID":        "Correlation.ActivityID",
+		"RelatedActivityID": "Correlation.RelatedActivityID",
+		"ProcessID":         "Execution.ProcessID",
+		"ThreadID":          "Execution.ThreadID",
+		"Keywords":          "Keywords.Value",
+		"Level":             "Level.Value",
+		"Opcode":            "Opcode.Value",
+		"Task":              "Task.Value",
+	}
+)
+
+// CanonicalField returns the canonical path of an Event field. System
+// fields are returned without System prefix and aliases are resolved.
+// EventData and UserData fields are returned unchanged. It returns false
+// if path does not designate a known field.
+func CanonicalField(path string) (string, bool) {
+	if strings.HasPrefix(path, EventDataPrefix) || strings.HasPrefix(path, UserDataPrefix) {
+		return path, true
+	}
+
+	path = strings.TrimPrefix(path, systemPrefix)
+	if alias, ok := eventFieldAliases[path]; ok {
+		path = alias
+	}
+
+	_, ok := eventFields[path]
+	return path, ok
+}
+
 type EventID uint16
 
+// IEvent is the interface of an event to be filtered. It only exposes
+// information available before event properties are parsed.
+type IEvent interface {
+	ProviderGUID() string
+	// ProviderID returns the GUID of the provider, unlike ProviderGUID it
+	// does not need any formatting
+	ProviderID() GUID
+	EventID() uint16
+	Level() uint8
+	Keywords() uint64
+	Opcode() uint8
+	Task() uint16
+	ProcessID() uint32
+	ThreadID() uint32
+}
+
 type Event struct {
 	Flags struct {
 		// Use to flag event as being skippable for performance reason
@@ -21,8 +148,8 @@ type Event struct {
 		EventType   string `json:",omitempty"`
 		EventGuid   string `json:",omitempty"`
 		Correlation struct {
-			ActivityID        string
-			RelatedActivityID string
+			ActivityID        GUID
+			RelatedActivityID GUID
 		}
 		Execution struct {
 			ProcessID uint32
@@ -41,28 +168,112 @@ type Event struct {
 			Name  string
 		}
 		Task struct {
-			Value uint8
+			Value uint16
 			Name  string
 		}
 		Provider struct {
-			Guid string
+			Guid GUID
 			Name string
 		}
 		TimeCreated struct {
 			SystemTime time.Time
 		}
 	}
-	ExtendedData []string `json:",omitempty"`
+	ExtendedData *EventExtendedData `json:",omitempty"`
+	// Set on events output by a Deduplicator
+	Dedup *DedupInfo `json:",omitempty"`
 }
 
 func NewEvent() (e *Event) {
 	e = &Event{}
 	e.EventData = make(map[string]interface{})
 	e.UserData = make(map[string]interface{})
-	e.ExtendedData = make([]string, 0)
 	return e
 }
 
+// ProviderGUID implements IEvent
+func (e *Event) ProviderGUID() string {
+	return e.System.Provider.Guid.String()
+}
+
+// ProviderID implements IEvent
+func (e *Event) ProviderID() GUID {
+	return e.System.Provider.Guid
+}
+
+// EventID implements IEvent
+func (e *Event) EventID() uint16 {
+	return e.System.EventID
+}
+
+// Level implements IEvent
+func (e *Event) Level() uint8 {
+	return e.System.Level.Value
+}
+
+// Keywords implements IEvent
+func (e *Event) Keywords() uint64 {
+	return e.System.Keywords.Value
+}
+
+// Opcode implements IEvent
+func (e *Event) Opcode() uint8 {
+	return e.System.Opcode.Value
+}
+
+// Task implements IEvent
+func (e *Event) Task() uint16 {
+	return e.System.Task.Value
+}
+
+// ProcessID implements IEvent
+func (e *Event) ProcessID() uint32 {
+	return e.System.Execution.ProcessID
+}
+
+// ThreadID implements IEvent
+func (e *Event) ThreadID() uint32 {
+	return e.System.Execution.ThreadID
+}
+
+// StackAddresses returns the addresses of the stack collected with the
+// event, or nil if no stack was collected (see Provider.StackWalk)
+func (e *Event) StackAddresses() []uint64 {
+	if e.ExtendedData == nil || e.ExtendedData.StackTrace == nil {
+		return nil
+	}
+	return e.ExtendedData.StackTrace.Addresses
+}
+
+// GetField returns the value of a field designated by its path, such as
+// Provider.Name, System.EventID or EventData.ImageName (see CanonicalField).
+func (e *Event) GetField(path string) (i interface{}, ok bool) {
+	var canon string
+
+	if canon, ok = CanonicalField(path); !ok {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(canon, EventDataPrefix):
+		i, ok = e.EventData[strings.TrimPrefix(canon, EventDataPrefix)]
+	case strings.HasPrefix(canon, UserDataPrefix):
+		i, ok = e.UserData[strings.TrimPrefix(canon, UserDataPrefix)]
+	default:
+		i = eventFields[canon].get(e)
+	}
+
+	return
+}
+
+// resolveField implements fieldResolver
+func (e *Event) resolveField(path string) (interface{}, fieldState) {
+	if i, ok := e.GetField(path); ok {
+		return i, fieldFound
+	}
+	return nil, fieldMissing
+}
+
 func (e *Event) GetProperty(name string) (i interface{}, ok bool) {
 
 	if e.EventData != nil {
@@ -0,0 +1,220 @@
+package etw
+
+type EventFilter interface {
+	// Match must return true if the event has to be filtered in
+	Match(IEvent) bool
+	// Update adds, replaces or removes the filter of a given provider.
+	// It may be called concurrently with Match on a running consumer.
+	Update(p *Provider)
+}
+
+// providerRemover is implemented by filters having to know when a provider
+// gets disabled, which Update cannot tell
+type providerRemover interface {
+	removeProvider(guid string)
+}
+
+// removeProvider notifies f that a provider got disabled, if it cares
+func removeProvider(f EventFilter, guid string) {
+	if r, ok := f.(providerRemover); ok {
+		r.removeProvider(guid)
+	}
+}
+
+// FilterFunc is an EventFilter not depending on providers
+type FilterFunc func(IEvent) bool
+
+// Match implements EventFilter
+func (f FilterFunc) Match(e IEvent) bool {
+	return f(e)
+}
+
+// Update implements EventFilter
+func (f FilterFunc) Update(p *Provider) {}
+
+// AndFilter matches events matched by all its filters
+type AndFilter []EventFilter
+
+// And creates a filter matching events matched by all filters
+func And(filters ...EventFilter) AndFilter {
+	return AndFilter(filters)
+}
+
+// Match implements EventFilter
+func (f AndFilter) Match(e IEvent) bool {
+	for _, sub := range f {
+		if !sub.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Update implements EventFilter, it updates all sub-filters
+func (f AndFilter) Update(p *Provider) {
+	for _, sub := range f {
+		sub.Update(p)
+	}
+}
+
+func (f AndFilter) removeProvider(guid string) {
+	for _, sub := range f {
+		removeProvider(sub, guid)
+	}
+}
+
+// OrFilter matches events matched by any of its filters
+type OrFilter []EventFilter
+
+// Or creates a filter matching events matched by any of the filters
+func Or(filters ...EventFilter) OrFilter {
+	return OrFilter(filters)
+}
+
+// Match implements EventFilter
+func (f OrFilter) Match(e IEvent) bool {
+	for _, sub := range f {
+		if sub.Match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update implements EventFilter, it updates all sub-filters
+func (f OrFilter) Update(p *Provider) {
+	for _, sub := range f {
+		sub.Update(p)
+	}
+}
+
+func (f OrFilter) removeProvider(guid string) {
+	for _, sub := range f {
+		removeProvider(sub, guid)
+	}
+}
+
+// NotFilter matches events not matched by its filter
+type NotFilter struct {
+	Filter EventFilter
+}
+
+// Not creates a filter matching events not matched by f
+func Not(f EventFilter) *NotFilter {
+	return &NotFilter{f}
+}
+
+// Match implements EventFilter
+func (f *NotFilter) Match(e IEvent) bool {
+	return !f.Filter.Match(e)
+}
+
+// Update implements EventFilter
+func (f *NotFilter) Update(p *Provider) {
+	f.Filter.Update(p)
+}
+
+func (f *NotFilter) removeProvider(guid string) {
+	removeProvider(f.Filter, guid)
+}
+
+// ProviderIn matches events generated by one of the providers,
+// given by GUID. It panics if a GUID is not valid.
+func ProviderIn(guids ...string) FilterFunc {
+	set := make(map[GUID]bool, len(guids))
+	for _, s := range guids {
+		set[*MustParseGUIDFromString(s)] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.ProviderID()]
+	}
+}
+
+// EventIDIn matches events with one of the IDs
+func EventIDIn(ids ...uint16) FilterFunc {
+	set := make(map[uint16]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.EventID()]
+	}
+}
+
+// LevelAtMost matches events with a level lower or equal to level.
+// As done by ETW, events of level 0 are always matched.
+func LevelAtMost(level uint8) FilterFunc {
+	return func(e IEvent) bool {
+		return e.Level() == 0 || e.Level() <= level
+	}
+}
+
+// KeywordAny matches events having any of the keyword bits of mask set.
+// As done by ETW, events without keywords are always matched.
+func KeywordAny(mask uint64) FilterFunc {
+	return func(e IEvent) bool {
+		return e.Keywords() == 0 || e.Keywords()&mask != 0
+	}
+}
+
+// KeywordAll matches events having all the keyword bits of mask set.
+// As done by ETW, events without keywords are always matched.
+func KeywordAll(mask uint64) FilterFunc {
+	return func(e IEvent) bool {
+		return e.Keywords() == 0 || e.Keywords()&mask == mask
+	}
+}
+
+// OpcodeIn matches events with one of the opcodes
+func OpcodeIn(opcodes ...uint8) FilterFunc {
+	set := make(map[uint8]bool, len(opcodes))
+	for _, o := range opcodes {
+		set[o] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.Opcode()]
+	}
+}
+
+// TaskIn matches events with one of the tasks
+func TaskIn(tasks ...uint16) FilterFunc {
+	set := make(map[uint16]bool, len(tasks))
+	for _, t := range tasks {
+		set[t] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.Task()]
+	}
+}
+
+// ProcessIn matches events generated by one of the processes
+func ProcessIn(pids ...uint32) FilterFunc {
+	set := make(map[uint32]bool, len(pids))
+	for _, pid := range pids {
+		set[pid] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.ProcessID()]
+	}
+}
+
+// ThreadIn matches events generated by one of the threads
+func ThreadIn(tids ...uint32) FilterFunc {
+	set := make(map[uint32]bool, len(tids))
+	for _, tid := range tids {
+		set[tid] = true
+	}
+
+	return func(e IEvent) bool {
+		return set[e.ThreadID()]
+	}
+}
+
+// Update implements EventFilter, expression filters
+// do not depend on providers
+func (f *ExprFilter) Update(p *Provider) {}
@@ -0,0 +1,166 @@
+package etw
+
+import (
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestEventFilters(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	e := newTestEvent()
+	e.System.Opcode.Value = 1
+	e.System.Task.Value = 1
+	e.System.Execution.ThreadID = 42
+
+	guid := "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+
+	matching := map[string]EventFilter{
+		"provider":        ProviderIn("{00000000-0000-0000-0000-000000000000}", "22fb2cd6-0e7b-422b-a0c7-2fad1fd0e716"),
+		"eventid":         EventIDIn(1, 2),
+		"level":           LevelAtMost(4),
+		"keyword any":     KeywordAny(0x10),
+		"keyword all":     KeywordAll(0x8000000000000010),
+		"opcode":          OpcodeIn(1, 2),
+		"task":            TaskIn(1),
+		"process":         ProcessIn(4, 4242),
+		"thread":          ThreadIn(42),
+		"func":            FilterFunc(func(e IEvent) bool { return e.EventID() == 1 }),
+		"and":             And(EventIDIn(1), LevelAtMost(5), ProcessIn(4242)),
+		"or":              Or(EventIDIn(2), ProcessIn(4242)),
+		"not":             Not(EventIDIn(2)),
+		"empty and":       And(),
+		"nested":          And(ProviderIn(guid), Or(Not(LevelAtMost(3)), KeywordAny(0x1)), Not(Or(ThreadIn(1), ThreadIn(2)))),
+		"expr":            And(EventIDIn(1), MustCompileExprFilter(`EventData.ImageName endswith "powershell.exe"`)),
+		"expr in not":     Not(MustCompileExprFilter(`Level > 4`)),
+		"double negation": Not(Not(OpcodeIn(1))),
+	}
+
+	for name, f := range matching {
+		tt.Assert(f.Match(e), name)
+	}
+
+	notMatching := map[string]EventFilter{
+		"provider":    ProviderIn("{00000000-0000-0000-0000-000000000000}"),
+		"eventid":     EventIDIn(2),
+		"level":       LevelAtMost(3),
+		"keyword any": KeywordAny(0x1),
+		"keyword all": KeywordAll(0x11),
+		"opcode":      OpcodeIn(2),
+		"task":        TaskIn(0, 2),
+		"process":     ProcessIn(4),
+		"thread":      ThreadIn(4242),
+		"and":         And(EventIDIn(1), LevelAtMost(3)),
+		"or":          Or(EventIDIn(2), ProcessIn(4)),
+		"not":         Not(EventIDIn(1)),
+		"empty or":    Or(),
+		"nested":      And(ProviderIn(guid), Not(Or(ThreadIn(42), ThreadIn(2)))),
+	}
+
+	for name, f := range notMatching {
+		tt.Assert(!f.Match(e), name)
+	}
+
+	// ETW semantics: level and keywords of 0 always match
+	e.System.Level.Value = 0
+	e.System.Keywords.Value = 0
+	tt.Assert(LevelAtMost(1).Match(e))
+	tt.Assert(KeywordAny(0x1).Match(e))
+	tt.Assert(KeywordAll(0x11).Match(e))
+}
+
+func TestEventFiltersHelper(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	b.inject("trace.etl", 42, 0)
+	er := b.records["trace.etl"][0]
+	er.EventHeader.EventDescriptor.Task = 300
+
+	h, err := b.prepare(er, nil)
+	tt.CheckErr(err)
+	e, err := h.buildEvent()
+	tt.CheckErr(err)
+
+	// filters must agree on events and on record helpers
+	for name, f := range map[string]EventFilter{
+		"provider": ProviderIn(fakeProviderGUID.String()),
+		"eventid":  EventIDIn(42),
+		"task":     TaskIn(300),
+	} {
+		tt.Assert(f.Match(h), name)
+		tt.Assert(f.Match(e), name)
+	}
+	tt.Assert(!TaskIn(44).Match(h) && !TaskIn(44).Match(e))
+}
+
+func TestEventFieldsHelper(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	b.inject("trace.etl", 42, 0)
+	er := b.records["trace.etl"][0]
+	er.EventHeader.ProcessId = 4242
+	er.EventHeader.EventDescriptor.Task = 300
+
+	h, err := b.prepare(er, nil)
+	tt.CheckErr(err)
+	e, err := h.buildEvent()
+	tt.CheckErr(err)
+
+	// fields known before parsing resolve the same on both
+	for path, f := range eventFields {
+		v, state := h.resolveField(path)
+		if f.record == nil {
+			tt.Assert(state == fieldUnknown, path)
+			continue
+		}
+		tt.Assert(state == fieldFound, path)
+		tt.Assert(v == f.get(e), path, v, f.get(e))
+	}
+}
+
+type updateCounter struct {
+	updates int
+}
+
+func (f *updateCounter) Match(IEvent) bool {
+	return true
+}
+
+func (f *updateCounter) Update(*Provider) {
+	f.updates++
+}
+
+func TestEventFiltersUpdate(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	counters := []*updateCounter{{}, {}, {}}
+
+	f := And(counters[0], Or(LevelAtMost(4), Not(counters[1])), counters[2])
+	f.Update(&Provider{GUID: "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"})
+	f.Update(&Provider{GUID: "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"})
+
+	for _, c := range counters {
+		tt.Assert(c.updates == 2)
+	}
+}
+
+func TestEventFiltersIEvent(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// filters only rely on IEvent
+	e := testIEvent{"{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}", 1}
+	tt.Assert(And(ProviderIn(e.guid), EventIDIn(1), LevelAtMost(4), ProcessIn(4242)).Match(e))
+	tt.Assert(!Or(EventIDIn(2), Not(KeywordAny(0x10))).Match(e))
+	tt.ShouldPanic(func() { ProviderIn("not a guid") })
+}
@@ -0,0 +1,55 @@
+package etw
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// namespace used by EventSource to derive provider GUIDs from names
+	eventSourceNamespace = GUID{0x482C2DB2, 0xC390, 0x47C8, [8]byte{0x87, 0xF8, 0x1A, 0x15, 0xBF, 0xC1, 0x30, 0xFB}}
+)
+
+// EventSourceGUID computes the GUID of an EventSource or TraceLogging
+// provider from its name. Such providers are usually not registered on the
+// system so they cannot be resolved with ResolveProvider. The GUID is a
+// version 5 like UUID made of the SHA-1 hash of the EventSource namespace
+// and the upper cased name encoded in UTF-16 big endian. Unlike NewGUIDv5,
+// the hash is read in little endian layout and the variant is not set.
+func EventSourceGUID(name string) *GUID {
+	ns := eventSourceNamespace.bigEndian()
+
+	h := sha1.New()
+	h.Write(ns[:])
+
+	b := make([]byte, 2)
+	for _, c := range utf16.Encode([]rune(strings.ToUpper(name))) {
+		binary.BigEndian.PutUint16(b, c)
+		h.Write(b)
+	}
+
+	sum := h.Sum(nil)
+	// set high nibble of Data3 to 5
+	sum[7] = (sum[7] & 0x0F) | 0x50
+
+	// first 16 bytes of the hash are the GUID in little endian layout
+	g := &GUID{
+		Data1: binary.LittleEndian.Uint32(sum[0:4]),
+		Data2: binary.LittleEndian.Uint16(sum[4:6]),
+		Data3: binary.LittleEndian.Uint16(sum[6:8]),
+	}
+	copy(g.Data4[:], sum[8:16])
+
+	return g
+}
+
+// EventSourceProvider returns a provider, initialized from DefaultProvider,
+// whose GUID is derived from name with EventSourceGUID
+func EventSourceProvider(name string) (p Provider) {
+	p = DefaultProvider
+	p.Name = name
+	p.GUID = EventSourceGUID(name).String()
+	return
+}
@@ -0,0 +1,45 @@
+package etw
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestEventSourceGUID(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	for name, guid := range map[string]string{
+		"SimpleTraceLoggingProvider":   "{0205C616-CF97-5C11-9756-56A2CEE02CA7}",
+		"Microsoft-Extensions-Logging": "{3AC73B97-AF73-50E9-0822-5DA4367920D0}",
+		// names are case insensitive
+		"microsoft-extensions-logging": "{3AC73B97-AF73-50E9-0822-5DA4367920D0}",
+	} {
+		g := EventSourceGUID(name)
+		tt.Assert(g.String() == guid, name, g.String())
+	}
+}
+
+func TestParseEventSourceProvider(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	r := newTestRegistry()
+
+	p, err := ParseProviderWith(r, "*Microsoft-Extensions-Logging:4::0x1")
+	tt.CheckErr(err)
+	tt.Assert(p.Name == "Microsoft-Extensions-Logging")
+	tt.Assert(p.GUID == "{3AC73B97-AF73-50E9-0822-5DA4367920D0}")
+	tt.Assert(p.EnableLevel == 4 && p.MatchAnyKeyword == 0x1)
+
+	_, err = ParseProviderWith(r, "*")
+	tt.Assert(errors.Is(err, ErrUnkownProvider))
+
+	// without star the name has to be known
+	_, err = ParseProviderWith(r, "Microsoft-Extensions-Logging")
+	tt.Assert(errors.Is(err, ErrUnkownProvider))
+}
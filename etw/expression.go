@@ -0,0 +1,492 @@
+package etw
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrExprSyntax = fmt.Errorf("expression syntax error")
+	ErrExprType   = fmt.Errorf("expression type error")
+)
+
+type exprType int
+
+const (
+	typeAny = exprType(iota)
+	typeString
+	typeNumber
+	typeBool
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeNumber:
+		return "number"
+	case typeBool:
+		return "bool"
+	}
+	return "any"
+}
+
+type tokenKind int
+
+const (
+	tokEOF = tokenKind(iota)
+	tokIdent
+	tokString
+	tokNumber
+	tokBool
+	tokLParen
+	tokRParen
+	tokComma
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+)
+
+// comparison operators
+const (
+	opEq         = "=="
+	opNeq        = "!="
+	opLt         = "<"
+	opLte        = "<="
+	opGt         = ">"
+	opGte        = ">="
+	opIn         = "in"
+	opContains   = "contains"
+	opStartswith = "startswith"
+	opEndswith   = "endswith"
+	opMatches    = "matches"
+)
+
+var (
+	exprKeywords = map[string]exprToken{
+		"and":        {kind: tokAnd, text: "and"},
+		"or":         {kind: tokOr, text: "or"},
+		"not":        {kind: tokNot, text: "not"},
+		"true":       {kind: tokBool, text: "true"},
+		"false":      {kind: tokBool, text: "false"},
+		"in":         {kind: tokOp, text: opIn},
+		"contains":   {kind: tokOp, text: opContains},
+		"startswith": {kind: tokOp, text: opStartswith},
+		"endswith":   {kind: tokOp, text: opEndswith},
+		"matches":    {kind: tokOp, text: opMatches},
+	}
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
+
+// tokenizeExpr splits an expression into tokens
+func tokenizeExpr(src string) (toks []exprToken, err error) {
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case r == '(':
+			toks = append(toks, exprToken{tokLParen, "(", start})
+			i++
+
+		case r == ')':
+			toks = append(toks, exprToken{tokRParen, ")", start})
+			i++
+
+		case r == ',':
+			toks = append(toks, exprToken{tokComma, ",", start})
+			i++
+
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			quote := r
+			i++
+			for ; i < len(runes) && runes[i] != quote; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case '\\', '"', '\'':
+						sb.WriteRune(runes[i])
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					case 'r':
+						sb.WriteRune('\r')
+					default:
+						// unknown escape sequences are kept as is to
+						// ease writing Windows paths
+						sb.WriteRune('\\')
+						sb.WriteRune(runes[i])
+					}
+					continue
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("%w at position %d: unterminated string", ErrExprSyntax, start)
+			}
+			i++
+			toks = append(toks, exprToken{tokString, sb.String(), start})
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			i++
+			for i < len(runes) && (isIdentRune(runes[i]) || ((runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			toks = append(toks, exprToken{tokNumber, string(runes[start:i]), start})
+
+		case isIdentRune(r):
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			if kw, ok := exprKeywords[strings.ToLower(word)]; ok {
+				kw.pos = start
+				toks = append(toks, kw)
+				continue
+			}
+			toks = append(toks, exprToken{tokIdent, word, start})
+
+		default:
+			// symbolic operators
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+
+			switch {
+			case two == "==" || two == "!=" || two == "<=" || two == ">=":
+				toks = append(toks, exprToken{tokOp, two, start})
+				i += 2
+			case two == "&&":
+				toks = append(toks, exprToken{tokAnd, "and", start})
+				i += 2
+			case two == "||":
+				toks = append(toks, exprToken{tokOr, "or", start})
+				i += 2
+			case r == '=':
+				toks = append(toks, exprToken{tokOp, opEq, start})
+				i++
+			case r == '<' || r == '>':
+				toks = append(toks, exprToken{tokOp, string(r), start})
+				i++
+			case r == '!':
+				toks = append(toks, exprToken{tokNot, "not", start})
+				i++
+			default:
+				return nil, fmt.Errorf("%w at position %d: unexpected character %q", ErrExprSyntax, start, r)
+			}
+		}
+	}
+
+	toks = append(toks, exprToken{kind: tokEOF, pos: len(runes)})
+	return
+}
+
+// exprLiteral is a constant value found in an expression
+type exprLiteral struct {
+	typ  exprType
+	text string
+	s    string
+	n    number
+	b    bool
+}
+
+func (l exprLiteral) String() string {
+	if l.typ == typeString {
+		return strconv.Quote(l.s)
+	}
+	return l.text
+}
+
+type exprNode interface {
+	eval(fieldResolver) tribool
+	String() string
+}
+
+type andNode struct {
+	left, right exprNode
+}
+
+func (n *andNode) String() string {
+	return fmt.Sprintf("(%s and %s)", n.left, n.right)
+}
+
+type orNode struct {
+	left, right exprNode
+}
+
+func (n *orNode) String() string {
+	return fmt.Sprintf("(%s or %s)", n.left, n.right)
+}
+
+type notNode struct {
+	expr exprNode
+}
+
+func (n *notNode) String() string {
+	return fmt.Sprintf("not %s", n.expr)
+}
+
+// cmpNode compares a field with one or several literals
+type cmpNode struct {
+	field  string
+	op     string
+	values []exprLiteral
+	re     *regexp.Regexp
+}
+
+func (n *cmpNode) String() string {
+	if n.op == opIn {
+		values := make([]string, 0, len(n.values))
+		for _, v := range n.values {
+			values = append(values, v.String())
+		}
+		return fmt.Sprintf("%s in (%s)", n.field, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", n.field, n.op, n.values[0])
+}
+
+// fieldType returns the type of a canonical field
+func fieldType(field string) exprType {
+	if f, ok := eventFields[field]; ok {
+		return f.typ
+	}
+	return typeAny
+}
+
+// check type checks a comparison
+func (n *cmpNode) check() (err error) {
+	ft := fieldType(n.field)
+
+	for _, v := range n.values {
+		switch n.op {
+		case opEq, opNeq, opIn:
+			if ft != typeAny && v.typ != ft {
+				return fmt.Errorf("%w: cannot compare %s field %s with %s %s", ErrExprType, ft, n.field, v.typ, v)
+			}
+		case opLt, opLte, opGt, opGte:
+			if v.typ != typeNumber || (ft != typeAny && ft != typeNumber) {
+				return fmt.Errorf("%w: operator %s requires numbers, got %s field %s and %s %s", ErrExprType, n.op, ft, n.field, v.typ, v)
+			}
+		case opContains, opStartswith, opEndswith, opMatches:
+			if v.typ != typeString || (ft != typeAny && ft != typeString) {
+				return fmt.Errorf("%w: operator %s requires strings, got %s field %s and %s %s", ErrExprType, n.op, ft, n.field, v.typ, v)
+			}
+		}
+	}
+
+	if n.op == opMatches {
+		if n.re, err = regexp.Compile(n.values[0].s); err != nil {
+			return fmt.Errorf("%w: bad regular expression %s: %s", ErrExprType, n.values[0], err)
+		}
+	}
+
+	return
+}
+
+type exprParser struct {
+	toks []exprToken
+	pos  int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(kind tokenKind, what string) (t exprToken, err error) {
+	if t = p.next(); t.kind != kind {
+		err = p.unexpected(t, what)
+	}
+	return
+}
+
+func (p *exprParser) unexpected(t exprToken, expected string) error {
+	return fmt.Errorf("%w at position %d: expected %s, got %s", ErrExprSyntax, t.pos, expected, t)
+}
+
+// parseOr parses: and-expression { "or" and-expression }
+func (p *exprParser) parseOr() (n exprNode, err error) {
+	var right exprNode
+
+	if n, err = p.parseAnd(); err != nil {
+		return
+	}
+
+	for p.peek().kind == tokOr {
+		p.next()
+		if right, err = p.parseAnd(); err != nil {
+			return
+		}
+		n = &orNode{n, right}
+	}
+
+	return
+}
+
+// parseAnd parses: unary { "and" unary }
+func (p *exprParser) parseAnd() (n exprNode, err error) {
+	var right exprNode
+
+	if n, err = p.parseUnary(); err != nil {
+		return
+	}
+
+	for p.peek().kind == tokAnd {
+		p.next()
+		if right, err = p.parseUnary(); err != nil {
+			return
+		}
+		n = &andNode{n, right}
+	}
+
+	return
+}
+
+// parseUnary parses: "not" unary | "(" or-expression ")" | comparison
+func (p *exprParser) parseUnary() (n exprNode, err error) {
+	switch p.peek().kind {
+	case tokNot:
+		p.next()
+		if n, err = p.parseUnary(); err != nil {
+			return
+		}
+		return &notNode{n}, nil
+	case tokLParen:
+		p.next()
+		if n, err = p.parseOr(); err != nil {
+			return
+		}
+		_, err = p.expect(tokRParen, `")"`)
+		return
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseLiteral() (l exprLiteral, err error) {
+	t := p.next()
+	l.text = t.text
+
+	switch t.kind {
+	case tokString:
+		l.typ = typeString
+		l.s = t.text
+	case tokNumber:
+		var ok bool
+		l.typ = typeNumber
+		if l.n, ok = parseNumber(t.text); !ok {
+			err = fmt.Errorf("%w at position %d: bad number %s", ErrExprSyntax, t.pos, t.text)
+		}
+	case tokBool:
+		l.typ = typeBool
+		l.b = t.text == "true"
+	default:
+		err = p.unexpected(t, "a value")
+	}
+
+	return
+}
+
+// parseComparison parses: field operator value | field "in" "(" value { "," value } ")"
+func (p *exprParser) parseComparison() (n exprNode, err error) {
+	var ident, op exprToken
+	var l exprLiteral
+	var ok bool
+
+	if ident, err = p.expect(tokIdent, "a field"); err != nil {
+		return
+	}
+
+	c := &cmpNode{}
+	if c.field, ok = CanonicalField(ident.text); !ok {
+		return nil, fmt.Errorf("%w at position %d: unknown field %s", ErrExprType, ident.pos, ident.text)
+	}
+
+	if op, err = p.expect(tokOp, "an operator"); err != nil {
+		return
+	}
+	c.op = op.text
+
+	if c.op == opIn {
+		if _, err = p.expect(tokLParen, `"("`); err != nil {
+			return
+		}
+		for {
+			if l, err = p.parseLiteral(); err != nil {
+				return
+			}
+			c.values = append(c.values, l)
+
+			if t := p.next(); t.kind == tokRParen {
+				break
+			} else if t.kind != tokComma {
+				return nil, p.unexpected(t, `"," or ")"`)
+			}
+		}
+	} else {
+		if l, err = p.parseLiteral(); err != nil {
+			return
+		}
+		c.values = append(c.values, l)
+	}
+
+	if err = c.check(); err != nil {
+		return
+	}
+
+	return c, nil
+}
+
+// parseExpr parses and type checks an expression
+func parseExpr(src string) (n exprNode, err error) {
+	p := exprParser{}
+
+	if p.toks, err = tokenizeExpr(src); err != nil {
+		return
+	}
+
+	if n, err = p.parseOr(); err != nil {
+		return
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t, `"and", "or" or end of expression`)
+	}
+
+	return
+}
@@ -0,0 +1,373 @@
+package etw
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tribool is the result of the evaluation of an expression against events
+// which fields are only partially known
+type tribool int8
+
+const (
+	triFalse = tribool(iota)
+	triTrue
+	triUnknown
+)
+
+func toTribool(b bool) tribool {
+	if b {
+		return triTrue
+	}
+	return triFalse
+}
+
+type fieldState int
+
+const (
+	fieldFound = fieldState(iota)
+	fieldMissing
+	// field cannot be resolved at this stage of event processing
+	fieldUnknown
+)
+
+// fieldResolver is implemented by structures able to resolve canonical
+// event fields (see CanonicalField)
+type fieldResolver interface {
+	resolveField(field string) (interface{}, fieldState)
+}
+
+// ieventResolver resolves the fields available through IEvent interface
+type ieventResolver struct {
+	e IEvent
+}
+
+func (r ieventResolver) resolveField(field string) (interface{}, fieldState) {
+	switch field {
+	case "Provider.Guid":
+		return r.e.ProviderGUID(), fieldFound
+	case "EventID":
+		return r.e.EventID(), fieldFound
+	case "Level.Value":
+		return r.e.Level(), fieldFound
+	case "Keywords.Value":
+		return r.e.Keywords(), fieldFound
+	case "Opcode.Value":
+		return r.e.Opcode(), fieldFound
+	case "Task.Value":
+		return r.e.Task(), fieldFound
+	case "Execution.ProcessID":
+		return r.e.ProcessID(), fieldFound
+	case "Execution.ThreadID":
+		return r.e.ThreadID(), fieldFound
+	}
+	return nil, fieldUnknown
+}
+
+// number is a signed integer, an unsigned integer or a float
+type number struct {
+	isFloat bool
+	neg     bool
+	// magnitude of integer
+	mag uint64
+	f   float64
+}
+
+func (n number) float() float64 {
+	switch {
+	case n.isFloat:
+		return n.f
+	case n.neg:
+		return -float64(n.mag)
+	}
+	return float64(n.mag)
+}
+
+func compareNumbers(a, b number) int {
+	if a.isFloat || b.isFloat {
+		af, bf := a.float(), b.float()
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+
+	switch {
+	case a.neg && !b.neg:
+		return -1
+	case !a.neg && b.neg:
+		return 1
+	}
+
+	c := 0
+	switch {
+	case a.mag < b.mag:
+		c = -1
+	case a.mag > b.mag:
+		c = 1
+	}
+
+	if a.neg {
+		return -c
+	}
+	return c
+}
+
+func parseNumber(s string) (n number, ok bool) {
+	var err error
+	var i int64
+
+	if n.mag, err = strconv.ParseUint(s, 0, 64); err == nil {
+		return n, true
+	}
+
+	if i, err = strconv.ParseInt(s, 0, 64); err == nil {
+		return signedNumber(i), true
+	}
+
+	if n.f, err = strconv.ParseFloat(s, 64); err == nil {
+		n.isFloat = true
+		return n, true
+	}
+
+	return
+}
+
+func signedNumber(i int64) number {
+	if i < 0 {
+		return number{neg: true, mag: uint64(-(i + 1)) + 1}
+	}
+	return number{mag: uint64(i)}
+}
+
+// toNumber converts a value to number, strings are parsed
+func toNumber(v interface{}) (number, bool) {
+	switch n := v.(type) {
+	case uint8:
+		return number{mag: uint64(n)}, true
+	case uint16:
+		return number{mag: uint64(n)}, true
+	case uint32:
+		return number{mag: uint64(n)}, true
+	case uint64:
+		return number{mag: n}, true
+	case uint:
+		return number{mag: uint64(n)}, true
+	case int8:
+		return signedNumber(int64(n)), true
+	case int16:
+		return signedNumber(int64(n)), true
+	case int32:
+		return signedNumber(int64(n)), true
+	case int64:
+		return signedNumber(n), true
+	case int:
+		return signedNumber(int64(n)), true
+	case float32:
+		return number{isFloat: true, f: float64(n)}, true
+	case float64:
+		return number{isFloat: true, f: n}, true
+	case string:
+		return parseNumber(strings.TrimSpace(n))
+	}
+	return number{}, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case fmt.Stringer:
+		return s.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func toBool(v interface{}) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		if pb, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+			return pb, true
+		}
+	}
+	return false, false
+}
+
+func (n *andNode) eval(r fieldResolver) tribool {
+	left := n.left.eval(r)
+	if left == triFalse {
+		return triFalse
+	}
+
+	right := n.right.eval(r)
+	switch {
+	case right == triFalse:
+		return triFalse
+	case left == triTrue && right == triTrue:
+		return triTrue
+	}
+	return triUnknown
+}
+
+func (n *orNode) eval(r fieldResolver) tribool {
+	left := n.left.eval(r)
+	if left == triTrue {
+		return triTrue
+	}
+
+	right := n.right.eval(r)
+	switch {
+	case right == triTrue:
+		return triTrue
+	case left == triFalse && right == triFalse:
+		return triFalse
+	}
+	return triUnknown
+}
+
+func (n *notNode) eval(r fieldResolver) tribool {
+	switch n.expr.eval(r) {
+	case triTrue:
+		return triFalse
+	case triFalse:
+		return triTrue
+	}
+	return triUnknown
+}
+
+func (l *exprLiteral) equals(v interface{}) bool {
+	switch l.typ {
+	case typeNumber:
+		if n, ok := toNumber(v); ok {
+			return compareNumbers(n, l.n) == 0
+		}
+	case typeBool:
+		if b, ok := toBool(v); ok {
+			return b == l.b
+		}
+	case typeString:
+		return toString(v) == l.s
+	}
+	return false
+}
+
+func (n *cmpNode) eval(r fieldResolver) tribool {
+	v, state := r.resolveField(n.field)
+
+	switch state {
+	case fieldUnknown:
+		return triUnknown
+	case fieldMissing:
+		// any comparison with a missing field is false
+		return triFalse
+	}
+
+	switch n.op {
+	case opEq:
+		return toTribool(n.values[0].equals(v))
+	case opNeq:
+		return toTribool(!n.values[0].equals(v))
+	case opIn:
+		for i := range n.values {
+			if n.values[i].equals(v) {
+				return triTrue
+			}
+		}
+		return triFalse
+	case opLt, opLte, opGt, opGte:
+		num, ok := toNumber(v)
+		if !ok {
+			return triFalse
+		}
+		c := compareNumbers(num, n.values[0].n)
+		switch n.op {
+		case opLt:
+			return toTribool(c < 0)
+		case opLte:
+			return toTribool(c <= 0)
+		case opGt:
+			return toTribool(c > 0)
+		}
+		return toTribool(c >= 0)
+	case opContains:
+		return toTribool(strings.Contains(toString(v), n.values[0].s))
+	case opStartswith:
+		return toTribool(strings.HasPrefix(toString(v), n.values[0].s))
+	case opEndswith:
+		return toTribool(strings.HasSuffix(toString(v), n.values[0].s))
+	case opMatches:
+		return toTribool(n.re.MatchString(toString(v)))
+	}
+
+	return triFalse
+}
+
+// ExprFilter is an EventFilter compiled from an expression such as:
+//
+//	Provider.Name == "Microsoft-Windows-Kernel-Process" and EventID in (1, 2)
+//
+// Expressions are made of comparisons between event fields (see
+// CanonicalField) and values (strings, numbers or booleans), combined with
+// and, or, not operators and parentheses. Comparison operators are:
+// ==, !=, <, <=, >, >=, in, contains, startswith, endswith and matches
+// (regular expression). String comparisons are case sensitive.
+//
+// When evaluated against an IEvent, such as EventRecordHelper, fields not
+// available at this stage of event processing are considered as unknown and
+// events are filtered out only if the expression cannot match whatever the
+// value of the unknown fields.
+type ExprFilter struct {
+	src  string
+	root exprNode
+}
+
+// CompileExprFilter parses and type checks an expression and
+// returns an ExprFilter
+func CompileExprFilter(src string) (f *ExprFilter, err error) {
+	f = &ExprFilter{src: src}
+
+	if f.root, err = parseExpr(src); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MustCompileExprFilter compiles an expression or panic
+func MustCompileExprFilter(src string) *ExprFilter {
+	f, err := CompileExprFilter(src)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// MatchEvent returns true if the event matches the expression
+func (f *ExprFilter) MatchEvent(e *Event) bool {
+	return f.root.eval(e) == triTrue
+}
+
+// Match implements EventFilter. It returns false only if the event
+// cannot match the expression.
+func (f *ExprFilter) Match(e IEvent) bool {
+	if r, ok := e.(fieldResolver); ok {
+		return f.root.eval(r) != triFalse
+	}
+	return f.root.eval(ieventResolver{e}) != triFalse
+}
+
+// Source returns the expression the filter has been compiled from
+func (f *ExprFilter) Source() string {
+	return f.src
+}
+
+// String returns a normalized form of the expression
+func (f *ExprFilter) String() string {
+	return f.root.String()
+}
@@ -0,0 +1,253 @@
+package etw
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func newTestEvent() *Event {
+	e := NewEvent()
+	e.System.EventID = 1
+	e.System.Channel = "Microsoft-Windows-Kernel-Process/Analytic"
+	e.System.Provider.Name = "Microsoft-Windows-Kernel-Process"
+	e.System.Provider.Guid = *MustParseGUIDFromString("{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}")
+	e.System.Execution.ProcessID = 4242
+	e.System.Level.Value = 4
+	e.System.Keywords.Value = 0x8000000000000010
+	e.EventData["ImageName"] = `\Device\HarddiskVolume2\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`
+	e.EventData["ParentProcessID"] = "1337"
+	e.EventData["Elevated"] = "true"
+	e.EventData["Score"] = -1.5
+	return e
+}
+
+type testIEvent struct {
+	guid string
+	id   uint16
+}
+
+func (e testIEvent) ProviderGUID() string {
+	return e.guid
+}
+
+func (e testIEvent) ProviderID() GUID {
+	if g, err := ParseGUID(e.guid); err == nil {
+		return *g
+	}
+	return GUID{}
+}
+
+func (e testIEvent) EventID() uint16 {
+	return e.id
+}
+
+// remaining IEvent methods return the values of newTestEvent
+
+func (e testIEvent) Level() uint8 {
+	return 4
+}
+
+func (e testIEvent) Keywords() uint64 {
+	return 0x8000000000000010
+}
+
+func (e testIEvent) Opcode() uint8 {
+	return 0
+}
+
+func (e testIEvent) Task() uint16 {
+	return 0
+}
+
+func (e testIEvent) ProcessID() uint32 {
+	return 4242
+}
+
+func (e testIEvent) ThreadID() uint32 {
+	return 0
+}
+
+func TestEventGetField(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	e := newTestEvent()
+
+	v, ok := e.GetField("Provider.Name")
+	tt.Assert(ok && v == "Microsoft-Windows-Kernel-Process")
+
+	v, ok = e.GetField("System.EventID")
+	tt.Assert(ok && v == uint16(1))
+
+	v, ok = e.GetField("ProcessID")
+	tt.Assert(ok && v == uint32(4242))
+
+	v, ok = e.GetField("EventData.ParentProcessID")
+	tt.Assert(ok && v == "1337")
+
+	_, ok = e.GetField("EventData.Unknown")
+	tt.Assert(!ok)
+
+	_, ok = e.GetField("Unknown.Field")
+	tt.Assert(!ok)
+}
+
+func TestExprFilter(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	e := newTestEvent()
+
+	matching := []string{
+		`Provider.Name == "Microsoft-Windows-Kernel-Process" and EventID in (1,2) and EventData.ImageName endswith "\\powershell.exe"`,
+		`EventData.ImageName endswith "\powershell.exe"`,
+		`EventID == 1`,
+		`EventID = 0x1`,
+		`System.EventID != 2`,
+		`EventID >= 1 and EventID <= 1 and EventID > 0 and EventID < 2`,
+		`ProcessID == 4242 && Level <= 4`,
+		`Keywords > 0x8000000000000000`,
+		`EventData.ParentProcessID == 1337`,
+		`EventData.ParentProcessID > 1000`,
+		`EventData.Elevated == true`,
+		`EventData.Score < -1`,
+		`EventData.Score == -1.5`,
+		`Channel startswith 'Microsoft-Windows-Kernel-Process'`,
+		`Channel contains "Kernel"`,
+		`Provider.Guid == "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"`,
+		`EventData.ImageName matches "(?i)\\\\POWERSHELL\\.EXE$"`,
+		`not EventID == 2`,
+		`!(EventID == 2 or EventID == 3)`,
+		`EventID == 2 or (EventID == 1 AND NOT Level == 5)`,
+		`Provider.Name in ("A", "Microsoft-Windows-Kernel-Process")`,
+	}
+
+	for _, src := range matching {
+		f, err := CompileExprFilter(src)
+		tt.CheckErr(err)
+		tt.Assert(f.MatchEvent(e), src)
+		tt.Assert(f.Source() == src)
+	}
+
+	notMatching := []string{
+		`EventID in (2, 3)`,
+		`Provider.Name == "microsoft-windows-kernel-process"`,
+		`EventData.ImageName endswith "\\cmd.exe"`,
+		`EventData.Unknown == "value"`,
+		// comparison with missing field is always false
+		`EventData.Unknown != "value"`,
+		`EventData.ImageName > 42`,
+		`EventData.Elevated == false`,
+		`EventID == 1 and Level > 4`,
+		`ProcessID < -1`,
+	}
+
+	for _, src := range notMatching {
+		f, err := CompileExprFilter(src)
+		tt.CheckErr(err)
+		tt.Assert(!f.MatchEvent(e), src)
+	}
+}
+
+func TestExprFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	syntaxErrors := []string{
+		``,
+		`EventID`,
+		`EventID ==`,
+		`EventID == 1 and`,
+		`(EventID == 1`,
+		`EventID == 1)`,
+		`EventID in 1, 2`,
+		`EventID in (1 2)`,
+		`Provider.Name == "unterminated`,
+		`EventID == 1 # 2`,
+		`EventID == 0xZZ`,
+		`== 1`,
+	}
+
+	for _, src := range syntaxErrors {
+		_, err := CompileExprFilter(src)
+		tt.Assert(errors.Is(err, ErrExprSyntax), src, err)
+	}
+
+	typeErrors := []string{
+		`Unknown == 1`,
+		`EventID == "1"`,
+		`Provider.Name == 42`,
+		`Provider.Name > 42`,
+		`EventID contains "1"`,
+		`EventID in (1, "2")`,
+		`EventData.ImageName startswith 42`,
+		`EventData.ImageName matches "("`,
+		`Level == true`,
+	}
+
+	for _, src := range typeErrors {
+		_, err := CompileExprFilter(src)
+		tt.Assert(errors.Is(err, ErrExprType), src, err)
+	}
+
+	tt.ShouldPanic(func() { MustCompileExprFilter(`EventID ==`) })
+}
+
+func TestExprFilterString(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	f := MustCompileExprFilter(`System.Level <= 4 && !(ProcessID in (4,8)) || EventData.Path contains 'C:\Temp'`)
+	tt.Assert(f.String() == `((Level.Value <= 4 and not Execution.ProcessID in (4, 8)) or EventData.Path contains "C:\\Temp")`, f.String())
+
+	// normalized form must compile to the same expression
+	g := MustCompileExprFilter(f.String())
+	tt.Assert(g.String() == f.String())
+}
+
+func TestExprFilterReduced(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	guid := "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+	e := testIEvent{guid, 1}
+
+	// fields not available through IEvent are unknown
+	f := MustCompileExprFilter(`Provider.Guid == "` + guid + `" and EventID in (1, 2) and EventData.ImageName endswith "\\powershell.exe"`)
+	tt.Assert(f.Match(e))
+	tt.Assert(!f.Match(testIEvent{guid, 3}))
+	tt.Assert(!f.Match(testIEvent{"{00000000-0000-0000-0000-000000000000}", 1}))
+
+	// unknown or true is true
+	f = MustCompileExprFilter(`EventData.ImageName endswith "\\cmd.exe" or EventID == 1`)
+	tt.Assert(f.Match(e))
+	tt.Assert(f.Match(testIEvent{guid, 2}))
+
+	// unknown and false is false
+	f = MustCompileExprFilter(`EventData.ImageName endswith "\\cmd.exe" and EventID == 2`)
+	tt.Assert(!f.Match(e))
+
+	// not unknown is unknown
+	f = MustCompileExprFilter(`not Provider.Name == "Microsoft-Windows-Kernel-Process"`)
+	tt.Assert(f.Match(e))
+
+	// the reduced form never filters out events the full form matches
+	full := newTestEvent()
+	for _, src := range []string{
+		`Provider.Name == "Microsoft-Windows-Kernel-Process" and EventID == 1`,
+		`not (EventID == 2 and EventData.ImageName contains "cmd")`,
+		`EventID == 2 or Level == 4`,
+	} {
+		f = MustCompileExprFilter(src)
+		tt.Assert(f.MatchEvent(full), src)
+		tt.Assert(f.Match(e), src)
+	}
+
+	// Event resolves all its fields
+	f = MustCompileExprFilter(`EventID == 1 and EventData.ImageName endswith "\\cmd.exe"`)
+	tt.Assert(f.root.eval(full) == triFalse)
+}
@@ -0,0 +1,405 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID = 0x0001
+	EVENT_HEADER_EXT_TYPE_SID                = 0x0002
+	EVENT_HEADER_EXT_TYPE_TS_ID              = 0x0003
+	EVENT_HEADER_EXT_TYPE_INSTANCE_INFO      = 0x0004
+	EVENT_HEADER_EXT_TYPE_STACK_TRACE32      = 0x0005
+	EVENT_HEADER_EXT_TYPE_STACK_TRACE64      = 0x0006
+	EVENT_HEADER_EXT_TYPE_PEBS_INDEX         = 0x0007
+	EVENT_HEADER_EXT_TYPE_PMC_COUNTERS       = 0x0008
+	EVENT_HEADER_EXT_TYPE_PSM_KEY            = 0x0009
+	EVENT_HEADER_EXT_TYPE_EVENT_KEY          = 0x000A
+	EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL    = 0x000B
+	EVENT_HEADER_EXT_TYPE_PROV_TRAITS        = 0x000C
+	EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY  = 0x000D
+	EVENT_HEADER_EXT_TYPE_CONTROL_GUID       = 0x000E
+	EVENT_HEADER_EXT_TYPE_QPC_DELTA          = 0x000F
+	EVENT_HEADER_EXT_TYPE_CONTAINER_ID       = 0x0010
+	EVENT_HEADER_EXT_TYPE_STACK_KEY32        = 0x0011
+	EVENT_HEADER_EXT_TYPE_STACK_KEY64        = 0x0012
+	EVENT_HEADER_EXT_TYPE_MAX                = 0x0013
+)
+
+// TraceLogging InType flags
+const (
+	tlgInTypeMask  = 0x1f
+	tlgInFlagMask  = 0x60
+	tlgInCcount    = 0x20
+	tlgInVcount    = 0x40
+	tlgInCustom    = 0x60
+	tlgInChain     = 0x80
+	tlgOutTypeMask = 0x7f
+	tlgOutChain    = 0x80
+)
+
+var (
+	ErrExtendedData = fmt.Errorf("extended data decoding error")
+)
+
+// ExtendedDataItem is an extended data item of an event
+// as found in EVENT_HEADER_EXTENDED_DATA_ITEM
+type ExtendedDataItem struct {
+	ExtType uint16
+	Data    []byte
+}
+
+/*
+typedef struct _EVENT_EXTENDED_ITEM_INSTANCE {
+  ULONG InstanceId;
+  ULONG ParentInstanceId;
+  GUID  ParentGuid;
+} EVENT_EXTENDED_ITEM_INSTANCE, *PEVENT_EXTENDED_ITEM_INSTANCE;
+*/
+
+// InstanceInfo holds the data of an EVENT_HEADER_EXT_TYPE_INSTANCE_INFO item
+type InstanceInfo struct {
+	InstanceID       uint32
+	ParentInstanceID uint32
+	ParentGuid       GUID
+}
+
+/*
+typedef struct _EVENT_EXTENDED_ITEM_STACK_TRACE64 {
+  ULONG64 MatchId;
+  ULONG64 Address[ANYSIZE_ARRAY];
+} EVENT_EXTENDED_ITEM_STACK_TRACE64, *PEVENT_EXTENDED_ITEM_STACK_TRACE64;
+*/
+
+// StackTrace holds the data of EVENT_HEADER_EXT_TYPE_STACK_TRACE32 and
+// EVENT_HEADER_EXT_TYPE_STACK_TRACE64 items
+type StackTrace struct {
+	// Identifier used to match kernel and user mode parts of a stack
+	MatchID   uint64
+	Addresses []uint64
+}
+
+/*
+typedef struct _EVENT_EXTENDED_ITEM_STACK_KEY64 {
+  ULONG64 MatchId;
+  ULONG64 StackKey;
+} EVENT_EXTENDED_ITEM_STACK_KEY64, *PEVENT_EXTENDED_ITEM_STACK_KEY64;
+*/
+
+// StackKey holds the data of EVENT_HEADER_EXT_TYPE_STACK_KEY32 and
+// EVENT_HEADER_EXT_TYPE_STACK_KEY64 items
+type StackKey struct {
+	MatchID uint64
+	Key     uint64
+}
+
+// TraceLoggingField describes a field of a TraceLogging event
+type TraceLoggingField struct {
+	Name    string
+	InType  TdhInType
+	OutType TdhOutType
+	Tags    uint32 `json:",omitempty"`
+	// Count of elements for fixed size arrays
+	Count uint16 `json:",omitempty"`
+	// Whether the field is an array
+	IsArray bool `json:",omitempty"`
+}
+
+// TraceLoggingSchema holds the data of an EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL
+// item which describes the layout of a TraceLogging event
+type TraceLoggingSchema struct {
+	EventName string
+	Tags      uint32 `json:",omitempty"`
+	Fields    []TraceLoggingField
+}
+
+// EventExtendedData holds the decoded extended data items of an event.
+// The related activity ID is not part of it as it is already reported in
+// Event.System.Correlation.
+type EventExtendedData struct {
+	UserSID            string              `json:",omitempty"`
+	TerminalSessionID  *uint32             `json:",omitempty"`
+	InstanceInfo       *InstanceInfo       `json:",omitempty"`
+	StackTrace         *StackTrace         `json:",omitempty"`
+	StackKey           *StackKey           `json:",omitempty"`
+	PEBSIndex          *uint64             `json:",omitempty"`
+	PMCCounters        []uint64            `json:",omitempty"`
+	EventKey           *uint64             `json:",omitempty"`
+	ProcessStartKey    *uint64             `json:",omitempty"`
+	ContainerID        *GUID               `json:",omitempty"`
+	ControlGuid        *GUID               `json:",omitempty"`
+	ProviderName       string              `json:",omitempty"`
+	TraceLoggingSchema *TraceLoggingSchema `json:",omitempty"`
+}
+
+// DecodeExtendedData decodes a list of extended data items. Items of
+// unsupported types are ignored. All the items are decoded even if some
+// are malformed, in which case the items decoded are returned along with
+// an error reporting all the malformed ones.
+func DecodeExtendedData(items []ExtendedDataItem) (ed *EventExtendedData, err error) {
+	var malformed []string
+
+	ed = &EventExtendedData{}
+
+	for _, item := range items {
+		if err := ed.decode(item.ExtType, item.Data); err != nil {
+			malformed = append(malformed, fmt.Sprintf("type=0x%04x: %s", item.ExtType, err))
+		}
+	}
+
+	if len(malformed) > 0 {
+		err = fmt.Errorf("%w %s", ErrExtendedData, strings.Join(malformed, ", "))
+	}
+
+	return
+}
+
+// Decode decodes the data of an extended data item of type extType and
+// sets the corresponding field. Items of unsupported types are ignored.
+func (ed *EventExtendedData) Decode(extType uint16, data []byte) (err error) {
+	if err = ed.decode(extType, data); err != nil {
+		err = fmt.Errorf("%w type=0x%04x: %s", ErrExtendedData, extType, err)
+	}
+	return
+}
+
+func (ed *EventExtendedData) decode(extType uint16, data []byte) (err error) {
+	switch extType {
+	case EVENT_HEADER_EXT_TYPE_SID:
+		ed.UserSID, _, err = DecodeSID(data)
+
+	case EVENT_HEADER_EXT_TYPE_TS_ID:
+		var id uint64
+		if id, err = extUint(data, 4); err == nil {
+			u := uint32(id)
+			ed.TerminalSessionID = &u
+		}
+
+	case EVENT_HEADER_EXT_TYPE_INSTANCE_INFO:
+		if len(data) < 8+sizeofGUID {
+			return ErrShortBuffer
+		}
+		ed.InstanceInfo = &InstanceInfo{
+			InstanceID:       binary.LittleEndian.Uint32(data),
+			ParentInstanceID: binary.LittleEndian.Uint32(data[4:]),
+			ParentGuid:       decodeGUID(data[8:]),
+		}
+
+	case EVENT_HEADER_EXT_TYPE_STACK_TRACE32, EVENT_HEADER_EXT_TYPE_STACK_TRACE64:
+		ed.StackTrace, err = decodeStackTrace(extType, data)
+
+	case EVENT_HEADER_EXT_TYPE_STACK_KEY32, EVENT_HEADER_EXT_TYPE_STACK_KEY64:
+		// the 32 bits key is followed by 4 bytes of padding
+		if len(data) < 16 {
+			return ErrShortBuffer
+		}
+		ed.StackKey = &StackKey{MatchID: binary.LittleEndian.Uint64(data)}
+		if extType == EVENT_HEADER_EXT_TYPE_STACK_KEY32 {
+			ed.StackKey.Key = uint64(binary.LittleEndian.Uint32(data[8:]))
+		} else {
+			ed.StackKey.Key = binary.LittleEndian.Uint64(data[8:])
+		}
+
+	case EVENT_HEADER_EXT_TYPE_PEBS_INDEX:
+		var u uint64
+		if u, err = extUint(data, 8); err == nil {
+			ed.PEBSIndex = &u
+		}
+
+	case EVENT_HEADER_EXT_TYPE_PMC_COUNTERS:
+		ed.PMCCounters = make([]uint64, 0, len(data)/8)
+		for i := 0; i+8 <= len(data); i += 8 {
+			ed.PMCCounters = append(ed.PMCCounters, binary.LittleEndian.Uint64(data[i:]))
+		}
+
+	case EVENT_HEADER_EXT_TYPE_EVENT_KEY:
+		var u uint64
+		if u, err = extUint(data, 8); err == nil {
+			ed.EventKey = &u
+		}
+
+	case EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY:
+		var u uint64
+		if u, err = extUint(data, 8); err == nil {
+			ed.ProcessStartKey = &u
+		}
+
+	case EVENT_HEADER_EXT_TYPE_CONTAINER_ID, EVENT_HEADER_EXT_TYPE_CONTROL_GUID:
+		if len(data) < sizeofGUID {
+			return ErrShortBuffer
+		}
+		g := decodeGUID(data)
+		if extType == EVENT_HEADER_EXT_TYPE_CONTAINER_ID {
+			ed.ContainerID = &g
+		} else {
+			ed.ControlGuid = &g
+		}
+
+	case EVENT_HEADER_EXT_TYPE_PROV_TRAITS:
+		// UINT16 TotalSize followed by the nul terminated UTF-8 provider name
+		if len(data) < 2 {
+			return ErrShortBuffer
+		}
+		ed.ProviderName, _, err = decodeAnsiNul(data[2:])
+
+	case EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL:
+		ed.TraceLoggingSchema, err = DecodeTraceLoggingSchema(data)
+	}
+
+	return
+}
+
+func extUint(data []byte, size int) (uint64, error) {
+	if len(data) < size {
+		return 0, ErrShortBuffer
+	}
+	if size == 4 {
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+func decodeStackTrace(extType uint16, data []byte) (st *StackTrace, err error) {
+	addrSize := 8
+	if extType == EVENT_HEADER_EXT_TYPE_STACK_TRACE32 {
+		addrSize = 4
+	}
+
+	if len(data) < 8 {
+		return nil, ErrShortBuffer
+	}
+
+	st = &StackTrace{MatchID: binary.LittleEndian.Uint64(data)}
+	st.Addresses = make([]uint64, 0, (len(data)-8)/addrSize)
+
+	for i := 8; i+addrSize <= len(data); i += addrSize {
+		if addrSize == 4 {
+			st.Addresses = append(st.Addresses, uint64(binary.LittleEndian.Uint32(data[i:])))
+		} else {
+			st.Addresses = append(st.Addresses, binary.LittleEndian.Uint64(data[i:]))
+		}
+	}
+
+	return
+}
+
+// tlgTags decodes TraceLogging tags stored on one to four bytes, the high
+// bit of each byte indicating another byte follows
+func tlgTags(data []byte) (tags uint32, size int, err error) {
+	for shift := 21; ; shift -= 7 {
+		if size >= len(data) || shift < 0 {
+			return 0, 0, ErrShortBuffer
+		}
+		b := data[size]
+		size++
+		tags |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return
+		}
+	}
+}
+
+/*
+TraceLogging event metadata layout:
+
+	UINT16 Size;             // size of the metadata including this field
+	UINT8  Tags[];           // 1 to 4 bytes, high bit set if another byte follows
+	char   Name[];           // nul terminated UTF-8 event name
+	struct {
+		char   Name[];       // nul terminated UTF-8 field name
+		UINT8  InType;       // high bit set if OutType follows
+		UINT8  OutType;      // optional, high bit set if Tags follow
+		UINT8  Tags[];       // optional
+		UINT16 Count;        // optional, for constant count arrays
+		UINT16 SchemaSize;   // optional, for custom fields
+		UINT8  Schema[];     // optional, for custom fields
+	} Fields[];
+*/
+
+// DecodeTraceLoggingSchema decodes TraceLogging event metadata as found in
+// EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL extended data items
+func DecodeTraceLoggingSchema(data []byte) (s *TraceLoggingSchema, err error) {
+	var n int
+
+	if len(data) < 2 {
+		return nil, ErrShortBuffer
+	}
+
+	if size := int(binary.LittleEndian.Uint16(data)); size <= len(data) {
+		data = data[:size]
+	} else {
+		return nil, ErrShortBuffer
+	}
+
+	s = &TraceLoggingSchema{Fields: make([]TraceLoggingField, 0)}
+	off := 2
+
+	if s.Tags, n, err = tlgTags(data[off:]); err != nil {
+		return
+	}
+	off += n
+
+	if s.EventName, n, err = decodeAnsiNul(data[off:]); err != nil {
+		return
+	}
+	off += n
+
+	for off < len(data) {
+		var f TraceLoggingField
+		var in, out byte
+
+		if f.Name, n, err = decodeAnsiNul(data[off:]); err != nil {
+			return
+		}
+		off += n
+
+		if off >= len(data) {
+			return nil, ErrShortBuffer
+		}
+		in = data[off]
+		off++
+		f.InType = TdhInType(in & tlgInTypeMask)
+
+		if in&tlgInChain != 0 {
+			if off >= len(data) {
+				return nil, ErrShortBuffer
+			}
+			out = data[off]
+			off++
+			f.OutType = TdhOutType(out & tlgOutTypeMask)
+
+			if out&tlgOutChain != 0 {
+				if f.Tags, n, err = tlgTags(data[off:]); err != nil {
+					return
+				}
+				off += n
+			}
+		}
+
+		switch in & tlgInFlagMask {
+		case tlgInCcount:
+			if off+2 > len(data) {
+				return nil, ErrShortBuffer
+			}
+			f.Count = binary.LittleEndian.Uint16(data[off:])
+			f.IsArray = true
+			off += 2
+		case tlgInVcount:
+			f.IsArray = true
+		case tlgInCustom:
+			if off+2 > len(data) {
+				return nil, ErrShortBuffer
+			}
+			off += 2 + int(binary.LittleEndian.Uint16(data[off:]))
+			if off > len(data) {
+				return nil, ErrShortBuffer
+			}
+		}
+
+		s.Fields = append(s.Fields, f)
+	}
+
+	return
+}
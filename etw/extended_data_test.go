@@ -0,0 +1,174 @@
+package etw
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+var (
+	// EVENT_EXTENDED_ITEM_STACK_TRACE64 with 3 frames
+	stackTrace64Fixture = []byte{
+		0x2a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x10, 0x32, 0x54, 0x76, 0xfe, 0x7f, 0x00, 0x00,
+		0x20, 0x32, 0x54, 0x76, 0xfe, 0x7f, 0x00, 0x00,
+		0x30, 0x12, 0x40, 0x00, 0x00, 0xf8, 0xff, 0xff,
+	}
+
+	// EVENT_EXTENDED_ITEM_STACK_TRACE32 with 2 frames
+	stackTrace32Fixture = []byte{
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x78, 0x56, 0x34, 0x12,
+		0xf0, 0xde, 0xbc, 0x9a,
+	}
+
+	// S-1-5-21-1004336348-1177238915-682003330-512
+	sidFixture = []byte{
+		0x01, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05,
+		0x15, 0x00, 0x00, 0x00,
+		0xdc, 0xf4, 0xdc, 0x3b,
+		0x83, 0x3d, 0x2b, 0x46,
+		0x82, 0x8b, 0xa6, 0x28,
+		0x00, 0x02, 0x00, 0x00,
+	}
+
+	// {EDD08927-9CC4-4E65-B970-C2560FB5C289}
+	guidFixture = []byte{
+		0x27, 0x89, 0xd0, 0xed, 0xc4, 0x9c, 0x65, 0x4e,
+		0xb9, 0x70, 0xc2, 0x56, 0x0f, 0xb5, 0xc2, 0x89,
+	}
+
+	// TraceLogging provider traits
+	provTraitsFixture = append([]byte{0x0f, 0x00}, []byte("MyTLProvider\x00")...)
+)
+
+// tlSchemaFixture builds TraceLogging event metadata
+func tlSchemaFixture() []byte {
+	b := []byte{0x00, 0x00}
+	// event tags
+	b = append(b, 0x00)
+	b = append(b, []byte("MyEvent\x00")...)
+	// unicode string with string out type
+	b = append(b, []byte("Name\x00")...)
+	b = append(b, byte(TdhInTypeUnicodestring)|tlgInChain, byte(TdhOutTypeString))
+	// simple int32
+	b = append(b, []byte("Count\x00")...)
+	b = append(b, byte(TdhInTypeInt32))
+	// constant count array
+	b = append(b, []byte("Values\x00")...)
+	b = append(b, byte(TdhInTypeUint32)|tlgInCcount, 0x03, 0x00)
+	// variable count array
+	b = append(b, []byte("Items\x00")...)
+	b = append(b, byte(TdhInTypeHexint32)|tlgInVcount)
+	// field with out type and tags
+	b = append(b, []byte("Pid\x00")...)
+	b = append(b, byte(TdhInTypeUint32)|tlgInChain, byte(TdhOutTypePid)|tlgOutChain, 0x81, 0x00)
+	binary.LittleEndian.PutUint16(b, uint16(len(b)))
+	return b
+}
+
+func TestDecodeExtendedData(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	items := []ExtendedDataItem{
+		{EVENT_HEADER_EXT_TYPE_RELATED_ACTIVITYID, guidFixture},
+		{EVENT_HEADER_EXT_TYPE_SID, sidFixture},
+		{EVENT_HEADER_EXT_TYPE_TS_ID, []byte{0x02, 0x00, 0x00, 0x00}},
+		{EVENT_HEADER_EXT_TYPE_STACK_TRACE64, stackTrace64Fixture},
+		{EVENT_HEADER_EXT_TYPE_PEBS_INDEX, []byte{0x07, 0, 0, 0, 0, 0, 0, 0}},
+		{EVENT_HEADER_EXT_TYPE_PMC_COUNTERS, []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}},
+		{EVENT_HEADER_EXT_TYPE_EVENT_KEY, []byte{0xef, 0xbe, 0xad, 0xde, 0, 0, 0, 0}},
+		{EVENT_HEADER_EXT_TYPE_PROCESS_START_KEY, []byte{0x39, 0x05, 0, 0, 0, 0, 0, 0x01}},
+		{EVENT_HEADER_EXT_TYPE_CONTAINER_ID, guidFixture},
+		{EVENT_HEADER_EXT_TYPE_PROV_TRAITS, provTraitsFixture},
+		{EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL, tlSchemaFixture()},
+		// unsupported items must be ignored
+		{EVENT_HEADER_EXT_TYPE_PSM_KEY, []byte{0x41}},
+	}
+
+	ed, err := DecodeExtendedData(items)
+	tt.CheckErr(err)
+
+	tt.Assert(ed.UserSID == "S-1-5-21-1004336348-1177238915-682003330-512")
+	tt.Assert(*ed.TerminalSessionID == 2)
+	tt.Assert(ed.StackTrace.MatchID == 42)
+	tt.Assert(len(ed.StackTrace.Addresses) == 3)
+	tt.Assert(ed.StackTrace.Addresses[0] == 0x7ffe76543210)
+	tt.Assert(ed.StackTrace.Addresses[2] == 0xfffff80000401230)
+	tt.Assert(*ed.PEBSIndex == 7)
+	tt.Assert(len(ed.PMCCounters) == 2 && ed.PMCCounters[1] == 2)
+	tt.Assert(*ed.EventKey == 0xdeadbeef)
+	tt.Assert(*ed.ProcessStartKey == 0x0100000000000539)
+	tt.Assert(ed.ContainerID.Equals(MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")))
+	tt.Assert(ed.ProviderName == "MyTLProvider")
+
+	s := ed.TraceLoggingSchema
+	tt.Assert(s.EventName == "MyEvent")
+	tt.Assert(len(s.Fields) == 5)
+	tt.Assert(s.Fields[0].Name == "Name" && s.Fields[0].InType == TdhInTypeUnicodestring && s.Fields[0].OutType == TdhOutTypeString)
+	tt.Assert(s.Fields[1].Name == "Count" && s.Fields[1].InType == TdhInTypeInt32 && !s.Fields[1].IsArray)
+	tt.Assert(s.Fields[2].Name == "Values" && s.Fields[2].IsArray && s.Fields[2].Count == 3)
+	tt.Assert(s.Fields[3].Name == "Items" && s.Fields[3].IsArray && s.Fields[3].Count == 0)
+	tt.Assert(s.Fields[4].Name == "Pid" && s.Fields[4].OutType == TdhOutTypePid && s.Fields[4].Tags == 1<<21)
+
+	// must be JSON serializable, GUIDs as strings
+	b, err := json.Marshal(ed)
+	tt.CheckErr(err)
+	tt.Assert(strings.Contains(string(b), `"ContainerID":"{EDD08927-9CC4-4E65-B970-C2560FB5C289}"`), string(b))
+}
+
+func TestDecodeExtendedDataItems(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	ed := &EventExtendedData{}
+
+	tt.CheckErr(ed.Decode(EVENT_HEADER_EXT_TYPE_STACK_TRACE32, stackTrace32Fixture))
+	tt.Assert(ed.StackTrace.MatchID == 1)
+	tt.Assert(len(ed.StackTrace.Addresses) == 2)
+	tt.Assert(ed.StackTrace.Addresses[0] == 0x12345678 && ed.StackTrace.Addresses[1] == 0x9abcdef0)
+
+	instance := append([]byte{1, 0, 0, 0, 2, 0, 0, 0}, guidFixture...)
+	tt.CheckErr(ed.Decode(EVENT_HEADER_EXT_TYPE_INSTANCE_INFO, instance))
+	tt.Assert(ed.InstanceInfo.InstanceID == 1 && ed.InstanceInfo.ParentInstanceID == 2)
+	tt.Assert(ed.InstanceInfo.ParentGuid.Equals(MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")))
+
+	key32 := []byte{9, 0, 0, 0, 0, 0, 0, 0, 0x44, 0x33, 0x22, 0x11, 0xff, 0xff, 0xff, 0xff}
+	tt.CheckErr(ed.Decode(EVENT_HEADER_EXT_TYPE_STACK_KEY32, key32))
+	tt.Assert(ed.StackKey.MatchID == 9 && ed.StackKey.Key == 0x11223344)
+
+	tt.CheckErr(ed.Decode(EVENT_HEADER_EXT_TYPE_CONTROL_GUID, guidFixture))
+	tt.Assert(ed.ControlGuid.Equals(MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")))
+
+	// truncated items
+	for _, item := range []ExtendedDataItem{
+		{EVENT_HEADER_EXT_TYPE_SID, sidFixture[:12]},
+		{EVENT_HEADER_EXT_TYPE_TS_ID, []byte{0x01}},
+		{EVENT_HEADER_EXT_TYPE_STACK_TRACE64, []byte{0x01}},
+		{EVENT_HEADER_EXT_TYPE_CONTAINER_ID, guidFixture[:8]},
+		{EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL, tlSchemaFixture()[:10]},
+	} {
+		err := ed.Decode(item.ExtType, item.Data)
+		tt.Assert(errors.Is(err, ErrExtendedData), "type", item.ExtType)
+	}
+
+	// all items are decoded even if some are malformed
+	ed, err := DecodeExtendedData([]ExtendedDataItem{
+		{EVENT_HEADER_EXT_TYPE_TS_ID, []byte{0x01}},
+		{EVENT_HEADER_EXT_TYPE_SID, sidFixture},
+		{EVENT_HEADER_EXT_TYPE_STACK_TRACE64, []byte{0x01}},
+		{EVENT_HEADER_EXT_TYPE_CONTAINER_ID, guidFixture},
+	})
+	tt.Assert(errors.Is(err, ErrExtendedData))
+	tt.Assert(strings.Contains(err.Error(), "type=0x0003") && strings.Contains(err.Error(), "type=0x0006"))
+	tt.Assert(ed.TerminalSessionID == nil && ed.StackTrace == nil)
+	tt.Assert(ed.UserSID == "S-1-5-21-1004336348-1177238915-682003330-512")
+	tt.Assert(ed.ContainerID.Equals(MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")))
+}
@@ -0,0 +1,165 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	EVENT_FILTER_TYPE_NONE               = 0x00000000
+	EVENT_FILTER_TYPE_SCHEMATIZED        = 0x80000000 // Provider-side.
+	EVENT_FILTER_TYPE_SYSTEM_FLAGS       = 0x80000001 // Internal use only.
+	EVENT_FILTER_TYPE_TRACEHANDLE        = 0x80000002 // Initiate rundown.
+	EVENT_FILTER_TYPE_PID                = 0x80000004 // Process ID.
+	EVENT_FILTER_TYPE_EXECUTABLE_NAME    = 0x80000008 // EXE file name.
+	EVENT_FILTER_TYPE_PACKAGE_ID         = 0x80000010 // Package ID.
+	EVENT_FILTER_TYPE_PACKAGE_APP_ID     = 0x80000020 // Package Relative App Id (PRAID).
+	EVENT_FILTER_TYPE_PAYLOAD            = 0x80000100 // TDH payload filter.
+	EVENT_FILTER_TYPE_EVENT_ID           = 0x80000200 // Event IDs.
+	EVENT_FILTER_TYPE_EVENT_NAME         = 0x80000400 // Event name (TraceLogging only).
+	EVENT_FILTER_TYPE_STACKWALK          = 0x80001000 // Event IDs for stack.
+	EVENT_FILTER_TYPE_STACKWALK_NAME     = 0x80002000 // Event name for stack (TraceLogging only).
+	EVENT_FILTER_TYPE_STACKWALK_LEVEL_KW = 0x80004000 // Filter stack collection by level and keyword.
+	EVENT_FILTER_TYPE_CONTAINER          = 0x80008000 // Filter by Container ID.
+)
+
+const (
+	MAX_EVENT_FILTER_DATA_SIZE = 1024
+
+	MAX_EVENT_FILTER_EVENT_ID_COUNT = 64
+
+	MAX_EVENT_FILTER_PID_COUNT = 8
+)
+
+var (
+	ErrFilterData = fmt.Errorf("bad filter data")
+)
+
+// FilterData is the serialized data of an event filter, as pointed
+// to by an EVENT_FILTER_DESCRIPTOR structure
+type FilterData struct {
+	Type uint32
+	Data []byte
+}
+
+// EncodeEventIDFilter serializes an EVENT_FILTER_EVENT_ID structure
+func EncodeEventIDFilter(ids []uint16, filterIn bool) []byte {
+	buf := make([]byte, 4+2*len(ids))
+
+	if filterIn {
+		buf[0] = 1
+	}
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(ids)))
+	for i, id := range ids {
+		binary.LittleEndian.PutUint16(buf[4+2*i:], id)
+	}
+
+	return buf
+}
+
+// EncodePIDFilter serializes the data of an EVENT_FILTER_TYPE_PID
+// filter, an array of at most MAX_EVENT_FILTER_PID_COUNT process IDs
+func EncodePIDFilter(pids []uint32) ([]byte, error) {
+	if len(pids) > MAX_EVENT_FILTER_PID_COUNT {
+		return nil, fmt.Errorf("%w: too many PIDs %d > %d", ErrFilterData, len(pids), MAX_EVENT_FILTER_PID_COUNT)
+	}
+
+	buf := make([]byte, 4*len(pids))
+	for i, pid := range pids {
+		binary.LittleEndian.PutUint32(buf[4*i:], pid)
+	}
+
+	return buf, nil
+}
+
+// EncodeExecutableNameFilter serializes the data of an
+// EVENT_FILTER_TYPE_EXECUTABLE_NAME filter, a NUL terminated UTF-16
+// string of semicolon separated executable names
+func EncodeExecutableNameFilter(names []string) ([]byte, error) {
+	for _, n := range names {
+		if n == "" || strings.ContainsRune(n, ';') {
+			return nil, fmt.Errorf("%w: bad executable name %q", ErrFilterData, n)
+		}
+	}
+
+	u := utf16.Encode([]rune(strings.Join(names, ";")))
+	buf := make([]byte, 2*(len(u)+1))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+
+	if len(buf) > MAX_EVENT_FILTER_DATA_SIZE {
+		return nil, fmt.Errorf("%w: executable names too large %d > %d bytes", ErrFilterData, len(buf), MAX_EVENT_FILTER_DATA_SIZE)
+	}
+
+	return buf, nil
+}
+
+// BuildFilterData serializes the filters of the provider to be
+// applied by ETW
+func (p *Provider) BuildFilterData() (fd []FilterData, err error) {
+	var data []byte
+
+	// too large event ID filters are applied on consumer side
+	if p.KernelEventIDFilter() {
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_EVENT_ID, EncodeEventIDFilter(p.Filter, !p.FilterOut)})
+	}
+
+	if len(p.PIDs) > 0 {
+		if data, err = EncodePIDFilter(p.PIDs); err != nil {
+			return
+		}
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_PID, data})
+	}
+
+	if len(p.ExecutableNames) > 0 {
+		if data, err = EncodeExecutableNameFilter(p.ExecutableNames); err != nil {
+			return
+		}
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_EXECUTABLE_NAME, data})
+	}
+
+	if p.StackWalkEnabled() {
+		var sfd []FilterData
+		if sfd, err = p.StackWalk.BuildFilterData(); err != nil {
+			return
+		}
+		fd = append(fd, sfd...)
+	}
+
+	return
+}
+
+// Descriptor returns an EventFilterDescriptor pointing to the filter data.
+// The FilterData must be kept alive as long as the descriptor is used.
+func (d *FilterData) Descriptor() EventFilterDescriptor {
+	desc := EventFilterDescriptor{
+		Size: uint32(len(d.Data)),
+		Type: d.Type,
+	}
+
+	if len(d.Data) > 0 {
+		desc.Ptr = uint64(uintptr(unsafe.Pointer(&d.Data[0])))
+	}
+
+	return desc
+}
+
+// BuildFilterDesc builds the filter descriptors of the provider. Descriptors
+// point to the returned filter data, which must be kept alive (see
+// runtime.KeepAlive) as long as descriptors are used.
+func (p *Provider) BuildFilterDesc() (fd []EventFilterDescriptor, data []FilterData, err error) {
+
+	if data, err = p.BuildFilterData(); err != nil {
+		return
+	}
+
+	for i := range data {
+		fd = append(fd, data[i].Descriptor())
+	}
+
+	return
+}
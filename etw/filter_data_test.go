@@ -0,0 +1,110 @@
+package etw
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestEncodeFilterData(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// EVENT_FILTER_EVENT_ID: FilterIn, Reserved, Count, Events
+	tt.Assert(bytes.Equal(EncodeEventIDFilter([]uint16{12, 0x1234}, true),
+		[]byte{1, 0, 2, 0, 12, 0, 0x34, 0x12}))
+	tt.Assert(bytes.Equal(EncodeEventIDFilter([]uint16{1}, false),
+		[]byte{0, 0, 1, 0, 1, 0}))
+
+	// array of ULONG
+	data, err := EncodePIDFilter([]uint32{4, 0x01020304})
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(data, []byte{4, 0, 0, 0, 4, 3, 2, 1}))
+
+	_, err = EncodePIDFilter(make([]uint32, MAX_EVENT_FILTER_PID_COUNT+1))
+	tt.Assert(errors.Is(err, ErrFilterData))
+
+	// NUL terminated UTF-16LE string of semicolon separated names
+	data, err = EncodeExecutableNameFilter([]string{"a.exe", "é.exe"})
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(data, []byte{
+		'a', 0, '.', 0, 'e', 0, 'x', 0, 'e', 0,
+		';', 0,
+		0xe9, 0, '.', 0, 'e', 0, 'x', 0, 'e', 0,
+		0, 0}), data)
+
+	for _, names := range [][]string{
+		{""},
+		{"a.exe;b.exe"},
+		{strings.Repeat("a", MAX_EVENT_FILTER_DATA_SIZE/2)},
+	} {
+		_, err = EncodeExecutableNameFilter(names)
+		tt.Assert(errors.Is(err, ErrFilterData), names)
+	}
+}
+
+func TestProviderBuildFilterData(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	p := Provider{}
+	fd, err := p.BuildFilterData()
+	tt.CheckErr(err)
+	tt.Assert(len(fd) == 0)
+
+	p.Filter = []uint16{1, 2}
+	p.PIDs = []uint32{42}
+	p.ExecutableNames = []string{"cmd.exe"}
+
+	fd, err = p.BuildFilterData()
+	tt.CheckErr(err)
+	tt.Assert(len(fd) == 3)
+	tt.Assert(fd[0].Type == EVENT_FILTER_TYPE_EVENT_ID && len(fd[0].Data) == 8)
+	tt.Assert(fd[1].Type == EVENT_FILTER_TYPE_PID && bytes.Equal(fd[1].Data, []byte{42, 0, 0, 0}))
+	tt.Assert(fd[2].Type == EVENT_FILTER_TYPE_EXECUTABLE_NAME && len(fd[2].Data) == 16)
+
+	p.PIDs = make([]uint32, MAX_EVENT_FILTER_PID_COUNT+1)
+	_, err = p.BuildFilterData()
+	tt.Assert(errors.Is(err, ErrFilterData))
+
+	// filter out mode
+	p = Provider{Filter: []uint16{10, 11}, FilterOut: true}
+	tt.Assert(p.KernelEventIDFilter())
+	fd, err = p.BuildFilterData()
+	tt.CheckErr(err)
+	tt.Assert(len(fd) == 1 && bytes.Equal(fd[0].Data, []byte{0, 0, 2, 0, 10, 0, 11, 0}))
+
+	// too many event IDs to be filtered by ETW
+	p.Filter = make([]uint16, MAX_EVENT_FILTER_EVENT_ID_COUNT+1)
+	tt.Assert(!p.KernelEventIDFilter())
+	fd, err = p.BuildFilterData()
+	tt.CheckErr(err)
+	tt.Assert(len(fd) == 0)
+}
+
+func TestProviderBuildFilterDesc(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	p := Provider{PIDs: []uint32{4, 16}, ExecutableNames: []string{"svchost.exe"}}
+	fds, data, err := p.BuildFilterDesc()
+	tt.CheckErr(err)
+	tt.Assert(len(fds) == 2 && len(data) == 2)
+
+	for i, fd := range fds {
+		tt.Assert(fd.Type == data[i].Type)
+		tt.Assert(fd.Size == uint32(len(data[i].Data)))
+		tt.Assert(fd.Ptr == uint64(uintptr(unsafe.Pointer(&data[i].Data[0]))))
+	}
+
+	// descriptor of empty data does not point anywhere
+	fd := (&FilterData{Type: EVENT_FILTER_TYPE_PID}).Descriptor()
+	tt.Assert(fd.Ptr == 0 && fd.Size == 0)
+}
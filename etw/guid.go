@@ -1,19 +1,16 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
-const (
-	nullGUIDStr = "{00000000-0000-0000-0000-000000000000}"
-)
-
 var (
 	nullGUID = GUID{}
 )
@@ -36,11 +33,13 @@ type GUID struct {
 }
 
 // IsZero checks if GUID is all zeros
-func (g *GUID) IsZero() bool {
-	return g.Equals(&nullGUID)
+func (g GUID) IsZero() bool {
+	return g == nullGUID
 }
 
-func (g *GUID) String() string {
+// String returns the registry format of the GUID
+// {XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX}
+func (g GUID) String() string {
 	return fmt.Sprintf("{%08X-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X}",
 		g.Data1,
 		g.Data2,
@@ -49,7 +48,8 @@ func (g *GUID) String() string {
 		g.Data4[2], g.Data4[3], g.Data4[4], g.Data4[5], g.Data4[6], g.Data4[7])
 }
 
-func (g *GUID) Equals(other *GUID) bool {
+// Equals returns true if g and other are the same GUIDs
+func (g GUID) Equals(other *GUID) bool {
 	return g.Data1 == other.Data1 &&
 		g.Data2 == other.Data2 &&
 		g.Data3 == other.Data3 &&
@@ -117,3 +117,109 @@ func ParseGUID(guid string) (g *GUID, err error) {
 
 	return
 }
+
+// Compare returns an integer comparing two GUIDs, the result is 0 if
+// g == other, -1 if g < other and +1 if g > other. GUIDs are ordered
+// the same way as their string representations.
+func (g GUID) Compare(other GUID) int {
+	a, b := g.bigEndian(), other.bigEndian()
+	return bytes.Compare(a[:], b[:])
+}
+
+// MarshalText implements encoding.TextMarshaler, it is used to encode
+// GUIDs as JSON strings
+func (g GUID) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts any format
+// ParseGUID accepts and empty text which decodes to a null GUID
+func (g *GUID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*g = nullGUID
+		return nil
+	}
+
+	pg, err := ParseGUID(string(text))
+	if err != nil {
+		return err
+	}
+
+	*g = *pg
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The GUID is encoded
+// in its in memory Windows layout, Data1, Data2 and Data3 being little
+// endian.
+func (g GUID) MarshalBinary() ([]byte, error) {
+	b := make([]byte, sizeofGUID)
+	binary.LittleEndian.PutUint32(b, g.Data1)
+	binary.LittleEndian.PutUint16(b[4:], g.Data2)
+	binary.LittleEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:], g.Data4[:])
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, see MarshalBinary
+func (g *GUID) UnmarshalBinary(data []byte) error {
+	if len(data) != sizeofGUID {
+		return fmt.Errorf("%w: GUID must be %d bytes long, got %d", ErrShortBuffer, sizeofGUID, len(data))
+	}
+
+	*g = decodeGUID(data)
+	return nil
+}
+
+// bigEndian returns the RFC 4122 byte representation of the GUID
+func (g GUID) bigEndian() (b [16]byte) {
+	binary.BigEndian.PutUint32(b[:], g.Data1)
+	binary.BigEndian.PutUint16(b[4:], g.Data2)
+	binary.BigEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:], g.Data4[:])
+	return
+}
+
+// guidFromBigEndian builds a GUID from its RFC 4122 byte representation
+func guidFromBigEndian(b []byte) (g GUID) {
+	g.Data1 = binary.BigEndian.Uint32(b)
+	g.Data2 = binary.BigEndian.Uint16(b[4:])
+	g.Data3 = binary.BigEndian.Uint16(b[6:])
+	copy(g.Data4[:], b[8:16])
+	return
+}
+
+// setVersion sets RFC 4122 version and variant bits of b
+func setVersion(b []byte, version byte) {
+	b[6] = (b[6] & 0x0f) | version<<4
+	b[8] = (b[8] & 0x3f) | 0x80
+}
+
+// Version returns the RFC 4122 version of the GUID
+func (g GUID) Version() int {
+	return int(g.Data3 >> 12)
+}
+
+// NewGUIDv4 generates a random RFC 4122 version 4 GUID
+func NewGUIDv4() (g GUID, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+
+	setVersion(b, 4)
+	return guidFromBigEndian(b), nil
+}
+
+// NewGUIDv5 generates a RFC 4122 version 5 GUID from a namespace and a name
+func NewGUIDv5(namespace GUID, name []byte) GUID {
+	ns := namespace.bigEndian()
+
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write(name)
+	sum := h.Sum(nil)
+
+	setVersion(sum, 5)
+	return guidFromBigEndian(sum)
+}
@@ -1,7 +1,11 @@
 package etw
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"testing"
 
@@ -36,34 +40,105 @@ func TestGUID(t *testing.T) {
 	tt.Assert(strings.EqualFold(fmt.Sprintf("{%s}", guid), g.String()))
 }
 
-func TestGUIDEquality(t *testing.T) {
+func TestGUIDMarshal(t *testing.T) {
 	t.Parallel()
 
 	tt := toast.FromT(t)
-	p := MustParseProvider("Microsoft-Windows-Kernel-File")
-	g1 := MustParseGUIDFromString(p.GUID)
-	g2 := MustParseGUIDFromString(p.GUID)
-
-	tt.Assert(g1.Equals(g2))
-
-	// testing Data1
-	g2.Data1++
-	tt.Assert(!g1.Equals(g2))
-
-	// testing Data2
-	g2 = MustParseGUIDFromString(p.GUID)
-	g2.Data2++
-	tt.Assert(!g1.Equals(g2))
-
-	// testing Data3
-	g2 = MustParseGUIDFromString(p.GUID)
-	g2.Data3++
-	tt.Assert(!g1.Equals(g2))
-
-	// testing Data4
-	for i := 0; i < 8; i++ {
-		g2 = MustParseGUIDFromString(p.GUID)
-		g2.Data4[i]++
-		tt.Assert(!g1.Equals(g2))
+
+	g := *MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")
+
+	// text and JSON
+	b, err := json.Marshal(g)
+	tt.CheckErr(err)
+	tt.Assert(string(b) == `"{EDD08927-9CC4-4E65-B970-C2560FB5C289}"`, string(b))
+
+	var u GUID
+	tt.CheckErr(json.Unmarshal([]byte(`"edd08927-9cc4-4e65-b970-c2560fb5c289"`), &u))
+	tt.Assert(u == g)
+	tt.Assert(json.Unmarshal([]byte(`"not a guid"`), &u) != nil)
+	tt.CheckErr(u.UnmarshalText(nil))
+	tt.Assert(u.IsZero())
+
+	// GUIDs can be used as JSON map keys
+	m := map[GUID]int{g: 42}
+	b, err = json.Marshal(m)
+	tt.CheckErr(err)
+	tt.Assert(string(b) == `{"{EDD08927-9CC4-4E65-B970-C2560FB5C289}":42}`, string(b))
+
+	// binary is the in memory Windows layout
+	b, err = g.MarshalBinary()
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(b, []byte{
+		0x27, 0x89, 0xD0, 0xED, 0xC4, 0x9C, 0x65, 0x4E,
+		0xB9, 0x70, 0xC2, 0x56, 0x0F, 0xB5, 0xC2, 0x89}), b)
+
+	u = GUID{}
+	tt.CheckErr(u.UnmarshalBinary(b))
+	tt.Assert(u == g)
+	tt.Assert(errors.Is(u.UnmarshalBinary(b[:15]), ErrShortBuffer))
+
+	// events are serialized with GUID strings
+	e := NewEvent()
+	e.System.Provider.Guid = g
+	b, err = json.Marshal(e)
+	tt.CheckErr(err)
+	tt.Assert(bytes.Contains(b, []byte(`"Guid":"{EDD08927-9CC4-4E65-B970-C2560FB5C289}"`)))
+	tt.Assert(bytes.Contains(b, []byte(`"ActivityID":"{00000000-0000-0000-0000-000000000000}"`)))
+
+	d := NewEvent()
+	tt.CheckErr(json.Unmarshal(b, d))
+	tt.Assert(d.System.Provider.Guid == g)
+}
+
+func TestGUIDCompare(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	strs := []string{
+		"{EDD08927-9CC4-4E65-B970-C2560FB5C289}",
+		"{00000000-0000-0000-0000-000000000001}",
+		"{EDD08927-9CC4-4E65-B970-C2560FB5C288}",
+		"{EDD08927-9CC5-4E65-B970-C2560FB5C289}",
+		"{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}",
+		"{00000000-0000-0000-0000-000000000000}",
+	}
+
+	guids := make([]GUID, len(strs))
+	for i, s := range strs {
+		guids[i] = *MustParseGUIDFromString(s)
+	}
+
+	sort.Strings(strs)
+	sort.Slice(guids, func(i, j int) bool { return guids[i].Compare(guids[j]) < 0 })
+
+	for i := range strs {
+		tt.Assert(guids[i].String() == strs[i], i, guids[i].String())
+	}
+
+	tt.Assert(guids[0].Compare(guids[0]) == 0)
+	tt.Assert(guids[1].Compare(guids[0]) == 1)
+}
+
+func TestGUIDGeneration(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	seen := make(map[GUID]bool)
+	for i := 0; i < 100; i++ {
+		g, err := NewGUIDv4()
+		tt.CheckErr(err)
+		tt.Assert(g.Version() == 4)
+		// RFC 4122 variant
+		tt.Assert(g.Data4[0]&0xc0 == 0x80)
+		tt.Assert(!seen[g])
+		seen[g] = true
 	}
+
+	// DNS namespace
+	ns := *MustParseGUIDFromString("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	g := NewGUIDv5(ns, []byte("www.example.com"))
+	tt.Assert(g.String() == "{2ED6657D-E927-568B-95E1-2665A8AEA6A2}", g.String())
+	tt.Assert(g.Version() == 5)
 }
@@ -0,0 +1,211 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// PayloadOperator is a PAYLOAD_OPERATOR used to compare an
+// event property with a value
+type PayloadOperator uint16
+
+const (
+	PAYLOADFIELD_EQ            = PayloadOperator(0)
+	PAYLOADFIELD_NE            = PayloadOperator(1)
+	PAYLOADFIELD_LE            = PayloadOperator(2)
+	PAYLOADFIELD_GT            = PayloadOperator(3)
+	PAYLOADFIELD_LT            = PayloadOperator(4)
+	PAYLOADFIELD_GE            = PayloadOperator(5)
+	PAYLOADFIELD_BETWEEN       = PayloadOperator(6)
+	PAYLOADFIELD_NOTBETWEEN    = PayloadOperator(7)
+	PAYLOADFIELD_MODULO        = PayloadOperator(8)
+	PAYLOADFIELD_CONTAINS      = PayloadOperator(20)
+	PAYLOADFIELD_DOESNTCONTAIN = PayloadOperator(21)
+	PAYLOADFIELD_IS            = PayloadOperator(30)
+	PAYLOADFIELD_ISNOT         = PayloadOperator(31)
+	PAYLOADFIELD_INVALID       = PayloadOperator(32)
+)
+
+const (
+	MAX_PAYLOAD_PREDICATES = 8
+)
+
+var (
+	ErrPayloadFilter = fmt.Errorf("bad payload filter")
+
+	payloadOperatorNames = map[PayloadOperator]string{
+		PAYLOADFIELD_EQ:            "eq",
+		PAYLOADFIELD_NE:            "ne",
+		PAYLOADFIELD_LE:            "le",
+		PAYLOADFIELD_GT:            "gt",
+		PAYLOADFIELD_LT:            "lt",
+		PAYLOADFIELD_GE:            "ge",
+		PAYLOADFIELD_BETWEEN:       "between",
+		PAYLOADFIELD_NOTBETWEEN:    "notbetween",
+		PAYLOADFIELD_MODULO:        "modulo",
+		PAYLOADFIELD_CONTAINS:      "contains",
+		PAYLOADFIELD_DOESNTCONTAIN: "doesntcontain",
+		PAYLOADFIELD_IS:            "is",
+		PAYLOADFIELD_ISNOT:         "isnot",
+	}
+)
+
+// ParsePayloadOperator parses an operator name such as eq, contains or between
+func ParsePayloadOperator(s string) (PayloadOperator, error) {
+	for op, name := range payloadOperatorNames {
+		if strings.EqualFold(s, name) {
+			return op, nil
+		}
+	}
+	return PAYLOADFIELD_INVALID, fmt.Errorf("%w: unknown operator %q", ErrPayloadFilter, s)
+}
+
+func (o PayloadOperator) String() string {
+	if name, ok := payloadOperatorNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("PayloadOperator(%d)", uint16(o))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (o PayloadOperator) MarshalText() ([]byte, error) {
+	if _, ok := payloadOperatorNames[o]; !ok {
+		return nil, fmt.Errorf("%w: unknown operator %d", ErrPayloadFilter, uint16(o))
+	}
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (o *PayloadOperator) UnmarshalText(text []byte) (err error) {
+	*o, err = ParsePayloadOperator(string(text))
+	return
+}
+
+// PayloadPredicate compares an event property with a value. Values are
+// always strings, ranges of BETWEEN and NOTBETWEEN operators are
+// given as two comma separated values.
+type PayloadPredicate struct {
+	Field string
+	Op    PayloadOperator
+	Value string
+}
+
+// Validate checks the predicate is well formed
+func (p *PayloadPredicate) Validate() error {
+	if p.Field == "" {
+		return fmt.Errorf("%w: empty field name", ErrPayloadFilter)
+	}
+
+	if _, ok := payloadOperatorNames[p.Op]; !ok {
+		return fmt.Errorf("%w: unknown operator %d", ErrPayloadFilter, uint16(p.Op))
+	}
+
+	if p.Op == PAYLOADFIELD_BETWEEN || p.Op == PAYLOADFIELD_NOTBETWEEN {
+		if len(strings.Split(p.Value, ",")) != 2 {
+			return fmt.Errorf("%w: %s expects two comma separated values, got %q", ErrPayloadFilter, p.Op, p.Value)
+		}
+	}
+
+	return nil
+}
+
+// PayloadFilter is a group of at most MAX_PAYLOAD_PREDICATES predicates
+// applied by ETW to the payload of an event. Predicates are ANDed unless
+// MatchAny is true, in which case they are ORed.
+type PayloadFilter struct {
+	EventID    uint16
+	Version    uint8
+	MatchAny   bool `json:",omitempty"`
+	Predicates []PayloadPredicate
+}
+
+// Validate checks the filter is well formed
+func (f *PayloadFilter) Validate() error {
+	if len(f.Predicates) == 0 || len(f.Predicates) > MAX_PAYLOAD_PREDICATES {
+		return fmt.Errorf("%w: event %d: expecting 1 to %d predicates, got %d", ErrPayloadFilter, f.EventID, MAX_PAYLOAD_PREDICATES, len(f.Predicates))
+	}
+
+	for i := range f.Predicates {
+		if err := f.Predicates[i].Validate(); err != nil {
+			return fmt.Errorf("event %d: %w", f.EventID, err)
+		}
+	}
+
+	return nil
+}
+
+// payloadPredicateSize returns the size of PAYLOAD_FILTER_PREDICATE
+//
+//	typedef struct _PAYLOAD_FILTER_PREDICATE {
+//	  LPWSTR FieldName;
+//	  USHORT CompareOp;
+//	  LPWSTR Value;
+//	} PAYLOAD_FILTER_PREDICATE, *PPAYLOAD_FILTER_PREDICATE;
+func payloadPredicateSize(ptrSize int) int {
+	return 3 * ptrSize
+}
+
+func utf16Nul(s string) []uint16 {
+	return append(utf16.Encode([]rune(s)), 0)
+}
+
+// PayloadPredicatesSize returns the size of the buffer needed
+// by EncodePayloadPredicates
+func PayloadPredicatesSize(preds []PayloadPredicate, ptrSize int) (size int) {
+	size = len(preds) * payloadPredicateSize(ptrSize)
+	for _, p := range preds {
+		size += 2 * (len(utf16Nul(p.Field)) + len(utf16Nul(p.Value)))
+	}
+	return
+}
+
+// EncodePayloadPredicates serializes predicates into buf as an array of
+// PAYLOAD_FILTER_PREDICATE structures followed by the NUL terminated
+// UTF-16 strings they point to. Base is the address of buf in memory
+// and ptrSize the size of pointers (4 or 8).
+func EncodePayloadPredicates(buf []byte, base uint64, preds []PayloadPredicate, ptrSize int) error {
+	if ptrSize != 4 && ptrSize != 8 {
+		return fmt.Errorf("%w: %d", ErrBadPointerSize, ptrSize)
+	}
+
+	if len(buf) < PayloadPredicatesSize(preds, ptrSize) {
+		return fmt.Errorf("%w: buffer too small", ErrPayloadFilter)
+	}
+
+	putPtr := func(off int, ptr uint64) {
+		if ptrSize == 8 {
+			binary.LittleEndian.PutUint64(buf[off:], ptr)
+		} else {
+			binary.LittleEndian.PutUint32(buf[off:], uint32(ptr))
+		}
+	}
+
+	putString := func(off int, s string) int {
+		for _, c := range utf16Nul(s) {
+			binary.LittleEndian.PutUint16(buf[off:], c)
+			off += 2
+		}
+		return off
+	}
+
+	strOff := len(preds) * payloadPredicateSize(ptrSize)
+	for i, p := range preds {
+		off := i * payloadPredicateSize(ptrSize)
+
+		putPtr(off, base+uint64(strOff))
+		strOff = putString(strOff, p.Field)
+
+		// CompareOp is followed by padding up to pointer alignment
+		binary.LittleEndian.PutUint16(buf[off+ptrSize:], uint16(p.Op))
+		for j := off + ptrSize + 2; j < off+2*ptrSize; j++ {
+			buf[j] = 0
+		}
+
+		putPtr(off+2*ptrSize, base+uint64(strOff))
+		strOff = putString(strOff, p.Value)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package etw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestEncodePayloadPredicates(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	preds := []PayloadPredicate{
+		{Field: "FileName", Op: PAYLOADFIELD_CONTAINS, Value: `\Temp\`},
+		{Field: "Size", Op: PAYLOADFIELD_BETWEEN, Value: "1,42"},
+	}
+
+	utf16le := func(s string) (b []byte) {
+		for _, c := range utf16Nul(s) {
+			b = append(b, byte(c), byte(c>>8))
+		}
+		return
+	}
+
+	strs := bytes.Join([][]byte{
+		utf16le("FileName"), utf16le(`\Temp\`),
+		utf16le("Size"), utf16le("1,42"),
+	}, nil)
+
+	for _, ptrSize := range []int{4, 8} {
+		base := uint64(0x10000)
+		size := PayloadPredicatesSize(preds, ptrSize)
+		structs := 2 * 3 * ptrSize
+		tt.Assert(size == structs+len(strs))
+
+		buf := bytes.Repeat([]byte{0xff}, size)
+		tt.CheckErr(EncodePayloadPredicates(buf, base, preds, ptrSize))
+
+		ptr := func(off int) uint64 {
+			if ptrSize == 8 {
+				return binary.LittleEndian.Uint64(buf[off:])
+			}
+			return uint64(binary.LittleEndian.Uint32(buf[off:]))
+		}
+
+		// first predicate
+		tt.Assert(ptr(0) == base+uint64(structs))
+		tt.Assert(binary.LittleEndian.Uint16(buf[ptrSize:]) == uint16(PAYLOADFIELD_CONTAINS))
+		// padding is zeroed
+		for _, b := range buf[ptrSize+2 : 2*ptrSize] {
+			tt.Assert(b == 0)
+		}
+		tt.Assert(ptr(2*ptrSize) == base+uint64(structs+len(utf16le("FileName"))))
+
+		// second predicate
+		off := 3 * ptrSize
+		tt.Assert(ptr(off) == base+uint64(structs+len(utf16le("FileName"))+len(utf16le(`\Temp\`))))
+		tt.Assert(binary.LittleEndian.Uint16(buf[off+ptrSize:]) == uint16(PAYLOADFIELD_BETWEEN))
+		tt.Assert(ptr(off+2*ptrSize) == base+uint64(size-len(utf16le("1,42"))))
+
+		// strings follow the structures
+		tt.Assert(bytes.Equal(buf[structs:], strs))
+	}
+
+	// errors
+	tt.Assert(errors.Is(EncodePayloadPredicates(make([]byte, 1), 0, preds, 8), ErrPayloadFilter))
+	tt.Assert(errors.Is(EncodePayloadPredicates(make([]byte, 1024), 0, preds, 2), ErrBadPointerSize))
+}
+
+func TestPayloadFilterValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	f := PayloadFilter{
+		EventID: 12,
+		Predicates: []PayloadPredicate{
+			{Field: "FileName", Op: PAYLOADFIELD_CONTAINS, Value: `\Windows\`},
+			{Field: "CreateOptions", Op: PAYLOADFIELD_NOTBETWEEN, Value: "0,16"},
+		},
+	}
+	tt.CheckErr(f.Validate())
+
+	bad := []PayloadFilter{
+		{EventID: 12},
+		{EventID: 12, Predicates: make([]PayloadPredicate, MAX_PAYLOAD_PREDICATES+1)},
+		{EventID: 12, Predicates: []PayloadPredicate{{Op: PAYLOADFIELD_EQ, Value: "1"}}},
+		{EventID: 12, Predicates: []PayloadPredicate{{Field: "A", Op: PAYLOADFIELD_INVALID}}},
+		{EventID: 12, Predicates: []PayloadPredicate{{Field: "A", Op: PAYLOADFIELD_BETWEEN, Value: "1"}}},
+	}
+
+	for _, f := range bad {
+		tt.Assert(errors.Is(f.Validate(), ErrPayloadFilter), f)
+	}
+}
+
+func TestPayloadFilterJSON(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	p := Provider{
+		GUID: "{EDD08927-9CC4-4E65-B970-C2560FB5C289}",
+		PayloadFilters: []PayloadFilter{
+			{
+				EventID:  12,
+				MatchAny: true,
+				Predicates: []PayloadPredicate{
+					{Field: "FileName", Op: PAYLOADFIELD_CONTAINS, Value: `\Temp\`},
+					{Field: "FileName", Op: PAYLOADFIELD_CONTAINS, Value: `\Downloads\`},
+				},
+			},
+		},
+	}
+
+	b, err := json.Marshal(&p)
+	tt.CheckErr(err)
+	tt.Assert(bytes.Contains(b, []byte(`"Op":"contains"`)), string(b))
+
+	var q Provider
+	tt.CheckErr(json.Unmarshal(b, &q))
+	tt.Assert(len(q.PayloadFilters) == 1)
+	tt.Assert(q.PayloadFilters[0].MatchAny)
+	tt.Assert(q.PayloadFilters[0].Predicates[1] == p.PayloadFilters[0].Predicates[1])
+
+	tt.Assert(json.Unmarshal([]byte(`{"Field":"A","Op":"unknown","Value":"1"}`), &PayloadPredicate{}) != nil)
+
+	op, err := ParsePayloadOperator("NotBetween")
+	tt.CheckErr(err)
+	tt.Assert(op == PAYLOADFIELD_NOTBETWEEN)
+	tt.Assert(PayloadOperator(99).String() == "PayloadOperator(99)")
+}
@@ -1,11 +1,7 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
-	"syscall"
-	"unsafe"
+	"fmt"
 )
 
 const (
@@ -23,20 +19,25 @@ type Session interface {
 }
 
 type RealTimeSession struct {
-	properties    *EventTraceProperties
-	sessionHandle syscall.Handle
+	backend    sessionBackend
+	properties *EventTraceProperties
 
 	traceName string
-	providers []Provider
+	// providers enabled on the session, it holds the session handle
+	providers providerSet
 }
 
 // NewRealTimeSession creates a new ETW session to receive events
 // in real time
 func NewRealTimeSession(name string) (p *RealTimeSession) {
-	p = &RealTimeSession{}
+	return newRealTimeSession(name, defaultBackend)
+}
+
+func newRealTimeSession(name string, backend sessionBackend) (p *RealTimeSession) {
+	p = &RealTimeSession{backend: backend}
 	p.properties = NewRealTimeEventTraceSessionProperties(name)
 	p.traceName = name
-	p.providers = make([]Provider, 0)
+	p.providers.backend = backend
 	return
 }
 
@@ -54,81 +55,81 @@ func NewKernelRealTimeSession(flags ...uint32) (p *RealTimeSession) {
 
 // IsStarted returns true if the session is already started
 func (p *RealTimeSession) IsStarted() bool {
-	return p.sessionHandle != 0
+	p.providers.RLock()
+	defer p.providers.RUnlock()
+
+	return p.providers.handle != 0
 }
 
 // Start starts the session
 func (p *RealTimeSession) Start() (err error) {
-	var u16TraceName *uint16
+	p.providers.Lock()
+	defer p.providers.Unlock()
 
-	if u16TraceName, err = syscall.UTF16PtrFromString(p.traceName); err != nil {
-		return err
-	}
-
-	if !p.IsStarted() {
-		if err = StartTrace(&p.sessionHandle, u16TraceName, p.properties); err != nil {
+	if p.providers.handle == 0 {
+		if p.providers.handle, err = p.backend.startTrace(p.traceName, p.properties); err != nil {
 			// we handle the case where the trace already exists
 			if err == ERROR_ALREADY_EXISTS {
 				// we have to use a copy of properties as ControlTrace modifies
 				// the structure and if we don't do that we cannot StartTrace later
 				prop := *p.properties
 				// we close the trace first
-				ControlTrace(0, u16TraceName, &prop, EVENT_TRACE_CONTROL_STOP)
-				return StartTrace(&p.sessionHandle, u16TraceName, p.properties)
+				p.backend.stopTrace(0, p.traceName, &prop)
+				p.providers.handle, err = p.backend.startTrace(p.traceName, p.properties)
+			}
+			if err != nil {
+				return
 			}
-			return
 		}
 	}
 
 	return
 }
 
-// EnableProvider enables the session to receive events from a given provider
+// EnableProvider enables the session to receive events from a given provider.
+// If the provider is already enabled, its configuration is updated.
 func (p *RealTimeSession) EnableProvider(prov Provider) (err error) {
-	var guid *GUID
-
 	// If the trace is not started yet we have to start it
 	// otherwise we cannot enable provider
-	if !p.IsStarted() {
-		if err = p.Start(); err != nil {
-			return
-		}
-	}
-
-	if guid, err = ParseGUID(prov.GUID); err != nil {
+	if err = p.Start(); err != nil {
 		return
 	}
 
-	params := EnableTraceParameters{
-		Version: 2,
-		// Does not seem to bring valuable information
-		//EnableProperty: EVENT_ENABLE_PROPERTY_PROCESS_START_KEY,
+	return p.providers.enable(prov)
+}
+
+// UpdateProvider updates the level, keywords, properties and filters of a
+// provider enabled on a running session without stopping the trace
+func (p *RealTimeSession) UpdateProvider(prov Provider) error {
+	return p.providers.update(prov)
+}
+
+// DisableProvider disables a provider enabled on a running session without
+// stopping the trace
+func (p *RealTimeSession) DisableProvider(guid string) error {
+	return p.providers.disable(guid)
+}
+
+// EnableKernelStackTracing enables stack collection for the given kernel
+// events. It only applies to NT Kernel Logger sessions, other providers
+// select the events stacks are collected for with Provider.StackWalk.
+func (p *RealTimeSession) EnableKernelStackTracing(ids ...ClassicEventID) (err error) {
+	if len(ids) == 0 {
+		return
 	}
 
-	if len(prov.Filter) > 0 {
-		fds := prov.BuildFilterDesc()
-		if len(fds) > 0 {
-			params.EnableFilterDesc = (*EventFilterDescriptor)(unsafe.Pointer(&fds[0]))
-			params.FilterDescCount = uint32(len(fds))
-		}
+	if len(ids) > MAX_STACK_TRACING_EVENTS {
+		return fmt.Errorf("too many stack tracing events %d > %d", len(ids), MAX_STACK_TRACING_EVENTS)
 	}
 
-	if err = EnableTraceEx2(
-		p.sessionHandle,
-		guid,
-		EVENT_CONTROL_CODE_ENABLE_PROVIDER,
-		prov.EnableLevel,
-		prov.MatchAnyKeyword,
-		prov.MatchAllKeyword,
-		0,
-		&params,
-	); err != nil {
+	if err = p.Start(); err != nil {
 		return
 	}
 
-	p.providers = append(p.providers, prov)
+	p.providers.RLock()
+	defer p.providers.RUnlock()
 
-	return
+	return p.backend.enableStackTracing(p.providers.handle, ids)
 }
 
 // TraceName implements Session interface
@@ -138,10 +139,26 @@ func (p *RealTimeSession) TraceName() string {
 
 // Providers implements Session interface
 func (p *RealTimeSession) Providers() []Provider {
-	return p.providers
+	return p.providers.list()
+}
+
+// watchProviders implements providerNotifier
+func (p *RealTimeSession) watchProviders(w providerWatcher) {
+	p.providers.watch(w)
+}
+
+// unwatchProviders implements providerNotifier
+func (p *RealTimeSession) unwatchProviders(w providerWatcher) {
+	p.providers.unwatch(w)
 }
 
 // Stop stops the session
-func (p *RealTimeSession) Stop() error {
-	return ControlTrace(p.sessionHandle, nil, p.properties, EVENT_TRACE_CONTROL_STOP)
+func (p *RealTimeSession) Stop() (err error) {
+	p.providers.Lock()
+	defer p.providers.Unlock()
+
+	if err = p.backend.stopTrace(p.providers.handle, "", p.properties); err == nil {
+		p.providers.handle = 0
+	}
+	return
 }
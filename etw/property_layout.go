@@ -0,0 +1,68 @@
+package etw
+
+import (
+	"encoding/binary"
+)
+
+// propertyValue is the value of an integer property, it might give the
+// length or the number of elements of another property
+type propertyValue struct {
+	value uint32
+	set   bool
+}
+
+// integerValue returns the value of a fixed size integer property found at
+// the beginning of data. Like with TdhGetProperty used to read lengths and
+// counts, only the lowest 32 bits of 64 bits integers are kept.
+func integerValue(in TdhInType, data []byte) (uint32, bool) {
+	switch in {
+	case TdhInTypeInt8, TdhInTypeUint8:
+		if len(data) >= 1 {
+			return uint32(data[0]), true
+		}
+	case TdhInTypeInt16, TdhInTypeUint16:
+		if len(data) >= 2 {
+			return uint32(binary.LittleEndian.Uint16(data)), true
+		}
+	case TdhInTypeInt32, TdhInTypeUint32, TdhInTypeHexint32,
+		TdhInTypeInt64, TdhInTypeUint64, TdhInTypeHexint64:
+		if len(data) >= 4 {
+			return binary.LittleEndian.Uint32(data), true
+		}
+	}
+	return 0, false
+}
+
+// localPropertySize computes the size of an element of property p found at
+// the beginning of data, length being the length of the property either
+// found in its schema or in the property it references. It returns false
+// if the layout of the property is unknown and must be given by TDH. A
+// length of 0 read from the referenced property means an empty property,
+// not one of implicit length.
+func localPropertySize(d *PropertyDecoder, p *PropertyInfo, length uint32, data []byte) (size uint32, ok bool, err error) {
+	var n int
+
+	switch {
+	case p.IsStruct():
+		return
+	case p.InType == TdhInTypeNull:
+		return
+	case p.Flags&PropertyParamLength == PropertyParamLength && length == 0:
+		return 0, true, nil
+	case p.InType == TdhInTypeBinary && length == 0 && p.OutType != TdhOutTypeIpv6:
+		// binary data of implicit length
+		return
+	}
+
+	if n, err = d.size(data, p.InType, p.OutType, int(length)); err == ErrUnknownInType {
+		return 0, false, nil
+	}
+
+	return uint32(n), true, err
+}
+
+// remainingUserData returns the user data not consumed by the properties
+// already prepared
+func (e *EventRecordHelper) remainingUserData() []byte {
+	return e.userData[e.userDataOffset:]
+}
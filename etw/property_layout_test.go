@@ -0,0 +1,321 @@
+package etw
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+func kernelFileCreateData(pointerSize int) (data []byte) {
+	ptr := make([]byte, pointerSize)
+	// Irp and FileObject
+	data = append(data, ptr...)
+	data = append(data, ptr...)
+	// IssuingThreadId, CreateOptions, CreateAttributes and ShareAccess
+	data = append(data, le32(4242)...)
+	data = append(data, le32(0x01000060)...)
+	data = append(data, le32(0)...)
+	data = append(data, le32(7)...)
+	return append(data, utf16le(`\Device\HarddiskVolume2\Windows\notepad.exe`, true)...)
+}
+
+func structArrayData() (data []byte) {
+	// EntryCount
+	data = append(data, le16(2)...)
+	// Entries made of ProcessId and Status
+	for _, pid := range []uint32{4, 1337} {
+		data = append(data, le32(pid)...)
+		data = append(data, le32(0)...)
+	}
+	// DataSize and Data
+	data = append(data, le32(3)...)
+	return append(data, 0xaa, 0xbb, 0xcc)
+}
+
+// testProperty describes a property of the event information built by
+// eventInformation
+type testProperty struct {
+	name   string
+	in     TdhInType
+	out    TdhOutType
+	flags  PropertyFlags
+	count  uint16
+	length uint16
+}
+
+// eventInformation builds the event information of a manifest event of
+// the fake provider made of top level properties
+func eventInformation(props ...testProperty) []byte {
+	size := unsafe.Sizeof(TraceEventInfo{}) + uintptr(len(props)-1)*unsafe.Sizeof(EventPropertyInfo{})
+	strs := utf16.Encode([]rune(fakeProviderName + "\x00"))
+	offsets := make([]uint32, len(props))
+	for i, p := range props {
+		offsets[i] = uint32(size) + uint32(len(strs)*2)
+		strs = append(strs, utf16.Encode([]rune(p.name+"\x00"))...)
+	}
+	total := size + uintptr(len(strs))*2
+
+	// uint64 slice guarantees structure alignment
+	buf := make([]uint64, (total+7)/8)
+	tei := (*TraceEventInfo)(unsafe.Pointer(&buf[0]))
+	copy(unsafe.Slice((*uint16)(unsafe.Add(unsafe.Pointer(tei), size)), len(strs)), strs)
+
+	tei.ProviderGUID = *fakeProviderGUID
+	tei.DecodingSource = DecodingSourceXMLFile
+	tei.ProviderNameOffset = uint32(size)
+	tei.PropertyCount = uint32(len(props))
+	tei.TopLevelPropertyCount = uint32(len(props))
+
+	epis := unsafe.Slice(&tei.EventPropertyInfoArray[0], len(props))
+	for i, p := range props {
+		epis[i].Flags = p.flags
+		epis[i].NameOffset = offsets[i]
+		epis[i].TypeUnion.u1 = uint16(p.in)
+		epis[i].TypeUnion.u2 = uint16(p.out)
+		epis[i].CountUnion = p.count
+		epis[i].LengthUnion = p.length
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(tei)), total)
+}
+
+// paramLengthInfo builds the event information of an event made of a string
+// whose length is given by the property preceding it, followed by an integer
+func paramLengthInfo() []byte {
+	return eventInformation(
+		testProperty{"NameLength", TdhInTypeUint16, TdhOutTypeNull, 0, 1, 2},
+		// length is the index of NameLength
+		testProperty{"Name", TdhInTypeUnicodestring, TdhOutTypeString, PropertyParamLength, 1, 0},
+		testProperty{"Value", TdhInTypeUint32, TdhOutTypeUnsignedint, 0, 1, 4},
+	)
+}
+
+// unknownInTypes returns a copy of info in which the in type of all the
+// properties of type in is replaced by a type of unknown layout
+func unknownInTypes(tb testing.TB, info []byte, in TdhInType) []byte {
+	schema, err := ParseTraceEventInfo(info)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	info = append([]byte(nil), info...)
+	for i, p := range schema.Properties {
+		if p.InType == in && !p.IsStruct() {
+			binary.LittleEndian.PutUint16(info[traceEventInfoHeaderSize+i*eventPropertyInfoSize+8:], 4242)
+		}
+	}
+	return info
+}
+
+func TestPropertyLayout(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	decode := func(h *EventRecordHelper, name string) interface{} {
+		p, ok := h.Properties[name]
+		tt.Assert(ok, name)
+		v, err := p.Decode()
+		tt.CheckErr(err)
+		return v
+	}
+
+	b := newFakeBackendWithInfo(readTestData(t, "kernel-file-create.tei.bin"))
+	for _, c := range []struct {
+		flags       uint16
+		pointerSize int
+	}{
+		{EVENT_HEADER_FLAG_64_BIT_HEADER, 8},
+		{EVENT_HEADER_FLAG_32_BIT_HEADER, 4},
+	} {
+		data := kernelFileCreateData(c.pointerSize)
+		h, err := b.prepare(b.record(data, len(data), c.flags), nil)
+		tt.CheckErr(err)
+		tt.Assert(decode(h, "IssuingThreadId") == uint32(4242))
+		tt.Assert(decode(h, "FileName") == `\Device\HarddiskVolume2\Windows\notepad.exe`)
+		tt.Assert(h.userDataOffset == len(h.userData))
+	}
+	tt.Assert(b.tdhCalls == 0)
+
+	// counts and lengths given by other properties
+	b = newFakeBackendWithInfo(readTestData(t, "struct-array.tei.bin"))
+	data := structArrayData()
+	h, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+	tt.CheckErr(err)
+	tt.Assert(len(h.Structures) == 2)
+	v, err := h.Structures[1]["ProcessId"].Decode()
+	tt.CheckErr(err)
+	tt.Assert(v == uint32(1337))
+	tt.Assert(string(decode(h, "Data").([]byte)) == "\xaa\xbb\xcc")
+	tt.Assert(h.userDataOffset == len(h.userData))
+	tt.Assert(b.tdhCalls == 0)
+}
+
+func TestPropertyLayoutParamLength(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackendWithInfo(paramLengthInfo())
+	for _, name := range []string{"", "notepad.exe"} {
+		data := le16(uint16(len(name)))
+		data = append(data, utf16le(name, false)...)
+		data = append(data, le32(4242)...)
+
+		// a length of 0 must not be taken as an implicit length
+		h, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+		tt.CheckErr(err)
+		v, err := h.Properties["Name"].Decode()
+		tt.CheckErr(err)
+		tt.Assert(v == name)
+		v, err = h.Properties["Value"].Decode()
+		tt.CheckErr(err)
+		tt.Assert(v == uint32(4242))
+		tt.Assert(h.userDataOffset == len(h.userData))
+	}
+	tt.Assert(b.tdhCalls == 0)
+}
+
+func TestPropertyLayoutEventData(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// an array of integers whose count is given by the property preceding it
+	b := newFakeBackendWithInfo(eventInformation(
+		testProperty{"Count", TdhInTypeUint16, TdhOutTypeNull, 0, 1, 2},
+		testProperty{"Pids", TdhInTypeUint32, TdhOutTypeUnsignedint, PropertyParamCount, 0, 4},
+	))
+	data := le16(3)
+	for _, pid := range []uint32{4, 1337, 4242} {
+		data = append(data, le32(pid)...)
+	}
+
+	for _, typed := range []bool{false, true} {
+		h, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+		tt.CheckErr(err)
+		h.typed = typed
+		e, err := h.buildEvent()
+		tt.CheckErr(err)
+
+		if typed {
+			tt.Assert(reflect.DeepEqual(e.EventData["Pids"], []interface{}{uint32(4), uint32(1337), uint32(4242)}), e.EventData["Pids"])
+		} else {
+			tt.Assert(reflect.DeepEqual(e.EventData["Pids"], []string{"4", "1337", "4242"}), e.EventData["Pids"])
+		}
+	}
+
+	// structures
+	b = newFakeBackendWithInfo(readTestData(t, "struct-array.tei.bin"))
+	data = structArrayData()
+	for _, typed := range []bool{false, true} {
+		h, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+		tt.CheckErr(err)
+		h.typed = typed
+		e, err := h.buildEvent()
+		tt.CheckErr(err)
+
+		if typed {
+			structs := e.EventData[StructurePropertyName].([]map[string]interface{})
+			tt.Assert(len(structs) == 2)
+			tt.Assert(structs[1]["ProcessId"] == uint32(1337))
+			tt.Assert(string(e.EventData["Data"].([]byte)) == "\xaa\xbb\xcc")
+		} else {
+			structs := e.EventData[StructurePropertyName].([]map[string]string)
+			tt.Assert(len(structs) == 2)
+			tt.Assert(structs[1]["ProcessId"] == "1337")
+		}
+	}
+}
+
+func TestPropertyLayoutTruncated(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	for _, c := range []struct {
+		fixture string
+		data    []byte
+	}{
+		{"kernel-file-create.tei.bin", kernelFileCreateData(8)},
+		{"struct-array.tei.bin", structArrayData()},
+	} {
+		b := newFakeBackendWithInfo(readTestData(t, c.fixture))
+		// FileName is not terminated or empty past its start
+		end := len(c.data)
+		if c.fixture == "kernel-file-create.tei.bin" {
+			end = 32
+		}
+
+		for n := 0; n < end; n++ {
+			_, err := b.prepare(b.record(c.data, n, EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+			tt.Assert(errors.Is(err, ErrPropertyParsing), c.fixture, n)
+		}
+		tt.Assert(b.tdhCalls == 0)
+	}
+
+	// lengths beyond user data
+	b := newFakeBackendWithInfo(readTestData(t, "struct-array.tei.bin"))
+	data := structArrayData()
+	binary.LittleEndian.PutUint32(data[len(data)-7:], 4)
+	_, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+	tt.Assert(errors.Is(err, ErrPropertyParsing))
+}
+
+func TestPropertyLayoutFallback(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	info := readTestData(t, "kernel-file-create.tei.bin")
+	// CreateOptions has an in type of unknown layout
+	binary.LittleEndian.PutUint16(info[traceEventInfoHeaderSize+3*eventPropertyInfoSize+8:], 4242)
+
+	b := newFakeBackendWithInfo(info)
+	data := kernelFileCreateData(8)
+	h, err := b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+	tt.CheckErr(err)
+	tt.Assert(b.tdhCalls == 1)
+	tt.Assert(h.userDataOffset == len(h.userData))
+
+	b.sizeErr = errors.New("size error")
+	_, err = b.prepare(b.record(data, len(data), EVENT_HEADER_FLAG_64_BIT_HEADER), nil)
+	tt.Assert(errors.Is(err, b.sizeErr))
+}
+
+func BenchmarkPrepareProperties(b *testing.B) {
+	for _, c := range []struct {
+		name    string
+		fixture string
+		data    []byte
+		// in type whose size is given by TDH
+		tdh TdhInType
+	}{
+		{"KernelFileCreate", "kernel-file-create.tei.bin", kernelFileCreateData(8), TdhInTypeNull},
+		// baseline where the size of the integers is given by TDH
+		{"KernelFileCreateTDH", "kernel-file-create.tei.bin", kernelFileCreateData(8), TdhInTypeUint32},
+		{"StructArray", "struct-array.tei.bin", structArrayData(), TdhInTypeNull},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			backend := newFakeBackendWithInfo(unknownInTypes(b, readTestData(b, c.fixture), c.tdh))
+			cache := NewSchemaCache(0)
+			er := backend.record(c.data, len(c.data), EVENT_HEADER_FLAG_64_BIT_HEADER)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := backend.prepare(er, cache); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(backend.tdhCalls)/float64(b.N), "tdh-calls/op")
+		})
+	}
+}
@@ -5,142 +5,115 @@ package etw
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
+	"runtime"
 	"unsafe"
 )
 
-var (
-	providers ProviderMap
-
-	DefaultProvider = Provider{EnableLevel: 0xff}
+func init() {
+	DefaultProviderRegistry.SetSource(TdhProviderSource{})
+	DefaultProviderRegistry.Lookup = TdhFieldLookup{}
+}
 
-	// Error returned when a provider is not found on the system
-	ErrUnkownProvider = fmt.Errorf("unknown provider")
-)
+// BuildPayloadFilterDesc creates and aggregates the payload filters of the
+// provider into a single filter descriptor. The cleanup function must be
+// called once the descriptor is not used anymore.
+func (p *Provider) BuildPayloadFilterDesc() (fd EventFilterDescriptor, cleanup func(), err error) {
+	var guid *GUID
 
-type ProviderMap map[string]*Provider
+	handles := make([]uintptr, 0, len(p.PayloadFilters))
+	matchAll := make([]uint8, 0, len(p.PayloadFilters))
 
-type Provider struct {
-	GUID            string
-	Name            string
-	EnableLevel     uint8
-	MatchAnyKeyword uint64
-	MatchAllKeyword uint64
-	Filter          []uint16
-}
+	cleanup = func() {
+		if fd.Ptr != 0 {
+			TdhCleanupPayloadEventFilterDescriptor(&fd)
+		}
+		for i := range handles {
+			TdhDeletePayloadFilter(&handles[i])
+		}
+	}
 
-// IsZero returns true if the provider is empty
-func (p *Provider) IsZero() bool {
-	return p.GUID == ""
-}
+	defer func() {
+		if err != nil {
+			cleanup()
+			cleanup = nil
+		}
+	}()
 
-func (p *Provider) eventIDFilterDescriptor() (d EventFilterDescriptor) {
+	if guid, err = ParseGUID(p.GUID); err != nil {
+		return
+	}
 
-	efeid := AllocEventFilterEventID(p.Filter)
-	efeid.FilterIn = 0x1
+	for i := range p.PayloadFilters {
+		var handle uintptr
 
-	d = EventFilterDescriptor{
-		Ptr:  uint64(uintptr(unsafe.Pointer(efeid))),
-		Size: uint32(efeid.Size()),
-		Type: EVENT_FILTER_TYPE_EVENT_ID,
-	}
+		f := &p.PayloadFilters[i]
+		if err = f.Validate(); err != nil {
+			return
+		}
 
-	return
-}
+		ptrSize := int(unsafe.Sizeof(uintptr(0)))
+		buf := make([]byte, PayloadPredicatesSize(f.Predicates, ptrSize))
+		if err = EncodePayloadPredicates(buf, uint64(uintptr(unsafe.Pointer(&buf[0]))), f.Predicates, ptrSize); err != nil {
+			return
+		}
 
-func (p *Provider) BuildFilterDesc() (fd []EventFilterDescriptor) {
+		desc := EventDescriptor{Id: f.EventID, Version: f.Version}
+		err = TdhCreatePayloadFilter(guid, &desc, f.MatchAny, uint32(len(f.Predicates)), &buf[0], &handle)
+		runtime.KeepAlive(buf)
+		if err != nil {
+			err = fmt.Errorf("failed to create payload filter for event %d: %w", f.EventID, err)
+			return
+		}
 
-	fd = append(fd, p.eventIDFilterDescriptor())
+		handles = append(handles, handle)
+		if f.MatchAny {
+			matchAll = append(matchAll, 0)
+		} else {
+			matchAll = append(matchAll, 1)
+		}
+	}
 
-	return
-}
+	if len(handles) == 0 {
+		return
+	}
 
-// MustParseProvider parses a provider string or panic
-func MustParseProvider(s string) (p Provider) {
-	var err error
-	if p, err = ParseProvider(s); err != nil {
-		panic(err)
+	if err = TdhAggregatePayloadFilters(uint32(len(handles)), &handles[0], &matchAll[0], &fd); err != nil {
+		err = fmt.Errorf("failed to aggregate payload filters: %w", err)
 	}
+
 	return
 }
 
-// IsKnownProvider returns true if the provider is known
-func IsKnownProvider(p string) bool {
-	prov := ResolveProvider(p)
-	return !prov.IsZero()
-}
+// TdhFieldLookup is a ProviderFieldLookup using
+// TdhEnumerateProviderFieldInformation
+type TdhFieldLookup struct{}
 
-// ParseProvider parses a string and returns a provider.
-// The returned provider is initialized from DefaultProvider.
-// Format (Name|GUID) string:EnableLevel uint8:Event IDs comma sep string:MatchAnyKeyword uint16:MatchAllKeyword uint16
-// Example: Microsoft-Windows-Kernel-File:0xff:13,14:0x80
-func ParseProvider(s string) (p Provider, err error) {
-	var u uint64
-
-	split := strings.Split(s, ":")
-	for i := 0; i < len(split); i++ {
-		chunk := split[i]
-		switch i {
-		case 0:
-			p = ResolveProvider(chunk)
-			if p.IsZero() {
-				err = fmt.Errorf("%w %s", ErrUnkownProvider, chunk)
-				return
-			}
-		case 1:
-			if chunk == "" {
-				break
-			}
-			// parsing EnableLevel
-			if u, err = strconv.ParseUint(chunk, 0, 8); err != nil {
-				err = fmt.Errorf("failed to parse EnableLevel: %w", err)
-				return
-			} else {
-				p.EnableLevel = uint8(u)
-			}
-		case 2:
-			if chunk == "" {
-				break
-			}
-			// parsing event ids
-			for _, eid := range strings.Split(chunk, ",") {
-				if u, err = strconv.ParseUint(eid, 0, 16); err != nil {
-					err = fmt.Errorf("failed to parse EventID: %w", err)
-					return
-				} else {
-					p.Filter = append(p.Filter, uint16(u))
-				}
-			}
-		case 3:
-			if chunk == "" {
-				break
-			}
+// ProviderFields implements ProviderFieldLookup
+func (TdhFieldLookup) ProviderFields(guid string, typ EventFieldType) ([]ProviderField, error) {
+	var g *GUID
+	var err error
 
-			// parsing MatchAnyKeyword
-			if u, err = strconv.ParseUint(chunk, 0, 64); err != nil {
-				err = fmt.Errorf("failed to parse MatchAnyKeyword: %w", err)
-				return
-			} else {
-				p.MatchAnyKeyword = u
-			}
-		case 4:
-			if chunk == "" {
-				break
-			}
+	if g, err = ParseGUID(guid); err != nil {
+		return nil, err
+	}
 
-			// parsing MatchAllKeyword
-			if u, err = strconv.ParseUint(chunk, 0, 64); err != nil {
-				err = fmt.Errorf("failed to parse MatchAllKeyword: %w", err)
-				return
-			} else {
-				p.MatchAllKeyword = u
-			}
+	size := uint32(unsafe.Sizeof(ProviderFieldInfoArray{}))
+	for {
+		buf := make([]byte, size)
+		err = TdhEnumerateProviderFieldInformation(g, int(typ), (*ProviderFieldInfoArray)(unsafe.Pointer(&buf[0])), &size)
+
+		switch err {
+		case nil:
+			return DecodeProviderFieldInfoArray(buf)
+		case ERROR_INSUFFICIENT_BUFFER:
+			continue
+		case ERROR_NOT_FOUND:
+			// provider does not declare any field of this type
+			return nil, nil
 		default:
-			return
+			return nil, err
 		}
 	}
-	return
 }
 
 // EnumerateProviders returns a ProviderMap containing available providers
@@ -156,12 +129,11 @@ func EnumerateProviders() (m ProviderMap) {
 		}
 	}
 	m = make(ProviderMap)
-	startProvEnumInfo := uintptr(unsafe.Pointer(buf))
-	it := uintptr(unsafe.Pointer(&buf.TraceProviderInfoArray[0]))
-	for i := uintptr(0); i < uintptr(buf.NumberOfProviders); i++ {
-		ptpi := (*TraceProviderInfo)(unsafe.Pointer(it + i*unsafe.Sizeof(buf.TraceProviderInfoArray[0])))
+	infos := unsafe.Slice(&buf.TraceProviderInfoArray[0], buf.NumberOfProviders)
+	for i := range infos {
+		ptpi := &infos[i]
 		guid := ptpi.ProviderGuid.String()
-		name := UTF16AtOffsetToString(startProvEnumInfo, uintptr(ptpi.ProviderNameOffset))
+		name := UTF16AtOffsetToString(unsafe.Pointer(buf), uintptr(ptpi.ProviderNameOffset))
 		// We use a default provider here
 		p := DefaultProvider
 		p.GUID = guid
@@ -172,21 +144,36 @@ func EnumerateProviders() (m ProviderMap) {
 	return
 }
 
-// ResolveProvider return a Provider structure given a GUID or
-// a provider name as input
-func ResolveProvider(s string) (p Provider) {
+// TdhProviderSource is a ProviderSource listing the providers registered
+// on the system
+type TdhProviderSource struct {
+	// Metadata makes Providers also retrieve the metadata of providers
+	// declaring a manifest, which is slow
+	Metadata bool
+}
+
+// Providers implements ProviderSource
+func (s TdhProviderSource) Providers() (infos []*ProviderInfo, err error) {
+	m := EnumerateProviders()
 
-	if providers == nil {
-		providers = EnumerateProviders()
-	}
+	infos = make([]*ProviderInfo, 0, len(m)/2)
+	for key, p := range m {
+		// ProviderMap is indexed by both name and GUID
+		if key != p.GUID {
+			continue
+		}
 
-	if g, err := ParseGUID(s); err == nil {
-		s = g.String()
-	}
+		info := &ProviderInfo{GUID: p.GUID, Name: p.Name}
+		if s.Metadata {
+			// providers without manifest (MOF, WPP ...) only come
+			// with their name and GUID
+			if meta, err := ProviderMetadata(p.GUID); err == nil {
+				meta.Name = p.Name
+				info = meta
+			}
+		}
 
-	if prov, ok := providers[s]; ok {
-		// search provider by name
-		return *prov
+		infos = append(infos, info)
 	}
 
 	return
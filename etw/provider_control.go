@@ -0,0 +1,165 @@
+package etw
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrProviderNotEnabled = fmt.Errorf("provider not enabled")
+)
+
+// sameGUID returns true if two GUID strings designate the same GUID
+// whatever their case and format
+func sameGUID(a, b string) bool {
+	ga, erra := ParseGUID(a)
+	gb, errb := ParseGUID(b)
+	if erra != nil || errb != nil {
+		return a == b
+	}
+	return ga.Equals(gb)
+}
+
+// providerWatcher is notified of the providers enabled, updated and
+// disabled on a session
+type providerWatcher interface {
+	UpdateProvider(p Provider)
+	RemoveProvider(guid string)
+}
+
+// providerNotifier is implemented by the sessions notifying watchers of the
+// changes made to their providers
+type providerNotifier interface {
+	watchProviders(w providerWatcher)
+	unwatchProviders(w providerWatcher)
+}
+
+// providerSet tracks the providers enabled on a session through the backend
+// of the session. It is safe to use concurrently, its lock guarding the
+// handle of the session too. Watchers are notified of the changes made to
+// the set while the set is locked, so that they are notified in order.
+type providerSet struct {
+	sync.RWMutex
+	backend sessionBackend
+	// handle of the session, zero when it is not started
+	handle    uint64
+	providers []Provider
+	watchers  []providerWatcher
+}
+
+func (s *providerSet) index(guid string) int {
+	for i := range s.providers {
+		if sameGUID(s.providers[i].GUID, guid) {
+			return i
+		}
+	}
+	return -1
+}
+
+// enable enables a provider or updates its configuration (level, keywords,
+// filters ...) if it is already enabled
+func (s *providerSet) enable(p Provider) (err error) {
+	s.Lock()
+
+	if err = s.backend.enableProvider(s.handle, &p); err != nil {
+		s.Unlock()
+		return
+	}
+
+	if i := s.index(p.GUID); i >= 0 {
+		s.providers[i] = p
+	} else {
+		s.providers = append(s.providers, p)
+	}
+	s.notifyUpdate(p)
+	s.Unlock()
+
+	return
+}
+
+// update updates the configuration of an already enabled provider
+func (s *providerSet) update(p Provider) (err error) {
+	s.Lock()
+
+	i := s.index(p.GUID)
+	if i < 0 {
+		s.Unlock()
+		return fmt.Errorf("%w: %s", ErrProviderNotEnabled, p.GUID)
+	}
+
+	if err = s.backend.enableProvider(s.handle, &p); err != nil {
+		s.Unlock()
+		return
+	}
+	s.providers[i] = p
+	s.notifyUpdate(p)
+	s.Unlock()
+
+	return
+}
+
+// disable disables an enabled provider
+func (s *providerSet) disable(guid string) (err error) {
+	s.Lock()
+
+	i := s.index(guid)
+	if i < 0 {
+		s.Unlock()
+		return fmt.Errorf("%w: %s", ErrProviderNotEnabled, guid)
+	}
+
+	if err = s.backend.disableProvider(s.handle, &s.providers[i]); err != nil {
+		s.Unlock()
+		return
+	}
+	s.providers = append(s.providers[:i], s.providers[i+1:]...)
+	for _, w := range s.watchers {
+		w.RemoveProvider(guid)
+	}
+	s.Unlock()
+
+	return
+}
+
+// notifyUpdate notifies the watchers of the set that a provider got
+// enabled or updated, the set must be locked
+func (s *providerSet) notifyUpdate(p Provider) {
+	for _, w := range s.watchers {
+		w.UpdateProvider(p)
+	}
+}
+
+// watch makes w watch the changes made to the set, it is notified at once
+// of the providers already enabled
+func (s *providerSet) watch(w providerWatcher) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.watchers = append(s.watchers, w)
+	for _, p := range s.providers {
+		w.UpdateProvider(p)
+	}
+}
+
+// unwatch stops w from watching the changes made to the set
+func (s *providerSet) unwatch(w providerWatcher) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.watchers {
+		if s.watchers[i] == w {
+			s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns a copy of the enabled providers
+func (s *providerSet) list() []Provider {
+	s.RLock()
+	defer s.RUnlock()
+
+	out := make([]Provider, len(s.providers))
+	copy(out, s.providers)
+	return out
+}
@@ -0,0 +1,125 @@
+package etw
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+// watcherCalls records the notifications of a providerWatcher
+type watcherCalls []string
+
+func (w *watcherCalls) UpdateProvider(p Provider) {
+	*w = append(*w, "update "+p.GUID)
+}
+
+func (w *watcherCalls) RemoveProvider(guid string) {
+	*w = append(*w, "remove "+guid)
+}
+
+func TestProviderSet(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	kernelFile := "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+	kernelProcess := "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+
+	b := newFakeBackend()
+	h, err := b.startTrace("EtwProviders", nil)
+	tt.CheckErr(err)
+	s := providerSet{backend: b, handle: h}
+
+	tt.CheckErr(s.enable(Provider{GUID: kernelFile, EnableLevel: 4}))
+	// watchers are notified of the providers already enabled
+	var w watcherCalls
+	s.watch(&w)
+	tt.CheckErr(s.enable(Provider{GUID: kernelProcess, EnableLevel: 4}))
+	tt.Assert(len(s.list()) == 2)
+	tt.Assert(fmt.Sprint(w) == fmt.Sprint([]string{"update " + kernelFile, "update " + kernelProcess}), w)
+
+	// enabling again updates the configuration
+	tt.CheckErr(s.enable(Provider{GUID: kernelFile, EnableLevel: 5}))
+	tt.Assert(len(s.list()) == 2)
+	p, _ := b.enabled(h, kernelFile)
+	tt.Assert(p.EnableLevel == 5)
+
+	// GUID case and braces do not matter
+	tt.CheckErr(s.update(Provider{GUID: "edd08927-9cc4-4e65-b970-c2560fb5c289", MatchAnyKeyword: 0x10}))
+	p, _ = b.enabled(h, kernelFile)
+	tt.Assert(p.MatchAnyKeyword == 0x10)
+	tt.Assert(s.list()[0].MatchAnyKeyword == 0x10)
+
+	tt.CheckErr(s.disable(kernelProcess))
+	tt.Assert(len(s.list()) == 1)
+	_, ok := b.enabled(h, kernelProcess)
+	tt.Assert(!ok)
+	tt.Assert(w[len(w)-1] == "remove "+kernelProcess)
+
+	// unwatched sets do not notify
+	s.unwatch(&w)
+	n := len(w)
+	tt.CheckErr(s.enable(Provider{GUID: kernelProcess}))
+	tt.CheckErr(s.disable(kernelProcess))
+	tt.Assert(len(w) == n)
+
+	// operations on providers not enabled
+	tt.Assert(errors.Is(s.disable(kernelProcess), ErrProviderNotEnabled))
+	tt.Assert(errors.Is(s.update(Provider{GUID: kernelProcess}), ErrProviderNotEnabled))
+
+	// backend errors leave the set unchanged
+	b.enableErr = fmt.Errorf("access denied")
+	b.disableErr = b.enableErr
+	tt.Assert(errors.Is(s.enable(Provider{GUID: kernelProcess}), b.enableErr))
+	tt.Assert(errors.Is(s.update(Provider{GUID: kernelFile, EnableLevel: 1}), b.enableErr))
+	tt.Assert(errors.Is(s.disable(kernelFile), b.disableErr))
+	tt.Assert(len(s.list()) == 1 && s.list()[0].EnableLevel == 0)
+
+	tt.Assert(len(b.providerCalls) == 7, b.providerCalls)
+}
+
+func TestProviderFilterLiveUpdate(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	kernelFile := "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+
+	f := NewProviderFilter()
+	// GUIDs are normalized
+	f.Update(&Provider{GUID: "edd08927-9cc4-4e65-b970-c2560fb5c289", Filter: []uint16{12}})
+	tt.Assert(!f.Match(testIEvent{kernelFile, 13}))
+
+	// filters are updated while being used
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			f.Match(testIEvent{kernelFile, uint16(i)})
+		}
+	}()
+
+	for i := uint16(0); i < 100; i++ {
+		f.Update(&Provider{GUID: kernelFile, Filter: []uint16{i}})
+	}
+	wg.Wait()
+
+	tt.Assert(f.Match(testIEvent{kernelFile, 99}))
+	tt.Assert(!f.Match(testIEvent{kernelFile, 12}))
+
+	// removing the event ID filter
+	f.Update(&Provider{GUID: kernelFile})
+	tt.Assert(f.Match(testIEvent{kernelFile, 12}))
+
+	// disabled providers are denied until enabled again, also in trees
+	tree := And(LevelAtMost(5), Or(f))
+	removeProvider(tree, "edd08927-9cc4-4e65-b970-c2560fb5c289")
+	tt.Assert(!f.Match(testIEvent{kernelFile, 12}))
+	tt.Assert(f.Match(testIEvent{"{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}", 12}))
+	f.Update(&Provider{GUID: kernelFile})
+	tt.Assert(f.Match(testIEvent{kernelFile, 12}))
+}
@@ -0,0 +1,267 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	ErrUnknownFieldName = fmt.Errorf("unknown field name")
+
+	// StandardLevels maps the names of the levels defined by winmeta.xml
+	// to their values, they can be used with any provider
+	StandardLevels = map[string]uint8{
+		"logalways":     0,
+		"critical":      1,
+		"error":         2,
+		"warning":       3,
+		"informational": 4,
+		"information":   4,
+		"info":          4,
+		"verbose":       5,
+	}
+)
+
+// String implements fmt.Stringer
+func (t EventFieldType) String() string {
+	switch t {
+	case EventKeywordInformation:
+		return "keyword"
+	case EventLevelInformation:
+		return "level"
+	case EventChannelInformation:
+		return "channel"
+	case EventTaskInformation:
+		return "task"
+	case EventOpcodeInformation:
+		return "opcode"
+	}
+	return fmt.Sprintf("EventFieldType(%d)", int32(t))
+}
+
+// ProviderField describes a keyword, level, channel, task or opcode
+// declared by a provider
+type ProviderField struct {
+	Name        string
+	Description string `json:",omitempty"`
+	Value       uint64
+}
+
+// ProviderFieldLookup gives the fields of a given type declared by a
+// provider identified by its GUID
+type ProviderFieldLookup interface {
+	ProviderFields(guid string, typ EventFieldType) ([]ProviderField, error)
+}
+
+// ProviderFieldLookupFunc is a function implementing ProviderFieldLookup
+type ProviderFieldLookupFunc func(guid string, typ EventFieldType) ([]ProviderField, error)
+
+// ProviderFields implements ProviderFieldLookup
+func (f ProviderFieldLookupFunc) ProviderFields(guid string, typ EventFieldType) ([]ProviderField, error) {
+	return f(guid, typ)
+}
+
+// lookupField returns the value of a field given its name. Names are
+// compared case insensitively and win: prefix of winmeta.xml names is
+// optional.
+func lookupField(lookup ProviderFieldLookup, guid string, typ EventFieldType, name string) (uint64, error) {
+	if lookup == nil {
+		return 0, fmt.Errorf("%w: cannot resolve %s %s without lookup", ErrUnknownFieldName, typ, name)
+	}
+
+	fields, err := lookup.ProviderFields(guid, typ)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup %s names of provider %s: %w", typ, guid, err)
+	}
+
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) ||
+			strings.EqualFold(strings.TrimPrefix(f.Name, "win:"), name) {
+			return f.Value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s %s for provider %s", ErrUnknownFieldName, typ, name, guid)
+}
+
+// ParseLevel parses a level given either as an integer, a standard level
+// name (see StandardLevels) or a level name declared by the provider
+func ParseLevel(s, guid string, lookup ProviderFieldLookup) (uint8, error) {
+	if u, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return uint8(u), nil
+	}
+
+	if l, ok := StandardLevels[strings.ToLower(strings.TrimPrefix(s, "win:"))]; ok {
+		return l, nil
+	}
+
+	v, err := lookupField(lookup, guid, EventLevelInformation, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if v > 0xff {
+		return 0, fmt.Errorf("%w: level %s value out of range %d", ErrUnknownFieldName, s, v)
+	}
+
+	return uint8(v), nil
+}
+
+// ParseKeywords parses a keyword mask made of integers and keyword names
+// declared by the provider separated by |, like
+// KERNEL_FILE_KEYWORD_FILENAME|KERNEL_FILE_KEYWORD_FILEIO|0x1000
+func ParseKeywords(s, guid string, lookup ProviderFieldLookup) (mask uint64, err error) {
+	var v uint64
+
+	for _, kw := range strings.Split(s, "|") {
+		kw = strings.TrimSpace(kw)
+
+		if v, err = strconv.ParseUint(kw, 0, 64); err != nil {
+			if v, err = lookupField(lookup, guid, EventKeywordInformation, kw); err != nil {
+				return
+			}
+		}
+
+		mask |= v
+	}
+
+	return
+}
+
+// ParseOpcode parses an opcode given either as an integer or as an opcode
+// name declared by the provider
+func ParseOpcode(s, guid string, lookup ProviderFieldLookup) (uint8, error) {
+	if u, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return uint8(u), nil
+	}
+
+	v, err := lookupField(lookup, guid, EventOpcodeInformation, s)
+	if err != nil {
+		return 0, err
+	}
+
+	// TDH reports opcodes along with the task they are declared in
+	// as (opcode << 16 | task)
+	if v > 0xff {
+		v >>= 16
+	}
+
+	return uint8(v), nil
+}
+
+// utf16StringAt decodes the NUL terminated UTF-16 string found at offset
+// in buf
+func utf16StringAt(buf []byte, offset uint32) (string, error) {
+	u := make([]uint16, 0, 32)
+
+	for i := int(offset); ; i += 2 {
+		if i+2 > len(buf) {
+			return "", fmt.Errorf("%w: unterminated string at offset %d", ErrShortBuffer, offset)
+		}
+		c := binary.LittleEndian.Uint16(buf[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+
+	return string(utf16.Decode(u)), nil
+}
+
+/*
+typedef struct _PROVIDER_FIELD_INFOARRAY {
+  ULONG               NumberOfElements;
+  EVENT_FIELD_TYPE    FieldType;
+  PROVIDER_FIELD_INFO FieldInfoArray[ANYSIZE_ARRAY];
+} PROVIDER_FIELD_INFOARRAY;
+
+typedef struct _PROVIDER_FIELD_INFO {
+  ULONG     NameOffset;
+  ULONG     DescriptionOffset;
+  ULONGLONG Value;
+} PROVIDER_FIELD_INFO;
+*/
+
+// DecodeProviderFieldInfoArray decodes a PROVIDER_FIELD_INFOARRAY buffer
+// as filled by TdhEnumerateProviderFieldInformation
+func DecodeProviderFieldInfoArray(buf []byte) (fields []ProviderField, err error) {
+	const (
+		header   = 8
+		infoSize = 16
+	)
+
+	if len(buf) < header {
+		return nil, ErrShortBuffer
+	}
+
+	n := int(binary.LittleEndian.Uint32(buf))
+	if len(buf) < header+n*infoSize {
+		return nil, fmt.Errorf("%w: %d field infos do not fit in %d bytes", ErrShortBuffer, n, len(buf))
+	}
+
+	fields = make([]ProviderField, 0, n)
+	for i := 0; i < n; i++ {
+		var f ProviderField
+
+		info := buf[header+i*infoSize:]
+		if f.Name, err = utf16StringAt(buf, binary.LittleEndian.Uint32(info)); err != nil {
+			return nil, err
+		}
+
+		if descOffset := binary.LittleEndian.Uint32(info[4:]); descOffset != 0 {
+			if f.Description, err = utf16StringAt(buf, descOffset); err != nil {
+				return nil, err
+			}
+		}
+
+		f.Value = binary.LittleEndian.Uint64(info[8:])
+		fields = append(fields, f)
+	}
+
+	return
+}
+
+/*
+typedef struct _PROVIDER_EVENT_INFO {
+  ULONG            NumberOfEvents;
+  ULONG            Reserved;
+  EVENT_DESCRIPTOR EventDescriptorsArray[ANYSIZE_ARRAY];
+} PROVIDER_EVENT_INFO;
+*/
+
+// DecodeProviderEventInfo decodes a PROVIDER_EVENT_INFO buffer as filled
+// by TdhEnumerateManifestProviderEvents
+func DecodeProviderEventInfo(buf []byte) (descs []EventDescriptor, err error) {
+	const (
+		header   = 8
+		descSize = 16
+	)
+
+	if len(buf) < header {
+		return nil, ErrShortBuffer
+	}
+
+	n := int(binary.LittleEndian.Uint32(buf))
+	if len(buf) < header+n*descSize {
+		return nil, fmt.Errorf("%w: %d event descriptors do not fit in %d bytes", ErrShortBuffer, n, len(buf))
+	}
+
+	descs = make([]EventDescriptor, 0, n)
+	for i := 0; i < n; i++ {
+		d := buf[header+i*descSize:]
+		descs = append(descs, EventDescriptor{
+			Id:      binary.LittleEndian.Uint16(d),
+			Version: d[2],
+			Channel: d[3],
+			Level:   d[4],
+			Opcode:  d[5],
+			Task:    binary.LittleEndian.Uint16(d[6:]),
+			Keyword: binary.LittleEndian.Uint64(d[8:]),
+		})
+	}
+
+	return
+}
@@ -0,0 +1,121 @@
+package etw
+
+import (
+	"sync"
+
+	"github.com/0xrawsec/golang-utils/datastructs"
+)
+
+// eventIDFilter filters in, or out, a set of event IDs and filters in
+// a set of opcodes
+type eventIDFilter struct {
+	ids       *datastructs.Set
+	filterOut bool
+	opcodes   map[uint8]bool
+	// all event IDs are filtered out
+	deny bool
+}
+
+func (f *eventIDFilter) match(e IEvent) bool {
+	if len(f.opcodes) > 0 && !f.opcodes[e.Opcode()] {
+		return false
+	}
+	if f.ids.Len() > 0 {
+		return f.ids.Contains(e.EventID()) != f.filterOut
+	}
+	return true
+}
+
+type baseFilter struct {
+	sync.RWMutex
+	m map[string]*eventIDFilter
+}
+
+func (f *baseFilter) matchKey(key string, e IEvent) bool {
+	f.RLock()
+	defer f.RUnlock()
+
+	// map is nil
+	if f.m == nil {
+		return true
+	}
+
+	// Filter is empty
+	if len(f.m) == 0 {
+		return true
+	}
+
+	if eventids, ok := f.m[key]; ok {
+		if eventids.deny {
+			return false
+		}
+		return eventids.match(e)
+	}
+	// we return true if no filter is found
+	return true
+}
+
+// ProviderFilter structure to filter events based on Provider
+// definition. It applies event ID and opcode filters on the consumer side,
+// which is needed when filters cannot be applied by ETW (see
+// Provider.KernelEventIDFilter and Provider.Opcodes).
+type ProviderFilter struct {
+	baseFilter
+}
+
+// NewProviderFilter creates a new ProviderFilter structure
+func NewProviderFilter() *ProviderFilter {
+	f := ProviderFilter{}
+	f.m = make(map[string]*eventIDFilter)
+	return &f
+}
+
+// Match implements EventFilter
+func (f *ProviderFilter) Match(e IEvent) bool {
+	return f.matchKey(e.ProviderGUID(), e)
+}
+
+// providerKey returns the key of a provider GUID in the filter map
+func providerKey(guid string) string {
+	// keys must match IEvent.ProviderGUID format
+	if g, err := ParseGUID(guid); err == nil {
+		return g.String()
+	}
+	return guid
+}
+
+// Update implements EventFilter. It replaces the event ID and opcode filter
+// of the provider, a provider without event IDs nor opcodes having its
+// filter removed. It is safe to call while the filter is used.
+func (f *ProviderFilter) Update(p *Provider) {
+	f.Lock()
+	defer f.Unlock()
+
+	key := providerKey(p.GUID)
+
+	if len(p.Filter) > 0 || len(p.Opcodes) > 0 {
+		s := datastructs.ToInterfaceSlice(p.Filter)
+		filter := &eventIDFilter{
+			ids:       datastructs.NewInitSet(s...),
+			filterOut: p.FilterOut,
+			opcodes:   make(map[uint8]bool, len(p.Opcodes)),
+		}
+		for _, o := range p.Opcodes {
+			filter.opcodes[o] = true
+		}
+		f.m[key] = filter
+		return
+	}
+
+	delete(f.m, key)
+}
+
+// removeProvider implements providerRemover. Events of a disabled provider
+// may still be in ETW buffers, they are filtered out until the provider is
+// enabled again.
+func (f *ProviderFilter) removeProvider(guid string) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.m[providerKey(guid)] = &eventIDFilter{deny: true}
+}
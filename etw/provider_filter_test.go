@@ -0,0 +1,69 @@
+package etw
+
+import (
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestProviderFilter(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	kernelFile := "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+	kernelProcess := "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+	other := "{5770385F-C22A-43E0-BF4C-06F5698FFBD9}"
+
+	f := NewProviderFilter()
+	// empty filter matches everything
+	tt.Assert(f.Match(testIEvent{kernelFile, 10}))
+
+	f.Update(&Provider{GUID: kernelFile, Filter: []uint16{10, 11}, FilterOut: true})
+	f.Update(&Provider{GUID: kernelProcess, Filter: []uint16{1, 2}})
+	// no event ID filter
+	f.Update(&Provider{GUID: other})
+
+	tt.Assert(!f.Match(testIEvent{kernelFile, 10}))
+	tt.Assert(!f.Match(testIEvent{kernelFile, 11}))
+	tt.Assert(f.Match(testIEvent{kernelFile, 12}))
+
+	tt.Assert(f.Match(testIEvent{kernelProcess, 1}))
+	tt.Assert(!f.Match(testIEvent{kernelProcess, 3}))
+
+	tt.Assert(f.Match(testIEvent{other, 42}))
+
+	// filters too large for ETW are applied by the consumer
+	large := Provider{GUID: kernelProcess, FilterOut: true}
+	for i := uint16(0); i < MAX_EVENT_FILTER_EVENT_ID_COUNT*2; i++ {
+		large.Filter = append(large.Filter, i)
+	}
+	tt.Assert(!large.KernelEventIDFilter())
+
+	f.Update(&large)
+	tt.Assert(!f.Match(testIEvent{kernelProcess, 1}))
+	tt.Assert(!f.Match(testIEvent{kernelProcess, MAX_EVENT_FILTER_EVENT_ID_COUNT + 1}))
+	tt.Assert(f.Match(testIEvent{kernelProcess, MAX_EVENT_FILTER_EVENT_ID_COUNT * 2}))
+
+	// opcodes are filtered in
+	opcode := func(guid string, id uint16, opcode uint8) *Event {
+		e := NewEvent()
+		e.System.Provider.Guid = *MustParseGUIDFromString(guid)
+		e.System.EventID = id
+		e.System.Opcode.Value = opcode
+		return e
+	}
+	f.Update(&Provider{GUID: other, Opcodes: []uint8{1, 2}})
+	tt.Assert(f.Match(opcode(other, 42, 2)))
+	tt.Assert(!f.Match(opcode(other, 42, 3)))
+	f.Update(&Provider{GUID: kernelFile, Filter: []uint16{10}, FilterOut: true, Opcodes: []uint8{1}})
+	tt.Assert(f.Match(opcode(kernelFile, 11, 1)))
+	tt.Assert(!f.Match(opcode(kernelFile, 10, 1)))
+	tt.Assert(!f.Match(opcode(kernelFile, 11, 0)))
+	f.Update(&Provider{GUID: kernelFile, Filter: []uint16{10, 11}, FilterOut: true})
+
+	// works in filter trees
+	tree := And(f, Not(EventIDIn(12)))
+	tt.Assert(!tree.Match(testIEvent{kernelFile, 12}))
+	tt.Assert(tree.Match(testIEvent{kernelFile, 13}))
+}
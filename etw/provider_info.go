@@ -0,0 +1,165 @@
+package etw
+
+// EventFieldInfo describes a field of an event template
+type EventFieldInfo struct {
+	Name    string
+	InType  TdhInType  `json:",omitempty"`
+	OutType TdhOutType `json:",omitempty"`
+	// Name of the value map used to format the field
+	MapName string `json:",omitempty"`
+	// Number of elements of fixed size arrays
+	Count uint16 `json:",omitempty"`
+	// Name of the field holding the number of elements of the array
+	CountField string `json:",omitempty"`
+	// Fixed length of the field in bytes
+	Length uint16 `json:",omitempty"`
+	// Name of the field holding the length of the field in bytes
+	LengthField string `json:",omitempty"`
+	// Members of structure fields
+	Fields []EventFieldInfo `json:",omitempty"`
+}
+
+// IsStruct returns true if the field is a structure
+func (f *EventFieldInfo) IsStruct() bool {
+	return len(f.Fields) > 0
+}
+
+// IsArray returns true if the field is an array
+func (f *EventFieldInfo) IsArray() bool {
+	return f.Count > 1 || f.CountField != ""
+}
+
+// EventInfo describes an event declared by a provider
+type EventInfo struct {
+	ID       uint16
+	Version  uint8
+	Channel  uint8
+	Level    uint8
+	Opcode   uint8
+	Task     uint16
+	Keywords uint64
+
+	ChannelName  string `json:",omitempty"`
+	LevelName    string `json:",omitempty"`
+	OpcodeName   string `json:",omitempty"`
+	TaskName     string `json:",omitempty"`
+	KeywordsName string `json:",omitempty"`
+
+	// Template fields of the event
+	Fields []EventFieldInfo `json:",omitempty"`
+}
+
+// ProviderInfo describes a provider as declared in its manifest. It is
+// returned by ProviderMetadata and can be serialized to be used on any OS.
+type ProviderInfo struct {
+	GUID     string
+	Name     string          `json:",omitempty"`
+	Keywords []ProviderField `json:",omitempty"`
+	Levels   []ProviderField `json:",omitempty"`
+	Channels []ProviderField `json:",omitempty"`
+	Tasks    []ProviderField `json:",omitempty"`
+	Opcodes  []ProviderField `json:",omitempty"`
+	Events   []EventInfo     `json:",omitempty"`
+}
+
+// Fields returns the fields of a given type declared by the provider
+func (p *ProviderInfo) Fields(typ EventFieldType) []ProviderField {
+	switch typ {
+	case EventKeywordInformation:
+		return p.Keywords
+	case EventLevelInformation:
+		return p.Levels
+	case EventChannelInformation:
+		return p.Channels
+	case EventTaskInformation:
+		return p.Tasks
+	case EventOpcodeInformation:
+		return p.Opcodes
+	}
+	return nil
+}
+
+// SetFields sets the fields of a given type declared by the provider
+func (p *ProviderInfo) SetFields(typ EventFieldType, fields []ProviderField) {
+	switch typ {
+	case EventKeywordInformation:
+		p.Keywords = fields
+	case EventLevelInformation:
+		p.Levels = fields
+	case EventChannelInformation:
+		p.Channels = fields
+	case EventTaskInformation:
+		p.Tasks = fields
+	case EventOpcodeInformation:
+		p.Opcodes = fields
+	}
+}
+
+// Event returns the description of an event given its ID and version
+func (p *ProviderInfo) Event(id uint16, version uint8) (*EventInfo, bool) {
+	for i := range p.Events {
+		if p.Events[i].ID == id && p.Events[i].Version == version {
+			return &p.Events[i], true
+		}
+	}
+	return nil, false
+}
+
+// hasMetadata returns true if p holds more than the name and GUID of the
+// provider
+func (p *ProviderInfo) hasMetadata() bool {
+	return len(p.Keywords) > 0 || len(p.Levels) > 0 || len(p.Channels) > 0 ||
+		len(p.Tasks) > 0 || len(p.Opcodes) > 0 || len(p.Events) > 0
+}
+
+func newEventInfo(s *EventSchema) EventInfo {
+	d := s.EventDescriptor
+	info := EventInfo{
+		ID:           d.Id,
+		Version:      d.Version,
+		Channel:      d.Channel,
+		Level:        d.Level,
+		Opcode:       d.Opcode,
+		Task:         d.Task,
+		Keywords:     d.Keyword,
+		ChannelName:  s.ChannelName,
+		LevelName:    s.LevelName,
+		OpcodeName:   s.OpcodeName,
+		TaskName:     s.TaskName,
+		KeywordsName: s.KeywordsName,
+	}
+
+	for _, p := range s.TopLevelProperties() {
+		info.Fields = append(info.Fields, newEventFieldInfo(s, &p))
+	}
+
+	return info
+}
+
+func newEventFieldInfo(s *EventSchema, p *PropertyInfo) (f EventFieldInfo) {
+	f.Name = p.Name
+
+	if p.IsStruct() {
+		for i := range p.Members {
+			f.Fields = append(f.Fields, newEventFieldInfo(s, &p.Members[i]))
+		}
+	} else {
+		f.InType = p.InType
+		f.OutType = p.OutType
+		f.MapName = p.MapName
+	}
+
+	if i, ok := p.CountIndex(); ok {
+		f.CountField = s.Properties[i].Name
+	} else if p.Count > 1 {
+		f.Count = p.Count
+	}
+
+	if i, ok := p.LengthIndex(); ok {
+		f.LengthField = s.Properties[i].Name
+	} else {
+		f.Length = p.Length
+	}
+
+	return
+}
@@ -0,0 +1,93 @@
+package etw
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func loadTestProviderInfo(t *testing.T) *ProviderInfo {
+	var info ProviderInfo
+
+	b, err := os.ReadFile(filepath.Join("testdata", "kernel-file-provider.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = json.Unmarshal(b, &info); err != nil {
+		t.Fatal(err)
+	}
+
+	return &info
+}
+
+func TestProviderInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	info := loadTestProviderInfo(t)
+	tt.Assert(info.Name == "Microsoft-Windows-Kernel-File")
+	tt.Assert(len(info.Fields(EventKeywordInformation)) == 4)
+	tt.Assert(info.Fields(EventTaskInformation)[1].Name == "Create")
+	tt.Assert(info.Fields(EventInformationMax) == nil)
+
+	e, ok := info.Event(12, 1)
+	tt.Assert(ok)
+	tt.Assert(e.TaskName == "Create")
+	tt.Assert(len(e.Fields) == 7)
+	tt.Assert(e.Fields[1].Name == "FileObject" && e.Fields[1].InType == TdhInTypePointer)
+	tt.Assert(!e.Fields[1].IsArray() && !e.Fields[1].IsStruct())
+
+	_, ok = info.Event(12, 0)
+	tt.Assert(!ok)
+
+	// serialization round trip
+	b, err := json.Marshal(info)
+	tt.CheckErr(err)
+
+	var other ProviderInfo
+	tt.CheckErr(json.Unmarshal(b, &other))
+	tt.Assert(len(other.Events) == len(info.Events))
+	tt.Assert(other.Events[0].Keywords == info.Events[0].Keywords)
+
+	other.SetFields(EventOpcodeInformation, nil)
+	tt.Assert(len(other.Opcodes) == 0)
+
+	f := EventFieldInfo{Name: "Array", CountField: "Count", Fields: []EventFieldInfo{{Name: "Member"}}}
+	tt.Assert(f.IsArray() && f.IsStruct())
+}
+
+func TestNewEventInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, err := ParseTraceEventInfo(readTestData(t, "kernel-file-create.tei.bin"))
+	tt.CheckErr(err)
+
+	// event information built from TDH output must match the manifest
+	expected, ok := loadTestProviderInfo(t).Event(12, 1)
+	tt.Assert(ok)
+	tt.Assert(reflect.DeepEqual(newEventInfo(s), *expected))
+
+	s, err = ParseTraceEventInfo(readTestData(t, "struct-array.tei.bin"))
+	tt.CheckErr(err)
+
+	info := newEventInfo(s)
+	tt.Assert(len(info.Fields) == 4)
+	tt.Assert(info.OpcodeName == "Info")
+
+	entries := info.Fields[1]
+	tt.Assert(entries.IsStruct() && entries.IsArray())
+	tt.Assert(entries.CountField == "EntryCount")
+	tt.Assert(len(entries.Fields) == 2)
+	tt.Assert(entries.Fields[1].MapName == "StatusMap")
+
+	data := info.Fields[3]
+	tt.Assert(data.LengthField == "DataSize" && data.Length == 0)
+}
@@ -0,0 +1,104 @@
+//go:build windows
+// +build windows
+
+package etw
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+var (
+	providerFieldTypes = []EventFieldType{
+		EventKeywordInformation,
+		EventLevelInformation,
+		EventChannelInformation,
+		EventTaskInformation,
+		EventOpcodeInformation,
+	}
+)
+
+// ProviderMetadata returns the description of a provider, the fields and
+// the events it declares in its manifest
+func ProviderMetadata(guid string) (info *ProviderInfo, err error) {
+	var g *GUID
+	var fields []ProviderField
+	var descs []EventDescriptor
+
+	if g, err = ParseGUID(guid); err != nil {
+		return
+	}
+
+	// the name is taken from event information rather than resolved from
+	// DefaultProviderRegistry as this function is used to refresh it
+	info = &ProviderInfo{GUID: g.String()}
+
+	lookup := TdhFieldLookup{}
+	for _, typ := range providerFieldTypes {
+		if fields, err = lookup.ProviderFields(info.GUID, typ); err != nil {
+			return nil, fmt.Errorf("failed to get %s information: %w", typ, err)
+		}
+		info.SetFields(typ, fields)
+	}
+
+	if descs, err = manifestProviderEvents(g); err != nil {
+		return nil, fmt.Errorf("failed to enumerate events: %w", err)
+	}
+
+	info.Events = make([]EventInfo, 0, len(descs))
+	for i := range descs {
+		var buf []byte
+		var schema *EventSchema
+
+		if buf, err = manifestEventInformation(g, &descs[i]); err != nil {
+			return nil, fmt.Errorf("failed to get event %d information: %w", descs[i].Id, err)
+		}
+
+		if schema, err = ParseTraceEventInfo(buf); err != nil {
+			return nil, fmt.Errorf("failed to parse event %d information: %w", descs[i].Id, err)
+		}
+
+		if info.Name == "" {
+			info.Name = schema.ProviderName
+		}
+
+		info.Events = append(info.Events, newEventInfo(schema))
+	}
+
+	return
+}
+
+func manifestProviderEvents(guid *GUID) (descs []EventDescriptor, err error) {
+	var buf []byte
+
+	size := uint32(unsafe.Sizeof(ProviderEventInfo{}))
+	for {
+		buf = make([]byte, size)
+		pei := (*ProviderEventInfo)(unsafe.Pointer(&buf[0]))
+		if err = TdhEnumerateManifestProviderEvents(guid, pei, &size); err != ERROR_INSUFFICIENT_BUFFER {
+			break
+		}
+	}
+
+	switch err {
+	case nil:
+	case ERROR_NOT_FOUND:
+		// provider does not declare any event
+		return nil, nil
+	default:
+		return
+	}
+
+	return DecodeProviderEventInfo(buf)
+}
+
+func manifestEventInformation(guid *GUID, desc *EventDescriptor) (buf []byte, err error) {
+	size := uint32(unsafe.Sizeof(TraceEventInfo{}))
+	for {
+		buf = make([]byte, size)
+		tei := (*TraceEventInfo)(unsafe.Pointer(&buf[0]))
+		if err = TdhGetManifestEventInformation(guid, desc, tei, &size); err != ERROR_INSUFFICIENT_BUFFER {
+			return buf, err
+		}
+	}
+}
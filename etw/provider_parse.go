@@ -0,0 +1,195 @@
+package etw
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MustParseProvider parses a provider string or panic
+func MustParseProvider(s string) (p Provider) {
+	var err error
+	if p, err = ParseProvider(s); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// ParseProvider parses a string and returns a provider.
+// The returned provider is initialized from DefaultProvider.
+// Format (Name|GUID) string:EnableLevel (uint8|name):Event IDs comma sep string:MatchAnyKeyword (uint64|name) | sep:MatchAllKeyword (uint64|name) | sep:EnableProperty comma sep string:PIDs comma sep string:ExecutableNames comma sep string:StackWalk Event IDs comma sep string:Opcodes (uint8|name) comma sep
+// Example: Microsoft-Windows-Kernel-File:0xff:13,14:0x80::sid,stack:4,1337:explorer.exe,svchost.exe
+// Event IDs prefixed with ! are filtered out, example: Microsoft-Windows-Kernel-File:0xff:!10,11
+// Stacks are collected only for StackWalk Event IDs, example: Microsoft-Windows-Kernel-File:0xff:::::::12
+// EnableProperty items are either names listed in EnableProperties or integers
+// Level, keyword and opcode names are the ones declared by the provider,
+// standard level names (see StandardLevels) being accepted for any provider,
+// example: Microsoft-Windows-Kernel-File:verbose::KERNEL_FILE_KEYWORD_FILENAME|KERNEL_FILE_KEYWORD_FILEIO
+// Opcodes are filtered on the consumer side (see Provider.Opcodes), example:
+// Microsoft-Windows-Kernel-File:::::::::Info,Create
+// Providers and their names are resolved with DefaultProviderRegistry.
+// Names prefixed with * are EventSource or TraceLogging provider names
+// whose GUID is computed with EventSourceGUID, example: *My-Company-Provider
+func ParseProvider(s string) (p Provider, err error) {
+	return ParseProviderWith(DefaultProviderRegistry, s)
+}
+
+// ParseProviderWith works as ParseProvider but resolves providers and
+// their level, keyword and opcode names with r
+func ParseProviderWith(r ProviderResolver, s string) (p Provider, err error) {
+	var u uint64
+
+	split := strings.Split(s, ":")
+	for i := 0; i < len(split); i++ {
+		chunk := split[i]
+		switch i {
+		case 0:
+			// name of an EventSource or TraceLogging provider
+			if strings.HasPrefix(chunk, "*") {
+				if chunk = chunk[1:]; chunk == "" {
+					err = fmt.Errorf("%w: empty EventSource name", ErrUnkownProvider)
+					return
+				}
+				p = EventSourceProvider(chunk)
+				break
+			}
+
+			var ok bool
+			if p, ok = r.ResolveProvider(chunk); !ok {
+				err = fmt.Errorf("%w %s", ErrUnkownProvider, chunk)
+				return
+			}
+		case 1:
+			if chunk == "" {
+				break
+			}
+			// parsing EnableLevel
+			if p.EnableLevel, err = ParseLevel(chunk, p.GUID, r); err != nil {
+				err = fmt.Errorf("failed to parse EnableLevel: %w", err)
+				return
+			}
+		case 2:
+			if chunk == "" {
+				break
+			}
+			// event ids prefixed with ! are filtered out
+			if strings.HasPrefix(chunk, "!") {
+				p.FilterOut = true
+				chunk = chunk[1:]
+			}
+
+			// parsing event ids
+			for _, eid := range strings.Split(chunk, ",") {
+				if u, err = strconv.ParseUint(eid, 0, 16); err != nil {
+					err = fmt.Errorf("failed to parse EventID: %w", err)
+					return
+				} else {
+					p.Filter = append(p.Filter, uint16(u))
+				}
+			}
+		case 3:
+			if chunk == "" {
+				break
+			}
+
+			// parsing MatchAnyKeyword
+			if p.MatchAnyKeyword, err = ParseKeywords(chunk, p.GUID, r); err != nil {
+				err = fmt.Errorf("failed to parse MatchAnyKeyword: %w", err)
+				return
+			}
+		case 4:
+			if chunk == "" {
+				break
+			}
+
+			// parsing MatchAllKeyword
+			if p.MatchAllKeyword, err = ParseKeywords(chunk, p.GUID, r); err != nil {
+				err = fmt.Errorf("failed to parse MatchAllKeyword: %w", err)
+				return
+			}
+		case 5:
+			if chunk == "" {
+				break
+			}
+
+			// parsing EnableProperty
+			if p.EnableProperty, err = ParseEnableProperty(chunk); err != nil {
+				err = fmt.Errorf("failed to parse EnableProperty: %w", err)
+				return
+			}
+		case 6:
+			if chunk == "" {
+				break
+			}
+
+			// parsing PIDs
+			for _, pid := range strings.Split(chunk, ",") {
+				if u, err = strconv.ParseUint(pid, 0, 32); err != nil {
+					err = fmt.Errorf("failed to parse PID: %w", err)
+					return
+				} else {
+					p.PIDs = append(p.PIDs, uint32(u))
+				}
+			}
+
+			if len(p.PIDs) > MAX_EVENT_FILTER_PID_COUNT {
+				err = fmt.Errorf("too many PIDs %d > %d", len(p.PIDs), MAX_EVENT_FILTER_PID_COUNT)
+				return
+			}
+		case 7:
+			if chunk == "" {
+				break
+			}
+
+			// parsing ExecutableNames
+			p.ExecutableNames = strings.Split(chunk, ",")
+			if _, err = EncodeExecutableNameFilter(p.ExecutableNames); err != nil {
+				err = fmt.Errorf("failed to parse ExecutableNames: %w", err)
+				return
+			}
+		case 8:
+			if chunk == "" {
+				break
+			}
+
+			p.StackWalk = &StackWalkFilter{}
+			// stack walk event ids prefixed with ! are filtered out
+			if strings.HasPrefix(chunk, "!") {
+				p.StackWalk.FilterOut = true
+				chunk = chunk[1:]
+			}
+
+			// parsing stack walk event ids
+			for _, eid := range strings.Split(chunk, ",") {
+				if u, err = strconv.ParseUint(eid, 0, 16); err != nil {
+					err = fmt.Errorf("failed to parse stack walk EventID: %w", err)
+					return
+				} else {
+					p.StackWalk.EventIDs = append(p.StackWalk.EventIDs, uint16(u))
+				}
+			}
+
+			if len(p.StackWalk.EventIDs) > MAX_EVENT_FILTER_EVENT_ID_COUNT {
+				err = fmt.Errorf("too many stack walk EventIDs %d > %d", len(p.StackWalk.EventIDs), MAX_EVENT_FILTER_EVENT_ID_COUNT)
+				return
+			}
+		case 9:
+			if chunk == "" {
+				break
+			}
+
+			// parsing Opcodes
+			for _, name := range strings.Split(chunk, ",") {
+				var o uint8
+				if o, err = ParseOpcode(name, p.GUID, r); err != nil {
+					err = fmt.Errorf("failed to parse Opcode: %w", err)
+					return
+				}
+				p.Opcodes = append(p.Opcodes, o)
+			}
+		default:
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,190 @@
+package etw
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/toast"
+)
+
+const (
+	testKernelFileGUID = "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+)
+
+func newTestRegistry() *ProviderRegistry {
+	r := NewProviderRegistry(nil)
+	r.Add(
+		&ProviderInfo{
+			GUID: testKernelFileGUID,
+			Name: "Microsoft-Windows-Kernel-File",
+			Keywords: []ProviderField{
+				{Name: "KERNEL_FILE_KEYWORD_FILENAME", Value: 0x10},
+				{Name: "KERNEL_FILE_KEYWORD_FILEIO", Value: 0x20},
+				{Name: "KERNEL_FILE_KEYWORD_OP_END", Value: 0x40},
+			},
+			Levels: []ProviderField{
+				{Name: "win:Informational", Value: 4},
+				{Name: "Debug", Value: 6},
+			},
+			Opcodes: []ProviderField{
+				{Name: "win:Info", Value: 0},
+				{Name: "Create", Value: 12<<16 | 1},
+			},
+		},
+		// provider known without metadata
+		&ProviderInfo{
+			GUID: "{00000000-0000-0000-0000-000000000002}",
+			Name: "Test-Provider-Without-Metadata",
+		},
+	)
+	return r
+}
+
+func TestParseProviderFieldNames(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	r := newTestRegistry()
+
+	p, err := ParseProviderWith(r, "Microsoft-Windows-Kernel-File:verbose::KERNEL_FILE_KEYWORD_FILENAME|kernel_file_keyword_fileio:0x40|KERNEL_FILE_KEYWORD_OP_END")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableLevel == 5)
+	tt.Assert(p.MatchAnyKeyword == 0x30)
+	tt.Assert(p.MatchAllKeyword == 0x40)
+
+	// provider defined level
+	p, err = ParseProviderWith(r, "Microsoft-Windows-Kernel-File:Debug")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableLevel == 6)
+
+	// opcodes
+	p, err = ParseProviderWith(r, "Microsoft-Windows-Kernel-File:::::::::Info,create,0x20")
+	tt.CheckErr(err)
+	tt.Assert(len(p.Opcodes) == 3)
+	tt.Assert(p.Opcodes[0] == 0 && p.Opcodes[1] == 12 && p.Opcodes[2] == 0x20)
+
+	// numbers do not need metadata
+	p, err = ParseProviderWith(r, "Test-Provider-Without-Metadata:4::0x10|0x20")
+	tt.CheckErr(err)
+	tt.Assert(p.EnableLevel == 4 && p.MatchAnyKeyword == 0x30)
+	tt.Assert(p.GUID == "{00000000-0000-0000-0000-000000000002}")
+
+	for _, s := range []string{
+		"Microsoft-Windows-Kernel-File:unknown",
+		"Microsoft-Windows-Kernel-File:::KERNEL_FILE_KEYWORD_UNKNOWN",
+		"Microsoft-Windows-Kernel-File::::0x10|",
+		"Microsoft-Windows-Kernel-File:::::::::Create,Delete",
+	} {
+		_, err = ParseProviderWith(r, s)
+		tt.Assert(errors.Is(err, ErrUnknownFieldName), s, err)
+	}
+
+	// names cannot be resolved without metadata
+	_, err = ParseProviderWith(r, "Test-Provider-Without-Metadata:::KERNEL_FILE_KEYWORD_FILENAME")
+	tt.Assert(errors.Is(err, ErrUnknownFieldName))
+
+	_, err = ParseProviderWith(r, "Microsoft-Windows-Unknown")
+	tt.Assert(errors.Is(err, ErrUnkownProvider))
+}
+
+func TestParseFieldNames(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	r := newTestRegistry()
+
+	l, err := ParseLevel("win:Warning", testKernelFileGUID, nil)
+	tt.CheckErr(err)
+	tt.Assert(l == 3)
+
+	l, err = ParseLevel("informational", testKernelFileGUID, r)
+	tt.CheckErr(err)
+	tt.Assert(l == 4)
+
+	o, err := ParseOpcode("create", testKernelFileGUID, r)
+	tt.CheckErr(err)
+	tt.Assert(o == 12)
+
+	o, err = ParseOpcode("Info", testKernelFileGUID, r)
+	tt.CheckErr(err)
+	tt.Assert(o == 0)
+
+	_, err = ParseKeywords("KERNEL_FILE_KEYWORD_FILENAME", "{00000000-0000-0000-0000-000000000001}", r)
+	tt.Assert(err != nil && !errors.Is(err, ErrUnknownFieldName))
+
+	tt.Assert(EventTaskInformation.String() == "task")
+	tt.Assert(EventFieldType(42).String() == "EventFieldType(42)")
+}
+
+func TestDecodeProviderFieldInfoArray(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	utf16le := func(s string) (b []byte) {
+		for _, c := range utf16.Encode([]rune(s + "\x00")) {
+			b = append(b, byte(c), byte(c>>8))
+		}
+		return
+	}
+
+	// header, two PROVIDER_FIELD_INFO then strings
+	buf := make([]byte, 8+2*16)
+	binary.LittleEndian.PutUint32(buf, 2)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(EventKeywordInformation))
+
+	strs := [][]byte{utf16le("KERNEL_FILE_KEYWORD_FILENAME"), utf16le("File name"), utf16le("KERNEL_FILE_KEYWORD_FILEIO")}
+	offsets := make([]uint32, len(strs))
+	for i, s := range strs {
+		offsets[i] = uint32(len(buf))
+		buf = append(buf, s...)
+	}
+
+	binary.LittleEndian.PutUint32(buf[8:], offsets[0])
+	binary.LittleEndian.PutUint32(buf[12:], offsets[1])
+	binary.LittleEndian.PutUint64(buf[16:], 0x10)
+	binary.LittleEndian.PutUint32(buf[24:], offsets[2])
+	binary.LittleEndian.PutUint64(buf[32:], 0x20)
+
+	fields, err := DecodeProviderFieldInfoArray(buf)
+	tt.CheckErr(err)
+	tt.Assert(len(fields) == 2)
+	tt.Assert(fields[0] == ProviderField{"KERNEL_FILE_KEYWORD_FILENAME", "File name", 0x10}, fields[0])
+	tt.Assert(fields[1] == ProviderField{"KERNEL_FILE_KEYWORD_FILEIO", "", 0x20}, fields[1])
+
+	// truncated buffers
+	for _, b := range [][]byte{buf[:4], buf[:30], buf[:len(buf)-2]} {
+		_, err = DecodeProviderFieldInfoArray(b)
+		tt.Assert(errors.Is(err, ErrShortBuffer), len(b))
+	}
+}
+
+func TestDecodeProviderEventInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// header then two EVENT_DESCRIPTOR
+	buf := make([]byte, 8+2*16)
+	binary.LittleEndian.PutUint32(buf, 2)
+	binary.LittleEndian.PutUint16(buf[8:], 12)
+	copy(buf[10:], []byte{1, 16, 4, 0})
+	binary.LittleEndian.PutUint16(buf[14:], 300)
+	binary.LittleEndian.PutUint64(buf[16:], 0x8000000000000010)
+	binary.LittleEndian.PutUint16(buf[24:], 13)
+
+	descs, err := DecodeProviderEventInfo(buf)
+	tt.CheckErr(err)
+	tt.Assert(len(descs) == 2)
+	tt.Assert(descs[0] == EventDescriptor{Id: 12, Version: 1, Channel: 16, Level: 4, Task: 300, Keyword: 0x8000000000000010}, descs[0])
+	tt.Assert(descs[1] == EventDescriptor{Id: 13}, descs[1])
+
+	// truncated buffers
+	for _, b := range [][]byte{buf[:4], buf[:8], buf[:len(buf)-1]} {
+		_, err = DecodeProviderEventInfo(b)
+		tt.Assert(errors.Is(err, ErrShortBuffer), len(b))
+	}
+}
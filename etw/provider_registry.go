@@ -0,0 +1,266 @@
+package etw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultProviderRegistry is the registry used by ParseProvider,
+	// ResolveProvider and IsKnownProvider. On Windows it is lazily
+	// refreshed from the providers registered on the system, on other
+	// OSes it is empty until a catalog is loaded with LoadCatalog.
+	DefaultProviderRegistry = NewProviderRegistry(nil)
+)
+
+// ProviderResolver resolves providers from their name or GUID and gives
+// access to the fields (keywords, levels ...) they declare
+type ProviderResolver interface {
+	ProviderFieldLookup
+	// ResolveProvider returns a provider initialized from DefaultProvider
+	// given its name or GUID, and false if the provider is unknown
+	ResolveProvider(s string) (Provider, bool)
+}
+
+// ProviderSource lists the providers a ProviderRegistry is refreshed from
+type ProviderSource interface {
+	Providers() ([]*ProviderInfo, error)
+}
+
+// ProviderSourceFunc is a function implementing ProviderSource
+type ProviderSourceFunc func() ([]*ProviderInfo, error)
+
+// Providers implements ProviderSource
+func (f ProviderSourceFunc) Providers() ([]*ProviderInfo, error) {
+	return f()
+}
+
+// ProviderRegistry is a concurrency safe ProviderResolver indexing
+// providers by name and GUID. It is filled either from a ProviderSource,
+// with Add or from a JSON catalog (see SaveCatalog and LoadCatalog).
+type ProviderRegistry struct {
+	sync.RWMutex
+	source ProviderSource
+	loaded bool
+	byGUID map[string]*ProviderInfo
+	byName map[string]*ProviderInfo
+
+	// Lookup is used to get the fields of providers whose metadata
+	// is not known by the registry, it can be nil
+	Lookup ProviderFieldLookup
+}
+
+// NewProviderRegistry creates a new registry refreshed from source,
+// source can be nil. The registry is refreshed on first use.
+func NewProviderRegistry(source ProviderSource) *ProviderRegistry {
+	return &ProviderRegistry{
+		source: source,
+		byGUID: make(map[string]*ProviderInfo),
+		byName: make(map[string]*ProviderInfo),
+	}
+}
+
+// SetSource sets the source the registry is refreshed from. The registry
+// is refreshed on next use.
+func (r *ProviderRegistry) SetSource(source ProviderSource) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.source = source
+	r.loaded = false
+}
+
+// Refresh replaces the content of the registry by the providers listed
+// by its source. It is a no-op for registries without source.
+func (r *ProviderRegistry) Refresh() error {
+	r.RLock()
+	source := r.source
+	r.RUnlock()
+
+	if source == nil {
+		return nil
+	}
+
+	// source is queried without holding the lock as it may be slow
+	infos, err := source.Providers()
+	if err != nil {
+		return fmt.Errorf("failed to refresh provider registry: %w", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.byGUID = make(map[string]*ProviderInfo, len(infos))
+	r.byName = make(map[string]*ProviderInfo, len(infos))
+	r.add(infos...)
+	r.loaded = true
+
+	return nil
+}
+
+// ensureLoaded refreshes the registry if it has never been
+func (r *ProviderRegistry) ensureLoaded() {
+	r.RLock()
+	loaded := r.loaded || r.source == nil
+	r.RUnlock()
+
+	if !loaded {
+		// a failed refresh is retried on next use
+		r.Refresh()
+	}
+}
+
+func (r *ProviderRegistry) add(infos ...*ProviderInfo) {
+	for _, info := range infos {
+		if g, err := ParseGUID(info.GUID); err == nil {
+			info.GUID = g.String()
+		}
+
+		if old, ok := r.byGUID[info.GUID]; ok && old.Name != "" {
+			delete(r.byName, strings.ToLower(old.Name))
+		}
+
+		r.byGUID[info.GUID] = info
+		if info.Name != "" {
+			r.byName[strings.ToLower(info.Name)] = info
+		}
+	}
+}
+
+// Add adds providers to the registry, replacing the ones with the
+// same GUID
+func (r *ProviderRegistry) Add(infos ...*ProviderInfo) {
+	r.ensureLoaded()
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.add(infos...)
+}
+
+// Get returns the information known about a provider given its name
+// or GUID
+func (r *ProviderRegistry) Get(s string) (*ProviderInfo, bool) {
+	r.ensureLoaded()
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.get(s)
+}
+
+func (r *ProviderRegistry) get(s string) (info *ProviderInfo, ok bool) {
+	if g, err := ParseGUID(s); err == nil {
+		info, ok = r.byGUID[g.String()]
+		return
+	}
+
+	info, ok = r.byName[strings.ToLower(s)]
+	return
+}
+
+// Len returns the number of providers in the registry
+func (r *ProviderRegistry) Len() int {
+	r.ensureLoaded()
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.byGUID)
+}
+
+// ResolveProvider implements ProviderResolver
+func (r *ProviderRegistry) ResolveProvider(s string) (p Provider, ok bool) {
+	var info *ProviderInfo
+
+	if info, ok = r.Get(s); ok {
+		p = DefaultProvider
+		p.GUID = info.GUID
+		p.Name = info.Name
+	}
+
+	return
+}
+
+// ProviderFields implements ProviderFieldLookup. Fields are taken from
+// the provider metadata and the registry Lookup is used for providers
+// without metadata.
+func (r *ProviderRegistry) ProviderFields(guid string, typ EventFieldType) ([]ProviderField, error) {
+	r.ensureLoaded()
+
+	r.RLock()
+	info, ok := r.get(guid)
+	lookup := r.Lookup
+	r.RUnlock()
+
+	if ok && info.hasMetadata() {
+		return info.Fields(typ), nil
+	}
+
+	if lookup != nil {
+		return lookup.ProviderFields(guid, typ)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnkownProvider, guid)
+	}
+
+	return nil, nil
+}
+
+// SaveCatalog writes the providers of the registry to w as a JSON
+// array of ProviderInfo sorted by GUID
+func (r *ProviderRegistry) SaveCatalog(w io.Writer) error {
+	r.ensureLoaded()
+
+	r.RLock()
+	infos := make([]*ProviderInfo, 0, len(r.byGUID))
+	for _, info := range r.byGUID {
+		infos = append(infos, info)
+	}
+	r.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GUID < infos[j].GUID
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
+
+// LoadCatalog adds to the registry the providers of a catalog written
+// by SaveCatalog
+func (r *ProviderRegistry) LoadCatalog(rd io.Reader) error {
+	var infos []*ProviderInfo
+
+	if err := json.NewDecoder(rd).Decode(&infos); err != nil {
+		return fmt.Errorf("failed to decode provider catalog: %w", err)
+	}
+
+	for _, info := range infos {
+		if _, err := ParseGUID(info.GUID); err != nil {
+			return fmt.Errorf("invalid provider %q in catalog: %w", info.Name, err)
+		}
+	}
+
+	r.Add(infos...)
+	return nil
+}
+
+// ResolveProvider return a Provider structure given a GUID or
+// a provider name as input, using DefaultProviderRegistry
+func ResolveProvider(s string) (p Provider) {
+	p, _ = DefaultProviderRegistry.ResolveProvider(s)
+	return
+}
+
+// IsKnownProvider returns true if the provider is known
+func IsKnownProvider(p string) bool {
+	_, ok := DefaultProviderRegistry.ResolveProvider(p)
+	return ok
+}
@@ -0,0 +1,159 @@
+package etw
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestProviderRegistry(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	refreshes := 0
+	infos := []*ProviderInfo{
+		{GUID: "{00000000-0000-0000-0000-000000000001}", Name: "Test-Provider-One"},
+		{GUID: "00000000-0000-0000-0000-000000000002", Name: "Test-Provider-Two"},
+	}
+	r := NewProviderRegistry(ProviderSourceFunc(func() ([]*ProviderInfo, error) {
+		refreshes++
+		return infos, nil
+	}))
+
+	// lazily refreshed on first use
+	tt.Assert(refreshes == 0)
+	p, ok := r.ResolveProvider("test-provider-one")
+	tt.Assert(ok)
+	tt.Assert(p.Name == "Test-Provider-One" && p.EnableLevel == DefaultProvider.EnableLevel)
+	tt.Assert(refreshes == 1)
+
+	// GUIDs are normalized
+	p, ok = r.ResolveProvider("{00000000-0000-0000-0000-000000000002}")
+	tt.Assert(ok && p.GUID == "{00000000-0000-0000-0000-000000000002}", p.GUID)
+
+	// renaming a provider drops its former name
+	r.Add(&ProviderInfo{GUID: "{00000000-0000-0000-0000-000000000002}", Name: "Test-Provider-Renamed"})
+	_, ok = r.ResolveProvider("Test-Provider-Two")
+	tt.Assert(!ok)
+	tt.Assert(r.Len() == 2)
+
+	// refresh replaces registry content
+	infos = infos[:1]
+	tt.CheckErr(r.Refresh())
+	tt.Assert(refreshes == 2)
+	tt.Assert(r.Len() == 1)
+	_, ok = r.ResolveProvider("Test-Provider-Renamed")
+	tt.Assert(!ok)
+
+	errSource := errors.New("source error")
+	r.SetSource(ProviderSourceFunc(func() ([]*ProviderInfo, error) { return nil, errSource }))
+	tt.Assert(errors.Is(r.Refresh(), errSource))
+	// content is kept on failed refresh
+	tt.Assert(r.Len() == 1)
+}
+
+func TestProviderRegistryFields(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	r := newTestRegistry()
+
+	fields, err := r.ProviderFields(testKernelFileGUID, EventKeywordInformation)
+	tt.CheckErr(err)
+	tt.Assert(len(fields) == 3)
+
+	_, err = r.ProviderFields("{00000000-0000-0000-0000-000000000001}", EventKeywordInformation)
+	tt.Assert(errors.Is(err, ErrUnkownProvider))
+
+	// Lookup is used for providers without metadata
+	lookups := 0
+	r.Lookup = ProviderFieldLookupFunc(func(guid string, typ EventFieldType) ([]ProviderField, error) {
+		lookups++
+		return []ProviderField{{Name: "TEST_KEYWORD", Value: 0x1}}, nil
+	})
+
+	p, err := ParseProviderWith(r, "Test-Provider-Without-Metadata:::TEST_KEYWORD")
+	tt.CheckErr(err)
+	tt.Assert(p.MatchAnyKeyword == 0x1)
+	tt.Assert(lookups == 1)
+
+	_, err = ParseProviderWith(r, "Microsoft-Windows-Kernel-File:::TEST_KEYWORD")
+	tt.Assert(errors.Is(err, ErrUnknownFieldName))
+	tt.Assert(lookups == 1)
+}
+
+func TestProviderRegistryCatalog(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	f, err := os.Open(filepath.Join("testdata", "kernel-file-provider-catalog.json"))
+	tt.CheckErr(err)
+	defer f.Close()
+
+	r := NewProviderRegistry(nil)
+	tt.CheckErr(r.LoadCatalog(f))
+	tt.Assert(r.Len() == 2)
+
+	// validating configuration with catalog only
+	p, err := ParseProviderWith(r, "Microsoft-Windows-Kernel-File:informational::KERNEL_FILE_KEYWORD_FILENAME|KERNEL_FILE_KEYWORD_CREATE")
+	tt.CheckErr(err)
+	tt.Assert(p.GUID == testKernelFileGUID)
+	tt.Assert(p.EnableLevel == 4 && p.MatchAnyKeyword == 0x90)
+
+	info, ok := r.Get("Microsoft-Windows-Kernel-File")
+	tt.Assert(ok)
+	_, ok = info.Event(12, 1)
+	tt.Assert(ok)
+
+	// catalog round trip
+	buf := new(bytes.Buffer)
+	tt.CheckErr(r.SaveCatalog(buf))
+	saved := buf.String()
+
+	other := NewProviderRegistry(nil)
+	tt.CheckErr(other.LoadCatalog(buf))
+	tt.Assert(other.Len() == r.Len())
+
+	buf.Reset()
+	tt.CheckErr(other.SaveCatalog(buf))
+	tt.Assert(buf.String() == saved)
+
+	// invalid catalogs
+	tt.Assert(r.LoadCatalog(bytes.NewBufferString(`{"GUID": "not an array"}`)) != nil)
+	tt.Assert(r.LoadCatalog(bytes.NewBufferString(`[{"GUID": "invalid"}]`)) != nil)
+}
+
+func TestProviderRegistryConcurrency(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	r := NewProviderRegistry(ProviderSourceFunc(func() ([]*ProviderInfo, error) {
+		return []*ProviderInfo{{GUID: testKernelFileGUID, Name: "Microsoft-Windows-Kernel-File"}}, nil
+	}))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.ResolveProvider("Microsoft-Windows-Kernel-File")
+				if j%10 == 0 {
+					r.Refresh()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tt.Assert(r.Len() == 1)
+}
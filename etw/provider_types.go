@@ -0,0 +1,66 @@
+package etw
+
+import "fmt"
+
+var (
+	DefaultProvider = Provider{EnableLevel: 0xff}
+
+	// Error returned when a provider is not found on the system
+	ErrUnkownProvider = fmt.Errorf("unknown provider")
+)
+
+type ProviderMap map[string]*Provider
+
+type Provider struct {
+	GUID            string
+	Name            string
+	EnableLevel     uint8
+	MatchAnyKeyword uint64
+	MatchAllKeyword uint64
+	Filter          []uint16
+	// Filter lists event IDs to exclude rather than to include
+	FilterOut bool `json:",omitempty"`
+	// EVENT_ENABLE_PROPERTY_* flags used to enable the provider
+	EnableProperty uint32
+	// Process IDs events are filtered on (max MAX_EVENT_FILTER_PID_COUNT)
+	PIDs []uint32
+	// Executable names (without path) events are filtered on
+	ExecutableNames []string
+	// Filters applied by ETW on event payloads, only supported by real
+	// time sessions (AutoLogger.EnableProvider fails if any is set)
+	PayloadFilters []PayloadFilter
+	// Events stacks are collected for
+	StackWalk *StackWalkFilter `json:",omitempty"`
+	// Opcodes of the events to keep, ETW cannot filter on opcodes so they
+	// are only applied on the consumer side by a ProviderFilter
+	Opcodes []uint8 `json:",omitempty"`
+}
+
+// IsZero returns true if the provider is empty
+func (p *Provider) IsZero() bool {
+	return p.GUID == ""
+}
+
+// KernelEventIDFilter returns true if the event ID filter of the provider
+// can be applied by ETW. ETW cannot filter on more than
+// MAX_EVENT_FILTER_EVENT_ID_COUNT event IDs, in such a case the filter
+// has to be applied on the consumer side by a ProviderFilter.
+func (p *Provider) KernelEventIDFilter() bool {
+	return len(p.Filter) > 0 && len(p.Filter) <= MAX_EVENT_FILTER_EVENT_ID_COUNT
+}
+
+// StackWalkEnabled returns true if stacks are collected for some of the
+// events of the provider
+func (p *Provider) StackWalkEnabled() bool {
+	return p.StackWalk != nil && !p.StackWalk.IsZero()
+}
+
+// EnableProperties returns the EVENT_ENABLE_PROPERTY_* flags the provider
+// must be enabled with. EVENT_ENABLE_PROPERTY_STACK_TRACE is set when a
+// stack walk filter is defined as ETW ignores the filter otherwise.
+func (p *Provider) EnableProperties() uint32 {
+	if p.StackWalkEnabled() {
+		return p.EnableProperty | EVENT_ENABLE_PROPERTY_STACK_TRACE
+	}
+	return p.EnableProperty
+}
@@ -0,0 +1,477 @@
+package etw
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSamplingPolicy = fmt.Errorf("bad sampling policy")
+)
+
+// Clock gives the current time, it allows to control time in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock returning the system time
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// sampler decides whether events of a given key are kept
+type sampler interface {
+	sample(now time.Time) bool
+}
+
+// SamplingPolicy defines how events matching a SamplingRule are sampled
+type SamplingPolicy interface {
+	Validate() error
+	newSampler(now time.Time, rng *rand.Rand) sampler
+}
+
+// OneInN keeps the first event out of every N events
+type OneInN struct {
+	N uint64
+}
+
+// Validate implements SamplingPolicy
+func (p OneInN) Validate() error {
+	if p.N == 0 {
+		return fmt.Errorf("%w: N must not be zero", ErrSamplingPolicy)
+	}
+	return nil
+}
+
+func (p OneInN) newSampler(time.Time, *rand.Rand) sampler {
+	return &oneInNSampler{n: p.N}
+}
+
+type oneInNSampler struct {
+	n     uint64
+	count uint64
+}
+
+func (s *oneInNSampler) sample(time.Time) bool {
+	keep := s.count%s.n == 0
+	s.count++
+	return keep
+}
+
+// TokenBucket limits the rate of events to Rate events per second, with
+// bursts of at most Burst events
+type TokenBucket struct {
+	Rate  float64
+	Burst uint64
+}
+
+// Validate implements SamplingPolicy
+func (p TokenBucket) Validate() error {
+	if p.Rate <= 0 {
+		return fmt.Errorf("%w: Rate must be positive", ErrSamplingPolicy)
+	}
+	if p.Burst == 0 {
+		return fmt.Errorf("%w: Burst must not be zero", ErrSamplingPolicy)
+	}
+	return nil
+}
+
+func (p TokenBucket) newSampler(now time.Time, _ *rand.Rand) sampler {
+	// bucket starts full
+	return &tokenBucketSampler{p, float64(p.Burst), now}
+}
+
+type tokenBucketSampler struct {
+	TokenBucket
+	tokens float64
+	last   time.Time
+}
+
+func (s *tokenBucketSampler) sample(now time.Time) bool {
+	if elapsed := now.Sub(s.last); elapsed > 0 {
+		s.tokens += elapsed.Seconds() * s.Rate
+		if burst := float64(s.Burst); s.tokens > burst {
+			s.tokens = burst
+		}
+		s.last = now
+	}
+
+	if s.tokens >= 1 {
+		s.tokens--
+		return true
+	}
+
+	return false
+}
+
+// DecayingProbability keeps events with a probability decreasing along a
+// Window. The first Size events of a window are always kept, then the n-th
+// one is kept with probability Size/n. As sampling happens before events are
+// parsed, kept events cannot be held back until the end of the window and
+// are delivered at once. So, a window of n events delivers about
+// Size*(1+ln(n/Size)) events: the number of events delivered grows slowly
+// with the event rate but it is not bounded.
+type DecayingProbability struct {
+	Size   uint64
+	Window time.Duration
+}
+
+// Validate implements SamplingPolicy
+func (p DecayingProbability) Validate() error {
+	if p.Size == 0 {
+		return fmt.Errorf("%w: Size must not be zero", ErrSamplingPolicy)
+	}
+	if p.Window <= 0 {
+		return fmt.Errorf("%w: Window must be positive", ErrSamplingPolicy)
+	}
+	return nil
+}
+
+func (p DecayingProbability) newSampler(now time.Time, rng *rand.Rand) sampler {
+	return &decayingSampler{DecayingProbability: p, rng: rng, start: now}
+}
+
+type decayingSampler struct {
+	DecayingProbability
+	rng   *rand.Rand
+	start time.Time
+	count uint64
+}
+
+func (s *decayingSampler) roll(now time.Time) {
+	if elapsed := now.Sub(s.start); elapsed >= s.Window {
+		// windows are aligned on the first one
+		s.start = s.start.Add(elapsed - elapsed%s.Window)
+		s.count = 0
+	}
+}
+
+func (s *decayingSampler) sample(now time.Time) bool {
+	s.roll(now)
+
+	s.count++
+	if s.count <= s.Size {
+		return true
+	}
+
+	return uint64(s.rng.Int63n(int64(s.count))) < s.Size
+}
+
+// Reservoir keeps a uniform sample of at most Size events per Window.
+// Events are held until the end of their window, which delays their
+// delivery by up to Window. The n-th event of a window enters the reservoir
+// with probability Size/n, replacing a random event once the reservoir is
+// full, so events never entering it are dropped before being parsed.
+type Reservoir struct {
+	Size   uint64
+	Window time.Duration
+}
+
+// Validate implements SamplingPolicy
+func (p Reservoir) Validate() error {
+	return DecayingProbability(p).Validate()
+}
+
+func (p Reservoir) newSampler(now time.Time, rng *rand.Rand) sampler {
+	return &reservoirSampler{decayingSampler: decayingSampler{DecayingProbability(p), rng, now, 0}}
+}
+
+// holdingSampler is implemented by samplers holding kept events until
+// the end of a window
+type holdingSampler interface {
+	sampler
+	// hold holds a kept event and returns the event it replaced, if any
+	hold(e *Event) *Event
+	// release returns the held events whose window elapsed
+	release(now time.Time) []*Event
+	// flush returns all the held events
+	flush() []*Event
+}
+
+type reservoirSampler struct {
+	decayingSampler
+	held  []*Event
+	ready []*Event
+}
+
+func (s *reservoirSampler) roll(now time.Time) {
+	if now.Sub(s.start) >= s.Window {
+		s.ready = append(s.ready, s.held...)
+		s.held = nil
+	}
+	// resets the window if needed
+	s.decayingSampler.roll(now)
+}
+
+func (s *reservoirSampler) sample(now time.Time) bool {
+	s.roll(now)
+	return s.decayingSampler.sample(now)
+}
+
+func (s *reservoirSampler) hold(e *Event) (replaced *Event) {
+	if uint64(len(s.held)) < s.Size {
+		s.held = append(s.held, e)
+		return
+	}
+
+	i := s.rng.Intn(len(s.held))
+	replaced, s.held[i] = s.held[i], e
+	return
+}
+
+func (s *reservoirSampler) release(now time.Time) (out []*Event) {
+	s.roll(now)
+	out, s.ready = s.ready, nil
+	return
+}
+
+func (s *reservoirSampler) flush() (out []*Event) {
+	out = append(s.ready, s.held...)
+	s.ready, s.held = nil, nil
+	return
+}
+
+// SamplingRule applies a SamplingPolicy to the events of a provider
+type SamplingRule struct {
+	// Provider GUID, empty to match all providers
+	Provider string
+	// Event IDs the rule applies to, each of them being sampled separately.
+	// When empty, all the events of the provider are sampled together.
+	EventIDs []uint16
+	Policy   SamplingPolicy
+}
+
+func (r *SamplingRule) key(id uint16, anyID bool) string {
+	if anyID {
+		return r.Provider
+	}
+	return fmt.Sprintf("%s:%d", r.Provider, id)
+}
+
+// SamplingStats holds the number of events kept and dropped for a key
+type SamplingStats struct {
+	Kept    uint64
+	Dropped uint64
+}
+
+type samplingState struct {
+	sampler sampler
+	stats   SamplingStats
+}
+
+// SamplingStage drops events according to SamplingRules. Rules are looked
+// up from the most to the least specific: provider and event ID, provider,
+// event ID for any provider and finally any event of any provider. Events
+// not matching any rule are always kept.
+type SamplingStage struct {
+	sync.Mutex
+	rules  map[string]*SamplingRule
+	states map[string]*samplingState
+
+	// Clock used to compute rates and windows, SystemClock by default
+	Clock Clock
+	// Random source used by DecayingProbability and Reservoir policies
+	Rand *rand.Rand
+}
+
+// NewSamplingStage creates a new SamplingStage from rules
+func NewSamplingStage(rules ...SamplingRule) (s *SamplingStage, err error) {
+	s = &SamplingStage{
+		rules:  make(map[string]*SamplingRule),
+		states: make(map[string]*samplingState),
+		Clock:  SystemClock{},
+		Rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i := range rules {
+		r := rules[i]
+
+		if r.Policy == nil {
+			return nil, fmt.Errorf("%w: missing policy", ErrSamplingPolicy)
+		}
+
+		if err = r.Policy.Validate(); err != nil {
+			return nil, err
+		}
+
+		if r.Provider != "" {
+			var guid *GUID
+			if guid, err = ParseGUID(r.Provider); err != nil {
+				return nil, fmt.Errorf("%w: bad provider %s: %s", ErrSamplingPolicy, r.Provider, err)
+			}
+			// normalize GUID so that it matches IEvent.ProviderGUID
+			r.Provider = guid.String()
+		}
+
+		keys := make([]string, 0, len(r.EventIDs))
+		if len(r.EventIDs) == 0 {
+			keys = append(keys, r.key(0, true))
+		}
+		for _, id := range r.EventIDs {
+			keys = append(keys, r.key(id, false))
+		}
+
+		for _, k := range keys {
+			if _, ok := s.rules[k]; ok {
+				return nil, fmt.Errorf("%w: several rules for %q", ErrSamplingPolicy, k)
+			}
+			s.rules[k] = &r
+		}
+	}
+
+	return
+}
+
+func (s *SamplingStage) lookup(guid string, id uint16) (r *SamplingRule, key string) {
+	var ok bool
+
+	p := SamplingRule{Provider: guid}
+	wildcard := SamplingRule{}
+	for _, k := range []string{p.key(id, false), p.key(id, true), wildcard.key(id, false), wildcard.key(id, true)} {
+		if r, ok = s.rules[k]; ok {
+			// state is kept per provider even for rules matching any provider
+			return r, p.key(id, len(r.EventIDs) == 0)
+		}
+	}
+
+	return
+}
+
+// Sample returns true if the event must be kept
+func (s *SamplingStage) Sample(e IEvent) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.rules) == 0 {
+		return true
+	}
+
+	r, key := s.lookup(e.ProviderGUID(), e.EventID())
+	if r == nil {
+		return true
+	}
+
+	now := s.Clock.Now()
+	state, ok := s.states[key]
+	if !ok {
+		state = &samplingState{sampler: r.Policy.newSampler(now, s.Rand)}
+		s.states[key] = state
+	}
+
+	if state.sampler.sample(now) {
+		state.stats.Kept++
+		return true
+	}
+
+	state.stats.Dropped++
+	return false
+}
+
+// Process returns the events ready to be output: the event itself, unless
+// it is held by a Reservoir, and the events whose Reservoir window elapsed.
+// Events must have been kept by Sample first.
+func (s *SamplingStage) Process(e *Event) (out []*Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.Clock.Now()
+	out = s.expire(now)
+
+	if len(s.rules) == 0 {
+		return append(out, e)
+	}
+
+	_, key := s.lookup(e.ProviderGUID(), e.EventID())
+	state, ok := s.states[key]
+	if !ok {
+		return append(out, e)
+	}
+
+	h, ok := state.sampler.(holdingSampler)
+	if !ok {
+		return append(out, e)
+	}
+
+	if h.hold(e) != nil {
+		state.stats.Kept--
+		state.stats.Dropped++
+	}
+
+	return
+}
+
+func (s *SamplingStage) expire(now time.Time) (out []*Event) {
+	for _, state := range s.states {
+		if h, ok := state.sampler.(holdingSampler); ok {
+			out = append(out, h.release(now)...)
+		}
+	}
+	return
+}
+
+// Expire returns the events whose Reservoir window elapsed. It must be
+// called regularly so that events are output even if no other event is
+// processed.
+func (s *SamplingStage) Expire() []*Event {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.expire(s.Clock.Now())
+}
+
+// Flush returns all the events held by Reservoir policies
+func (s *SamplingStage) Flush() (out []*Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, state := range s.states {
+		if h, ok := state.sampler.(holdingSampler); ok {
+			out = append(out, h.flush()...)
+		}
+	}
+
+	return
+}
+
+// holdWindow returns the shortest Reservoir window, zero if no policy
+// holds events
+func (s *SamplingStage) holdWindow() (w time.Duration) {
+	for _, r := range s.rules {
+		if p, ok := r.Policy.(Reservoir); ok && (w == 0 || p.Window < w) {
+			w = p.Window
+		}
+	}
+	return
+}
+
+// Stats returns sampling statistics by key, keys being either a provider
+// GUID or a provider GUID and an event ID separated by a colon. Events held
+// by a Reservoir are counted as kept until they get replaced.
+func (s *SamplingStage) Stats() map[string]SamplingStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := make(map[string]SamplingStats, len(s.states))
+	for k, state := range s.states {
+		stats[k] = state.stats
+	}
+
+	return stats
+}
+
+// Dropped returns the total number of events dropped
+func (s *SamplingStage) Dropped() (n uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, state := range s.states {
+		n += state.stats.Dropped
+	}
+
+	return
+}
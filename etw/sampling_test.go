@@ -0,0 +1,285 @@
+package etw
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestSamplingStage(t *testing.T, rules ...SamplingRule) (*SamplingStage, *fakeClock) {
+	s, err := NewSamplingStage(rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.Clock = clock
+	s.Rand = rand.New(rand.NewSource(42))
+	return s, clock
+}
+
+const (
+	samplingFileIo  = "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+	samplingNetwork = "{7DD42A49-5329-4832-8DFD-43D979153A88}"
+)
+
+func TestSamplingOneInN(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// rule provider GUID is normalized
+	s, _ := newTestSamplingStage(t, SamplingRule{
+		Provider: "edd08927-9cc4-4e65-b970-c2560fb5c289",
+		Policy:   OneInN{3},
+	})
+
+	kept := 0
+	for i := 0; i < 9; i++ {
+		if s.Sample(testIEvent{samplingFileIo, uint16(i)}) {
+			kept++
+		}
+	}
+	tt.Assert(kept == 3)
+	// other providers are not sampled
+	tt.Assert(s.Sample(testIEvent{samplingNetwork, 10}))
+
+	stats := s.Stats()
+	tt.Assert(len(stats) == 1)
+	tt.Assert(stats[samplingFileIo] == SamplingStats{Kept: 3, Dropped: 6}, stats)
+	tt.Assert(s.Dropped() == 6)
+}
+
+func TestSamplingTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, clock := newTestSamplingStage(t, SamplingRule{
+		Provider: samplingNetwork,
+		EventIDs: []uint16{10, 11},
+		Policy:   TokenBucket{Rate: 2, Burst: 4},
+	})
+
+	sample := func(id uint16, n int) (kept int) {
+		for i := 0; i < n; i++ {
+			if s.Sample(testIEvent{samplingNetwork, id}) {
+				kept++
+			}
+		}
+		return
+	}
+
+	// bucket starts full
+	tt.Assert(sample(10, 10) == 4)
+	// event IDs have their own bucket
+	tt.Assert(sample(11, 10) == 4)
+	// other events are not sampled
+	tt.Assert(sample(12, 10) == 10)
+
+	clock.Advance(time.Second)
+	tt.Assert(sample(10, 10) == 2)
+
+	// bucket does not refill above Burst
+	clock.Advance(time.Hour)
+	tt.Assert(sample(10, 10) == 4)
+
+	clock.Advance(250 * time.Millisecond)
+	tt.Assert(sample(10, 10) == 0)
+	clock.Advance(250 * time.Millisecond)
+	tt.Assert(sample(10, 10) == 1)
+
+	stats := s.Stats()
+	tt.Assert(stats[samplingNetwork+":10"] == SamplingStats{Kept: 11, Dropped: 39}, stats)
+	tt.Assert(stats[samplingNetwork+":11"] == SamplingStats{Kept: 4, Dropped: 6}, stats)
+	_, ok := stats[samplingNetwork+":12"]
+	tt.Assert(!ok)
+}
+
+func TestSamplingDecayingProbability(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, clock := newTestSamplingStage(t, SamplingRule{
+		Policy: DecayingProbability{Size: 10, Window: time.Minute},
+	})
+
+	window := func(guid string, n int) (kept int) {
+		for i := 0; i < n; i++ {
+			if s.Sample(testIEvent{guid, 1}) {
+				kept++
+			}
+		}
+		return
+	}
+
+	// first Size events of a window are kept
+	tt.Assert(window(samplingFileIo, 10) == 10)
+	kept := window(samplingFileIo, 990)
+	// expected around Size*ln(1000/Size) ~ 46
+	tt.Assert(kept > 20 && kept < 80, kept)
+
+	// rules matching any provider are applied per provider
+	tt.Assert(window(samplingNetwork, 10) == 10)
+
+	// new window
+	clock.Advance(90 * time.Second)
+	tt.Assert(window(samplingFileIo, 10) == 10)
+	// window is aligned so it ends 30s later
+	clock.Advance(30 * time.Second)
+	tt.Assert(window(samplingFileIo, 10) == 10)
+
+	stats := s.Stats()
+	tt.Assert(len(stats) == 2)
+	tt.Assert(stats[samplingFileIo].Kept+stats[samplingFileIo].Dropped == 1020)
+	tt.Assert(stats[samplingNetwork] == SamplingStats{Kept: 10})
+}
+
+func TestSamplingReservoir(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, clock := newTestSamplingStage(t, SamplingRule{
+		Provider: samplingFileIo,
+		Policy:   Reservoir{Size: 10, Window: time.Minute},
+	})
+
+	// events are output by Process or Expire only
+	window := func(n int) (out []*Event) {
+		for i := 0; i < n; i++ {
+			e := NewEvent()
+			e.System.Provider.Guid = *MustParseGUIDFromString(samplingFileIo)
+			e.System.EventID = 1
+			e.EventData["Index"] = i
+			if s.Sample(e) {
+				out = append(out, s.Process(e)...)
+			}
+		}
+		return
+	}
+
+	for _, n := range []int{1000, 5, 10000} {
+		tt.Assert(len(window(n)) == 0)
+		tt.Assert(len(s.Expire()) == 0)
+
+		clock.Advance(time.Minute)
+		out := s.Expire()
+		if n < 10 {
+			tt.Assert(len(out) == n, len(out))
+			continue
+		}
+		// at most Size events per window
+		tt.Assert(len(out) == 10, len(out))
+		// held events are not only the first ones of the window
+		late := 0
+		for _, e := range out {
+			if e.EventData["Index"].(int) >= 10 {
+				late++
+			}
+		}
+		tt.Assert(late > 0)
+	}
+
+	stats := s.Stats()[samplingFileIo]
+	tt.Assert(stats == SamplingStats{Kept: 25, Dropped: 10980}, stats)
+
+	// events of an elapsed window are released by the next event
+	window(3)
+	clock.Advance(time.Minute)
+	tt.Assert(len(window(1)) == 3)
+	tt.Assert(len(s.Flush()) == 1)
+	tt.Assert(len(s.Flush()) == 0)
+
+	// other events are not held
+	e := NewEvent()
+	tt.Assert(s.Sample(e))
+	tt.Assert(len(s.Process(e)) == 1)
+}
+
+func TestSamplingRuleLookup(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, _ := newTestSamplingStage(t,
+		// drop everything but the first event
+		SamplingRule{Policy: OneInN{1000}},
+		SamplingRule{EventIDs: []uint16{1}, Policy: OneInN{1}},
+		SamplingRule{Provider: samplingFileIo, Policy: OneInN{2}},
+		SamplingRule{Provider: samplingFileIo, EventIDs: []uint16{1}, Policy: OneInN{3}},
+	)
+
+	count := func(guid string, id uint16) (kept int) {
+		for i := 0; i < 6; i++ {
+			if s.Sample(testIEvent{guid, id}) {
+				kept++
+			}
+		}
+		return
+	}
+
+	tt.Assert(count(samplingFileIo, 1) == 2)
+	tt.Assert(count(samplingFileIo, 2) == 3)
+	tt.Assert(count(samplingNetwork, 1) == 6)
+	tt.Assert(count(samplingNetwork, 2) == 1)
+
+	stats := s.Stats()
+	for _, k := range []string{
+		samplingFileIo + ":1",
+		samplingFileIo,
+		samplingNetwork + ":1",
+		samplingNetwork,
+	} {
+		_, ok := stats[k]
+		tt.Assert(ok, k)
+	}
+}
+
+func TestSamplingErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	for _, r := range []SamplingRule{
+		{},
+		{Policy: OneInN{}},
+		{Policy: TokenBucket{Rate: 0, Burst: 1}},
+		{Policy: TokenBucket{Rate: 1}},
+		{Policy: DecayingProbability{Size: 1}},
+		{Policy: DecayingProbability{Window: time.Second}},
+		{Policy: Reservoir{Size: 1}},
+		{Policy: Reservoir{Window: time.Second}},
+		{Provider: "not a guid", Policy: OneInN{1}},
+	} {
+		_, err := NewSamplingStage(r)
+		tt.Assert(errors.Is(err, ErrSamplingPolicy), r)
+	}
+
+	_, err := NewSamplingStage(
+		SamplingRule{Provider: samplingFileIo, EventIDs: []uint16{1, 2}, Policy: OneInN{1}},
+		SamplingRule{Provider: samplingFileIo, EventIDs: []uint16{2}, Policy: OneInN{1}},
+	)
+	tt.Assert(errors.Is(err, ErrSamplingPolicy))
+
+	// empty stage keeps everything
+	s, err := NewSamplingStage()
+	tt.CheckErr(err)
+	tt.Assert(s.Sample(testIEvent{samplingFileIo, 1}))
+	tt.Assert(len(s.Stats()) == 0)
+}
@@ -0,0 +1,249 @@
+package etw
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+)
+
+const (
+	DefaultSchemaCacheCapacity = 4096
+)
+
+// eventMetadata holds the validated information of an event and the value
+// maps used by its properties
+type eventMetadata struct {
+	sync.Mutex
+	key    schemaKey
+	info   *TraceEventInfo
+	schema *EventSchema
+	maps   map[string]*EventMapInfo
+}
+
+// loadEventMetadata gets the information of an event from backend and
+// validates it so that TraceEventInfo accessors never read out of it
+func loadEventMetadata(backend eventInfoBackend, er *EventRecord) (*eventMetadata, error) {
+	buf, err := backend.eventInformation(er)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := ParseTraceEventInfo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventMetadata{
+		info:   (*TraceEventInfo)(unsafe.Pointer(&buf[0])),
+		schema: schema,
+		maps:   make(map[string]*EventMapInfo),
+	}, nil
+}
+
+// mapInformation returns a value map used by the event, maps are loaded
+// from backend only once. It returns nil without error if the map is not
+// found.
+func (m *eventMetadata) mapInformation(backend eventInfoBackend, er *EventRecord, name string) (*EventMapInfo, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if mapInfo, ok := m.maps[name]; ok {
+		return mapInfo, nil
+	}
+
+	buf, err := backend.mapInformation(er, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapInfo *EventMapInfo
+	if buf != nil {
+		if _, err = ParseEventMapInfo(buf); err != nil {
+			return nil, err
+		}
+
+		mapInfo = (*EventMapInfo)(unsafe.Pointer(&buf[0]))
+		if m.info.DecodingSource == DecodingSourceXMLFile {
+			mapInfo.RemoveTrailingSpace()
+		}
+	}
+
+	// maps not found are cached as well
+	m.maps[name] = mapInfo
+
+	return mapInfo, nil
+}
+
+// schemaKey identifies the schema of an event, opcode is only part of the
+// key of MOF events
+type schemaKey struct {
+	provider GUID
+	id       uint16
+	version  uint8
+	opcode   uint8
+}
+
+// schemaKeyOf returns the key of the schema of an event. Events whose
+// schema cannot be identified by their header, like WPP or TraceLogging
+// events, cannot be cached.
+func schemaKeyOf(er *EventRecord) (k schemaKey, ok bool) {
+	h := &er.EventHeader
+
+	if h.Flags&EVENT_HEADER_FLAG_TRACE_MESSAGE != 0 {
+		return
+	}
+
+	for i := uint16(0); i < er.ExtendedDataCount; i++ {
+		if er.ExtendedDataItem(i).ExtType == EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL {
+			return
+		}
+	}
+
+	k.provider = h.ProviderId
+	k.id = h.EventDescriptor.Id
+	k.version = h.EventDescriptor.Version
+	if h.Flags&EVENT_HEADER_FLAG_CLASSIC_HEADER != 0 {
+		k.opcode = h.EventDescriptor.Opcode
+	}
+
+	return k, true
+}
+
+// SchemaCacheStats holds the statistics of a SchemaCache
+type SchemaCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+}
+
+// SchemaCache caches the information of events, and of the value maps they
+// use, so that TDH is not queried for every event. Schemas are keyed by
+// provider, event ID, version and opcode for MOF events. Memory is bounded
+// as at most Capacity schemas are kept, least recently used ones being
+// evicted first. It is safe to use concurrently.
+type SchemaCache struct {
+	sync.Mutex
+	lru     *list.List
+	entries map[schemaKey]*list.Element
+	stats   SchemaCacheStats
+
+	Capacity int
+}
+
+// NewSchemaCache creates a new SchemaCache holding at most capacity
+// schemas, DefaultSchemaCacheCapacity is used if capacity is not positive
+func NewSchemaCache(capacity int) *SchemaCache {
+	if capacity <= 0 {
+		capacity = DefaultSchemaCacheCapacity
+	}
+
+	return &SchemaCache{
+		lru:      list.New(),
+		entries:  make(map[schemaKey]*list.Element),
+		Capacity: capacity,
+	}
+}
+
+// eventMetadata returns the metadata of an event record, loading it from
+// backend if it is not cached. A nil cache always loads metadata.
+func (c *SchemaCache) eventMetadata(backend eventInfoBackend, er *EventRecord) (m *eventMetadata, err error) {
+	key, ok := schemaKeyOf(er)
+	if c == nil || !ok {
+		return loadEventMetadata(backend, er)
+	}
+
+	c.Lock()
+	if elt, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elt)
+		c.stats.Hits++
+		c.Unlock()
+		return elt.Value.(*eventMetadata), nil
+	}
+	c.stats.Misses++
+	c.Unlock()
+
+	// backend is not queried with the lock held
+	if m, err = loadEventMetadata(backend, er); err != nil {
+		return
+	}
+	m.key = key
+
+	c.Lock()
+	defer c.Unlock()
+
+	// metadata might have been loaded concurrently
+	if elt, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elt)
+		return elt.Value.(*eventMetadata), nil
+	}
+
+	c.entries[key] = c.lru.PushFront(m)
+	for c.lru.Len() > c.Capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+
+	return
+}
+
+func (c *SchemaCache) remove(elt *list.Element) {
+	m := c.lru.Remove(elt).(*eventMetadata)
+	delete(c.entries, m.key)
+}
+
+// invalidate removes metadata from the cache if it is still cached
+func (c *SchemaCache) invalidate(m *eventMetadata) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elt, ok := c.entries[m.key]; ok && elt.Value == m {
+		c.remove(elt)
+		c.stats.Invalidations++
+	}
+}
+
+// Invalidate removes all the schemas of a provider, it must be called when
+// a provider registers a new manifest. MOF event schemas are removed given
+// their event class GUID.
+func (c *SchemaCache) Invalidate(provider GUID) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, elt := range c.entries {
+		if key.provider.Equals(&provider) {
+			c.remove(elt)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// Purge removes all the schemas
+func (c *SchemaCache) Purge() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stats.Invalidations += uint64(c.lru.Len())
+	c.lru.Init()
+	c.entries = make(map[schemaKey]*list.Element)
+}
+
+// Len returns the number of schemas cached
+func (c *SchemaCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len()
+}
+
+// Stats returns the statistics of the cache
+func (c *SchemaCache) Stats() SchemaCacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.stats
+}
@@ -0,0 +1,254 @@
+package etw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+// newFakeMetadataBackend creates a fakeBackend with the value maps used
+// by schema tests
+func newFakeMetadataBackend(t *testing.T) *fakeBackend {
+	b := newFakeBackend()
+	b.maps["FileShareMap"] = readTestData(t, "file-share.emi.bin")
+	// a single truncated entry
+	b.maps["BrokenMap"] = []byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}
+	return b
+}
+
+func fakeRecord(provider GUID, id uint16, version, opcode uint8) *EventRecord {
+	er := &EventRecord{}
+	er.EventHeader.ProviderId = provider
+	er.EventHeader.EventDescriptor.Id = id
+	er.EventHeader.EventDescriptor.Version = version
+	er.EventHeader.EventDescriptor.Opcode = opcode
+	return er
+}
+
+func TestSchemaCache(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	src := newFakeMetadataBackend(t)
+	c := NewSchemaCache(2)
+	provider := *fakeProviderGUID
+	other := *MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")
+	src.names[other] = "Microsoft-Windows-Kernel-File"
+
+	get := func(er *EventRecord) *eventMetadata {
+		m, err := c.eventMetadata(src, er)
+		tt.CheckErr(err)
+		return m
+	}
+
+	m := get(fakeRecord(provider, 1, 0, 0))
+	tt.Assert(m.schema.ProviderName == fakeProviderName)
+	tt.Assert(m.info.EventID() == 1)
+	tt.Assert(get(fakeRecord(provider, 1, 0, 0)) == m)
+	// opcode is not part of the key of manifest events
+	tt.Assert(get(fakeRecord(provider, 1, 0, 11)) == m)
+	tt.Assert(src.infoCalls == 1)
+	tt.Assert(c.Stats() == SchemaCacheStats{Hits: 2, Misses: 1})
+
+	// version is part of the key
+	tt.Assert(get(fakeRecord(provider, 1, 1, 0)) != m)
+	tt.Assert(src.infoCalls == 2)
+
+	// opcode is part of the key of MOF events
+	mof1 := fakeRecord(other, 0, 2, 1)
+	mof1.EventHeader.Flags = EVENT_HEADER_FLAG_CLASSIC_HEADER
+	mof2 := fakeRecord(other, 0, 2, 2)
+	mof2.EventHeader.Flags = EVENT_HEADER_FLAG_CLASSIC_HEADER
+	tt.Assert(get(mof1) != get(mof2))
+	tt.Assert(src.infoCalls == 4)
+
+	// capacity is bounded, least recently used schema is evicted
+	tt.Assert(c.Len() == 2)
+	tt.Assert(c.Stats().Evictions == 2)
+	get(mof2)
+	tt.Assert(src.infoCalls == 4)
+	get(fakeRecord(provider, 3, 0, 0))
+	get(mof1)
+	tt.Assert(src.infoCalls == 6)
+	tt.Assert(c.Stats().Evictions == 4)
+
+	// invalidation
+	c.Invalidate(other)
+	tt.Assert(c.Len() == 1)
+	tt.Assert(c.Stats().Invalidations == 1)
+	get(mof1)
+	c.Purge()
+	tt.Assert(c.Len() == 0)
+	tt.Assert(c.Stats().Invalidations == 3)
+
+	// events whose schema is in the record are not cached
+	wpp := fakeRecord(provider, 1, 0, 0)
+	wpp.EventHeader.Flags = EVENT_HEADER_FLAG_TRACE_MESSAGE
+	tl := fakeRecord(provider, 1, 0, 0)
+	item := EventHeaderExtendedDataItem{ExtType: EVENT_HEADER_EXT_TYPE_EVENT_SCHEMA_TL}
+	tl.ExtendedData = &item
+	tl.ExtendedDataCount = 1
+	calls := src.infoCalls
+	stats := c.Stats()
+	for i := 0; i < 2; i++ {
+		get(wpp)
+		get(tl)
+	}
+	tt.Assert(src.infoCalls == calls+4)
+	tt.Assert(c.Stats() == stats)
+	tt.Assert(c.Len() == 0)
+
+	// errors are not cached
+	src.infoErr = errors.New("information error")
+	_, err := c.eventMetadata(src, fakeRecord(provider, 2, 0, 0))
+	tt.Assert(errors.Is(err, src.infoErr))
+	src.infoErr = nil
+	get(fakeRecord(provider, 2, 0, 0))
+	tt.Assert(c.Len() == 1)
+
+	// nil cache always queries source
+	calls = src.infoCalls
+	var nilCache *SchemaCache
+	m, err = nilCache.eventMetadata(src, fakeRecord(provider, 1, 0, 0))
+	tt.CheckErr(err)
+	nilCache.invalidate(m)
+	tt.Assert(src.infoCalls == calls+1)
+}
+
+func TestSchemaCacheMalformed(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	c := NewSchemaCache(0)
+	tt.Assert(c.Capacity == DefaultSchemaCacheCapacity)
+
+	buf := newFakeEventInformation(*fakeProviderGUID, fakeProviderName, 1)
+	_, err := loadEventMetadata(newFakeBackendWithInfo(buf[:len(buf)-1]), fakeRecord(*fakeProviderGUID, 1, 0, 0))
+	tt.Assert(errors.Is(err, ErrTruncatedInfo))
+
+	_, err = c.eventMetadata(newFakeBackendWithInfo([]byte{}), fakeRecord(*fakeProviderGUID, 1, 0, 0))
+	tt.Assert(errors.Is(err, ErrTruncatedInfo))
+	tt.Assert(c.Len() == 0)
+}
+
+func TestSchemaCacheMaps(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	src := newFakeMetadataBackend(t)
+	er := fakeRecord(*fakeProviderGUID, 1, 0, 0)
+	m, err := NewSchemaCache(1).eventMetadata(src, er)
+	tt.CheckErr(err)
+
+	for i := 0; i < 2; i++ {
+		mapInfo, err := m.mapInformation(src, er, "FileShareMap")
+		tt.CheckErr(err)
+		tt.Assert(mapInfo.EntryCount == 3)
+		// trailing spaces of manifest maps are removed
+		entry := mapInfo.GetEventMapEntryAt(0)
+		tt.Assert(UTF16PtrToString((*uint16)(unsafe.Add(unsafe.Pointer(mapInfo), entry.OutputOffset))) == "FILE_SHARE_READ")
+
+		// missing maps are cached too
+		mapInfo, err = m.mapInformation(src, er, "MissingMap")
+		tt.CheckErr(err)
+		tt.Assert(mapInfo == nil)
+	}
+	tt.Assert(src.mapCalls == 2)
+
+	_, err = m.mapInformation(src, er, "BrokenMap")
+	tt.Assert(errors.Is(err, ErrTruncatedInfo))
+}
+
+func TestSchemaCacheConcurrency(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	var wg sync.WaitGroup
+
+	src := newFakeMetadataBackend(t)
+	c := NewSchemaCache(8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := uint16(0); id < 100; id++ {
+				er := fakeRecord(*fakeProviderGUID, id%16, 0, 0)
+				m, err := c.eventMetadata(src, er)
+				if err != nil || m.schema.EventDescriptor.Id != id%16 {
+					t.Error("unexpected metadata", err)
+					return
+				}
+				if id%10 == 0 {
+					c.Invalidate(*fakeProviderGUID)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	stats := c.Stats()
+	tt.Assert(stats.Hits+stats.Misses == 800)
+	tt.Assert(c.Len() <= 8)
+}
+
+func TestConsumerSchemaCache(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	b := newFakeBackend()
+	for i := 0; i < 10; i++ {
+		b.inject("trace.etl", 42, uint32(i))
+		b.inject("trace.etl", 43, uint32(i))
+	}
+
+	c := newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+
+	tt.Assert(len(c.Events) == 20)
+	tt.Assert(b.infoCalls == 2)
+	tt.Assert(c.Schemas.Stats() == SchemaCacheStats{Hits: 18, Misses: 2})
+
+	// schemas failing to prepare event properties are invalidated
+	b = newFakeBackend()
+	for i := 0; i < 10; i++ {
+		// truncated user data
+		b.injectData("trace.etl", 42, []byte{0, 0})
+	}
+
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+
+	tt.Assert(b.infoCalls == 10)
+	tt.Assert(c.Schemas.Stats().Invalidations == 10)
+	tt.Assert(c.Schemas.Len() == 0)
+
+	// caching can be disabled
+	b = newFakeBackend()
+	for i := 0; i < 10; i++ {
+		b.inject("trace.etl", 42, uint32(i))
+	}
+
+	c = newConsumer(context.Background(), b).FromLogFiles("trace.etl")
+	c.Schemas = nil
+	tt.CheckErr(c.Start())
+	<-c.Completed()
+	tt.CheckErr(c.Stop())
+
+	tt.Assert(len(c.Events) == 10)
+	tt.Assert(b.infoCalls == 10)
+}
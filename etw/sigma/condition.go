@@ -0,0 +1,271 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrConditionSyntax = fmt.Errorf("condition syntax error")
+)
+
+type condNode interface {
+	eval(*matchContext) bool
+}
+
+type condAnd []condNode
+
+func (n condAnd) eval(c *matchContext) bool {
+	for _, e := range n {
+		if !e.eval(c) {
+			return false
+		}
+	}
+	return true
+}
+
+type condOr []condNode
+
+func (n condOr) eval(c *matchContext) bool {
+	for _, e := range n {
+		if e.eval(c) {
+			return true
+		}
+	}
+	return false
+}
+
+type condNot struct {
+	expr condNode
+}
+
+func (n condNot) eval(c *matchContext) bool {
+	return !n.expr.eval(c)
+}
+
+type condSearch struct {
+	s search
+}
+
+func (n condSearch) eval(c *matchContext) bool {
+	return n.s.match(c)
+}
+
+type condToken struct {
+	text string
+	pos  int
+}
+
+func (t condToken) String() string {
+	if t.text == "" {
+		return "end of condition"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isCondIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '*' || r == '-' || r == '.'
+}
+
+func tokenizeCondition(src string) (toks []condToken, err error) {
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(' || r == ')':
+			toks = append(toks, condToken{string(r), start})
+			i++
+		case r == '|':
+			return nil, fmt.Errorf("%w at position %d: aggregations are not supported", ErrConditionSyntax, start)
+		case isCondIdentRune(r):
+			for i < len(runes) && isCondIdentRune(runes[i]) {
+				i++
+			}
+			toks = append(toks, condToken{string(runes[start:i]), start})
+		default:
+			return nil, fmt.Errorf("%w at position %d: unexpected character %q", ErrConditionSyntax, start, r)
+		}
+	}
+
+	toks = append(toks, condToken{pos: len(runes)})
+	return
+}
+
+type condParser struct {
+	toks     []condToken
+	pos      int
+	searches map[string]search
+}
+
+// keyword returns the lowercased text of the next token
+func (p *condParser) keyword() string {
+	return strings.ToLower(p.toks[p.pos].text)
+}
+
+func (p *condParser) next() condToken {
+	t := p.toks[p.pos]
+	if t.text != "" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *condParser) unexpected(t condToken, expected string) error {
+	return fmt.Errorf("%w at position %d: expected %s, got %s", ErrConditionSyntax, t.pos, expected, t)
+}
+
+// parseOr parses: and-expression { "or" and-expression }
+func (p *condParser) parseOr() (condNode, error) {
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	or := condOr{n}
+	for p.keyword() == "or" {
+		p.next()
+		if n, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		or = append(or, n)
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+// parseAnd parses: unary { "and" unary }
+func (p *condParser) parseAnd() (condNode, error) {
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	and := condAnd{n}
+	for p.keyword() == "and" {
+		p.next()
+		if n, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+	}
+
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+// parseUnary parses: "not" unary | "(" or-expression ")" | quantifier "of" target | search
+func (p *condParser) parseUnary() (n condNode, err error) {
+	switch p.keyword() {
+	case "not":
+		p.next()
+		if n, err = p.parseUnary(); err != nil {
+			return
+		}
+		return condNot{n}, nil
+
+	case "(":
+		p.next()
+		if n, err = p.parseOr(); err != nil {
+			return
+		}
+		if t := p.next(); t.text != ")" {
+			return nil, p.unexpected(t, `")"`)
+		}
+		return
+
+	case "1", "any", "all":
+		return p.parseOf()
+
+	case "", ")", "and", "or", "of", "them":
+		return nil, p.unexpected(p.next(), "a search identifier")
+	}
+
+	t := p.next()
+	s, ok := p.searches[t.text]
+	if !ok {
+		return nil, fmt.Errorf("%w at position %d: unknown search identifier %s", ErrConditionSyntax, t.pos, t)
+	}
+
+	return condSearch{s}, nil
+}
+
+// parseOf parses: ("1" | "any" | "all") "of" ("them" | pattern)
+func (p *condParser) parseOf() (condNode, error) {
+	quantifier := p.keyword()
+	p.next()
+
+	if t := p.next(); strings.ToLower(t.text) != "of" {
+		return nil, p.unexpected(t, `"of"`)
+	}
+
+	t := p.next()
+	pattern := t.text
+	switch {
+	case strings.ToLower(pattern) == "them":
+		pattern = "*"
+	case pattern == "" || pattern == "(" || pattern == ")":
+		return nil, p.unexpected(t, `"them" or a search identifier pattern`)
+	}
+
+	names := make([]string, 0)
+	for name := range p.searches {
+		// by convention "them" does not include identifiers
+		// starting with an underscore
+		if pattern == "*" && strings.HasPrefix(name, "_") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w at position %d: no search identifier matching %s", ErrConditionSyntax, t.pos, t)
+	}
+
+	// deterministic evaluation order
+	sort.Strings(names)
+
+	nodes := make([]condNode, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, condSearch{p.searches[name]})
+	}
+
+	if quantifier == "all" {
+		return condAnd(nodes), nil
+	}
+	return condOr(nodes), nil
+}
+
+// parseCondition parses a condition referencing searches
+func parseCondition(src string, searches map[string]search) (n condNode, err error) {
+	p := condParser{searches: searches}
+
+	if p.toks, err = tokenizeCondition(src); err != nil {
+		return
+	}
+
+	if n, err = p.parseOr(); err != nil {
+		return
+	}
+
+	if t := p.next(); t.text != "" {
+		return nil, p.unexpected(t, `"and", "or" or end of condition`)
+	}
+
+	return
+}
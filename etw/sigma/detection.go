@@ -0,0 +1,520 @@
+package sigma
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/golang-etw/etw"
+)
+
+var (
+	ErrUnsupportedModifier = fmt.Errorf("unsupported modifier")
+	ErrBadValue            = fmt.Errorf("bad value")
+)
+
+// field modifiers
+const (
+	modContains     = "contains"
+	modStartswith   = "startswith"
+	modEndswith     = "endswith"
+	modAll          = "all"
+	modRe           = "re"
+	modBase64       = "base64"
+	modBase64offset = "base64offset"
+	modWide         = "wide"
+	modUTF16LE      = "utf16le"
+	modWindash      = "windash"
+	modCidr         = "cidr"
+	modLt           = "lt"
+	modLte          = "lte"
+	modGt           = "gt"
+	modGte          = "gte"
+	modExists       = "exists"
+	modCased        = "cased"
+)
+
+var (
+	// dash like characters windash modifier replaces dashes with
+	windashChars = []string{"-", "/", "–", "—", "―"}
+)
+
+// matchContext is the context a rule is evaluated in
+type matchContext struct {
+	e *etw.Event
+	t *Target
+}
+
+// get returns the value of a Sigma field. Event properties are looked up
+// first then fields accessible through etw.Event.GetField.
+func (c *matchContext) get(field string) (interface{}, bool) {
+	if c.t != nil {
+		field = c.t.Field(field)
+	}
+
+	if v, ok := c.e.GetProperty(field); ok {
+		return v, true
+	}
+
+	return c.e.GetField(field)
+}
+
+// properties returns all the property values of the event
+func (c *matchContext) properties() (values []interface{}) {
+	for _, v := range c.e.EventData {
+		values = append(values, v)
+	}
+	for _, v := range c.e.UserData {
+		values = append(values, v)
+	}
+	return
+}
+
+// search is a compiled search identifier of the detection section
+type search interface {
+	match(*matchContext) bool
+}
+
+// allSearch matches if all the searches match (map of fields)
+type allSearch []search
+
+func (s allSearch) match(c *matchContext) bool {
+	for _, f := range s {
+		if !f.match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// anySearch matches if any of the searches match (list of maps)
+type anySearch []search
+
+func (s anySearch) match(c *matchContext) bool {
+	for _, f := range s {
+		if f.match(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueMatcher matches a single value
+type valueMatcher func(v interface{}) bool
+
+// fieldSearch matches a field, or any property value when field is empty
+// (keyword search), against a list of values
+type fieldSearch struct {
+	field string
+	// all values must match
+	all bool
+	// exists modifier
+	exists *bool
+	// null values match empty or missing fields
+	null     bool
+	matchers []valueMatcher
+}
+
+// flatten returns the elements of slice values
+func flatten(v interface{}) []interface{} {
+	switch s := v.(type) {
+	case []interface{}:
+		return s
+	case []string:
+		out := make([]interface{}, 0, len(s))
+		for _, e := range s {
+			out = append(out, e)
+		}
+		return out
+	}
+	return []interface{}{v}
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case fmt.Stringer:
+		return s.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func (s *fieldSearch) matchValues(values []interface{}) bool {
+	var elts []interface{}
+
+	for _, v := range values {
+		elts = append(elts, flatten(v)...)
+	}
+
+	matchAny := func(m valueMatcher) bool {
+		for _, e := range elts {
+			if m(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if s.all {
+		for _, m := range s.matchers {
+			if !matchAny(m) {
+				return false
+			}
+		}
+		return len(s.matchers) > 0 || s.null
+	}
+
+	for _, m := range s.matchers {
+		if matchAny(m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *fieldSearch) match(c *matchContext) bool {
+	if s.field == "" {
+		return s.matchValues(c.properties())
+	}
+
+	v, ok := c.get(s.field)
+
+	if s.exists != nil {
+		return ok == *s.exists
+	}
+
+	if s.null && (!ok || v == nil || toString(v) == "") {
+		return true
+	}
+
+	if !ok {
+		return false
+	}
+
+	return s.matchValues([]interface{}{v})
+}
+
+// compileSearch compiles a search identifier definition
+func compileSearch(def interface{}) (search, error) {
+	switch d := def.(type) {
+	case map[string]interface{}:
+		return compileFieldMap(d)
+
+	case []interface{}:
+		if len(d) == 0 {
+			return nil, fmt.Errorf("%w: empty search", ErrRuleFormat)
+		}
+
+		if _, ok := d[0].(map[string]interface{}); ok {
+			searches := make(anySearch, 0, len(d))
+			for _, i := range d {
+				m, ok := i.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%w: cannot mix maps and values in a search", ErrRuleFormat)
+				}
+				s, err := compileFieldMap(m)
+				if err != nil {
+					return nil, err
+				}
+				searches = append(searches, s)
+			}
+			return searches, nil
+		}
+
+		// list of keywords
+		return compileFieldSearch("|"+modContains, d)
+
+	case string, int, float64:
+		return compileFieldSearch("|"+modContains, d)
+	}
+
+	return nil, fmt.Errorf("%w: unexpected search definition %v", ErrRuleFormat, def)
+}
+
+func compileFieldMap(m map[string]interface{}) (search, error) {
+	all := make(allSearch, 0, len(m))
+	for key, value := range m {
+		s, err := compileFieldSearch(key, value)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+// valueString converts a YAML scalar to string
+func valueString(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case int, float64, bool:
+		return fmt.Sprint(s), nil
+	}
+	return "", fmt.Errorf("%w: unexpected value %v", ErrBadValue, v)
+}
+
+// compileFieldSearch compiles a Field|modifier... key and its value(s)
+func compileFieldSearch(key string, value interface{}) (s *fieldSearch, err error) {
+	var kind string
+	var cased bool
+	var reFlags string
+	var transforms []func([]string) []string
+
+	split := strings.Split(key, "|")
+	s = &fieldSearch{field: split[0]}
+
+	for i, mod := range split[1:] {
+		switch mod {
+		case modContains, modStartswith, modEndswith, modCidr, modLt, modLte, modGt, modGte, modExists, modRe:
+			if kind != "" {
+				return nil, fmt.Errorf("%w: %s cannot be combined with %s", ErrUnsupportedModifier, mod, kind)
+			}
+			kind = mod
+		case "i", "m", "s":
+			if kind != modRe {
+				return nil, fmt.Errorf("%w: %s must follow re", ErrUnsupportedModifier, mod)
+			}
+			reFlags += mod
+		case modAll:
+			s.all = true
+		case modCased:
+			cased = true
+		case modWide, modUTF16LE:
+			transforms = append(transforms, wide)
+		case modBase64:
+			transforms = append(transforms, base64Encode)
+		case modBase64offset:
+			transforms = append(transforms, base64Offset)
+		case modWindash:
+			transforms = append(transforms, windash)
+		default:
+			return nil, fmt.Errorf("%w: %s (position %d in %s)", ErrUnsupportedModifier, mod, i+1, key)
+		}
+	}
+
+	if kind == modExists {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: exists modifier expects a boolean", ErrBadValue)
+		}
+		s.exists = &b
+		return
+	}
+
+	values := make([]string, 0)
+	for _, v := range flatten(value) {
+		if v == nil {
+			s.null = true
+			continue
+		}
+		sv, err := valueString(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, sv)
+	}
+
+	for _, t := range transforms {
+		values = t(values)
+	}
+
+	for _, v := range values {
+		var m valueMatcher
+
+		switch kind {
+		case modRe:
+			m, err = regexpMatcher(v, reFlags)
+		case modCidr:
+			m, err = cidrMatcher(v)
+		case modLt, modLte, modGt, modGte:
+			m, err = numberMatcher(kind, v)
+		default:
+			m, err = patternMatcher(kind, v, cased)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.matchers = append(s.matchers, m)
+	}
+
+	return
+}
+
+// patternToRegexp converts a Sigma string value to a regular expression.
+// * matches any sequence of characters and ? any single character,
+// \*, \? and \\ are used to escape them. Other backslashes are literal.
+func patternToRegexp(value string) string {
+	var sb strings.Builder
+
+	runes := []rune(value)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(runes) && (runes[i+1] == '*' || runes[i+1] == '?' || runes[i+1] == '\\') {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return sb.String()
+}
+
+func patternMatcher(kind, value string, cased bool) (valueMatcher, error) {
+	expr := patternToRegexp(value)
+
+	switch kind {
+	case modContains:
+		expr = ".*" + expr + ".*"
+	case modStartswith:
+		expr = expr + ".*"
+	case modEndswith:
+		expr = ".*" + expr
+	}
+
+	flags := "(?s)"
+	if !cased {
+		flags = "(?is)"
+	}
+
+	re, err := regexp.Compile(flags + "^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %s", ErrBadValue, value, err)
+	}
+
+	return func(v interface{}) bool {
+		return re.MatchString(toString(v))
+	}, nil
+}
+
+func regexpMatcher(value, flags string) (valueMatcher, error) {
+	if flags != "" {
+		value = "(?" + flags + ")" + value
+	}
+
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad regular expression %q: %s", ErrBadValue, value, err)
+	}
+
+	return func(v interface{}) bool {
+		return re.MatchString(toString(v))
+	}, nil
+}
+
+func cidrMatcher(value string) (valueMatcher, error) {
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadValue, err)
+	}
+
+	return func(v interface{}) bool {
+		ip := net.ParseIP(strings.TrimSpace(toString(v)))
+		return ip != nil && network.Contains(ip)
+	}, nil
+}
+
+func numberMatcher(kind, value string) (valueMatcher, error) {
+	ref, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s modifier expects a number: %s", ErrBadValue, kind, err)
+	}
+
+	return func(v interface{}) bool {
+		var f float64
+
+		switch n := v.(type) {
+		case float64:
+			f = n
+		default:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(toString(v)), 64); err != nil {
+				return false
+			}
+		}
+
+		switch kind {
+		case modLt:
+			return f < ref
+		case modLte:
+			return f <= ref
+		case modGt:
+			return f > ref
+		}
+		return f >= ref
+	}, nil
+}
+
+// wide encodes values in UTF-16LE
+func wide(values []string) (out []string) {
+	for _, v := range values {
+		var sb strings.Builder
+		for _, u := range utf16.Encode([]rune(v)) {
+			sb.WriteByte(byte(u))
+			sb.WriteByte(byte(u >> 8))
+		}
+		out = append(out, sb.String())
+	}
+	return
+}
+
+func base64Encode(values []string) (out []string) {
+	for _, v := range values {
+		out = append(out, base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return
+}
+
+// base64Offset returns the three possible encodings of values when they
+// are part of a larger base64 encoded string
+func base64Offset(values []string) (out []string) {
+	start := []int{0, 2, 3}
+	end := []int{0, 3, 2}
+
+	for _, v := range values {
+		for i := 0; i < 3; i++ {
+			enc := base64.StdEncoding.EncodeToString([]byte(strings.Repeat(" ", i) + v))
+			trim := end[(len(v)+i)%3]
+			out = append(out, enc[start[i]:len(enc)-trim])
+		}
+	}
+	return
+}
+
+// windash expands values with all the variants of command line
+// flags starting with a dash
+func windash(values []string) (out []string) {
+	for _, v := range values {
+		seen := make(map[string]bool)
+		for _, c := range windashChars {
+			var sb strings.Builder
+			for i, r := range v {
+				if r == '-' && (i == 0 || v[i-1] == ' ') {
+					sb.WriteString(c)
+					continue
+				}
+				sb.WriteRune(r)
+			}
+			if !seen[sb.String()] {
+				seen[sb.String()] = true
+				out = append(out, sb.String())
+			}
+		}
+	}
+	return
+}
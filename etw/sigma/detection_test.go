@@ -0,0 +1,261 @@
+package sigma
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/toast"
+)
+
+func newTestEvent() *etw.Event {
+	e := etw.NewEvent()
+	e.System.EventID = 1
+	e.System.Provider.Name = "Microsoft-Windows-Sysmon"
+	e.System.Provider.Guid = *etw.MustParseGUIDFromString(SysmonProvider)
+	e.EventData["Image"] = `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`
+	e.EventData["CommandLine"] = `powershell.exe -nop –enc SQBFAFgAIAAoAE4AZQB3AC0ATwBiAGoAZQBjAHQA`
+	e.EventData["User"] = ""
+	e.EventData["DestinationIp"] = "192.168.1.42"
+	e.EventData["DestinationPort"] = 4444.0
+	e.EventData["Hashes"] = []interface{}{"MD5=D41D8CD98F00B204E9800998ECF8427E", "SHA1=DA39A3EE5E6B4B0D3255BFEF95601890AFD80709"}
+	return e
+}
+
+func compileRule(detection string) (*Rule, error) {
+	return ParseRule([]byte(fmt.Sprintf("title: test\nlogsource:\n    category: process_creation\ndetection:\n%s", detection)))
+}
+
+func TestModifiers(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	e := newTestEvent()
+
+	matching := []string{
+		`Image: 'c:\windows\system32\windowspowershell\v1.0\POWERSHELL.EXE'`,
+		`Image: '*\powershell.exe'`,
+		`Image: 'C:\Windows*power?hell.exe'`,
+		`Image|endswith: '\powershell.exe'`,
+		`Image|startswith: 'C:\Windows\'`,
+		`Image|contains: 'WindowsPowerShell'`,
+		`Image|contains|cased: 'WindowsPowerShell'`,
+		`Image|contains|all: ['System32', 'v1.0']`,
+		`Image|endswith: ['\cmd.exe', '\powershell.exe']`,
+		`Image|re: '(?i)\\POWERSHELL\.exe$'`,
+		`Image|re|i: '\\POWERSHELL\.exe$'`,
+		`CommandLine|windash|contains: ' -enc '`,
+		`CommandLine|contains: '-nop'`,
+		`CommandLine|wide|base64offset|contains: 'IEX (New-Object'`,
+		`CommandLine|contains|all: ['-nop', 'SQBFAFgA']`,
+		`DestinationIp|cidr: '192.168.0.0/16'`,
+		`DestinationPort: 4444`,
+		`DestinationPort|gte: 4444`,
+		`DestinationPort|lt: 5000`,
+		`DestinationPort|gt: 1024`,
+		`DestinationPort|lte: 4444`,
+		`User: null`,
+		`User: ''`,
+		`Missing: null`,
+		`User|exists: true`,
+		`Missing|exists: false`,
+		`Hashes|contains: 'MD5=D41D8CD98F00B204E9800998ECF8427E'`,
+		`EventID: 1`,
+		`Provider.Name: 'Microsoft-Windows-Sysmon'`,
+		`Image: ['C:\Windows\explorer.exe', null, '*\powershell.exe']`,
+	}
+
+	for _, sel := range matching {
+		r, err := compileRule(fmt.Sprintf("    selection:\n        %s\n    condition: selection", sel))
+		tt.CheckErr(err)
+		tt.Assert(r.Match(e, nil), sel)
+	}
+
+	notMatching := []string{
+		`Image: 'powershell.exe'`,
+		`Image: 'C:\Windows*power?hell'`,
+		// backslash escapes the wildcard
+		`Image: 'C:\Windows\*\power?hell.exe'`,
+		`Image|contains|cased: 'windowspowershell'`,
+		`Image|contains|all: ['System32', 'cmd']`,
+		`Image: '\*\powershell.exe'`,
+		`CommandLine|contains: ' -enc '`,
+		`CommandLine|base64|contains: 'IEX (New-Object'`,
+		// encoded command is UTF-16LE
+		`CommandLine|base64offset|contains: 'IEX (New-Object'`,
+		`DestinationIp|cidr: '10.0.0.0/8'`,
+		`DestinationPort|lt: 4444`,
+		`DestinationPort|gt: 4444`,
+		`Image: null`,
+		`Missing: 'value'`,
+		`Missing|exists: true`,
+		`Image|exists: false`,
+	}
+
+	for _, sel := range notMatching {
+		r, err := compileRule(fmt.Sprintf("    selection:\n        %s\n    condition: selection", sel))
+		tt.CheckErr(err)
+		tt.Assert(!r.Match(e, nil), sel)
+	}
+}
+
+func TestModifierTransforms(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// values from the Sigma specification
+	out := base64Offset([]string{"/bin/sh"})
+	tt.Assert(len(out) == 3)
+	tt.Assert(out[0] == "L2Jpbi9za", out)
+	tt.Assert(out[1] == "9iaW4vc2", out)
+	tt.Assert(out[2] == "vYmluL3No", out)
+
+	tt.Assert(wide([]string{"ab"})[0] == "a\x00b\x00")
+	tt.Assert(base64Encode([]string{"sigma"})[0] == "c2lnbWE=")
+
+	out = windash([]string{"-foo bar-baz"})
+	tt.Assert(len(out) == 5, out)
+	tt.Assert(out[1] == "/foo bar-baz", out)
+
+	// no dash means no variant
+	tt.Assert(len(windash([]string{"foo"})) == 1)
+}
+
+func TestConditions(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	e := newTestEvent()
+
+	searches := `
+    sel_ps:
+        Image|endswith: '\powershell.exe'
+    sel_cmd:
+        Image|endswith: '\cmd.exe'
+    filter:
+        User: 'SYSTEM'
+    _helper:
+        EventID: 2
+    keywords:
+        - 'SQBFAFgA'
+    any_list:
+        - Image|endswith: '\cmd.exe'
+        - EventID: 1
+`
+
+	matching := []string{
+		`sel_ps`,
+		`sel_ps and not filter`,
+		`sel_cmd or sel_ps`,
+		`1 of sel_*`,
+		`any of sel_*`,
+		`not all of sel_*`,
+		`all of sel_ps*`,
+		`1 of them`,
+		`not all of them`,
+		`keywords`,
+		`any_list`,
+		`(sel_cmd or sel_ps) and not (filter or _helper)`,
+		`not sel_cmd and not _helper`,
+		`sel_cmd or sel_ps and keywords`,
+		`NOT sel_cmd AND sel_ps`,
+		`all of them and not _helper or sel_ps`,
+	}
+
+	for _, cond := range matching {
+		r, err := compileRule(searches + "    condition: " + cond)
+		tt.CheckErr(err)
+		tt.Assert(r.Match(e, nil), cond)
+	}
+
+	notMatching := []string{
+		`sel_cmd`,
+		`not sel_ps`,
+		`all of sel_*`,
+		`all of them`,
+		`_helper`,
+		`sel_ps and (filter or sel_cmd)`,
+		`(sel_cmd or sel_ps) and keywords and _helper`,
+	}
+
+	for _, cond := range notMatching {
+		r, err := compileRule(searches + "    condition: " + cond)
+		tt.CheckErr(err)
+		tt.Assert(!r.Match(e, nil), cond)
+	}
+
+	// list of conditions are ORed
+	r, err := compileRule(searches + "    condition:\n        - sel_cmd\n        - sel_ps")
+	tt.CheckErr(err)
+	tt.Assert(r.Match(e, nil))
+}
+
+func TestRuleErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	syntaxErrors := []string{
+		`sel`,
+		`selection and`,
+		`(selection`,
+		`selection)`,
+		`1 of`,
+		`1 of unknown*`,
+		`all selection`,
+		`selection | count() > 5`,
+		`selection & selection`,
+		`not`,
+	}
+
+	for _, cond := range syntaxErrors {
+		_, err := compileRule("    selection:\n        Image: test\n    condition: " + cond)
+		tt.Assert(errors.Is(err, ErrConditionSyntax), cond, err)
+	}
+
+	modifierErrors := []string{
+		`Image|unknown: test`,
+		`Image|contains|endswith: test`,
+		`Image|i: test`,
+	}
+
+	for _, sel := range modifierErrors {
+		_, err := compileRule(fmt.Sprintf("    selection:\n        %s\n    condition: selection", sel))
+		tt.Assert(errors.Is(err, ErrUnsupportedModifier), sel, err)
+	}
+
+	valueErrors := []string{
+		`Image|re: '('`,
+		`Ip|cidr: '10.0.0.0'`,
+		`Port|lt: abc`,
+		`Image|exists: yes please`,
+		`Image: {a: b}`,
+	}
+
+	for _, sel := range valueErrors {
+		_, err := compileRule(fmt.Sprintf("    selection:\n        %s\n    condition: selection", sel))
+		tt.Assert(errors.Is(err, ErrBadValue), sel, err)
+	}
+
+	formatErrors := []string{
+		"title: test\ndetection:\n    selection:\n        Image: test\n",
+		"logsource:\n    category: process_creation\ndetection:\n    selection:\n        Image: test\n    condition: selection\n",
+		"title: test\n",
+		"title: [",
+		"title: test\ndetection:\n    selection: []\n    condition: selection\n",
+	}
+
+	for _, rule := range formatErrors {
+		_, err := ParseRule([]byte(rule))
+		tt.Assert(errors.Is(err, ErrRuleFormat), rule, err)
+	}
+
+	// multi documents
+	rules, err := ParseRules([]byte("title: a\ndetection:\n    s:\n        A: 1\n    condition: s\n---\ntitle: b\ndetection:\n    s:\n        B: 1\n    condition: s\n"))
+	tt.CheckErr(err)
+	tt.Assert(len(rules) == 2)
+	_, err = ParseRule([]byte("title: a\ndetection:\n    s:\n        A: 1\n    condition: s\n---\ntitle: b\ndetection:\n    s:\n        B: 1\n    condition: s\n"))
+	tt.Assert(errors.Is(err, ErrRuleFormat))
+}
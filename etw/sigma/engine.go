@@ -0,0 +1,232 @@
+package sigma
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/0xrawsec/golang-etw/etw"
+)
+
+var (
+	ErrUnknownLogsource = fmt.Errorf("unknown logsource")
+	ErrDuplicateRule    = fmt.Errorf("duplicate rule")
+)
+
+// Match is emitted when an event matches a rule
+type Match struct {
+	Rule  *Rule
+	Event *etw.Event
+}
+
+type engineRule struct {
+	rule    *Rule
+	targets []Target
+}
+
+// Engine evaluates Sigma rules against events. It is safe
+// to load rules while events are being matched.
+type Engine struct {
+	sync.RWMutex
+	logsources LogsourceConfig
+	rules      []*engineRule
+	ids        map[string]bool
+}
+
+// NewEngine creates an Engine using DefaultLogsources
+func NewEngine() *Engine {
+	return NewEngineWithLogsources(DefaultLogsources)
+}
+
+// NewEngineWithLogsources creates an Engine mapping rule
+// log sources to ETW events according to config
+func NewEngineWithLogsources(config LogsourceConfig) *Engine {
+	return &Engine{
+		logsources: config,
+		ids:        make(map[string]bool),
+	}
+}
+
+// AddRule adds a compiled rule to the engine. An error is returned if the
+// log source of the rule does not map to any ETW provider.
+func (e *Engine) AddRule(r *Rule) error {
+	targets := e.logsources.Targets(r.Logsource)
+	if len(targets) == 0 {
+		return fmt.Errorf("%w: rule %q: %+v", ErrUnknownLogsource, r.Title, r.Logsource)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if r.ID != "" {
+		if e.ids[r.ID] {
+			return fmt.Errorf("%w: %s", ErrDuplicateRule, r.ID)
+		}
+		e.ids[r.ID] = true
+	}
+
+	e.rules = append(e.rules, &engineRule{r, targets})
+
+	return nil
+}
+
+// LoadRules parses and adds all the rules found in data
+func (e *Engine) LoadRules(data []byte) (err error) {
+	var rules []*Rule
+
+	if rules, err = ParseRules(data); err != nil {
+		return
+	}
+
+	for _, r := range rules {
+		if err = e.AddRule(r); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// LoadFile parses and adds all the rules found in a file
+func (e *Engine) LoadFile(path string) (err error) {
+	var rules []*Rule
+
+	if rules, err = ParseRuleFile(path); err != nil {
+		return
+	}
+
+	for _, r := range rules {
+		if err = e.AddRule(r); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return
+}
+
+// LoadDirectory recursively loads all .yml and .yaml files of a directory
+func (e *Engine) LoadDirectory(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yml", ".yaml":
+			return e.LoadFile(path)
+		}
+
+		return nil
+	})
+}
+
+// Rules returns the rules loaded in the engine
+func (e *Engine) Rules() (rules []*Rule) {
+	e.RLock()
+	defer e.RUnlock()
+
+	rules = make([]*Rule, 0, len(e.rules))
+	for _, er := range e.rules {
+		rules = append(rules, er.rule)
+	}
+
+	return
+}
+
+// Match returns the matches of all the rules for event evt
+func (e *Engine) Match(evt *etw.Event) (matches []*Match) {
+	e.RLock()
+	defer e.RUnlock()
+
+	for _, er := range e.rules {
+		for i := range er.targets {
+			t := &er.targets[i]
+			if t.Match(evt) && er.rule.Match(evt, t) {
+				matches = append(matches, &Match{Rule: er.rule, Event: evt})
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// ProviderSelection is a provider, and optionally some of its
+// events, rules loaded in an engine need to be enabled
+type ProviderSelection struct {
+	Provider string
+	// all events if empty
+	EventIDs []uint16
+}
+
+// String returns the selection in the format used by etw.ParseProvider
+func (s ProviderSelection) String() string {
+	if len(s.EventIDs) == 0 {
+		return s.Provider
+	}
+
+	ids := make([]string, 0, len(s.EventIDs))
+	for _, id := range s.EventIDs {
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	return fmt.Sprintf("%s:0xff:%s", s.Provider, strings.Join(ids, ","))
+}
+
+// Providers returns the providers and event IDs needed by the loaded rules
+func (e *Engine) Providers() (selections []ProviderSelection) {
+	e.RLock()
+	defer e.RUnlock()
+
+	type selection struct {
+		provider string
+		all      bool
+		ids      map[uint16]bool
+	}
+
+	keys := make([]string, 0)
+	m := make(map[string]*selection)
+	for _, er := range e.rules {
+		for i := range er.targets {
+			t := &er.targets[i]
+			key := t.providerKey()
+
+			s, ok := m[key]
+			if !ok {
+				s = &selection{provider: t.Provider, ids: make(map[uint16]bool)}
+				m[key] = s
+				keys = append(keys, key)
+			}
+
+			if len(t.EventIDs) == 0 {
+				s.all = true
+			}
+
+			for _, id := range t.EventIDs {
+				s.ids[id] = true
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m[key]
+		ps := ProviderSelection{Provider: s.provider}
+		if !s.all {
+			for id := range s.ids {
+				ps.EventIDs = append(ps.EventIDs, id)
+			}
+			sort.Slice(ps.EventIDs, func(i, j int) bool { return ps.EventIDs[i] < ps.EventIDs[j] })
+		}
+		selections = append(selections, ps)
+	}
+
+	return
+}
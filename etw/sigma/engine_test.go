@@ -0,0 +1,169 @@
+package sigma
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/toast"
+)
+
+const (
+	rulesDir   = "testdata/rules"
+	eventsPath = "testdata/events.jsonl"
+)
+
+func loadEvents(t *testing.T) (events []*etw.Event) {
+	tt := toast.FromT(t)
+
+	fd, err := os.Open(eventsPath)
+	tt.CheckErr(err)
+	defer fd.Close()
+
+	s := bufio.NewScanner(fd)
+	for s.Scan() {
+		e := etw.NewEvent()
+		tt.CheckErr(json.Unmarshal(s.Bytes(), e))
+		events = append(events, e)
+	}
+	tt.CheckErr(s.Err())
+
+	return
+}
+
+func matchedTitles(matches []*Match) (titles []string) {
+	for _, m := range matches {
+		titles = append(titles, m.Rule.Title)
+	}
+	sort.Strings(titles)
+	return
+}
+
+func TestEngine(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	engine := NewEngine()
+	tt.CheckErr(engine.LoadDirectory(rulesDir))
+	tt.Assert(len(engine.Rules()) == 6)
+
+	// expected matches indexed by line of the events file
+	expected := [][]string{
+		{"Encoded PowerShell Command Line", "Office Application Spawning Shell"},
+		{"Rundll32 Execution"},
+		{"Mimikatz In PowerShell Script Block"},
+		nil,
+		{"File Written In Startup Folder"},
+		{"Internal Connection To Port 4444"},
+		nil,
+		{"File Written In Startup Folder"},
+		{"Office Application Spawning Shell"},
+		nil,
+	}
+
+	events := loadEvents(t)
+	tt.Assert(len(events) == len(expected))
+
+	for i, e := range events {
+		matches := engine.Match(e)
+		titles := matchedTitles(matches)
+		tt.Assert(len(titles) == len(expected[i]), i, titles)
+		for j := range titles {
+			tt.Assert(titles[j] == expected[i][j], i, titles)
+		}
+		for _, m := range matches {
+			tt.Assert(m.Event == e)
+		}
+	}
+
+	// matches carry rule metadata
+	m := engine.Match(events[2])[0]
+	tt.Assert(m.Rule.ID == "3c2b1a09-8f7e-4d6c-b5a4-392817f6e5d4")
+	tt.Assert(m.Rule.Level == "critical")
+	tt.Assert(len(m.Rule.Tags) == 1 && m.Rule.Tags[0] == "attack.credential_access")
+
+	b, err := json.Marshal(m)
+	tt.CheckErr(err)
+	out := struct {
+		Rule  map[string]interface{}
+		Event *etw.Event
+	}{}
+	tt.CheckErr(json.Unmarshal(b, &out))
+	tt.Assert(out.Rule["Title"] == m.Rule.Title)
+	_, ok := out.Rule["Detection"]
+	tt.Assert(!ok)
+	tt.Assert(out.Event.System.EventID == 4104)
+}
+
+func TestEngineProviders(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	engine := NewEngine()
+	tt.CheckErr(engine.LoadFile(filepath.Join(rulesDir, "proc_creation_rundll32.yml")))
+	tt.CheckErr(engine.LoadFile(filepath.Join(rulesDir, "file_event_startup.yml")))
+
+	var providers []string
+	for _, s := range engine.Providers() {
+		providers = append(providers, s.String())
+	}
+
+	tt.Assert(len(providers) == 3, providers)
+	tt.Assert(providers[0] == KernelProcessProvider+":0xff:1", providers)
+	tt.Assert(providers[1] == SysmonProvider+":0xff:1,11", providers)
+	tt.Assert(providers[2] == KernelFileProvider+":0xff:12", providers)
+}
+
+func TestEngineErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+	engine := NewEngine()
+
+	tt.CheckErr(engine.LoadFile(filepath.Join(rulesDir, "proc_creation_rundll32.yml")))
+	err := engine.LoadFile(filepath.Join(rulesDir, "proc_creation_rundll32.yml"))
+	tt.Assert(errors.Is(err, ErrDuplicateRule), err)
+
+	err = engine.LoadRules([]byte(`
+title: Unknown
+logsource:
+    product: linux
+    category: process_creation
+detection:
+    selection:
+        Image: /bin/sh
+    condition: selection
+`))
+	tt.Assert(errors.Is(err, ErrUnknownLogsource), err)
+
+	// custom log source mapping
+	engine = NewEngineWithLogsources(LogsourceConfig{
+		{
+			Logsource: Logsource{Product: "linux", Category: "process_creation"},
+			Targets:   []Target{{Provider: "Linux-Process", Fields: map[string]string{"Image": "Exe"}}},
+		},
+	})
+	tt.CheckErr(engine.LoadRules([]byte(`
+title: Shell
+logsource:
+    product: linux
+    category: process_creation
+detection:
+    selection:
+        Image: /bin/sh
+    condition: selection
+`)))
+
+	e := etw.NewEvent()
+	e.System.Provider.Name = "linux-process"
+	e.EventData["Exe"] = "/bin/sh"
+	tt.Assert(len(engine.Match(e)) == 1)
+	tt.Assert(engine.Providers()[0].String() == "Linux-Process")
+}
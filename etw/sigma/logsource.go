@@ -0,0 +1,255 @@
+package sigma
+
+import (
+	"strings"
+
+	"github.com/0xrawsec/golang-etw/etw"
+)
+
+const (
+	SysmonProvider        = "{5770385F-C22A-43E0-BF4C-06F5698FFBD9}"
+	KernelProcessProvider = "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+	KernelFileProvider    = "{EDD08927-9CC4-4E65-B970-C2560FB5C289}"
+	KernelNetworkProvider = "{7DD42A49-5329-4832-8DFD-43D979153A88}"
+	PowerShellProvider    = "{A0C1853B-5C40-4B15-8766-3CF1C58F985A}"
+	DNSClientProvider     = "{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}"
+)
+
+var (
+	// DefaultLogsources maps common Sigma log sources to ETW providers
+	DefaultLogsources = LogsourceConfig{
+		{
+			Logsource: Logsource{Product: "windows", Category: "process_creation"},
+			Targets: []Target{
+				{Provider: SysmonProvider, EventIDs: []uint16{1}},
+				{
+					Provider: KernelProcessProvider,
+					EventIDs: []uint16{1},
+					Fields: map[string]string{
+						"Image":           "ImageName",
+						"ProcessId":       "ProcessID",
+						"ParentProcessId": "ParentProcessID",
+					},
+				},
+			},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "file_change"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{2}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "network_connection"},
+			Targets: []Target{
+				{Provider: SysmonProvider, EventIDs: []uint16{3}},
+				{
+					Provider: KernelNetworkProvider,
+					// TCP connect events for IPv4 and IPv6
+					EventIDs: []uint16{12, 28},
+					Fields: map[string]string{
+						"ProcessId":       "PID",
+						"SourceIp":        "saddr",
+						"SourcePort":      "sport",
+						"DestinationIp":   "daddr",
+						"DestinationPort": "dport",
+					},
+				},
+			},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "process_termination"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{5}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "driver_load"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{6}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "image_load"},
+			Targets: []Target{
+				{Provider: SysmonProvider, EventIDs: []uint16{7}},
+				{
+					Provider: KernelProcessProvider,
+					EventIDs: []uint16{5},
+					Fields: map[string]string{
+						"ImageLoaded": "ImageName",
+						"ProcessId":   "ProcessID",
+					},
+				},
+			},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "create_remote_thread"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{8}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "raw_access_thread"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{9}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "process_access"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{10}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "file_event"},
+			Targets: []Target{
+				{Provider: SysmonProvider, EventIDs: []uint16{11}},
+				{
+					Provider: KernelFileProvider,
+					EventIDs: []uint16{12},
+					Fields: map[string]string{
+						"TargetFilename": "FileName",
+					},
+				},
+			},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "registry_event"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{12, 13, 14}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "registry_add"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{12}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "registry_delete"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{12}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "registry_set"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{13}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "registry_rename"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{14}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "create_stream_hash"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{15}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "pipe_created"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{17, 18}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "wmi_event"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{19, 20, 21}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "dns_query"},
+			Targets: []Target{
+				{Provider: SysmonProvider, EventIDs: []uint16{22}},
+				{Provider: DNSClientProvider, EventIDs: []uint16{3008}},
+			},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "file_delete"},
+			Targets:   []Target{{Provider: SysmonProvider, EventIDs: []uint16{23, 26}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "ps_script"},
+			Targets:   []Target{{Provider: PowerShellProvider, EventIDs: []uint16{4104}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Category: "ps_module"},
+			Targets:   []Target{{Provider: PowerShellProvider, EventIDs: []uint16{4103}}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Service: "sysmon"},
+			Targets:   []Target{{Provider: SysmonProvider}},
+		},
+		{
+			Logsource: Logsource{Product: "windows", Service: "powershell"},
+			Targets:   []Target{{Provider: PowerShellProvider}},
+		},
+	}
+)
+
+// Logsource is the log source of a Sigma rule
+type Logsource struct {
+	Product    string `yaml:"product,omitempty" json:",omitempty"`
+	Category   string `yaml:"category,omitempty" json:",omitempty"`
+	Service    string `yaml:"service,omitempty" json:",omitempty"`
+	Definition string `yaml:"definition,omitempty" json:",omitempty"`
+}
+
+// Target defines which ETW events a log source applies to
+type Target struct {
+	// Provider GUID or name
+	Provider string
+	// Event IDs of the provider, all events if empty
+	EventIDs []uint16 `json:",omitempty"`
+	// Maps Sigma field names to event field names. Event field names can
+	// be property names or paths accepted by etw.Event.GetField
+	Fields map[string]string `json:",omitempty"`
+}
+
+func (t *Target) providerKey() string {
+	if g, err := etw.ParseGUID(t.Provider); err == nil {
+		return g.String()
+	}
+	return strings.ToLower(t.Provider)
+}
+
+// Match returns true if the event has been generated by the target
+func (t *Target) Match(e *etw.Event) bool {
+	if g, err := etw.ParseGUID(t.Provider); err == nil {
+		if !g.Equals(&e.System.Provider.Guid) {
+			return false
+		}
+	} else if !strings.EqualFold(t.Provider, e.System.Provider.Name) {
+		return false
+	}
+
+	if len(t.EventIDs) == 0 {
+		return true
+	}
+
+	for _, id := range t.EventIDs {
+		if id == e.System.EventID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Field returns the event field name for a Sigma field name
+func (t *Target) Field(name string) string {
+	if f, ok := t.Fields[name]; ok {
+		return f
+	}
+	return name
+}
+
+// LogsourceMapping maps a Sigma log source to ETW targets
+type LogsourceMapping struct {
+	Logsource Logsource
+	Targets   []Target
+}
+
+// match returns true if the mapping applies to log source l. Category
+// and service must be equal, product must be equal if set on both sides.
+func (m *LogsourceMapping) match(l Logsource) bool {
+	if !strings.EqualFold(m.Logsource.Category, l.Category) || !strings.EqualFold(m.Logsource.Service, l.Service) {
+		return false
+	}
+
+	if m.Logsource.Product != "" && l.Product != "" {
+		return strings.EqualFold(m.Logsource.Product, l.Product)
+	}
+
+	return true
+}
+
+// LogsourceConfig is a list of log source mappings
+type LogsourceConfig []LogsourceMapping
+
+// Targets returns all the targets l is mapped to
+func (c LogsourceConfig) Targets(l Logsource) (targets []Target) {
+	for i := range c {
+		if c[i].match(l) {
+			targets = append(targets, c[i].Targets...)
+		}
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package sigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrRuleFormat = fmt.Errorf("bad rule format")
+)
+
+const (
+	conditionKey = "condition"
+	timeframeKey = "timeframe"
+)
+
+// Rule is a compiled Sigma rule
+type Rule struct {
+	Title          string    `yaml:"title"`
+	ID             string    `yaml:"id" json:",omitempty"`
+	Status         string    `yaml:"status" json:",omitempty"`
+	Description    string    `yaml:"description" json:",omitempty"`
+	Author         string    `yaml:"author" json:",omitempty"`
+	Date           string    `yaml:"date" json:",omitempty"`
+	Modified       string    `yaml:"modified" json:",omitempty"`
+	References     []string  `yaml:"references" json:",omitempty"`
+	Tags           []string  `yaml:"tags" json:",omitempty"`
+	Level          string    `yaml:"level" json:",omitempty"`
+	FalsePositives []string  `yaml:"falsepositives" json:",omitempty"`
+	Fields         []string  `yaml:"fields" json:",omitempty"`
+	Logsource      Logsource `yaml:"logsource"`
+	// Detection section as found in the rule
+	Detection map[string]interface{} `yaml:"detection" json:"-"`
+
+	cond condNode
+}
+
+// ParseRule parses and compiles a single Sigma rule
+func ParseRule(data []byte) (r *Rule, err error) {
+	var rules []*Rule
+
+	if rules, err = ParseRules(data); err != nil {
+		return
+	}
+
+	if len(rules) != 1 {
+		return nil, fmt.Errorf("%w: expecting one rule, got %d", ErrRuleFormat, len(rules))
+	}
+
+	return rules[0], nil
+}
+
+// ParseRules parses and compiles all the Sigma rules found in
+// a (multi-document) YAML stream
+func ParseRules(data []byte) (rules []*Rule, err error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		r := &Rule{}
+		if err = dec.Decode(r); err != nil {
+			if errors.Is(err, io.EOF) {
+				return rules, nil
+			}
+			return nil, fmt.Errorf("%w: %s", ErrRuleFormat, err)
+		}
+
+		if err = r.compile(); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+}
+
+// ParseRuleFile parses and compiles all the Sigma rules found in a file
+func ParseRuleFile(path string) (rules []*Rule, err error) {
+	var data []byte
+
+	if data, err = os.ReadFile(path); err != nil {
+		return
+	}
+
+	if rules, err = ParseRules(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return
+}
+
+func (r *Rule) compile() (err error) {
+	var cond condNode
+
+	if r.Title == "" {
+		return fmt.Errorf("%w: missing title", ErrRuleFormat)
+	}
+
+	if len(r.Detection) == 0 {
+		return fmt.Errorf("%w: rule %q: missing detection", ErrRuleFormat, r.Title)
+	}
+
+	searches := make(map[string]search)
+	for name, def := range r.Detection {
+		if name == conditionKey || name == timeframeKey {
+			continue
+		}
+
+		if searches[name], err = compileSearch(def); err != nil {
+			return fmt.Errorf("rule %q: search %s: %w", r.Title, name, err)
+		}
+	}
+
+	switch c := r.Detection[conditionKey].(type) {
+	case string:
+		if cond, err = parseCondition(c, searches); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Title, err)
+		}
+	case []interface{}:
+		// list of conditions are ORed
+		or := condOr{}
+		for _, i := range c {
+			s, ok := i.(string)
+			if !ok {
+				return fmt.Errorf("%w: rule %q: condition must be a string", ErrRuleFormat, r.Title)
+			}
+			if cond, err = parseCondition(s, searches); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Title, err)
+			}
+			or = append(or, cond)
+		}
+		cond = or
+	default:
+		return fmt.Errorf("%w: rule %q: missing condition", ErrRuleFormat, r.Title)
+	}
+
+	r.cond = cond
+
+	return
+}
+
+// Match returns true if the event matches the rule detection. Field names
+// are mapped to event fields according to target t, which can be nil.
+// Log source of the rule is not taken into account.
+func (r *Rule) Match(e *etw.Event, t *Target) bool {
+	return r.cond.eval(&matchContext{e: e, t: t})
+}
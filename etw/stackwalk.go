@@ -0,0 +1,164 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// maximum number of kernel events stacks can be collected for
+	MAX_STACK_TRACING_EVENTS = 256
+)
+
+// StackWalkFilter selects the events of a provider stacks are collected
+// for. Event IDs, TraceLogging event names and level/keywords selections
+// are independent and each of them is applied by ETW when set. As
+// collecting stacks is expensive, it is better to select few events.
+type StackWalkFilter struct {
+	// Event IDs stacks are collected for (max MAX_EVENT_FILTER_EVENT_ID_COUNT)
+	EventIDs []uint16 `json:",omitempty"`
+	// TraceLogging event names stacks are collected for
+	EventNames []string `json:",omitempty"`
+	// Level and keywords of the events stacks are collected for
+	Level           uint8  `json:",omitempty"`
+	MatchAnyKeyword uint64 `json:",omitempty"`
+	MatchAllKeyword uint64 `json:",omitempty"`
+	// Stacks are collected for all events but the selected ones
+	FilterOut bool `json:",omitempty"`
+}
+
+// IsZero returns true if the filter does not select any event
+func (f *StackWalkFilter) IsZero() bool {
+	return len(f.EventIDs) == 0 &&
+		len(f.EventNames) == 0 &&
+		!f.levelKw()
+}
+
+func (f *StackWalkFilter) levelKw() bool {
+	return f.Level != 0 || f.MatchAnyKeyword != 0 || f.MatchAllKeyword != 0
+}
+
+// BuildFilterData serializes the stack walk filters to be applied by ETW
+func (f *StackWalkFilter) BuildFilterData() (fd []FilterData, err error) {
+	var data []byte
+
+	if len(f.EventIDs) > 0 {
+		if len(f.EventIDs) > MAX_EVENT_FILTER_EVENT_ID_COUNT {
+			return nil, fmt.Errorf("%w: too many stack walk event IDs %d > %d", ErrFilterData, len(f.EventIDs), MAX_EVENT_FILTER_EVENT_ID_COUNT)
+		}
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_STACKWALK, EncodeEventIDFilter(f.EventIDs, !f.FilterOut)})
+	}
+
+	if len(f.EventNames) > 0 {
+		if data, err = EncodeEventNameFilter(f.EventNames, 0, 0, 0, !f.FilterOut); err != nil {
+			return nil, err
+		}
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_STACKWALK_NAME, data})
+	}
+
+	if f.levelKw() {
+		fd = append(fd, FilterData{EVENT_FILTER_TYPE_STACKWALK_LEVEL_KW,
+			EncodeLevelKwFilter(f.Level, f.MatchAnyKeyword, f.MatchAllKeyword, !f.FilterOut)})
+	}
+
+	return
+}
+
+/*
+typedef struct _EVENT_FILTER_EVENT_NAME {
+  ULONGLONG MatchAnyKeyword;
+  ULONGLONG MatchAllKeyword;
+  UCHAR     Level;
+  BOOLEAN   FilterIn;
+  USHORT    NameCount;
+  UCHAR     Names[ANYSIZE_ARRAY];
+} EVENT_FILTER_EVENT_NAME, *PEVENT_FILTER_EVENT_NAME;
+*/
+
+// EncodeEventNameFilter serializes an EVENT_FILTER_EVENT_NAME structure,
+// names being stored as NUL terminated UTF-8 strings
+func EncodeEventNameFilter(names []string, level uint8, matchAny, matchAll uint64, filterIn bool) ([]byte, error) {
+	size := 20
+	for _, n := range names {
+		if n == "" || strings.ContainsRune(n, 0) {
+			return nil, fmt.Errorf("%w: bad event name %q", ErrFilterData, n)
+		}
+		size += len(n) + 1
+	}
+
+	if size > MAX_EVENT_FILTER_DATA_SIZE {
+		return nil, fmt.Errorf("%w: event names too large %d > %d bytes", ErrFilterData, size, MAX_EVENT_FILTER_DATA_SIZE)
+	}
+
+	buf := make([]byte, 20, size)
+	binary.LittleEndian.PutUint64(buf, matchAny)
+	binary.LittleEndian.PutUint64(buf[8:], matchAll)
+	buf[16] = level
+	if filterIn {
+		buf[17] = 1
+	}
+	binary.LittleEndian.PutUint16(buf[18:], uint16(len(names)))
+	for _, n := range names {
+		buf = append(buf, n...)
+		buf = append(buf, 0)
+	}
+
+	return buf, nil
+}
+
+/*
+typedef struct _EVENT_FILTER_LEVEL_KW {
+  ULONGLONG MatchAnyKeyword;
+  ULONGLONG MatchAllKeyword;
+  UCHAR     Level;
+  BOOLEAN   FilterIn;
+} EVENT_FILTER_LEVEL_KW, *PEVENT_FILTER_LEVEL_KW;
+*/
+
+// EncodeLevelKwFilter serializes an EVENT_FILTER_LEVEL_KW structure
+func EncodeLevelKwFilter(level uint8, matchAny, matchAll uint64, filterIn bool) []byte {
+	// structure is padded to its 8 bytes alignment
+	buf := make([]byte, 24)
+
+	binary.LittleEndian.PutUint64(buf, matchAny)
+	binary.LittleEndian.PutUint64(buf[8:], matchAll)
+	buf[16] = level
+	if filterIn {
+		buf[17] = 1
+	}
+
+	return buf
+}
+
+/*
+typedef struct _CLASSIC_EVENT_ID {
+  GUID  EventGuid;
+  UCHAR Type;
+  UCHAR Reserved[7];
+} CLASSIC_EVENT_ID, *PCLASSIC_EVENT_ID;
+*/
+
+// ClassicEventID identifies a kernel event by its event class GUID and
+// its type (opcode). It is used to select the kernel events stacks are
+// collected for.
+type ClassicEventID struct {
+	EventGuid GUID
+	Type      uint8
+	Reserved  [7]uint8
+}
+
+// ParseClassicEventID creates a ClassicEventID from an event class GUID
+// string and an event type
+func ParseClassicEventID(guid string, typ uint8) (id ClassicEventID, err error) {
+	var g *GUID
+
+	if g, err = ParseGUID(guid); err != nil {
+		return
+	}
+
+	id.EventGuid = *g
+	id.Type = typ
+
+	return
+}
@@ -0,0 +1,99 @@
+package etw
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestEncodeStackWalkFilters(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	// EVENT_FILTER_EVENT_NAME: MatchAnyKeyword, MatchAllKeyword, Level,
+	// FilterIn, NameCount, Names
+	data, err := EncodeEventNameFilter([]string{"Start", "Stop"}, 4, 0x10, 0, true)
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(data, []byte{
+		0x10, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		4, 1, 2, 0,
+		'S', 't', 'a', 'r', 't', 0,
+		'S', 't', 'o', 'p', 0}), data)
+
+	for _, names := range [][]string{
+		{""},
+		{"a\x00b"},
+		{strings.Repeat("a", MAX_EVENT_FILTER_DATA_SIZE)},
+	} {
+		_, err = EncodeEventNameFilter(names, 0, 0, 0, true)
+		tt.Assert(errors.Is(err, ErrFilterData), names)
+	}
+
+	// EVENT_FILTER_LEVEL_KW padded to 24 bytes
+	data = EncodeLevelKwFilter(3, 0x01, 0x0102, false)
+	tt.Assert(bytes.Equal(data, []byte{
+		1, 0, 0, 0, 0, 0, 0, 0,
+		2, 1, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 0, 0, 0, 0}), data)
+
+	// CLASSIC_EVENT_ID layout
+	tt.Assert(unsafe.Sizeof(ClassicEventID{}) == 24)
+	id, err := ParseClassicEventID("{3d6fa8d0-fe05-11d0-9dda-00c04fd7ba7c}", 1)
+	tt.CheckErr(err)
+	tt.Assert(id.EventGuid.Data1 == 0x3d6fa8d0 && id.Type == 1)
+	_, err = ParseClassicEventID("not a guid", 1)
+	tt.Assert(err != nil)
+}
+
+func TestProviderStackWalk(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	p := Provider{EnableProperty: EVENT_ENABLE_PROPERTY_SID, StackWalk: &StackWalkFilter{}}
+	tt.Assert(!p.StackWalkEnabled())
+	tt.Assert(p.EnableProperties() == EVENT_ENABLE_PROPERTY_SID)
+
+	p.StackWalk = &StackWalkFilter{
+		EventIDs:        []uint16{12},
+		EventNames:      []string{"Start"},
+		Level:           3,
+		MatchAnyKeyword: 0x10,
+		FilterOut:       true,
+	}
+	tt.Assert(p.StackWalkEnabled())
+	tt.Assert(p.EnableProperties() == EVENT_ENABLE_PROPERTY_SID|EVENT_ENABLE_PROPERTY_STACK_TRACE)
+
+	fd, err := p.BuildFilterData()
+	tt.CheckErr(err)
+	tt.Assert(len(fd) == 3)
+	tt.Assert(fd[0].Type == EVENT_FILTER_TYPE_STACKWALK && bytes.Equal(fd[0].Data, []byte{0, 0, 1, 0, 12, 0}))
+	tt.Assert(fd[1].Type == EVENT_FILTER_TYPE_STACKWALK_NAME && fd[1].Data[17] == 0)
+	tt.Assert(fd[2].Type == EVENT_FILTER_TYPE_STACKWALK_LEVEL_KW && fd[2].Data[16] == 3)
+
+	// unlike event filters, stack walk filters are never applied on
+	// consumer side so they cannot exceed ETW limits
+	p.StackWalk.EventIDs = make([]uint16, MAX_EVENT_FILTER_EVENT_ID_COUNT+1)
+	_, err = p.BuildFilterData()
+	tt.Assert(errors.Is(err, ErrFilterData))
+}
+
+func TestEventStackAddresses(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	e := NewEvent()
+	tt.Assert(e.StackAddresses() == nil)
+
+	e.ExtendedData = &EventExtendedData{}
+	tt.CheckErr(e.ExtendedData.Decode(EVENT_HEADER_EXT_TYPE_STACK_TRACE64, stackTrace64Fixture))
+	tt.Assert(len(e.StackAddresses()) == 3)
+	tt.Assert(e.StackAddresses()[0] == 0x7ffe76543210)
+}
@@ -54,6 +54,55 @@ func TdhEnumerateProviders(
 	return syscall.Errno(r1)
 }
 
+/*
+TdhEnumerateManifestProviderEvents API wrapper generated from prototype
+ULONG __stdcall TdhEnumerateManifestProviderEvents(
+	 LPGUID ProviderGuid,
+	 PPROVIDER_EVENT_INFO Buffer,
+	 ULONG *BufferSize );
+
+Tested: NOK
+*/
+func TdhEnumerateManifestProviderEvents(
+	providerGuid *GUID,
+	buffer *ProviderEventInfo,
+	bufferSize *uint32) error {
+	r1, _, _ := tdhEnumerateManifestProviderEvents.Call(
+		uintptr(unsafe.Pointer(providerGuid)),
+		uintptr(unsafe.Pointer(buffer)),
+		uintptr(unsafe.Pointer(bufferSize)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
+/*
+TdhGetManifestEventInformation API wrapper generated from prototype
+ULONG __stdcall TdhGetManifestEventInformation(
+	 LPGUID ProviderGuid,
+	 PEVENT_DESCRIPTOR EventDescriptor,
+	 PTRACE_EVENT_INFO Buffer,
+	 ULONG *BufferSize );
+
+Tested: NOK
+*/
+func TdhGetManifestEventInformation(
+	providerGuid *GUID,
+	eventDescriptor *EventDescriptor,
+	buffer *TraceEventInfo,
+	bufferSize *uint32) error {
+	r1, _, _ := tdhGetManifestEventInformation.Call(
+		uintptr(unsafe.Pointer(providerGuid)),
+		uintptr(unsafe.Pointer(eventDescriptor)),
+		uintptr(unsafe.Pointer(buffer)),
+		uintptr(unsafe.Pointer(bufferSize)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
 /*
 TdhGetEventInformation API wrapper generated from prototype
 ULONG __stdcall TdhGetEventInformation(
@@ -247,3 +296,95 @@ func TdhFormatProperty(
 	}
 	return syscall.Errno(r1)
 }
+
+/*
+TdhCreatePayloadFilter API wrapper generated from prototype
+ULONG __stdcall TdhCreatePayloadFilter(
+	 LPCGUID ProviderGuid,
+	 PCEVENT_DESCRIPTOR EventDescriptor,
+	 BOOLEAN EventMatchANY,
+	 ULONG PayloadPredicateCount,
+	 PPAYLOAD_FILTER_PREDICATE PayloadPredicates,
+	 PVOID *PayloadFilter );
+
+Tested: NOK
+*/
+func TdhCreatePayloadFilter(providerGuid *GUID,
+	eventDescriptor *EventDescriptor,
+	eventMatchANY bool,
+	payloadPredicateCount uint32,
+	payloadPredicates *byte,
+	payloadFilter *uintptr) error {
+	matchAny := uintptr(0)
+	if eventMatchANY {
+		matchAny = 1
+	}
+	r1, _, _ := tdhCreatePayloadFilter.Call(
+		uintptr(unsafe.Pointer(providerGuid)),
+		uintptr(unsafe.Pointer(eventDescriptor)),
+		matchAny,
+		uintptr(payloadPredicateCount),
+		uintptr(unsafe.Pointer(payloadPredicates)),
+		uintptr(unsafe.Pointer(payloadFilter)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
+/*
+TdhAggregatePayloadFilters API wrapper generated from prototype
+ULONG __stdcall TdhAggregatePayloadFilters(
+	 ULONG PayloadFilterCount,
+	 PVOID *PayloadFilterPtrs,
+	 PBOOLEAN EventMatchALLFlags,
+	 PEVENT_FILTER_DESCRIPTOR EventFilterDescriptor );
+
+Tested: NOK
+*/
+func TdhAggregatePayloadFilters(payloadFilterCount uint32,
+	payloadFilterPtrs *uintptr,
+	eventMatchALLFlags *uint8,
+	eventFilterDescriptor *EventFilterDescriptor) error {
+	r1, _, _ := tdhAggregatePayloadFilters.Call(
+		uintptr(payloadFilterCount),
+		uintptr(unsafe.Pointer(payloadFilterPtrs)),
+		uintptr(unsafe.Pointer(eventMatchALLFlags)),
+		uintptr(unsafe.Pointer(eventFilterDescriptor)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
+/*
+TdhCleanupPayloadEventFilterDescriptor API wrapper generated from prototype
+ULONG __stdcall TdhCleanupPayloadEventFilterDescriptor(
+	 PEVENT_FILTER_DESCRIPTOR EventFilterDescriptor );
+
+Tested: NOK
+*/
+func TdhCleanupPayloadEventFilterDescriptor(eventFilterDescriptor *EventFilterDescriptor) error {
+	r1, _, _ := tdhCleanupPayloadEventFilterDescriptor.Call(
+		uintptr(unsafe.Pointer(eventFilterDescriptor)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
+
+/*
+TdhDeletePayloadFilter API wrapper generated from prototype
+ULONG __stdcall TdhDeletePayloadFilter(
+	 PVOID *PayloadFilter );
+
+Tested: NOK
+*/
+func TdhDeletePayloadFilter(payloadFilter *uintptr) error {
+	r1, _, _ := tdhDeletePayloadFilter.Call(
+		uintptr(unsafe.Pointer(payloadFilter)))
+	if r1 == 0 {
+		return nil
+	}
+	return syscall.Errno(r1)
+}
@@ -9,6 +9,11 @@ import (
 
 var (
 	tdh                                            = syscall.NewLazyDLL("tdh.dll")
+	tdhAggregatePayloadFilters                     = tdh.NewProc("TdhAggregatePayloadFilters")
+	tdhCleanupPayloadEventFilterDescriptor         = tdh.NewProc("TdhCleanupPayloadEventFilterDescriptor")
+	tdhCreatePayloadFilter                         = tdh.NewProc("TdhCreatePayloadFilter")
+	tdhDeletePayloadFilter                         = tdh.NewProc("TdhDeletePayloadFilter")
+	tdhEnumerateManifestProviderEvents             = tdh.NewProc("TdhEnumerateManifestProviderEvents")
 	tdhEnumerateProviderFieldInformation           = tdh.NewProc("TdhEnumerateProviderFieldInformation")
 	tdhEnumerateProviderFilters                    = tdh.NewProc("TdhEnumerateProviderFilters")
 	tdhEnumerateProviders                          = tdh.NewProc("TdhEnumerateProviders")
@@ -18,6 +23,7 @@ var (
 	tdhGetAllEventsInformation                     = tdh.NewProc("TdhGetAllEventsInformation")
 	tdhGetEventInformation                         = tdh.NewProc("TdhGetEventInformation")
 	tdhGetEventMapInformation                      = tdh.NewProc("TdhGetEventMapInformation")
+	tdhGetManifestEventInformation                 = tdh.NewProc("TdhGetManifestEventInformation")
 	tdhGetProperty                                 = tdh.NewProc("TdhGetProperty")
 	tdhGetPropertyOffsetAndSize                    = tdh.NewProc("TdhGetPropertyOffsetAndSize")
 	tdhGetPropertySize                             = tdh.NewProc("TdhGetPropertySize")
@@ -1,6 +1,3 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
@@ -86,26 +83,18 @@ type ProviderFieldInfo struct {
 }
 
 /*
-typedef enum _EVENT_FIELD_TYPE {
-  EventKeywordInformation   = 0,
-  EventLevelInformation     = 1,
-  EventChannelInformation   = 2,
-  EventTaskInformation      = 3,
-  EventOpcodeInformation    = 4,
-  EventInformationMax       = 5
-} EVENT_FIELD_TYPE;
+typedef struct _PROVIDER_EVENT_INFO {
+  ULONG            NumberOfEvents;
+  ULONG            Reserved;
+  EVENT_DESCRIPTOR EventDescriptorsArray[ANYSIZE_ARRAY];
+} PROVIDER_EVENT_INFO;
 */
 
-type EventFieldType int32
-
-const (
-	EventKeywordInformation = EventFieldType(0)
-	EventLevelInformation   = EventFieldType(1)
-	EventChannelInformation = EventFieldType(2)
-	EventTaskInformation    = EventFieldType(3)
-	EventOpcodeInformation  = EventFieldType(4)
-	EventInformationMax     = EventFieldType(5)
-)
+type ProviderEventInfo struct {
+	NumberOfEvents        uint32
+	Reserved              uint32
+	EventDescriptorsArray [1]EventDescriptor
+}
 
 /*
 typedef struct _PROVIDER_ENUMERATION_INFO {
@@ -229,7 +218,7 @@ func (t *TraceEventInfo) pointerOffset(offset uintptr) uintptr {
 
 func (t *TraceEventInfo) stringAt(offset uintptr) string {
 	if offset > 0 {
-		return UTF16AtOffsetToString(t.pointer(), offset)
+		return UTF16PtrToString((*uint16)(unsafe.Add(unsafe.Pointer(t), offset)))
 	}
 	return ""
 }
@@ -302,12 +291,9 @@ func (t *TraceEventInfo) EventID() uint16 {
 
 func (t *TraceEventInfo) GetEventPropertyInfoAt(i uint32) *EventPropertyInfo {
 	if i < t.PropertyCount {
-		pEpi := uintptr(unsafe.Pointer(&t.EventPropertyInfoArray[0]))
-		pEpi += uintptr(i) * unsafe.Sizeof(EventPropertyInfo{})
-		// this line triggers checkptr
-		// I guess that is because TraceInfo is variable size C
-		// struct we had to hack with to make it compatible with Go
-		return ((*EventPropertyInfo)(unsafe.Pointer(pEpi)))
+		// unsafe.Add keeps track of the buffer TraceInfo is part of
+		pEpi := unsafe.Add(unsafe.Pointer(&t.EventPropertyInfoArray[0]), uintptr(i)*unsafe.Sizeof(EventPropertyInfo{}))
+		return (*EventPropertyInfo)(pEpi)
 	}
 	panic(fmt.Errorf("index out of range"))
 }
@@ -369,9 +355,8 @@ type EventMapInfo struct {
 
 func (e *EventMapInfo) GetEventMapEntryAt(i int) *EventMapEntry {
 	if uint32(i) < e.EntryCount {
-		pEmi := uintptr(unsafe.Pointer(&e.MapEntryArray[0]))
-		pEmi += uintptr(i) * unsafe.Sizeof(EventMapEntry{})
-		return ((*EventMapEntry)(unsafe.Pointer(pEmi)))
+		pEmi := unsafe.Add(unsafe.Pointer(&e.MapEntryArray[0]), uintptr(i)*unsafe.Sizeof(EventMapEntry{}))
+		return (*EventMapEntry)(pEmi)
 	}
 	panic(fmt.Errorf("Index out of range"))
 }
@@ -396,9 +381,9 @@ void RemoveTrailingSpace(PEVENT_MAP_INFO pMapInfo)
 func (e *EventMapInfo) RemoveTrailingSpace() {
 	for i := uint32(0); i < e.EntryCount; i++ {
 		me := e.GetEventMapEntryAt(int(i))
-		pStr := uintptr(unsafe.Pointer(e)) + uintptr(me.OutputOffset)
-		byteLen := (Wcslen(((*uint16)(unsafe.Pointer(pStr)))) - 1) * 2
-		*((*uint16)(unsafe.Pointer(pStr + uintptr(byteLen)))) = 0
+		pStr := unsafe.Add(unsafe.Pointer(e), me.OutputOffset)
+		byteLen := (Wcslen((*uint16)(pStr)) - 1) * 2
+		*(*uint16)(unsafe.Add(pStr, byteLen)) = 0
 	}
 }
 
@@ -555,91 +540,3 @@ func (i *EventPropertyInfo) LengthPropertyIndex() uint16 {
 func (i *EventPropertyInfo) Length() uint16 {
 	return i.LengthUnion
 }
-
-type TdhInType uint32
-
-// found info there: https://github.com/microsoft/ETW2JSON/blob/6721e0438733b316d316d36c488166853a05f836/Deserializer/Tdh.cs
-const (
-	TdhInTypeNull = TdhInType(iota)
-	TdhInTypeUnicodestring
-	TdhInTypeAnsistring
-	TdhInTypeInt8
-	TdhInTypeUint8
-	TdhInTypeInt16
-	TdhInTypeUint16
-	TdhInTypeInt32
-	TdhInTypeUint32
-	TdhInTypeInt64
-	TdhInTypeUint64
-	TdhInTypeFloat
-	TdhInTypeDouble
-	TdhInTypeBoolean
-	TdhInTypeBinary
-	TdhInTypeGUID
-	TdhInTypePointer
-	TdhInTypeFiletime
-	TdhInTypeSystemtime
-	TdhInTypeSid
-	TdhInTypeHexint32
-	TdhInTypeHexint64 // End of winmeta types
-)
-
-const (
-	TdhInTypeCountedstring = TdhInType(iota + 300) // Start of TDH intypes for WBEM.
-	TdhInTypeCountedansistring
-	TdhInTypeReversedcountedstring
-	TdhInTypeReversedcountedansistring
-	TdhInTypeNonnullterminatedstring
-	TdhInTypeNonnullterminatedansistring
-	TdhInTypeUnicodechar
-	TdhInTypeAnsichar
-	TdhInTypeSizet
-	TdhInTypeHexdump
-	TdhInTypeWbemsid
-)
-
-type TdhOutType uint32
-
-const (
-	TdhOutTypeNull = TdhOutType(iota)
-	TdhOutTypeString
-	TdhOutTypeDatetime
-	TdhOutTypeByte
-	TdhOutTypeUnsignedbyte
-	TdhOutTypeShort
-	TdhOutTypeUnsignedshort
-	TdhOutTypeInt
-	TdhOutTypeUnsignedint
-	TdhOutTypeLong
-	TdhOutTypeUnsignedlong
-	TdhOutTypeFloat
-	TdhOutTypeDouble
-	TdhOutTypeBoolean
-	TdhOutTypeGUID
-	TdhOutTypeHexbinary
-	TdhOutTypeHexint8
-	TdhOutTypeHexint16
-	TdhOutTypeHexint32
-	TdhOutTypeHexint64
-	TdhOutTypePid
-	TdhOutTypeTid
-	TdhOutTypePort
-	TdhOutTypeIpv4
-	TdhOutTypeIpv6
-	TdhOutTypeSocketaddress
-	TdhOutTypeCimdatetime
-	TdhOutTypeEtwtime
-	TdhOutTypeXML
-	TdhOutTypeErrorcode
-	TdhOutTypeWin32error
-	TdhOutTypeNtstatus
-	TdhOutTypeHresult                    // End of winmeta outtypes.
-	TdhOutTypeCultureInsensitiveDatetime // Culture neutral datetime string.
-	TdhOutTypeJSON
-)
-
-const (
-	// Start of TDH outtypes for WBEM.
-	TdhOutTypeREDUCEDSTRING = TdhOutType(iota + 300)
-	TdhOutTypeNOPRINT
-)
@@ -0,0 +1,354 @@
+package etw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// sizes of the structures as laid out by TDH
+	traceEventInfoHeaderSize = 112
+	eventPropertyInfoSize    = 24
+	eventMapInfoHeaderSize   = 16
+	eventMapEntrySize        = 8
+)
+
+var (
+	ErrTruncatedInfo = fmt.Errorf("truncated TDH information")
+	ErrMalformedInfo = fmt.Errorf("malformed TDH information")
+)
+
+// tdhBuffer gives bounds checked access to a buffer returned by TDH
+type tdhBuffer []byte
+
+// slice returns size bytes at offset off
+func (b tdhBuffer) slice(off, size uint32) ([]byte, error) {
+	if uint64(off)+uint64(size) > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d out of %d bytes buffer", ErrTruncatedInfo, size, off, len(b))
+	}
+	return b[off : off+size], nil
+}
+
+// string returns the NUL terminated UTF-16 string at offset off, an
+// offset of zero meaning there is no string
+func (b tdhBuffer) string(off uint32) (string, error) {
+	if off == 0 {
+		return "", nil
+	}
+
+	if uint64(off) >= uint64(len(b)) {
+		return "", fmt.Errorf("%w: string offset %d out of %d bytes buffer", ErrTruncatedInfo, off, len(b))
+	}
+
+	u := make([]uint16, 0, 32)
+	for i := int(off); i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(u)), nil
+		}
+		u = append(u, c)
+	}
+
+	return "", fmt.Errorf("%w: string at offset %d is not terminated", ErrTruncatedInfo, off)
+}
+
+// cleanString is like string but trims the spaces surrounding the string
+func (b tdhBuffer) cleanString(off uint32) (s string, err error) {
+	s, err = b.string(off)
+	return strings.Trim(s, " "), err
+}
+
+// PropertyInfo describes a property of an event, as parsed from an
+// EVENT_PROPERTY_INFO structure
+type PropertyInfo struct {
+	Name  string
+	Flags PropertyFlags
+	// In and out types of non structure properties
+	InType  TdhInType
+	OutType TdhOutType
+	MapName string
+	// Number of elements or, if Flags has PropertyParamCount, index of the
+	// property holding it
+	Count uint16
+	// Length in bytes or, if Flags has PropertyParamLength, index of the
+	// property holding it
+	Length uint16
+	// Members of structure properties
+	Members []PropertyInfo
+	// index of the first member in EventSchema.Properties
+	membersIndex int
+}
+
+// IsStruct returns true if the property is a structure
+func (p *PropertyInfo) IsStruct() bool {
+	return p.Flags&PropertyStruct == PropertyStruct
+}
+
+// CountIndex returns the index of the property holding the number of
+// elements of the property if any
+func (p *PropertyInfo) CountIndex() (int, bool) {
+	return int(p.Count), p.Flags&PropertyParamCount == PropertyParamCount
+}
+
+// LengthIndex returns the index of the property holding the length of the
+// property if any
+func (p *PropertyInfo) LengthIndex() (int, bool) {
+	return int(p.Length), p.Flags&PropertyParamLength == PropertyParamLength
+}
+
+// MembersIndex returns the index of the first member of the property if it
+// is a structure
+func (p *PropertyInfo) MembersIndex() (int, bool) {
+	return p.membersIndex, p.IsStruct()
+}
+
+// EventSchema describes an event, as parsed from a TRACE_EVENT_INFO
+// structure
+type EventSchema struct {
+	ProviderGUID    GUID
+	EventGUID       GUID
+	EventDescriptor EventDescriptor
+	DecodingSource  DecodingSource
+	Flags           TemplateFlags
+
+	ProviderName          string
+	LevelName             string
+	ChannelName           string
+	KeywordsName          string
+	TaskName              string
+	OpcodeName            string
+	EventMessage          string
+	ProviderMessage       string
+	ActivityIDName        string
+	RelatedActivityIDName string
+
+	// All the properties in TDH order, the indexes of properties holding
+	// counts and lengths refer to this slice
+	Properties            []PropertyInfo
+	TopLevelPropertyCount uint32
+}
+
+// TopLevelProperties returns the properties which are not structure members
+func (s *EventSchema) TopLevelProperties() []PropertyInfo {
+	return s.Properties[:s.TopLevelPropertyCount]
+}
+
+// EventID returns the ID of the event, see TraceEventInfo.EventID
+func (s *EventSchema) EventID() uint16 {
+	if s.DecodingSource == DecodingSourceXMLFile {
+		return s.EventDescriptor.Id
+	} else if s.DecodingSource == DecodingSourceWbem {
+		if c, ok := MofClassMapping[s.EventGUID.Data1]; ok {
+			return c.BaseId + uint16(s.EventDescriptor.Opcode)
+		}
+	}
+	// not meaningful, cannot be used to identify event
+	return 0
+}
+
+// ParseTraceEventInfo parses a buffer filled by TdhGetEventInformation or
+// TdhGetManifestEventInformation. Unlike TraceEventInfo methods, it never
+// reads out of the buffer and returns ErrTruncatedInfo or ErrMalformedInfo
+// if the buffer is inconsistent.
+func ParseTraceEventInfo(buf []byte) (s *EventSchema, err error) {
+	var hdr []byte
+
+	b := tdhBuffer(buf)
+	if hdr, err = b.slice(0, traceEventInfoHeaderSize); err != nil {
+		return
+	}
+
+	s = &EventSchema{}
+	// header size guarantees unmarshalling cannot fail
+	s.ProviderGUID.UnmarshalBinary(hdr[0:16])
+	s.EventGUID.UnmarshalBinary(hdr[16:32])
+	s.EventDescriptor = EventDescriptor{
+		Id:      binary.LittleEndian.Uint16(hdr[32:]),
+		Version: hdr[34],
+		Channel: hdr[35],
+		Level:   hdr[36],
+		Opcode:  hdr[37],
+		Task:    binary.LittleEndian.Uint16(hdr[38:]),
+		Keyword: binary.LittleEndian.Uint64(hdr[40:]),
+	}
+	s.DecodingSource = DecodingSource(binary.LittleEndian.Uint32(hdr[48:]))
+
+	offset := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(hdr[52+i*4:])
+	}
+
+	for i, dst := range []*string{
+		&s.ProviderName,
+		&s.LevelName,
+		&s.ChannelName,
+		&s.KeywordsName,
+		&s.TaskName,
+		&s.OpcodeName,
+		&s.EventMessage,
+		&s.ProviderMessage,
+	} {
+		if *dst, err = b.cleanString(offset(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	// offsets 8 and 9 are BinaryXMLOffset and BinaryXMLSize
+	if s.ActivityIDName, err = b.string(offset(10)); err != nil {
+		return nil, err
+	}
+
+	if s.RelatedActivityIDName, err = b.string(offset(11)); err != nil {
+		return nil, err
+	}
+
+	count := offset(12)
+	s.TopLevelPropertyCount = offset(13)
+	s.Flags = TemplateFlags(offset(14))
+
+	if s.TopLevelPropertyCount > count {
+		return nil, fmt.Errorf("%w: %d top level properties out of %d", ErrMalformedInfo, s.TopLevelPropertyCount, count)
+	}
+
+	if s.Properties, err = parseEventPropertyInfoArray(b, count); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func parseEventPropertyInfoArray(b tdhBuffer, count uint32) (props []PropertyInfo, err error) {
+	var array []byte
+
+	// checking size first so that count cannot overflow or make us
+	// allocate too much
+	if uint64(count)*eventPropertyInfoSize > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: %d properties", ErrTruncatedInfo, count)
+	}
+
+	if array, err = b.slice(traceEventInfoHeaderSize, count*eventPropertyInfoSize); err != nil {
+		return
+	}
+
+	props = make([]PropertyInfo, count)
+	for i := range props {
+		p := &props[i]
+		raw := array[i*eventPropertyInfoSize:]
+
+		p.Flags = PropertyFlags(binary.LittleEndian.Uint32(raw))
+		if p.Name, err = b.string(binary.LittleEndian.Uint32(raw[4:])); err != nil {
+			return nil, fmt.Errorf("property %d name: %w", i, err)
+		}
+
+		p.Count = binary.LittleEndian.Uint16(raw[16:])
+		p.Length = binary.LittleEndian.Uint16(raw[18:])
+
+		if j, ok := p.CountIndex(); ok && j >= len(props) {
+			return nil, fmt.Errorf("%w: property %d count index %d out of range", ErrMalformedInfo, i, j)
+		}
+
+		if j, ok := p.LengthIndex(); ok && j >= len(props) {
+			return nil, fmt.Errorf("%w: property %d length index %d out of range", ErrMalformedInfo, i, j)
+		}
+
+		if p.IsStruct() {
+			start := int(binary.LittleEndian.Uint16(raw[8:]))
+			end := start + int(binary.LittleEndian.Uint16(raw[10:]))
+			// members following the structure guarantees there is no cycle
+			if start <= i || end > len(props) {
+				return nil, fmt.Errorf("%w: structure %d members [%d:%d] out of range", ErrMalformedInfo, i, start, end)
+			}
+			// members are filled in place as they follow the structure
+			p.Members = props[start:end:end]
+			p.membersIndex = start
+			continue
+		}
+
+		p.InType = TdhInType(binary.LittleEndian.Uint16(raw[8:]))
+		p.OutType = TdhOutType(binary.LittleEndian.Uint16(raw[10:]))
+		if p.MapName, err = b.string(binary.LittleEndian.Uint32(raw[12:])); err != nil {
+			return nil, fmt.Errorf("property %d map name: %w", i, err)
+		}
+	}
+
+	return
+}
+
+// EventMapEntryInfo is an entry of a value map
+type EventMapEntryInfo struct {
+	// Value mapped, unless the map is keyed by strings
+	Value uint32
+	// Input string mapped for maps keyed by strings
+	Input  string
+	Output string
+}
+
+// EventMap describes a value map, as parsed from an EVENT_MAP_INFO
+// structure
+type EventMap struct {
+	Name      string
+	Flags     MapFlags
+	ValueType MapValueType
+	Entries   []EventMapEntryInfo
+}
+
+// IsBitMap returns true if the map values are bit flags
+func (m *EventMap) IsBitMap() bool {
+	return m.Flags&(EVENTMAP_INFO_FLAG_MANIFEST_BITMAP|EVENTMAP_INFO_FLAG_WBEM_BITMAP) != 0
+}
+
+// ParseEventMapInfo parses a buffer filled by TdhGetEventMapInformation.
+// Trailing spaces of output strings, added by manifests, are removed.
+func ParseEventMapInfo(buf []byte) (m *EventMap, err error) {
+	var hdr, array []byte
+
+	b := tdhBuffer(buf)
+	if hdr, err = b.slice(0, eventMapInfoHeaderSize); err != nil {
+		return
+	}
+
+	m = &EventMap{}
+	if m.Name, err = b.string(binary.LittleEndian.Uint32(hdr)); err != nil {
+		return nil, err
+	}
+	m.Flags = MapFlags(binary.LittleEndian.Uint32(hdr[4:]))
+	count := binary.LittleEndian.Uint32(hdr[8:])
+	// the union is a format string offset for WBEM maps without entries
+	if m.Flags&EVENTMAP_INFO_FLAG_WBEM_NO_MAP == 0 {
+		m.ValueType = MapValueType(binary.LittleEndian.Uint32(hdr[12:]))
+	}
+
+	if uint64(count)*eventMapEntrySize > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: %d map entries", ErrTruncatedInfo, count)
+	}
+
+	if array, err = b.slice(eventMapInfoHeaderSize, count*eventMapEntrySize); err != nil {
+		return nil, err
+	}
+
+	keyedByString := m.ValueType == EVENTMAP_ENTRY_VALUETYPE_STRING ||
+		m.Flags&EVENTMAP_INFO_FLAG_MANIFEST_PATTERNMAP != 0
+
+	m.Entries = make([]EventMapEntryInfo, count)
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		raw := array[i*eventMapEntrySize:]
+
+		if e.Output, err = b.string(binary.LittleEndian.Uint32(raw)); err != nil {
+			return nil, fmt.Errorf("map entry %d output: %w", i, err)
+		}
+		e.Output = strings.TrimRight(e.Output, " ")
+
+		if keyedByString {
+			if e.Input, err = b.string(binary.LittleEndian.Uint32(raw[4:])); err != nil {
+				return nil, fmt.Errorf("map entry %d input: %w", i, err)
+			}
+			continue
+		}
+
+		e.Value = binary.LittleEndian.Uint32(raw[4:])
+	}
+
+	return
+}
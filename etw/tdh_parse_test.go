@@ -0,0 +1,203 @@
+package etw
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/0xrawsec/toast"
+)
+
+// Binary fixtures reproduce the layout of TdhGetEventInformation and
+// TdhGetEventMapInformation outputs, strings being stored after the
+// structures so that any truncation cuts a string.
+
+func readTestData(t testing.TB, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseTraceEventInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s, err := ParseTraceEventInfo(readTestData(t, "kernel-file-create.tei.bin"))
+	tt.CheckErr(err)
+	tt.Assert(s.ProviderGUID.Equals(MustParseGUIDFromString("{EDD08927-9CC4-4E65-B970-C2560FB5C289}")))
+	tt.Assert(s.EventGUID.IsZero())
+	tt.Assert(s.EventID() == 12)
+	tt.Assert(s.EventDescriptor.Version == 1)
+	tt.Assert(s.EventDescriptor.Keyword == 0x8000000000000080)
+	tt.Assert(s.DecodingSource == DecodingSourceXMLFile)
+	tt.Assert(s.Flags == TEMPLATE_EVENT_DATA)
+	// names are trimmed
+	tt.Assert(s.ProviderName == "Microsoft-Windows-Kernel-File")
+	tt.Assert(s.ChannelName == "Microsoft-Windows-Kernel-File/Analytic")
+	tt.Assert(s.TaskName == "Create")
+	tt.Assert(s.OpcodeName == "")
+	tt.Assert(len(s.Properties) == 7)
+	tt.Assert(len(s.TopLevelProperties()) == 7)
+
+	p := s.Properties[6]
+	tt.Assert(p.Name == "FileName")
+	tt.Assert(p.InType == TdhInTypeUnicodestring && p.OutType == TdhOutTypeString)
+	tt.Assert(!p.IsStruct())
+
+	s, err = ParseTraceEventInfo(readTestData(t, "struct-array.tei.bin"))
+	tt.CheckErr(err)
+	tt.Assert(s.DecodingSource == DecodingSourceWbem)
+	tt.Assert(s.EventID() == MofClassMapping[s.EventGUID.Data1].BaseId+10)
+	tt.Assert(len(s.Properties) == 6)
+	tt.Assert(len(s.TopLevelProperties()) == 4)
+
+	entries := s.Properties[1]
+	tt.Assert(entries.IsStruct())
+	i, ok := entries.CountIndex()
+	tt.Assert(ok && s.Properties[i].Name == "EntryCount")
+	tt.Assert(len(entries.Members) == 2)
+	tt.Assert(entries.Members[0].Name == "ProcessId")
+	tt.Assert(entries.Members[1].MapName == "StatusMap")
+
+	data := s.Properties[3]
+	i, ok = data.LengthIndex()
+	tt.Assert(ok && s.Properties[i].Name == "DataSize")
+	_, ok = data.CountIndex()
+	tt.Assert(!ok && data.Count == 1)
+}
+
+func TestParseTraceEventInfoMatchesTraceEventInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	for _, fixture := range []string{"kernel-file-create.tei.bin", "struct-array.tei.bin"} {
+		b := readTestData(t, fixture)
+		s, err := ParseTraceEventInfo(b)
+		tt.CheckErr(err)
+
+		// uint64 slice guarantees structure alignment
+		aligned := make([]uint64, (len(b)+7)/8)
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&aligned[0])), len(b)), b)
+		tei := (*TraceEventInfo)(unsafe.Pointer(&aligned[0]))
+
+		tt.Assert(tei.ProviderGUID.Equals(&s.ProviderGUID))
+		tt.Assert(tei.EventDescriptor == s.EventDescriptor)
+		tt.Assert(tei.EventID() == s.EventID())
+		tt.Assert(tei.ProviderName() == s.ProviderName)
+		tt.Assert(tei.TaskName() == s.TaskName)
+		tt.Assert(tei.LevelName() == s.LevelName)
+		tt.Assert(tei.PropertyCount == uint32(len(s.Properties)))
+		tt.Assert(tei.TopLevelPropertyCount == s.TopLevelPropertyCount)
+
+		for i, p := range s.Properties {
+			epi := tei.GetEventPropertyInfoAt(uint32(i))
+			tt.Assert(tei.stringAt(uintptr(epi.NameOffset)) == p.Name)
+			tt.Assert(epi.Flags == p.Flags)
+			if !p.IsStruct() {
+				tt.Assert(TdhInType(epi.InType()) == p.InType)
+				tt.Assert(TdhOutType(epi.OutType()) == p.OutType)
+			}
+		}
+	}
+}
+
+func TestParseTraceEventInfoTruncated(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	for _, fixture := range []string{"kernel-file-create.tei.bin", "struct-array.tei.bin"} {
+		b := readTestData(t, fixture)
+		for n := 0; n < len(b); n++ {
+			_, err := ParseTraceEventInfo(b[:n])
+			tt.Assert(errors.Is(err, ErrTruncatedInfo), fixture, n)
+		}
+	}
+}
+
+func TestParseTraceEventInfoMalformed(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	fixture := readTestData(t, "struct-array.tei.bin")
+	property := func(b []byte, i int) []byte {
+		return b[traceEventInfoHeaderSize+i*eventPropertyInfoSize:]
+	}
+
+	for _, c := range []struct {
+		name    string
+		corrupt func([]byte)
+		err     error
+	}{
+		{"top level count", func(b []byte) { binary.LittleEndian.PutUint32(b[104:], 7) }, ErrMalformedInfo},
+		{"property count", func(b []byte) { binary.LittleEndian.PutUint32(b[100:], 0xffffffff) }, ErrTruncatedInfo},
+		{"provider name", func(b []byte) { binary.LittleEndian.PutUint32(b[52:], 0xffffffff) }, ErrTruncatedInfo},
+		{"property name", func(b []byte) { binary.LittleEndian.PutUint32(property(b, 2)[4:], uint32(len(b))) }, ErrTruncatedInfo},
+		{"count index", func(b []byte) { binary.LittleEndian.PutUint16(property(b, 1)[16:], 6) }, ErrMalformedInfo},
+		{"length index", func(b []byte) { binary.LittleEndian.PutUint16(property(b, 3)[18:], 42) }, ErrMalformedInfo},
+		{"struct cycle", func(b []byte) { binary.LittleEndian.PutUint16(property(b, 1)[8:], 1) }, ErrMalformedInfo},
+		{"struct members", func(b []byte) { binary.LittleEndian.PutUint16(property(b, 1)[10:], 3) }, ErrMalformedInfo},
+	} {
+		b := append([]byte{}, fixture...)
+		c.corrupt(b)
+		_, err := ParseTraceEventInfo(b)
+		tt.Assert(errors.Is(err, c.err), c.name, err)
+	}
+
+	// corrupting any byte must never make the parser panic
+	for i := range fixture {
+		b := append([]byte{}, fixture...)
+		b[i] ^= 0xff
+		ParseTraceEventInfo(b)
+	}
+}
+
+func TestParseEventMapInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	m, err := ParseEventMapInfo(readTestData(t, "file-share.emi.bin"))
+	tt.CheckErr(err)
+	tt.Assert(m.Name == "FileShareMap")
+	tt.Assert(m.IsBitMap())
+	tt.Assert(m.ValueType == EVENTMAP_ENTRY_VALUETYPE_ULONG)
+	tt.Assert(len(m.Entries) == 3)
+	// trailing spaces are removed
+	tt.Assert(m.Entries[0] == EventMapEntryInfo{Value: 1, Output: "FILE_SHARE_READ"})
+	tt.Assert(m.Entries[2] == EventMapEntryInfo{Value: 4, Output: "FILE_SHARE_DELETE"})
+
+	m, err = ParseEventMapInfo(readTestData(t, "pattern.emi.bin"))
+	tt.CheckErr(err)
+	tt.Assert(!m.IsBitMap())
+	tt.Assert(m.ValueType == EVENTMAP_ENTRY_VALUETYPE_STRING)
+	tt.Assert(len(m.Entries) == 2)
+	tt.Assert(m.Entries[1] == EventMapEntryInfo{Input: "%2", Output: "Thread %2"})
+
+	for _, fixture := range []string{"file-share.emi.bin", "pattern.emi.bin"} {
+		b := readTestData(t, fixture)
+		for n := 0; n < len(b); n++ {
+			_, err = ParseEventMapInfo(b[:n])
+			tt.Assert(errors.Is(err, ErrTruncatedInfo), fixture, n)
+		}
+
+		for i := range b {
+			corrupted := append([]byte{}, b...)
+			corrupted[i] ^= 0xff
+			ParseEventMapInfo(corrupted)
+		}
+	}
+
+	b := readTestData(t, "file-share.emi.bin")
+	binary.LittleEndian.PutUint32(b[8:], 0xffffffff)
+	_, err = ParseEventMapInfo(b)
+	tt.Assert(errors.Is(err, ErrTruncatedInfo))
+}
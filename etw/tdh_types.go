@@ -0,0 +1,111 @@
+package etw
+
+type TdhInType uint32
+
+// found info there: https://github.com/microsoft/ETW2JSON/blob/6721e0438733b316d316d36c488166853a05f836/Deserializer/Tdh.cs
+const (
+	TdhInTypeNull = TdhInType(iota)
+	TdhInTypeUnicodestring
+	TdhInTypeAnsistring
+	TdhInTypeInt8
+	TdhInTypeUint8
+	TdhInTypeInt16
+	TdhInTypeUint16
+	TdhInTypeInt32
+	TdhInTypeUint32
+	TdhInTypeInt64
+	TdhInTypeUint64
+	TdhInTypeFloat
+	TdhInTypeDouble
+	TdhInTypeBoolean
+	TdhInTypeBinary
+	TdhInTypeGUID
+	TdhInTypePointer
+	TdhInTypeFiletime
+	TdhInTypeSystemtime
+	TdhInTypeSid
+	TdhInTypeHexint32
+	TdhInTypeHexint64 // End of winmeta types
+)
+
+const (
+	TdhInTypeCountedstring = TdhInType(iota + 300) // Start of TDH intypes for WBEM.
+	TdhInTypeCountedansistring
+	TdhInTypeReversedcountedstring
+	TdhInTypeReversedcountedansistring
+	TdhInTypeNonnullterminatedstring
+	TdhInTypeNonnullterminatedansistring
+	TdhInTypeUnicodechar
+	TdhInTypeAnsichar
+	TdhInTypeSizet
+	TdhInTypeHexdump
+	TdhInTypeWbemsid
+)
+
+type TdhOutType uint32
+
+const (
+	TdhOutTypeNull = TdhOutType(iota)
+	TdhOutTypeString
+	TdhOutTypeDatetime
+	TdhOutTypeByte
+	TdhOutTypeUnsignedbyte
+	TdhOutTypeShort
+	TdhOutTypeUnsignedshort
+	TdhOutTypeInt
+	TdhOutTypeUnsignedint
+	TdhOutTypeLong
+	TdhOutTypeUnsignedlong
+	TdhOutTypeFloat
+	TdhOutTypeDouble
+	TdhOutTypeBoolean
+	TdhOutTypeGUID
+	TdhOutTypeHexbinary
+	TdhOutTypeHexint8
+	TdhOutTypeHexint16
+	TdhOutTypeHexint32
+	TdhOutTypeHexint64
+	TdhOutTypePid
+	TdhOutTypeTid
+	TdhOutTypePort
+	TdhOutTypeIpv4
+	TdhOutTypeIpv6
+	TdhOutTypeSocketaddress
+	TdhOutTypeCimdatetime
+	TdhOutTypeEtwtime
+	TdhOutTypeXML
+	TdhOutTypeErrorcode
+	TdhOutTypeWin32error
+	TdhOutTypeNtstatus
+	TdhOutTypeHresult                    // End of winmeta outtypes.
+	TdhOutTypeCultureInsensitiveDatetime // Culture neutral datetime string.
+	TdhOutTypeJSON
+)
+
+const (
+	// Start of TDH outtypes for WBEM.
+	TdhOutTypeREDUCEDSTRING = TdhOutType(iota + 300)
+	TdhOutTypeNOPRINT
+)
+
+/*
+typedef enum _EVENT_FIELD_TYPE {
+  EventKeywordInformation   = 0,
+  EventLevelInformation     = 1,
+  EventChannelInformation   = 2,
+  EventTaskInformation      = 3,
+  EventOpcodeInformation    = 4,
+  EventInformationMax       = 5
+} EVENT_FIELD_TYPE;
+*/
+
+type EventFieldType int32
+
+const (
+	EventKeywordInformation = EventFieldType(0)
+	EventLevelInformation   = EventFieldType(1)
+	EventChannelInformation = EventFieldType(2)
+	EventTaskInformation    = EventFieldType(3)
+	EventOpcodeInformation  = EventFieldType(4)
+	EventInformationMax     = EventFieldType(5)
+)
@@ -1,12 +1,9 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
-	"crypto/rand"
-	"fmt"
-	"syscall"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
 	"unsafe"
 )
 
@@ -20,50 +17,60 @@ func max(a, b int) int {
 // UTF16BytesToString transforms a bytes array of UTF16 encoded characters to
 // a Go string
 func UTF16BytesToString(utf16 []byte) string {
-	return syscall.UTF16ToString(*(*[]uint16)(unsafe.Pointer(&utf16)))
+	u := make([]uint16, len(utf16)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(utf16[i*2:])
+	}
+	return utf16ToString(u)
 }
 
 // UTF16PtrToString transforms a *uint16 to a Go string
 func UTF16PtrToString(utf16 *uint16) string {
-	return UTF16AtOffsetToString(uintptr(unsafe.Pointer(utf16)), 0)
+	return utf16ToString(unsafe.Slice(utf16, Wcslen(utf16)))
 }
 
 func Wcslen(uintf16 *uint16) (len uint64) {
-	for it := uintptr((unsafe.Pointer(uintf16))); ; it += 2 {
-		wc := (*uint16)(unsafe.Pointer(it))
-		if *wc == 0 {
-			return
-		}
+	// unsafe.Add keeps track of the pointer, unlike uintptr arithmetic
+	for p := unsafe.Pointer(uintf16); *(*uint16)(p) != 0; p = unsafe.Add(p, 2) {
 		len++
 	}
+	return
 }
 
-func UTF16AtOffsetToString(pstruct uintptr, offset uintptr) string {
-	out := make([]uint16, 0, 64)
-	wc := (*uint16)(unsafe.Pointer(pstruct + offset))
-	for i := uintptr(2); *wc != 0; i += 2 {
-		out = append(out, *wc)
-		wc = (*uint16)(unsafe.Pointer(pstruct + offset + i))
+// UTF16AtOffsetToString transforms the NUL terminated UTF16 string found
+// at offset of a structure to a Go string
+func UTF16AtOffsetToString(pstruct unsafe.Pointer, offset uintptr) string {
+	return UTF16PtrToString((*uint16)(unsafe.Add(pstruct, offset)))
+}
+
+// utf16ToString decodes u up to the first NUL character
+func utf16ToString(u []uint16) string {
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
 	}
-	return syscall.UTF16ToString(out)
+	return string(utf16.Decode(u))
 }
 
+// CopyData copies size bytes found at pointer. Pointer is not checked as
+// it is meant to point to memory allocated by Windows APIs.
+//
+//go:nocheckptr
 func CopyData(pointer uintptr, size int) []byte {
-	out := make([]byte, 0, size)
-	for it := pointer; it != pointer+uintptr(size); it++ {
-		b := (*byte)(unsafe.Pointer(it))
-		out = append(out, *b)
-	}
+	out := make([]byte, size)
+	copy(out, unsafe.Slice((*byte)(unsafe.Pointer(pointer)), size))
 	return out
 }
 
-// UUID is a simple UUIDgenerator
+// UUID returns the string, without curly brackets, of a new random
+// GUID (see NewGUIDv4)
 func UUID() (uuid string, err error) {
-	b := make([]byte, 16)
-	_, err = rand.Read(b)
-	if err != nil {
+	var g GUID
+	if g, err = NewGUIDv4(); err != nil {
 		return
 	}
-	uuid = fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	uuid = strings.Trim(g.String(), "{}")
 	return
 }
@@ -1,11 +1,8 @@
-//go:build windows
-// +build windows
-
 package etw
 
 import (
-	"syscall"
 	"testing"
+	"unicode/utf16"
 	"unsafe"
 
 	"github.com/0xrawsec/toast"
@@ -16,12 +13,15 @@ func TestUtils(t *testing.T) {
 	tt := toast.FromT(t)
 
 	s := "this is a utf16 string"
-	sutf16, err := syscall.UTF16PtrFromString(s)
-	tt.CheckErr(err)
+	sutf16 := &utf16.Encode([]rune(s + "\x00"))[0]
 
 	tt.Assert(UTF16PtrToString(sutf16) == s)
 	tt.Assert(Wcslen(sutf16) == uint64(len(s)))
 
+	// string found after a 4 bytes header
+	pstruct := utf16.Encode([]rune("hd" + s + "\x00"))
+	tt.Assert(UTF16AtOffsetToString(unsafe.Pointer(&pstruct[0]), 4) == s)
+
 	// we have to double the length because we are in utf16
 	butf16 := CopyData(uintptr(unsafe.Pointer(sutf16)), len(s)*2)
 
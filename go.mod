@@ -5,4 +5,5 @@ go 1.19
 require (
 	github.com/0xrawsec/golang-utils v1.3.1
 	github.com/0xrawsec/toast v1.2.3
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xrawsec/golang-etw/etw"
@@ -134,7 +135,7 @@ func (s *Stats) Update(e *etw.Event) {
 
 	key := e.System.Channel
 	if key == "" {
-		key = e.System.Provider.Guid
+		key = e.System.Provider.Guid.String()
 	}
 
 	if eventIDs, ok = s.s[key]; !ok {
@@ -430,7 +431,7 @@ func main() {
 			log.Errorf("Error while stopping consumer: %s", err)
 		}
 
-		log.Infof("Skipped: %d", c.Skipped)
+		log.Infof("Skipped: %d", atomic.LoadUint64(&c.Skipped))
 
 		log.Debug("Stopping producers")
 		for _, p := range producers {